@@ -7,6 +7,7 @@ import (
 	"sync"
 
 	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/offset"
 )
 
 // ErrNamespaceMalformed represents the error to be returned when an invalid namespace is given.
@@ -39,6 +40,39 @@ type Adaptor interface {
 	Writer(chan struct{}, *sync.WaitGroup) (client.Writer, error)
 }
 
+// Snapshotter defines the interface for adaptors able to provide a client.Reader which only
+// copies the existing data, regardless of whether the adaptor is configured to tail. It is used
+// to bootstrap a sink configured with a start_from of snapshot.
+type Snapshotter interface {
+	SnapshotReader() (client.Reader, error)
+}
+
+// OffsetTracker defines the interface for adaptors able to store the offsets of a sink in the
+// sink itself. Adaptors supporting transactions write the offset attached to each message
+// (see message.WithOffset) in the same transaction as the message.
+type OffsetTracker interface {
+	OffsetManager(name string) (offset.Manager, error)
+}
+
+// SlotManager defines the interface for adaptors whose source keeps changes on the server until
+// the pipeline has read them, such as a postgres replication slot. Slots keep holding changes
+// after the pipeline using them is removed so they need to be dropped explicitly. DropSlot
+// refuses to drop a slot the adaptor doesn't own unless force is set.
+type SlotManager interface {
+	Slots() ([]Slot, error)
+	DropSlot(name string, force bool) error
+}
+
+// Slot describes a replication slot of a source, Owned is true for the slot the adaptor is
+// configured to use and RetainedBytes is the amount of WAL the server keeps for it.
+type Slot struct {
+	Name          string
+	Plugin        string
+	Active        bool
+	Owned         bool
+	RetainedBytes int64
+}
+
 // Connectable defines the interface that adapters should follow to have their connections set
 // on load
 // Connect() allows the adaptor an opportunity to setup connections prior to Start()
@@ -68,6 +68,8 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 			_, err = w.esClient.Index().Index(w.index).Type(indexType).Id(id).BodyJson(msg.Data()).Do(context.TODO())
 		case ops.Update:
 			_, err = w.esClient.Index().Index(w.index).Type(indexType).BodyJson(msg.Data()).Id(id).Do(context.TODO())
+		case ops.Command:
+			// commands (i.e. collection options and indexes) have no equivalent in the index
 		}
 		if msg.Confirms() != nil && err == nil {
 			msg.Confirms() <- struct{}{}
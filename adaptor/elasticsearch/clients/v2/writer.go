@@ -88,6 +88,10 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 
 		var br elastic.BulkableRequest
 		switch msg.OP() {
+		case ops.Command:
+			// commands (i.e. collection options and indexes) have no equivalent in the index, the
+			// message is confirmed along with the next flush of the BulkProcessor
+			return msg, nil
 		case ops.Delete:
 			// we need to flush any pending writes here or this could fail because we're using
 			// more than 1 worker
@@ -40,6 +40,11 @@ func (f *File) Reader() (client.Reader, error) {
 	return newReader(), nil
 }
 
+// SnapshotReader instantiates a Reader, the file Reader never tails so it is the same as Reader.
+func (f *File) SnapshotReader() (client.Reader, error) {
+	return newReader(), nil
+}
+
 // Writer instantiates a Writer for use with working with the file.
 func (f *File) Writer(done chan struct{}, wg *sync.WaitGroup) (client.Writer, error) {
 	return newWriter(), nil
@@ -6,6 +6,7 @@ import (
 
 	"github.com/compose/transporter/client"
 	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
 )
 
 var _ client.Writer = &Writer{}
@@ -20,8 +21,11 @@ func newWriter() *Writer {
 
 func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
 	return func(s client.Session) (message.Msg, error) {
-		if err := dumpMessage(msg, s.(*Session).file); err != nil {
-			return nil, err
+		// commands (i.e. collection options and indexes) aren't documents so they're not written
+		if msg.OP() != ops.Command {
+			if err := dumpMessage(msg, s.(*Session).file); err != nil {
+				return nil, err
+			}
 		}
 		if msg.Confirms() != nil {
 			msg.Confirms() <- struct{}{}
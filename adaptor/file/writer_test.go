@@ -44,3 +44,25 @@ func TestWrite(t *testing.T) {
 		t.Errorf("mismatched data in file, expected %s, got %s", string(expected), string(actual))
 	}
 }
+
+func TestWriteCommand(t *testing.T) {
+	tmpD, err := ioutil.TempDir("", "write_test")
+	if err != nil {
+		t.Fatalf("unable to create tmp dir, %s", err)
+	}
+	defer os.RemoveAll(tmpD)
+	f, err := os.Create(filepath.Join(tmpD, "data.json"))
+	if err != nil {
+		t.Fatalf("unable to create file, %s", err)
+	}
+	defer f.Close()
+	confirms, cleanup := adaptor.MockConfirmWrites()
+	defer adaptor.VerifyWriteConfirmed(cleanup, t)
+	msg := message.From(ops.Command, "test", map[string]interface{}{"create": "test", "indexes": []interface{}{}})
+	if _, err := newWriter().Write(message.WithConfirms(confirms, msg))(&Session{file: f}); err != nil {
+		t.Errorf("unexpected Write error, %s\n", err)
+	}
+	if actual, _ := ioutil.ReadFile(filepath.Join(tmpD, "data.json")); len(actual) != 0 {
+		t.Errorf("expected command not to be written, got %s", actual)
+	}
+}
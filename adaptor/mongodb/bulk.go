@@ -8,6 +8,7 @@ import (
 	"github.com/compose/transporter/log"
 	"github.com/compose/transporter/message"
 	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/offset"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -18,14 +19,19 @@ const (
 
 var (
 	_ client.Writer = &Bulk{}
+	_ client.Closer = &Bulk{}
 )
 
 // Bulk implements client.Writer for use with MongoDB and takes advantage of the Bulk API for
-// performance improvements.
+// performance improvements. When the sink stores its offsets, the newest offset of every
+// namespace is saved once all the buffered messages are flushed.
 type Bulk struct {
 	bulkMap map[string]*bulkOperation
 	*sync.RWMutex
 	confirmChan chan struct{}
+	collections *collectionBuilder
+	offsets     *offsetStore
+	offsetMap   map[string]uint64
 }
 
 type bulkOperation struct {
@@ -37,8 +43,10 @@ type bulkOperation struct {
 
 func newBulker(done chan struct{}, wg *sync.WaitGroup) *Bulk {
 	b := &Bulk{
-		bulkMap: make(map[string]*bulkOperation),
-		RWMutex: &sync.RWMutex{},
+		bulkMap:     make(map[string]*bulkOperation),
+		RWMutex:     &sync.RWMutex{},
+		collections: newCollectionBuilder(),
+		offsetMap:   make(map[string]uint64),
 	}
 	wg.Add(1)
 	go b.run(done, wg)
@@ -47,10 +55,23 @@ func newBulker(done chan struct{}, wg *sync.WaitGroup) *Bulk {
 
 func (b *Bulk) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
 	return func(s client.Session) (message.Msg, error) {
+		if err := b.collections.Err(); err != nil {
+			return nil, err
+		}
 		coll := msg.Namespace()
 		b.Lock()
 		b.confirmChan = msg.Confirms()
 		bOp, ok := b.bulkMap[coll]
+		if msg.OP() == ops.Command {
+			// the documents queued for the collection are written before it's changed
+			defer b.Unlock()
+			if ok {
+				if err := b.flush(coll, bOp); err != nil {
+					return nil, err
+				}
+			}
+			return msg, b.collections.apply(msg, s.(*Session).mgoSession.DB("").C(coll))
+		}
 		if !ok {
 			s := s.(*Session).mgoSession.Clone()
 			bOp = &bulkOperation{
@@ -90,10 +111,20 @@ func (b *Bulk) Write(msg message.Msg) func(client.Session) (message.Msg, error)
 		case ops.Insert:
 			bOp.bulk.Insert(msg.Data())
 		case ops.Update:
+			if update, ok := message.Update(msg); ok {
+				// an empty update is left when a transform removed every field it set
+				if len(update) > 0 {
+					bOp.bulk.Update(bson.M{"_id": msg.Data().Get("_id")}, update)
+				}
+				break
+			}
 			bOp.bulk.Update(bson.M{"_id": msg.Data().Get("_id")}, msg.Data())
 		}
 		bOp.bsonOpSize += msgSize
 		bOp.opCounter++
+		if ns, logOffset, ok := message.Offset(msg); ok && b.offsets != nil && logOffset >= b.offsetMap[ns] {
+			b.offsetMap[ns] = logOffset
+		}
 		b.Unlock()
 		return msg, err
 	}
@@ -118,6 +149,11 @@ func (b *Bulk) run(done chan struct{}, wg *sync.WaitGroup) {
 	}
 }
 
+// Close waits for the deferred index builds to finish.
+func (b *Bulk) Close() {
+	b.collections.wait()
+}
+
 func (b *Bulk) flushAll() error {
 	b.Lock()
 	for c, bOp := range b.bulkMap {
@@ -125,6 +161,9 @@ func (b *Bulk) flushAll() error {
 			return err
 		}
 	}
+	if err := b.saveOffsets(); err != nil {
+		return err
+	}
 	if b.confirmChan != nil {
 		b.confirmChan <- struct{}{}
 	}
@@ -132,6 +171,19 @@ func (b *Bulk) flushAll() error {
 	return nil
 }
 
+// saveOffsets saves the offsets of the flushed messages, it is only called once every
+// collection is flushed since messages with the same offset namespace can be written to
+// different collections.
+func (b *Bulk) saveOffsets() error {
+	for ns, logOffset := range b.offsetMap {
+		if err := b.offsets.Save(offset.Offset{Namespace: ns, LogOffset: logOffset}); err != nil {
+			return err
+		}
+		delete(b.offsetMap, ns)
+	}
+	return nil
+}
+
 func (b *Bulk) flush(c string, bOp *bulkOperation) error {
 	log.With("collection", c).With("opCounter", bOp.opCounter).With("bsonOpSize", bOp.bsonOpSize).Infoln("flushing bulk messages")
 	_, err := bOp.bulk.Run()
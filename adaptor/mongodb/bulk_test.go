@@ -178,6 +178,40 @@ func TestFlushOnDone(t *testing.T) {
 	checkBulkCount("baz", bson.M{}, testBulkMsgCount, t)
 }
 
+func TestBulkSavesOffsets(t *testing.T) {
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	b := newBulker(done, &wg)
+
+	c, _ := NewClient(WithURI(fmt.Sprintf("mongodb://transporter-db:27017/%s", bulkTestData.DB)))
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to initialize connection to mongodb, %s", err)
+	}
+	defer s.(*Session).Close()
+	b.offsets = newOffsetStore(c, "", "bulk_sink")
+	for i := 0; i < testBulkMsgCount; i++ {
+		msg := message.From(ops.Insert, fmt.Sprintf("offsets_%d", i%2), map[string]interface{}{"i": i})
+		b.Write(message.WithOffset("foo", uint64(i), msg))(s)
+	}
+	nsMap, err := b.offsets.Load()
+	if err != nil {
+		t.Fatalf("unexpected Load error, %s", err)
+	}
+	if _, ok := nsMap["foo"]; ok {
+		t.Errorf("offset saved before the messages were flushed, %v", nsMap)
+	}
+	close(done)
+	wg.Wait()
+	checkBulkCount("offsets_0", bson.M{}, testBulkMsgCount/2, t)
+	if nsMap, err = b.offsets.Load(); err != nil {
+		t.Fatalf("unexpected Load error, %s", err)
+	}
+	if nsMap["foo"] != uint64(testBulkMsgCount-1) {
+		t.Errorf("wrong offset saved, expected %d, got %d", testBulkMsgCount-1, nsMap["foo"])
+	}
+}
+
 func TestBulkMulitpleCollections(t *testing.T) {
 	var wg sync.WaitGroup
 	done := make(chan struct{})
@@ -0,0 +1,363 @@
+package mongodb
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// TailModeOplog tails the oplog of the replica set directly.
+	TailModeOplog = "oplog"
+	// TailModeChangeStream tails with change streams, which also work on sharded clusters and
+	// on servers which don't give access to the oplog.
+	TailModeChangeStream = "change_stream"
+
+	// ScopeCollection opens a change stream for every collection.
+	ScopeCollection = "collection"
+	// ScopeDatabase opens a single change stream for the database.
+	ScopeDatabase = "database"
+	// ScopeCluster opens a single change stream for the whole cluster, only the changes made
+	// to the database are sent.
+	ScopeCluster = "cluster"
+)
+
+// ChangeStreamInvalidatedError is returned when the change stream is closed by the server,
+// such as when the watched collection is dropped or renamed.
+type ChangeStreamInvalidatedError struct {
+	Namespace string
+}
+
+func (e ChangeStreamInvalidatedError) Error() string {
+	return fmt.Sprintf("change stream on %s was invalidated", e.Namespace)
+}
+
+// changeEvent is a document returned by a change stream, its _id is the resume token.
+type changeEvent struct {
+	ID            bson.Raw            `bson:"_id"`
+	OperationType string              `bson:"operationType"`
+	ClusterTime   bson.MongoTimestamp `bson:"clusterTime"`
+	Ns            struct {
+		DB   string `bson:"db"`
+		Coll string `bson:"coll"`
+	} `bson:"ns"`
+	DocumentKey  bson.M `bson:"documentKey"`
+	FullDocument bson.M `bson:"fullDocument"`
+}
+
+type cursorReply struct {
+	Cursor struct {
+		ID         int64      `bson:"id"`
+		NS         string     `bson:"ns"`
+		FirstBatch []bson.Raw `bson:"firstBatch"`
+		NextBatch  []bson.Raw `bson:"nextBatch"`
+		// PostBatchResumeToken is returned by change streams on MongoDB 4.0.7+, it's the
+		// resume token of the end of the batch.
+		PostBatchResumeToken bson.Raw `bson:"postBatchResumeToken"`
+	} `bson:"cursor"`
+}
+
+// changeStream watches a collection, the database or the whole cluster. It starts from the
+// resume token of the newest message read by a previous run or, when there is none, from the
+// time the copy started.
+type changeStream struct {
+	db       string
+	coll     string
+	cluster  bool
+	filterFn client.NsFilterFunc
+	match    bson.M
+
+	token   []byte
+	tokenTS int64
+	startAt bson.MongoTimestamp
+	// batchToken is the postBatchResumeToken of the last batch read
+	batchToken []byte
+
+	cursorID   int64
+	cursorColl string
+}
+
+func (r *Reader) newChangeStream(db, coll string, filterFn client.NsFilterFunc) *changeStream {
+	return &changeStream{
+		db:       db,
+		coll:     coll,
+		cluster:  r.scope == ScopeCluster,
+		filterFn: filterFn,
+		match:    changeStreamMatch(r.collectionFilters, coll),
+	}
+}
+
+// resumeFrom records where the stream needs to start for a collection, m is the newest message
+// of the collection found in the resume map and startAt is when its copy started. Only messages
+// read from a change stream hold a resume token in their Position.
+func (cs *changeStream) resumeFrom(m client.MessageSet, startAt bson.MongoTimestamp) {
+	if m.Mode == commitlog.Sync && len(m.Position) > 0 {
+		if cs.token == nil || m.Timestamp > cs.tokenTS || m.Timestamp == cs.tokenTS && bytes.Compare(m.Position, cs.token) > 0 {
+			cs.token, cs.tokenTS = m.Position, m.Timestamp
+		}
+		return
+	}
+	if cs.startAt == 0 || startAt < cs.startAt {
+		cs.startAt = startAt
+	}
+}
+
+func (cs *changeStream) namespace() string {
+	if cs.coll != "" {
+		return fmt.Sprintf("%s.%s", cs.db, cs.coll)
+	}
+	return cs.db
+}
+
+func (cs *changeStream) pipeline() []bson.M {
+	opts := bson.D{{Name: "fullDocument", Value: "updateLookup"}}
+	if cs.cluster {
+		opts = append(opts, bson.DocElem{Name: "allChangesForCluster", Value: true})
+	}
+	if cs.token != nil {
+		opts = append(opts, bson.DocElem{Name: "resumeAfter", Value: bson.Raw{Kind: 0x03, Data: cs.token}})
+	} else if cs.startAt != 0 {
+		opts = append(opts, bson.DocElem{Name: "startAtOperationTime", Value: cs.startAt})
+	}
+	pipeline := []bson.M{{"$changeStream": opts}}
+	if cs.match != nil {
+		pipeline = append(pipeline, bson.M{"$match": cs.match})
+	}
+	return pipeline
+}
+
+// database returns the database the aggregation runs on, change streams on the cluster have
+// to be opened on the admin database.
+func (cs *changeStream) database(s *mgo.Session) *mgo.Database {
+	if cs.cluster {
+		return s.DB("admin")
+	}
+	return s.DB(cs.db)
+}
+
+func (cs *changeStream) open(s *mgo.Session) ([]bson.Raw, error) {
+	var aggregate interface{} = 1
+	if cs.coll != "" {
+		aggregate = cs.coll
+	}
+	var reply cursorReply
+	err := cs.database(s).Run(bson.D{
+		{Name: "aggregate", Value: aggregate},
+		{Name: "pipeline", Value: cs.pipeline()},
+		{Name: "cursor", Value: bson.M{}},
+	}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	cs.cursorID = reply.Cursor.ID
+	cs.cursorColl = reply.Cursor.NS[strings.Index(reply.Cursor.NS, ".")+1:]
+	cs.batchToken = reply.Cursor.PostBatchResumeToken.Data
+	return reply.Cursor.FirstBatch, nil
+}
+
+// next waits up to timeout for more changes.
+func (cs *changeStream) next(s *mgo.Session, timeout time.Duration) ([]bson.Raw, error) {
+	var reply cursorReply
+	err := cs.database(s).Run(bson.D{
+		{Name: "getMore", Value: cs.cursorID},
+		{Name: "collection", Value: cs.cursorColl},
+		{Name: "maxTimeMS", Value: int64(timeout / time.Millisecond)},
+	}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	cs.cursorID = reply.Cursor.ID
+	cs.batchToken = reply.Cursor.PostBatchResumeToken.Data
+	return reply.Cursor.NextBatch, nil
+}
+
+func (cs *changeStream) close(s *mgo.Session) {
+	if cs.cursorID == 0 {
+		return
+	}
+	cs.database(s).Run(bson.D{
+		{Name: "killCursors", Value: cs.cursorColl},
+		{Name: "cursors", Value: []int64{cs.cursorID}},
+	}, nil)
+	cs.cursorID = 0
+}
+
+// message returns the message for a change, nil is returned for changes which aren't sent.
+func (cs *changeStream) message(e changeEvent) (message.Msg, error) {
+	if e.OperationType == "invalidate" {
+		return nil, ChangeStreamInvalidatedError{Namespace: cs.namespace()}
+	}
+	if e.Ns.DB != cs.db || strings.HasPrefix(e.Ns.Coll, "system.") || !cs.filterFn(e.Ns.Coll) {
+		return nil, nil
+	}
+	var (
+		op  ops.Op
+		doc bson.M
+	)
+	switch e.OperationType {
+	case "insert":
+		op, doc = ops.Insert, e.FullDocument
+	case "update", "replace":
+		if e.FullDocument == nil {
+			log.With("db", e.Ns.DB).With("collection", e.Ns.Coll).Infoln("document deleted before the update was read, skipping")
+			return nil, nil
+		}
+		op, doc = ops.Update, e.FullDocument
+	case "delete":
+		op, doc = ops.Delete, e.DocumentKey
+	default:
+		log.With("db", e.Ns.DB).With("collection", e.Ns.Coll).Infof("skipping %s change", e.OperationType)
+		return nil, nil
+	}
+	msg := message.From(op, e.Ns.Coll, data.Data(doc)).(*message.Base)
+	msg.TS = int64(e.ClusterTime) >> 32
+	return msg, nil
+}
+
+// watchChangeStream sends the changes read from the stream until done is closed. The stream is
+// opened again from the last resume token when reading from it fails.
+func (r *Reader) watchChangeStream(cs *changeStream, s *mgo.Session, out chan<- client.MessageSet, done chan struct{}) error {
+	log.With("ns", cs.namespace()).Infoln("opening change stream")
+	batch, err := cs.open(s)
+	if err != nil {
+		return fmt.Errorf("unable to open change stream on %s, %s", cs.namespace(), err)
+	}
+	defer cs.close(s)
+	for {
+		if err := cs.send(batch, out); err != nil {
+			return err
+		}
+
+		select {
+		case <-done:
+			log.With("ns", cs.namespace()).Infoln("change stream stopping...")
+			return nil
+		default:
+		}
+
+		if batch, err = cs.next(s, r.oplogTimeout); err != nil {
+			log.With("ns", cs.namespace()).Errorf("error reading change stream, %s", err)
+			s.Refresh()
+			cs.cursorID = 0
+			if batch, err = cs.open(s); err != nil {
+				return fmt.Errorf("unable to resume change stream on %s, %s", cs.namespace(), err)
+			}
+		}
+	}
+}
+
+// send sends the changes of the batch. The resume token is then advanced to the end of the
+// batch, when the server returns it, so a stream whose $match stage filters out every change
+// doesn't fall behind the oplog.
+func (cs *changeStream) send(batch []bson.Raw, out chan<- client.MessageSet) error {
+	for _, raw := range batch {
+		var e changeEvent
+		if err := raw.Unmarshal(&e); err != nil {
+			return fmt.Errorf("unable to read change on %s, %s", cs.namespace(), err)
+		}
+		cs.token = e.ID.Data
+		msg, err := cs.message(e)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			continue
+		}
+		out <- client.MessageSet{
+			Msg:       msg,
+			Timestamp: msg.(*message.Base).TS,
+			Mode:      commitlog.Sync,
+			Position:  cs.token,
+		}
+	}
+	if cs.batchToken != nil {
+		cs.token = cs.batchToken
+	}
+	return nil
+}
+
+// startChangeStream runs watchChangeStream in the background until it returns, an error is
+// passed to fail.
+func (r *Reader) startChangeStream(wg *sync.WaitGroup, cs *changeStream, s *mgo.Session, out chan<- client.MessageSet, done chan struct{}, fail func(error)) {
+	wg.Add(1)
+	go func() {
+		defer func() {
+			s.Close()
+			wg.Done()
+		}()
+		if err := r.watchChangeStream(cs, s, out, done); err != nil {
+			log.With("ns", cs.namespace()).Errorln(err)
+			fail(err)
+		}
+	}()
+}
+
+// changeStreamMatch returns the $match stage applying the collection_filters to the documents
+// inserted or updated, deletes and other changes are always sent. coll is empty when the stream
+// isn't on a single collection.
+func changeStreamMatch(filters map[string]CollectionFilter, coll string) bson.M {
+	if len(filters) == 0 {
+		return nil
+	}
+	or := []bson.M{{"operationType": bson.M{"$nin": []string{"insert", "update", "replace"}}}}
+	if coll != "" {
+		f, ok := filters[coll]
+		if !ok {
+			return nil
+		}
+		or = append(or, prefixFilter(f, "fullDocument."))
+		return bson.M{"$or": or}
+	}
+	var colls []string
+	for c, f := range filters {
+		colls = append(colls, c)
+		m := prefixFilter(f, "fullDocument.")
+		m["ns.coll"] = c
+		or = append(or, m)
+	}
+	or = append(or, bson.M{"ns.coll": bson.M{"$nin": colls}})
+	return bson.M{"$or": or}
+}
+
+// prefixFilter returns the query with every field prefixed so it matches an embedded document,
+// including the fields of queries combined with $and, $or and $nor.
+func prefixFilter(f map[string]interface{}, prefix string) bson.M {
+	m := bson.M{}
+	for k, v := range f {
+		switch {
+		case k == "$and" || k == "$or" || k == "$nor":
+			if clauses, ok := v.([]interface{}); ok {
+				var prefixed []interface{}
+				for _, c := range clauses {
+					switch cm := c.(type) {
+					case map[string]interface{}:
+						prefixed = append(prefixed, prefixFilter(cm, prefix))
+						continue
+					case bson.M:
+						prefixed = append(prefixed, prefixFilter(cm, prefix))
+						continue
+					}
+					prefixed = append(prefixed, c)
+				}
+				v = prefixed
+			}
+			m[k] = v
+		case strings.HasPrefix(k, "$"):
+			m[k] = v
+		default:
+			m[prefix+k] = v
+		}
+	}
+	return m
+}
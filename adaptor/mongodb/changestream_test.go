@@ -0,0 +1,202 @@
+package mongodb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var changeStreamMatchTests = []struct {
+	name     string
+	filters  map[string]CollectionFilter
+	coll     string
+	expected bson.M
+}{
+	{
+		"no filters",
+		DefaultCollectionFilter,
+		"foo",
+		nil,
+	},
+	{
+		"collection without filter",
+		map[string]CollectionFilter{"bar": {"i": 1}},
+		"foo",
+		nil,
+	},
+	{
+		"collection",
+		map[string]CollectionFilter{"foo": {"i": map[string]interface{}{"$gt": 10}, "$or": []interface{}{map[string]interface{}{"a": 1}, map[string]interface{}{"b": 1}}}},
+		"foo",
+		bson.M{"$or": []bson.M{
+			{"operationType": bson.M{"$nin": []string{"insert", "update", "replace"}}},
+			{"fullDocument.i": map[string]interface{}{"$gt": 10}, "$or": []interface{}{bson.M{"fullDocument.a": 1}, bson.M{"fullDocument.b": 1}}},
+		}},
+	},
+	{
+		"database",
+		map[string]CollectionFilter{"foo": {"i": 1}},
+		"",
+		bson.M{"$or": []bson.M{
+			{"operationType": bson.M{"$nin": []string{"insert", "update", "replace"}}},
+			{"fullDocument.i": 1, "ns.coll": "foo"},
+			{"ns.coll": bson.M{"$nin": []string{"foo"}}},
+		}},
+	},
+}
+
+func TestChangeStreamMatch(t *testing.T) {
+	for _, ct := range changeStreamMatchTests {
+		t.Run(ct.name, func(t *testing.T) {
+			m := changeStreamMatch(ct.filters, ct.coll)
+			if !reflect.DeepEqual(m, ct.expected) {
+				t.Errorf("wrong $match, expected %+v, got %+v", ct.expected, m)
+			}
+		})
+	}
+}
+
+func changeEventFor(op, coll string, doc bson.M) changeEvent {
+	e := changeEvent{OperationType: op, ClusterTime: bson.MongoTimestamp(1500000000 << 32)}
+	e.Ns.DB = "test"
+	e.Ns.Coll = coll
+	switch op {
+	case "delete":
+		e.DocumentKey = doc
+	default:
+		e.FullDocument = doc
+	}
+	return e
+}
+
+var changeMessageTests = []struct {
+	name     string
+	event    changeEvent
+	expected message.Msg
+	err      error
+}{
+	{
+		"insert",
+		changeEventFor("insert", "foo", bson.M{"_id": 1, "i": 2}),
+		message.From(ops.Insert, "foo", map[string]interface{}{"_id": 1, "i": 2}),
+		nil,
+	},
+	{
+		"update",
+		changeEventFor("update", "foo", bson.M{"_id": 1, "i": 3}),
+		message.From(ops.Update, "foo", map[string]interface{}{"_id": 1, "i": 3}),
+		nil,
+	},
+	{
+		"update of a deleted document",
+		changeEventFor("update", "foo", nil),
+		nil,
+		nil,
+	},
+	{
+		"delete",
+		changeEventFor("delete", "foo", bson.M{"_id": 1}),
+		message.From(ops.Delete, "foo", map[string]interface{}{"_id": 1}),
+		nil,
+	},
+	{
+		"filtered collection",
+		changeEventFor("insert", "bar", bson.M{"_id": 1}),
+		nil,
+		nil,
+	},
+	{
+		"drop",
+		changeEventFor("drop", "foo", nil),
+		nil,
+		nil,
+	},
+	{
+		"invalidate",
+		changeEventFor("invalidate", "foo", nil),
+		nil,
+		ChangeStreamInvalidatedError{Namespace: "test"},
+	},
+}
+
+func TestChangeStreamMessage(t *testing.T) {
+	cs := newReader(true, DefaultCollectionFilter).newChangeStream("test", "", func(c string) bool { return c == "foo" })
+	for _, ct := range changeMessageTests {
+		t.Run(ct.name, func(t *testing.T) {
+			msg, err := cs.message(ct.event)
+			if err != ct.err {
+				t.Fatalf("wrong error, expected %v, got %v", ct.err, err)
+			}
+			if ct.expected == nil {
+				if msg != nil {
+					t.Errorf("expected no message, got %+v", msg)
+				}
+				return
+			}
+			if msg.OP() != ct.expected.OP() || msg.Namespace() != ct.expected.Namespace() || !reflect.DeepEqual(map[string]interface{}(msg.Data()), map[string]interface{}(ct.expected.Data())) {
+				t.Errorf("wrong message, expected %+v, got %+v", ct.expected, msg)
+			}
+			if msg.(*message.Base).TS != 1500000000 {
+				t.Errorf("wrong TS, expected 1500000000, got %d", msg.(*message.Base).TS)
+			}
+		})
+	}
+}
+
+func TestChangeStreamResumeFrom(t *testing.T) {
+	cs := &changeStream{}
+	cs.resumeFrom(client.MessageSet{}, bson.MongoTimestamp(20<<32))
+	cs.resumeFrom(client.MessageSet{}, bson.MongoTimestamp(10<<32))
+	cs.resumeFrom(client.MessageSet{Timestamp: 40, Position: []byte("copy")}, bson.MongoTimestamp(40<<32))
+	if cs.startAt != bson.MongoTimestamp(10<<32) {
+		t.Errorf("wrong startAt, expected the earliest copy, got %d", cs.startAt)
+	}
+	cs.resumeFrom(client.MessageSet{Timestamp: 30, Mode: commitlog.Sync, Position: []byte("b")}, 0)
+	cs.resumeFrom(client.MessageSet{Timestamp: 20, Mode: commitlog.Sync, Position: []byte("c")}, 0)
+	cs.resumeFrom(client.MessageSet{Timestamp: 30, Mode: commitlog.Sync, Position: []byte("a")}, 0)
+	if string(cs.token) != "b" {
+		t.Errorf("wrong token, expected the newest, got %s", cs.token)
+	}
+}
+
+func rawChange(t *testing.T, e changeEvent, token string) bson.Raw {
+	id, _ := bson.Marshal(bson.M{"_data": token})
+	e.ID = bson.Raw{Kind: 0x03, Data: id}
+	b, err := bson.Marshal(e)
+	if err != nil {
+		t.Fatalf("unable to marshal change, %s", err)
+	}
+	return bson.Raw{Kind: 0x03, Data: b}
+}
+
+func TestChangeStreamSend(t *testing.T) {
+	cs := newReader(true, DefaultCollectionFilter).newChangeStream("test", "", func(c string) bool { return c == "foo" })
+	out := make(chan client.MessageSet, 2)
+
+	// the changes of an idle stream are all filtered out, the token still moves to the end of the batch
+	batchToken, _ := bson.Marshal(bson.M{"_data": "2"})
+	cs.batchToken = batchToken
+	if err := cs.send([]bson.Raw{rawChange(t, changeEventFor("insert", "bar", bson.M{"_id": 1}), "1")}, out); err != nil {
+		t.Fatalf("unexpected send error, %s", err)
+	}
+	if len(out) != 0 || !reflect.DeepEqual(cs.token, batchToken) {
+		t.Errorf("expected no message and the batch token, got %d messages and token %v", len(out), cs.token)
+	}
+
+	cs.batchToken = nil
+	err := cs.send([]bson.Raw{
+		rawChange(t, changeEventFor("insert", "foo", bson.M{"_id": 1}), "3"),
+		rawChange(t, changeEventFor("invalidate", "foo", nil), "4"),
+	}, out)
+	if err != (ChangeStreamInvalidatedError{Namespace: "test"}) {
+		t.Errorf("expected ChangeStreamInvalidatedError, got %v", err)
+	}
+	if msg := <-out; msg.Msg.OP() != ops.Insert || len(msg.Position) == 0 {
+		t.Errorf("wrong message, got %+v", msg)
+	}
+}
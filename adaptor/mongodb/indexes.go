@@ -0,0 +1,232 @@
+package mongodb
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// createKey holds the collection name in the ops.Command message sent before a collection is
+	// copied, along with its "options" and secondary "indexes".
+	createKey = "create"
+	// copiedKey holds the collection name in the ops.Command message sent once a collection is
+	// copied.
+	copiedKey = "copied"
+
+	// errNamespaceExists is the code of the error returned when creating a collection which
+	// exists.
+	errNamespaceExists = 48
+)
+
+// IndexBuildError is returned by the writes following a failed build of the deferred indexes of
+// a collection.
+type IndexBuildError struct {
+	Collection string
+	Err        error
+}
+
+func (e IndexBuildError) Error() string {
+	return fmt.Sprintf("unable to build the indexes of %s, %s", e.Collection, e.Err)
+}
+
+// collectionSpec returns the options of the collection as listed by listCollections and the
+// specs of its secondary indexes as listed by listIndexes. Documents are read as bson.D so the
+// order of the keys of compound indexes is kept.
+func collectionSpec(s *mgo.Session, c string) (bson.D, []interface{}, error) {
+	db := s.DB("")
+	var colls cursorReply
+	err := db.Run(bson.D{
+		{Name: "listCollections", Value: 1},
+		{Name: "filter", Value: bson.M{"name": c}},
+	}, &colls)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to list the options of %s, %s", c, err)
+	}
+	var options bson.D
+	if len(colls.Cursor.FirstBatch) > 0 {
+		var info struct {
+			Options bson.D `bson:"options"`
+		}
+		if err := colls.Cursor.FirstBatch[0].Unmarshal(&info); err != nil {
+			return nil, nil, err
+		}
+		options = info.Options
+	}
+
+	var idxs cursorReply
+	if err := db.Run(bson.D{{Name: "listIndexes", Value: c}}, &idxs); err != nil {
+		return nil, nil, fmt.Errorf("unable to list the indexes of %s, %s", c, err)
+	}
+	var indexes []interface{}
+	for _, raw := range idxs.Cursor.FirstBatch {
+		var spec bson.D
+		if err := raw.Unmarshal(&spec); err != nil {
+			return nil, nil, err
+		}
+		if index := secondaryIndex(spec); index != nil {
+			indexes = append(indexes, index)
+		}
+	}
+	return options, indexes, nil
+}
+
+// secondaryIndex returns the spec to create the index with, the namespace and version are left
+// for the target to set. nil is returned for the _id index, which every collection has.
+func secondaryIndex(spec bson.D) bson.D {
+	var index bson.D
+	for _, e := range spec {
+		switch e.Name {
+		case "name":
+			if e.Value == "_id_" {
+				return nil
+			}
+		case "ns", "v":
+			continue
+		}
+		index = append(index, e)
+	}
+	return index
+}
+
+// sendCollectionSpec sends the ops.Command message describing the collection before it's copied,
+// a collection which can't be described is still copied.
+func (r *Reader) sendCollectionSpec(s *mgo.Session, c string, out chan<- client.MessageSet, origOplogTime int64) {
+	defer s.Close()
+	options, indexes, err := collectionSpec(s, c)
+	if err != nil {
+		log.With("db", s.DB("").Name).With("collection", c).Errorf("not copying indexes and options, %s", err)
+		return
+	}
+	out <- client.MessageSet{
+		Msg:       message.From(ops.Command, c, data.Data{createKey: c, "options": options, "indexes": indexes}),
+		Timestamp: origOplogTime,
+	}
+}
+
+// sendCopied sends the ops.Command message marking the end of the copy of the collection. It's
+// sent in the Complete mode so the collection isn't copied again when resuming from it.
+func (r *Reader) sendCopied(c string, out chan<- client.MessageSet, origOplogTime int64) {
+	out <- client.MessageSet{
+		Msg:       message.From(ops.Command, c, data.Data{copiedKey: c}),
+		Timestamp: origOplogTime,
+		Mode:      commitlog.Complete,
+	}
+}
+
+// collectionBuilder creates the collections and indexes described by the ops.Command messages
+// of a MongoDB source. When deferIndexes is set the secondary indexes of a collection are only
+// built once it's copied, which is faster than updating them with every document. The deferred
+// build runs in the background since it can take much longer than the write_timeout, its error is
+// returned by Err.
+type collectionBuilder struct {
+	deferIndexes bool
+	build        func(*mgo.Collection, []interface{}) error
+
+	mu      sync.Mutex
+	pending map[string][]interface{}
+	err     error
+	wg      sync.WaitGroup
+}
+
+func newCollectionBuilder() *collectionBuilder {
+	return &collectionBuilder{
+		build:   createIndexes,
+		pending: make(map[string][]interface{}),
+	}
+}
+
+// Err returns the error of the first deferred index build which failed.
+func (cb *collectionBuilder) Err() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.err
+}
+
+// wait blocks until the deferred index builds are done.
+func (cb *collectionBuilder) wait() {
+	cb.wg.Wait()
+}
+
+func (cb *collectionBuilder) buildDeferred(c *mgo.Collection, indexes []interface{}) {
+	defer cb.wg.Done()
+	if err := cb.build(c, indexes); err != nil {
+		log.With("collection", c.Name).Errorf("deferred index build failed, %s", err)
+		cb.mu.Lock()
+		if cb.err == nil {
+			cb.err = IndexBuildError{Collection: c.Name, Err: err}
+		}
+		cb.mu.Unlock()
+	}
+}
+
+func (cb *collectionBuilder) apply(msg message.Msg, c *mgo.Collection) error {
+	d := msg.Data()
+	if _, ok := d.Has(copiedKey); ok {
+		cb.mu.Lock()
+		indexes, ok := cb.pending[c.Name]
+		delete(cb.pending, c.Name)
+		cb.mu.Unlock()
+		if !ok {
+			return nil
+		}
+		s := c.Database.Session.Clone()
+		cb.wg.Add(1)
+		go func() {
+			defer s.Close()
+			cb.buildDeferred(c.With(s), indexes)
+		}()
+		return nil
+	}
+	if _, ok := d.Has(createKey); !ok {
+		log.With("collection", c.Name).Infoln("unknown command, skipping")
+		return nil
+	}
+	if err := createCollection(c, d.Get("options")); err != nil {
+		return err
+	}
+	indexes, _ := d.Get("indexes").([]interface{})
+	if cb.deferIndexes {
+		log.With("collection", c.Name).With("indexes", len(indexes)).Infoln("building indexes once the collection is copied")
+		cb.mu.Lock()
+		cb.pending[c.Name] = indexes
+		cb.mu.Unlock()
+		return nil
+	}
+	return cb.build(c, indexes)
+}
+
+// createCollection creates the collection with the options, a collection which exists is left
+// as it is.
+func createCollection(c *mgo.Collection, options interface{}) error {
+	cmd := bson.D{{Name: "create", Value: c.Name}}
+	if o, ok := options.(bson.D); ok {
+		cmd = append(cmd, o...)
+	}
+	log.With("collection", c.Name).Infoln("creating collection")
+	err := c.Database.Run(cmd, nil)
+	if qerr, ok := err.(*mgo.QueryError); ok && qerr.Code == errNamespaceExists {
+		log.With("collection", c.Name).Infoln("collection exists, options not applied")
+		return nil
+	}
+	return err
+}
+
+func createIndexes(c *mgo.Collection, indexes []interface{}) error {
+	if len(indexes) == 0 {
+		return nil
+	}
+	log.With("collection", c.Name).With("indexes", len(indexes)).Infoln("building indexes")
+	return c.Database.Run(bson.D{
+		{Name: "createIndexes", Value: c.Name},
+		{Name: "indexes", Value: indexes},
+	}, nil)
+}
@@ -0,0 +1,65 @@
+package mongodb
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var secondaryIndexTests = []struct {
+	name     string
+	spec     bson.D
+	expected bson.D
+}{
+	{
+		"_id index",
+		bson.D{{Name: "v", Value: 2}, {Name: "key", Value: bson.D{{Name: "_id", Value: 1}}}, {Name: "name", Value: "_id_"}},
+		nil,
+	},
+	{
+		"compound index",
+		bson.D{
+			{Name: "v", Value: 2},
+			{Name: "unique", Value: true},
+			{Name: "key", Value: bson.D{{Name: "b", Value: 1}, {Name: "a", Value: -1}}},
+			{Name: "name", Value: "b_1_a_-1"},
+			{Name: "ns", Value: "test.foo"},
+		},
+		bson.D{
+			{Name: "unique", Value: true},
+			{Name: "key", Value: bson.D{{Name: "b", Value: 1}, {Name: "a", Value: -1}}},
+			{Name: "name", Value: "b_1_a_-1"},
+		},
+	},
+}
+
+func TestSecondaryIndex(t *testing.T) {
+	for _, st := range secondaryIndexTests {
+		t.Run(st.name, func(t *testing.T) {
+			index := secondaryIndex(st.spec)
+			if !reflect.DeepEqual(index, st.expected) {
+				t.Errorf("wrong index, expected %+v, got %+v", st.expected, index)
+			}
+		})
+	}
+}
+
+func TestDeferredIndexBuildError(t *testing.T) {
+	buildErr := errors.New("index build failed")
+	w := newWriter()
+	w.collections.build = func(*mgo.Collection, []interface{}) error { return buildErr }
+	w.collections.wg.Add(1)
+	go w.collections.buildDeferred(&mgo.Collection{Name: "foo"}, nil)
+	w.Close()
+
+	expected := IndexBuildError{Collection: "foo", Err: buildErr}
+	if _, err := w.Write(message.From(ops.Insert, "foo", data.Data{"_id": 1}))(nil); err != expected {
+		t.Errorf("wrong error, expected %v, got %v", expected, err)
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/compose/transporter/adaptor"
 	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/offset"
 )
 
 const (
@@ -16,21 +17,35 @@ const (
   "uri": "${MONGODB_URI}"
   // "timeout": "30s",
   // "tail": false,
+  // "tail_mode": "oplog",
+  // "change_stream_scope": "collection",
   // "ssl": false,
   // "cacerts": ["/path/to/cert.pem"],
   // "wc": 1,
   // "fsync": false,
   // "bulk": false,
   // "collection_filters": "{}",
+  // "copy_parallelism": 1,
+  // "partial_updates": false,
+  // "copy_indexes": false,
+  // "defer_indexes": false,
   // "read_preference": "Primary"
 }`
 )
 
 var (
-	_ adaptor.Adaptor = &mongoDB{}
+	_ adaptor.Adaptor       = &mongoDB{}
+	_ adaptor.Snapshotter   = &mongoDB{}
+	_ adaptor.OffsetTracker = &mongoDB{}
 
 	// ErrCollectionFilter is returned when an error occurs attempting to Unmarshal the string.
 	ErrCollectionFilter = errors.New("malformed collection_filters")
+
+	// ErrTailMode is returned when tail_mode isn't one of the supported modes.
+	ErrTailMode = errors.New("tail_mode must be oplog or change_stream")
+
+	// ErrChangeStreamScope is returned when change_stream_scope isn't one of the supported scopes.
+	ErrChangeStreamScope = errors.New("change_stream_scope must be collection, database or cluster")
 )
 
 // mongoDB is an adaptor to read / write to mongodb.
@@ -45,6 +60,15 @@ type mongoDB struct {
 	Bulk              bool     `json:"bulk"`
 	CollectionFilters string   `json:"collection_filters"`
 	ReadPreference    string   `json:"read_preference"`
+	OffsetCollection  string   `json:"offset_collection"`
+	TailMode          string   `json:"tail_mode"`
+	ChangeStreamScope string   `json:"change_stream_scope"`
+	CopyParallelism   int      `json:"copy_parallelism"`
+	PartialUpdates    bool     `json:"partial_updates"`
+	CopyIndexes       bool     `json:"copy_indexes"`
+	DeferIndexes      bool     `json:"defer_indexes"`
+
+	offsets *offsetStore
 }
 
 func init() {
@@ -62,26 +86,71 @@ func (m *mongoDB) Client() (client.Client, error) {
 		WithSSL(m.SSL),
 		WithCACerts(m.CACerts),
 		WithFsync(m.FSync),
-		WithTail(m.Tail),
+		WithTail(m.Tail && m.TailMode != TailModeChangeStream),
 		WithWriteConcern(m.Wc),
 		WithReadPreference(m.ReadPreference))
 }
 
 func (m *mongoDB) Reader() (client.Reader, error) {
+	return m.reader(m.Tail)
+}
+
+// SnapshotReader returns a Reader which copies every collection without tailing the oplog.
+func (m *mongoDB) SnapshotReader() (client.Reader, error) {
+	return m.reader(false)
+}
+
+func (m *mongoDB) reader(tail bool) (client.Reader, error) {
 	var f map[string]CollectionFilter
 	if m.CollectionFilters != "" {
 		if jerr := json.Unmarshal([]byte(m.CollectionFilters), &f); jerr != nil {
 			return nil, ErrCollectionFilter
 		}
 	}
-	return newReader(m.Tail, f), nil
+	r := newReader(tail, f)
+	r.copyParallelism = m.CopyParallelism
+	r.partialUpdates = m.PartialUpdates
+	r.copyIndexes = m.CopyIndexes
+	switch m.TailMode {
+	case "", TailModeOplog:
+	case TailModeChangeStream:
+		r.tailMode = m.TailMode
+	default:
+		return nil, ErrTailMode
+	}
+	switch m.ChangeStreamScope {
+	case "", ScopeCollection:
+	case ScopeDatabase, ScopeCluster:
+		r.scope = m.ChangeStreamScope
+	default:
+		return nil, ErrChangeStreamScope
+	}
+	return r, nil
 }
 
 func (m *mongoDB) Writer(done chan struct{}, wg *sync.WaitGroup) (client.Writer, error) {
 	if m.Bulk {
-		return newBulker(done, wg), nil
+		b := newBulker(done, wg)
+		b.offsets = m.offsets
+		b.collections.deferIndexes = m.DeferIndexes
+		return b, nil
+	}
+	w := newWriter()
+	w.offsets = m.offsets
+	w.collections.deferIndexes = m.DeferIndexes
+	return w, nil
+}
+
+// OffsetManager stores the offsets of the named sink in the offset_collection, the Writer
+// created afterwards saves the offset of each message directly after writing it, or after
+// flushing it when bulk is set.
+func (m *mongoDB) OffsetManager(name string) (offset.Manager, error) {
+	c, err := m.Client()
+	if err != nil {
+		return nil, err
 	}
-	return newWriter(), nil
+	m.offsets = newOffsetStore(c.(*Client), m.OffsetCollection, name)
+	return offset.NewStoreManager(m.offsets)
 }
 
 func (m *mongoDB) Description() string {
@@ -48,6 +48,18 @@ var initTests = []struct {
 		&mongoDB{BaseConfig: adaptor.BaseConfig{URI: DefaultURI}, Tail: true},
 		nil, nil, nil,
 	},
+	{
+		"with change streams",
+		map[string]interface{}{"uri": DefaultURI, "tail": true, "tail_mode": "change_stream", "change_stream_scope": "database"},
+		&mongoDB{BaseConfig: adaptor.BaseConfig{URI: DefaultURI}, Tail: true, TailMode: TailModeChangeStream, ChangeStreamScope: ScopeDatabase},
+		nil, nil, nil,
+	},
+	{
+		"bad tail mode",
+		map[string]interface{}{"uri": DefaultURI, "tail": true, "tail_mode": "polling"},
+		&mongoDB{BaseConfig: adaptor.BaseConfig{URI: DefaultURI}, Tail: true, TailMode: "polling"},
+		nil, ErrTailMode, nil,
+	},
 	{
 		"with bulk",
 		map[string]interface{}{"uri": DefaultURI, "bulk": true},
@@ -0,0 +1,106 @@
+package mongodb
+
+import (
+	"sync"
+
+	"github.com/compose/transporter/offset"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+const (
+	// DefaultOffsetCollection is the collection used for storing offsets when
+	// offset_collection is not set.
+	DefaultOffsetCollection = "transporter_offsets"
+)
+
+var _ offset.Store = &offsetStore{}
+
+// offsetStore implements offset.Store with a collection in the sink database. MongoDB
+// does not support multi-document transactions through mgo so the Writer saves the offset
+// directly after writing each message, and Bulk after flushing its messages. The writes are
+// idempotent so a message written without its offset is simply written again on restart.
+type offsetStore struct {
+	client     *Client
+	collection string
+	sink       string
+
+	mu        sync.Mutex
+	committed map[string]uint64
+}
+
+type offsetDoc struct {
+	ID        string `bson:"_id"`
+	Sink      string `bson:"sink"`
+	Namespace string `bson:"namespace"`
+	LogOffset int64  `bson:"log_offset"`
+}
+
+func newOffsetStore(c *Client, collection, sink string) *offsetStore {
+	if collection == "" {
+		collection = DefaultOffsetCollection
+	}
+	return &offsetStore{
+		client:     c,
+		collection: collection,
+		sink:       sink,
+		committed:  make(map[string]uint64),
+	}
+}
+
+func (s *offsetStore) session() (*mgo.Session, error) {
+	sess, err := s.client.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return sess.(*Session).mgoSession, nil
+}
+
+// Load reads the offsets of the sink.
+func (s *offsetStore) Load() (map[string]uint64, error) {
+	sess, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+	var docs []offsetDoc
+	if err := sess.DB("").C(s.collection).Find(bson.M{"sink": s.sink}).All(&docs); err != nil {
+		return nil, err
+	}
+	nsMap := make(map[string]uint64)
+	for _, d := range docs {
+		nsMap[d.Namespace] = uint64(d.LogOffset)
+	}
+	return nsMap, nil
+}
+
+// Save upserts the offset unless the Writer already saved it.
+func (s *offsetStore) Save(o offset.Offset) error {
+	s.mu.Lock()
+	committed, ok := s.committed[o.Namespace]
+	s.mu.Unlock()
+	if ok && committed == o.LogOffset {
+		return nil
+	}
+	sess, err := s.session()
+	if err != nil {
+		return err
+	}
+	return s.save(sess, o.Namespace, o.LogOffset)
+}
+
+func (s *offsetStore) save(sess *mgo.Session, ns string, logOffset uint64) error {
+	id := s.sink + "." + ns
+	_, err := sess.DB("").C(s.collection).UpsertId(id, offsetDoc{
+		ID:        id,
+		Sink:      s.sink,
+		Namespace: ns,
+		LogOffset: int64(logOffset),
+	})
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.committed[ns] = logOffset
+	s.mu.Unlock()
+	return nil
+}
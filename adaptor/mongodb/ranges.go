@@ -0,0 +1,247 @@
+package mongodb
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// samplesPerRange is how many _ids are sampled for each range when the collection can't be
+// split with splitVector.
+const samplesPerRange = 10
+
+// copyState is the progress of a collection copied in ranges of _id, it's stored in the
+// Position of every copied message so an interrupted copy resumes each range after the last
+// document it sent. Bounds split the collection into len(Bounds)+1 ranges and Last holds the
+// _id of the last document sent from each range, nil until the range sends one.
+type copyState struct {
+	Bounds []interface{} `bson:"bounds"`
+	Last   []interface{} `bson:"last"`
+}
+
+func newCopyState(bounds []interface{}) *copyState {
+	return &copyState{Bounds: bounds, Last: make([]interface{}, len(bounds)+1)}
+}
+
+func parseCopyState(b []byte) (*copyState, error) {
+	st := &copyState{}
+	if err := bson.Unmarshal(b, st); err != nil {
+		return nil, fmt.Errorf("invalid copy position, %s", err)
+	}
+	if len(st.Last) != len(st.Bounds)+1 {
+		return nil, errors.New("invalid copy position, wrong number of ranges")
+	}
+	return st, nil
+}
+
+func (st *copyState) marshal() []byte {
+	b, _ := bson.Marshal(st)
+	return b
+}
+
+// query returns the query for the documents of range i which haven't been sent. The first range
+// holds every _id which isn't in the other ones, including _ids of another type than the bounds
+// since comparisons only match values of the same type.
+func (st *copyState) query(i int, filter CollectionFilter) bson.M {
+	var conds []bson.M
+	if len(filter) > 0 {
+		conds = append(conds, bson.M(filter))
+	}
+	last := st.Last[i]
+	if i == 0 {
+		if len(st.Bounds) > 0 {
+			conds = append(conds, bson.M{"_id": bson.M{"$not": bson.M{"$gte": st.Bounds[0]}}})
+		}
+		if last != nil {
+			conds = append(conds, bson.M{"_id": bson.M{"$not": bson.M{"$lte": last}}})
+		}
+	} else {
+		id := bson.M{"$gte": st.Bounds[i-1]}
+		if last != nil {
+			id = bson.M{"$gt": last}
+		}
+		if i < len(st.Bounds) {
+			id["$lt"] = st.Bounds[i]
+		}
+		conds = append(conds, bson.M{"_id": id})
+	}
+	switch len(conds) {
+	case 0:
+		return bson.M{}
+	case 1:
+		return conds[0]
+	}
+	return bson.M{"$and": conds}
+}
+
+// splitCollection returns up to n-1 _ids splitting the collection into ranges of about the same
+// size. splitVector is used when it's allowed, otherwise the bounds are picked from a sample of
+// the collection.
+func (r *Reader) splitCollection(s *mgo.Session, c string, n int) ([]interface{}, error) {
+	db := s.DB("")
+	var stats struct {
+		Size int64 `bson:"size"`
+	}
+	if err := db.Run(bson.D{{Name: "collStats", Value: c}}, &stats); err != nil {
+		return nil, err
+	}
+	if stats.Size == 0 {
+		return nil, nil
+	}
+	var split struct {
+		SplitKeys []bson.M `bson:"splitKeys"`
+	}
+	err := db.Run(bson.D{
+		{Name: "splitVector", Value: fmt.Sprintf("%s.%s", db.Name, c)},
+		{Name: "keyPattern", Value: bson.M{"_id": 1}},
+		{Name: "maxChunkSizeBytes", Value: stats.Size/int64(n) + 1},
+	}, &split)
+	var ids []interface{}
+	if err == nil {
+		for _, k := range split.SplitKeys {
+			ids = append(ids, k["_id"])
+		}
+		return pickBounds(ids, n), nil
+	}
+	log.With("db", db.Name).With("collection", c).Infof("unable to run splitVector, sampling the collection instead, %s", err)
+	var sample []bson.M
+	err = db.C(c).Pipe([]bson.M{
+		{"$sample": bson.M{"size": n * samplesPerRange}},
+		{"$project": bson.M{"_id": 1}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&sample)
+	if err != nil {
+		return nil, err
+	}
+	for _, doc := range sample {
+		ids = append(ids, doc["_id"])
+	}
+	return pickBounds(ids, n), nil
+}
+
+// pickBounds returns n-1 evenly spaced ids from the sorted ids, skipping duplicates.
+func pickBounds(ids []interface{}, n int) []interface{} {
+	if len(ids) < n {
+		n = len(ids) + 1
+	}
+	var bounds []interface{}
+	for k := 1; k < n; k++ {
+		id := ids[k*len(ids)/n]
+		if len(bounds) > 0 && reflect.DeepEqual(bounds[len(bounds)-1], id) {
+			continue
+		}
+		bounds = append(bounds, id)
+	}
+	return bounds
+}
+
+type rangeDoc struct {
+	i   int
+	doc bson.M
+}
+
+// copyRanges copies the ranges of the collection concurrently, resuming from the copy state
+// found in position when there is one.
+func (r *Reader) copyRanges(s *mgo.Session, c string, position []byte, out chan<- client.MessageSet, done chan struct{}, origOplogTime int64) error {
+	defer s.Close()
+	db := s.DB("").Name
+	var st *copyState
+	if len(position) > 0 {
+		var err error
+		if st, err = parseCopyState(position); err != nil {
+			return err
+		}
+		log.With("db", db).With("collection", c).With("ranges", len(st.Last)).Infoln("resuming copy")
+	} else {
+		bounds, err := r.splitCollection(s, c, r.copyParallelism)
+		if err != nil {
+			return fmt.Errorf("unable to split %s, %s", c, err)
+		}
+		st = newCopyState(bounds)
+		log.With("db", db).With("collection", c).With("ranges", len(st.Last)).Infoln("copying in ranges")
+	}
+
+	var (
+		docs = make(chan rangeDoc)
+		stop = make(chan struct{})
+		wg   sync.WaitGroup
+	)
+	defer close(stop)
+	for i := range st.Last {
+		wg.Add(1)
+		go func(i int, query bson.M) {
+			defer wg.Done()
+			r.iterateRange(s.Copy(), c, i, query, docs, stop)
+		}(i, st.query(i, r.collectionFilters[c]))
+	}
+	go func() {
+		wg.Wait()
+		close(docs)
+	}()
+
+	for {
+		select {
+		case rd, ok := <-docs:
+			if !ok {
+				return nil
+			}
+			st.Last[rd.i] = rd.doc["_id"]
+			out <- client.MessageSet{
+				Msg:       message.From(ops.Insert, c, data.Data(rd.doc)),
+				Timestamp: origOplogTime,
+				Position:  st.marshal(),
+			}
+		case <-done:
+			return errors.New("iteration cancelled")
+		}
+	}
+}
+
+// iterateRange sends the documents matching the query sorted by _id, the query is reissued
+// for the documents after the last one sent when reading fails.
+func (r *Reader) iterateRange(s *mgo.Session, c string, i int, query bson.M, docs chan<- rangeDoc, stop chan struct{}) {
+	defer s.Close()
+	var (
+		db   = s.DB("").Name
+		q    = query
+		last interface{}
+	)
+	for {
+		session := s.Copy()
+		iter := session.DB("").C(c).Find(q).Sort("_id").Iter()
+		var result bson.M
+		for iter.Next(&result) {
+			select {
+			case docs <- rangeDoc{i, result}:
+			case <-stop:
+				iter.Close()
+				session.Close()
+				return
+			}
+			last = result["_id"]
+			result = bson.M{}
+		}
+		if err := iter.Err(); err != nil {
+			log.With("database", db).With("collection", c).With("range", i).Errorf("error reading, %s", err)
+			session.Close()
+			if last != nil {
+				q = bson.M{"$and": []bson.M{query, {"_id": bson.M{"$not": bson.M{"$lte": last}}}}}
+			}
+			time.Sleep(5 * time.Second)
+			continue
+		}
+		iter.Close()
+		session.Close()
+		return
+	}
+}
@@ -0,0 +1,108 @@
+package mongodb
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var copyStateQueryTests = []struct {
+	name     string
+	state    *copyState
+	i        int
+	filter   CollectionFilter
+	expected bson.M
+}{
+	{
+		"single range",
+		newCopyState(nil),
+		0,
+		nil,
+		bson.M{},
+	},
+	{
+		"first range",
+		newCopyState([]interface{}{10, 20}),
+		0,
+		nil,
+		bson.M{"_id": bson.M{"$not": bson.M{"$gte": 10}}},
+	},
+	{
+		"middle range",
+		newCopyState([]interface{}{10, 20}),
+		1,
+		nil,
+		bson.M{"_id": bson.M{"$gte": 10, "$lt": 20}},
+	},
+	{
+		"last range with filter",
+		newCopyState([]interface{}{10, 20}),
+		2,
+		CollectionFilter{"i": 1},
+		bson.M{"$and": []bson.M{{"i": 1}, {"_id": bson.M{"$gte": 20}}}},
+	},
+	{
+		"resumed first range",
+		&copyState{Bounds: []interface{}{10, 20}, Last: []interface{}{5, nil, nil}},
+		0,
+		nil,
+		bson.M{"$and": []bson.M{{"_id": bson.M{"$not": bson.M{"$gte": 10}}}, {"_id": bson.M{"$not": bson.M{"$lte": 5}}}}},
+	},
+	{
+		"resumed middle range",
+		&copyState{Bounds: []interface{}{10, 20}, Last: []interface{}{nil, 15, nil}},
+		1,
+		nil,
+		bson.M{"_id": bson.M{"$gt": 15, "$lt": 20}},
+	},
+}
+
+func TestCopyStateQuery(t *testing.T) {
+	for _, ct := range copyStateQueryTests {
+		t.Run(ct.name, func(t *testing.T) {
+			q := ct.state.query(ct.i, ct.filter)
+			if !reflect.DeepEqual(q, ct.expected) {
+				t.Errorf("wrong query, expected %+v, got %+v", ct.expected, q)
+			}
+		})
+	}
+}
+
+func TestCopyStatePosition(t *testing.T) {
+	id := bson.ObjectIdHex("5a1d5b7c9e1b7c0d2a3b4c5d")
+	st := &copyState{Bounds: []interface{}{id, "b"}, Last: []interface{}{nil, id, nil}}
+	parsed, err := parseCopyState(st.marshal())
+	if err != nil {
+		t.Fatalf("unexpected parseCopyState error, %s", err)
+	}
+	if !reflect.DeepEqual(parsed, st) {
+		t.Errorf("wrong copy state, expected %+v, got %+v", st, parsed)
+	}
+	if _, err := parseCopyState([]byte("not bson")); err == nil {
+		t.Error("expected an error for an invalid position")
+	}
+}
+
+var pickBoundsTests = []struct {
+	name     string
+	ids      []interface{}
+	n        int
+	expected []interface{}
+}{
+	{"no ids", nil, 4, nil},
+	{"fewer ids than ranges", []interface{}{1, 2}, 4, []interface{}{1, 2}},
+	{"evenly spaced", []interface{}{1, 2, 3, 4, 5, 6, 7, 8}, 4, []interface{}{3, 5, 7}},
+	{"duplicates", []interface{}{1, 1, 1, 1, 1, 1, 2, 2}, 4, []interface{}{1, 2}},
+}
+
+func TestPickBounds(t *testing.T) {
+	for _, pt := range pickBoundsTests {
+		t.Run(pt.name, func(t *testing.T) {
+			bounds := pickBounds(pt.ids, pt.n)
+			if !reflect.DeepEqual(bounds, pt.expected) {
+				t.Errorf("wrong bounds, expected %v, got %v", pt.expected, bounds)
+			}
+		})
+	}
+}
@@ -18,7 +18,8 @@ import (
 )
 
 var (
-	_ client.Reader = &Reader{}
+	_ client.Reader        = &Reader{}
+	_ client.ErrorReporter = &Reader{}
 
 	// DefaultCollectionFilter is an empty map of empty maps
 	DefaultCollectionFilter = map[string]CollectionFilter{}
@@ -32,10 +33,24 @@ type Reader struct {
 	tail              bool
 	collectionFilters map[string]CollectionFilter
 	oplogTimeout      time.Duration
+	tailMode          string
+	scope             string
+	copyParallelism   int
+	partialUpdates    bool
+	copyIndexes       bool
+
+	mu  sync.Mutex
+	err error // why a change stream stopped before done was closed
 }
 
-func newReader(tail bool, filters map[string]CollectionFilter) client.Reader {
-	return &Reader{tail, filters, 5 * time.Second}
+func newReader(tail bool, filters map[string]CollectionFilter) *Reader {
+	return &Reader{
+		tail:              tail,
+		collectionFilters: filters,
+		oplogTimeout:      5 * time.Second,
+		tailMode:          TailModeOplog,
+		scope:             ScopeCollection,
+	}
 }
 
 func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.NsFilterFunc) client.MessageChanFunc {
@@ -53,24 +68,69 @@ func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 				log.With("db", session.DB("").Name).Errorf("unable to list collections, %s", err)
 				return
 			}
-			var wg sync.WaitGroup
+			var (
+				wg sync.WaitGroup
+				// a single change stream is used for the database and cluster scopes, it
+				// starts once every collection is copied
+				stream *changeStream
+				// the change streams stop once one of them fails, so the Read ends and the
+				// error is reported by Err
+				streamDone = make(chan struct{})
+				stopOnce   sync.Once
+			)
+			fail := func(err error) {
+				r.setErr(err)
+				stopOnce.Do(func() { close(streamDone) })
+			}
+			go func() {
+				select {
+				case <-done:
+					stopOnce.Do(func() { close(streamDone) })
+				case <-streamDone:
+				}
+			}()
+			if r.tail && r.tailMode == TailModeChangeStream && r.scope != ScopeCollection {
+				stream = r.newChangeStream(session.DB("").Name, "", filterFn)
+			}
 			for _, c := range collections {
 				var lastID interface{}
 				oplogTime := timeAsMongoTimestamp(time.Now())
 				var mode commitlog.Mode // default to Copy
-				if m, ok := resumeMap[c]; ok {
+				m, ok := resumeMap[c]
+				if ok {
 					lastID = m.Msg.Data().Get("_id")
 					mode = m.Mode
 					oplogTime = timeAsMongoTimestamp(time.Unix(m.Timestamp, 0))
 				}
 				if mode == commitlog.Copy {
-					if err := r.iterateCollection(r.iterate(lastID, session.Copy(), c), out, done, int64(oplogTime)>>32); err != nil {
+					if r.copyIndexes {
+						r.sendCollectionSpec(session.Copy(), c, out, int64(oplogTime)>>32)
+					}
+					var err error
+					// copies interrupted before copying in ranges continue with a single cursor
+					if r.copyParallelism > 1 && (lastID == nil || len(m.Position) > 0) && r.requeryable(c, session) {
+						err = r.copyRanges(session.Copy(), c, m.Position, out, done, int64(oplogTime)>>32)
+					} else {
+						err = r.iterateCollection(r.iterate(lastID, session.Copy(), c), out, done, int64(oplogTime)>>32)
+					}
+					if err != nil {
 						log.With("db", session.DB("").Name).Errorln(err)
 						return
 					}
 					log.With("db", session.DB("").Name).With("collection", c).Infoln("iterating complete")
+					if r.copyIndexes {
+						r.sendCopied(c, out, int64(oplogTime)>>32)
+					}
 				}
-				if r.tail {
+				if r.tail && r.tailMode == TailModeChangeStream {
+					if stream != nil {
+						stream.resumeFrom(m, oplogTime)
+						continue
+					}
+					cs := r.newChangeStream(session.DB("").Name, c, filterFn)
+					cs.resumeFrom(m, oplogTime)
+					r.startChangeStream(&wg, cs, session.Copy(), out, streamDone, fail)
+				} else if r.tail {
 					wg.Add(1)
 					log.With("collection", c).Infof("oplog start timestamp: %d", oplogTime)
 					go func(wg *sync.WaitGroup, c string, o bson.MongoTimestamp) {
@@ -83,6 +143,9 @@ func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 					}(&wg, c, oplogTime)
 				}
 			}
+			if stream != nil {
+				r.startChangeStream(&wg, stream, session.Copy(), out, streamDone, fail)
+			}
 			log.With("db", session.DB("").Name).Infoln("Read completed")
 			// this will block if we're tailing
 			wg.Wait()
@@ -92,6 +155,22 @@ func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 	}
 }
 
+// Err returns the error which stopped a change stream, nil when they stopped because done was
+// closed.
+func (r *Reader) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+func (r *Reader) setErr(err error) {
+	r.mu.Lock()
+	if r.err == nil {
+		r.err = err
+	}
+	r.mu.Unlock()
+}
+
 func (r *Reader) listCollections(mgoSession *mgo.Session, filterFn func(name string) bool) ([]string, error) {
 	defer mgoSession.Close()
 	var colls []string
@@ -241,9 +320,10 @@ func (r *Reader) tailCollection(c string, mgoSession *mgo.Session, oplogTime bso
 				for iter.Next(&result) {
 					if result.validOp(ns) {
 						var (
-							doc bson.M
-							err error
-							op  ops.Op
+							doc    bson.M
+							err    error
+							op     ops.Op
+							update bson.M
 						)
 						switch result.Op {
 						case "i":
@@ -254,6 +334,10 @@ func (r *Reader) tailCollection(c string, mgoSession *mgo.Session, oplogTime bso
 							doc = result.O
 						case "u":
 							op = ops.Update
+							if update = r.updateOperators(result.O, c); update != nil {
+								doc = partialDoc(result.O2, update)
+								break
+							}
 							doc, err = r.getOriginalDoc(result.O2, c, mgoSession)
 							if err != nil {
 								// errors aren't fatal here, but we need to send it down the pipe
@@ -264,6 +348,9 @@ func (r *Reader) tailCollection(c string, mgoSession *mgo.Session, oplogTime bso
 
 						msg := message.From(op, c, data.Data(doc)).(*message.Base)
 						msg.TS = int64(result.Ts) >> 32
+						if update != nil {
+							message.WithUpdate(data.Data(update), msg)
+						}
 
 						out <- client.MessageSet{
 							Msg:       msg,
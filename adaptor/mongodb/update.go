@@ -0,0 +1,153 @@
+package mongodb
+
+import (
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// updateOperators returns the $set and $unset operators the oplog entry of an update changed
+// the document with, nil is returned when the document has to be read instead: partial updates
+// aren't enabled, the collection is filtered (the document must be read to apply the filter) or
+// the update can't be expressed with $set and $unset, such as a replacement or an array being
+// truncated.
+func (r *Reader) updateOperators(o bson.M, c string) bson.M {
+	if !r.partialUpdates {
+		return nil
+	}
+	if _, ok := r.collectionFilters[c]; ok {
+		return nil
+	}
+	if v, ok := o["$v"]; ok && toInt(v) == 2 {
+		diff, ok := o["diff"].(bson.M)
+		if !ok {
+			return nil
+		}
+		update := bson.M{}
+		if !applyDiff(update, diff, "") {
+			return nil
+		}
+		return update
+	}
+	update := bson.M{}
+	for k, v := range o {
+		switch k {
+		case "$v":
+		case "$set", "$unset":
+			fields, ok := v.(bson.M)
+			if !ok {
+				return nil
+			}
+			update[k] = fields
+		default:
+			return nil
+		}
+	}
+	if len(update) == 0 {
+		return nil
+	}
+	return update
+}
+
+// applyDiff adds the changes of a $v 2 oplog diff, as written by MongoDB 5.0+, to update. Fields
+// are updated ("u") or inserted ("i") with $set, deleted ("d") with $unset and embedded
+// documents and arrays are diffed ("s" followed by the field name) on their own. false is
+// returned for changes which can't be expressed with $set and $unset.
+func applyDiff(update bson.M, diff bson.M, prefix string) bool {
+	if isArray, _ := diff["a"].(bool); isArray {
+		return applyArrayDiff(update, diff, prefix)
+	}
+	for k, v := range diff {
+		switch {
+		case k == "u" || k == "i":
+			fields, ok := v.(bson.M)
+			if !ok {
+				return false
+			}
+			for f, value := range fields {
+				setField(update, "$set", prefix+f, value)
+			}
+		case k == "d":
+			fields, ok := v.(bson.M)
+			if !ok {
+				return false
+			}
+			for f := range fields {
+				setField(update, "$unset", prefix+f, true)
+			}
+		case strings.HasPrefix(k, "s"):
+			sub, ok := v.(bson.M)
+			if !ok || !applyDiff(update, sub, prefix+k[1:]+".") {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// applyArrayDiff adds the changes of an array diff, whose entries are keyed by "u" or "s"
+// followed by the index of the element. A new length ("l") shortens the array, which $set and
+// $unset can't do.
+func applyArrayDiff(update bson.M, diff bson.M, prefix string) bool {
+	for k, v := range diff {
+		if k == "a" {
+			continue
+		}
+		if len(k) < 2 {
+			return false
+		}
+		if _, err := strconv.Atoi(k[1:]); err != nil {
+			return false
+		}
+		switch k[0] {
+		case 'u':
+			setField(update, "$set", prefix+k[1:], v)
+		case 's':
+			sub, ok := v.(bson.M)
+			if !ok || !applyDiff(update, sub, prefix+k[1:]+".") {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func setField(update bson.M, operator, field string, value interface{}) {
+	fields, ok := update[operator].(bson.M)
+	if !ok {
+		fields = bson.M{}
+		update[operator] = fields
+	}
+	fields[field] = value
+}
+
+// partialDoc returns the document sent for a partial update, the _id of the document along
+// with the fields set by the update.
+func partialDoc(o2 bson.M, update bson.M) bson.M {
+	doc := bson.M{"_id": o2["_id"]}
+	if set, ok := update["$set"].(bson.M); ok {
+		for k, v := range set {
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int32:
+		return int(n)
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
@@ -0,0 +1,85 @@
+package mongodb
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var updateOperatorsTests = []struct {
+	name     string
+	o        bson.M
+	expected bson.M
+}{
+	{
+		"replacement",
+		bson.M{"_id": 1, "name": "bob"},
+		nil,
+	},
+	{
+		"set and unset",
+		bson.M{"$v": 1, "$set": bson.M{"name": "bob", "address.city": "NYC"}, "$unset": bson.M{"age": true}},
+		bson.M{"$set": bson.M{"name": "bob", "address.city": "NYC"}, "$unset": bson.M{"age": true}},
+	},
+	{
+		"unknown operator",
+		bson.M{"$inc": bson.M{"count": 1}},
+		nil,
+	},
+	{
+		"diff",
+		bson.M{"$v": 2, "diff": bson.M{
+			"u":        bson.M{"name": "bob"},
+			"i":        bson.M{"email": "bob@example.com"},
+			"d":        bson.M{"age": false},
+			"saddress": bson.M{"u": bson.M{"city": "NYC"}},
+			"stags":    bson.M{"a": true, "u2": "new", "s0": bson.M{"d": bson.M{"old": false}}},
+		}},
+		bson.M{
+			"$set":   bson.M{"name": "bob", "email": "bob@example.com", "address.city": "NYC", "tags.2": "new"},
+			"$unset": bson.M{"age": true, "tags.0.old": true},
+		},
+	},
+	{
+		"truncated array",
+		bson.M{"$v": 2, "diff": bson.M{"stags": bson.M{"a": true, "l": 1}}},
+		nil,
+	},
+}
+
+func TestUpdateOperators(t *testing.T) {
+	r := newReader(true, nil)
+	r.partialUpdates = true
+	for _, ut := range updateOperatorsTests {
+		t.Run(ut.name, func(t *testing.T) {
+			update := r.updateOperators(ut.o, "foo")
+			if !reflect.DeepEqual(update, ut.expected) {
+				t.Errorf("wrong update, expected %+v, got %+v", ut.expected, update)
+			}
+		})
+	}
+}
+
+func TestUpdateOperatorsFallback(t *testing.T) {
+	o := bson.M{"$set": bson.M{"name": "bob"}}
+	r := newReader(true, map[string]CollectionFilter{"foo": {"i": bson.M{"$gt": 10}}})
+	if update := r.updateOperators(o, "bar"); update != nil {
+		t.Errorf("expected no update when partial updates are disabled, got %+v", update)
+	}
+	r.partialUpdates = true
+	if update := r.updateOperators(o, "foo"); update != nil {
+		t.Errorf("expected no update for a filtered collection, got %+v", update)
+	}
+	if update := r.updateOperators(o, "bar"); update == nil {
+		t.Error("expected an update for a collection without filter")
+	}
+}
+
+func TestPartialDoc(t *testing.T) {
+	doc := partialDoc(bson.M{"_id": 1}, bson.M{"$set": bson.M{"name": "bob"}, "$unset": bson.M{"age": true}})
+	expected := bson.M{"_id": 1, "name": "bob"}
+	if !reflect.DeepEqual(doc, expected) {
+		t.Errorf("wrong doc, expected %+v, got %+v", expected, doc)
+	}
+}
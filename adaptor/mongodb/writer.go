@@ -9,25 +9,34 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
-var _ client.Writer = &Writer{}
+var (
+	_ client.Writer = &Writer{}
+	_ client.Closer = &Writer{}
+)
 
 // Writer implements client.Writer for use with MongoDB
 type Writer struct {
-	writeMap map[ops.Op]func(message.Msg, *mgo.Collection) error
+	writeMap    map[ops.Op]func(message.Msg, *mgo.Collection) error
+	offsets     *offsetStore
+	collections *collectionBuilder
 }
 
 func newWriter() *Writer {
-	w := &Writer{}
+	w := &Writer{collections: newCollectionBuilder()}
 	w.writeMap = map[ops.Op]func(message.Msg, *mgo.Collection) error{
-		ops.Insert: insertMsg,
-		ops.Update: updateMsg,
-		ops.Delete: deleteMsg,
+		ops.Insert:  insertMsg,
+		ops.Update:  updateMsg,
+		ops.Delete:  deleteMsg,
+		ops.Command: w.collections.apply,
 	}
 	return w
 }
 
 func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
 	return func(s client.Session) (message.Msg, error) {
+		if err := w.collections.Err(); err != nil {
+			return nil, err
+		}
 		writeFunc, ok := w.writeMap[msg.OP()]
 		if !ok {
 			log.Infof("no function registered for operation, %s\n", msg.OP())
@@ -39,6 +48,11 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 		if err := writeFunc(msg, msgCollection(msg, s)); err != nil {
 			return nil, err
 		}
+		if ns, logOffset, ok := message.Offset(msg); ok && w.offsets != nil {
+			if err := w.offsets.save(s.(*Session).mgoSession, ns, logOffset); err != nil {
+				return nil, err
+			}
+		}
 		if msg.Confirms() != nil {
 			msg.Confirms() <- struct{}{}
 		}
@@ -46,6 +60,11 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 	}
 }
 
+// Close waits for the deferred index builds to finish.
+func (w *Writer) Close() {
+	w.collections.wait()
+}
+
 func msgCollection(msg message.Msg, s client.Session) *mgo.Collection {
 	return s.(*Session).mgoSession.DB("").C(msg.Namespace())
 }
@@ -59,6 +78,13 @@ func insertMsg(msg message.Msg, c *mgo.Collection) error {
 }
 
 func updateMsg(msg message.Msg, c *mgo.Collection) error {
+	if update, ok := message.Update(msg); ok {
+		if len(update) == 0 {
+			// a transform removed every field the update set
+			return nil
+		}
+		return c.UpdateId(msg.Data().Get("_id"), update)
+	}
 	return c.Update(bson.M{"_id": msg.Data().Get("_id")}, msg.Data())
 }
 
@@ -57,6 +57,10 @@ var (
 		writerComplexUpdateTestData,
 		writerComplexDeleteTestData,
 		writerComplexDeletePkTestData,
+		batchTestData,
+		writerUpsertTestData,
+		schemaTestData,
+		snapshotTestData,
 	}
 
 	randomHeros = []string{"Superwoman", "Wonder Woman", "Batman", "Superman",
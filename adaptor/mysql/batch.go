@@ -0,0 +1,214 @@
+package mysql
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
+)
+
+const (
+	// DefaultBatchTimeout is how long messages are buffered when batch_timeout is not set.
+	DefaultBatchTimeout = time.Second
+
+	// maxParams is the most bind parameters allowed in a single statement.
+	maxParams = 65535
+)
+
+var _ client.Writer = &Batch{}
+
+// Batch implements client.Writer by applying messages in transactions of up to size messages,
+// the buffered messages are also committed every interval so they never wait long on a quiet
+// source. Consecutive inserts into the same table with the same columns are combined into a
+// multi-row INSERT and Confirms is signaled once per transaction.
+type Batch struct {
+	writeMap map[ops.Op]func(message.Msg, executor) error
+	offsets  *offsetStore
+	schema   *schema
+	size     int
+	upsert   bool
+
+	sync.Mutex
+	db          *sql.DB
+	msgs        []message.Msg
+	confirmChan chan struct{}
+	err         error
+}
+
+func newBatcher(size int, interval time.Duration, upsert bool, offsets *offsetStore, done chan struct{}, wg *sync.WaitGroup) *Batch {
+	b := &Batch{
+		writeMap: newWriter(upsert).writeMap,
+		offsets:  offsets,
+		size:     size,
+		upsert:   upsert,
+		msgs:     make([]message.Msg, 0, size),
+	}
+	wg.Add(1)
+	go b.run(interval, done, wg)
+	return b
+}
+
+func (b *Batch) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
+	return func(s client.Session) (message.Msg, error) {
+		b.Lock()
+		defer b.Unlock()
+		if b.err != nil {
+			return nil, b.err
+		}
+		b.db = s.(*Session).mysqlSession
+		msg, err := ensureSchema(b.schema, b.db, msg)
+		if err != nil {
+			return nil, err
+		}
+		if msg.Confirms() != nil {
+			b.confirmChan = msg.Confirms()
+		}
+		// DDL commits the transaction it runs in so it's applied in one of its own
+		command := msg.OP() == ops.Command
+		if command {
+			if b.err = b.flush(); b.err != nil {
+				return nil, b.err
+			}
+		}
+		b.msgs = append(b.msgs, msg)
+		if command || len(b.msgs) >= b.size {
+			if b.err = b.flush(); b.err != nil {
+				return nil, b.err
+			}
+		}
+		if command {
+			b.schema.forget(msg.Namespace())
+		}
+		return msg, nil
+	}
+}
+
+func (b *Batch) run(interval time.Duration, done chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-time.After(interval):
+			b.Lock()
+			if b.err == nil {
+				if b.err = b.flush(); b.err != nil {
+					log.Errorf("flush error, %s", b.err)
+				}
+			}
+			b.Unlock()
+		case <-done:
+			log.Infoln("received done channel")
+			b.Lock()
+			if b.err == nil {
+				if err := b.flush(); err != nil {
+					log.Errorf("flush error, %s", err)
+				}
+			}
+			b.Unlock()
+			return
+		}
+	}
+}
+
+// flush applies the buffered messages, and their offsets when they are stored in the sink,
+// in a single transaction.
+func (b *Batch) flush() error {
+	if len(b.msgs) == 0 {
+		return nil
+	}
+	log.With("messages", len(b.msgs)).Debugln("flushing batch")
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(b.msgs); {
+		n, err := b.apply(tx, b.msgs[i:])
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		i += n
+	}
+
+	offsets := make(map[string]uint64)
+	if b.offsets != nil {
+		for _, msg := range b.msgs {
+			if ns, logOffset, ok := message.Offset(msg); ok && logOffset >= offsets[ns] {
+				offsets[ns] = logOffset
+			}
+		}
+		for ns, logOffset := range offsets {
+			if err := b.offsets.save(tx, ns, logOffset); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if b.offsets != nil {
+		b.offsets.markCommitted(offsets)
+	}
+	b.msgs = make([]message.Msg, 0, b.size)
+	if b.confirmChan != nil {
+		b.confirmChan <- struct{}{}
+	}
+	return nil
+}
+
+// apply writes the first message along with any inserts directly following it into the
+// same table with the same columns, the number of messages written is returned.
+func (b *Batch) apply(e executor, msgs []message.Msg) (int, error) {
+	m := msgs[0]
+	if m.OP() != ops.Insert {
+		writeFunc, ok := b.writeMap[m.OP()]
+		if !ok {
+			log.Infof("no function registered for operation, %s", m.OP())
+			return 1, nil
+		}
+		return 1, writeFunc(m, e)
+	}
+
+	keys, placeholders, row, err := insertRow(m)
+	if err != nil {
+		return 0, err
+	}
+	rows := [][]interface{}{row}
+	for _, next := range msgs[1:] {
+		if next.OP() != ops.Insert || next.Namespace() != m.Namespace() || (len(rows)+1)*len(keys) > maxParams {
+			break
+		}
+		nextKeys, nextPlaceholders, nextRow, err := insertRow(next)
+		if err != nil {
+			return 0, err
+		}
+		if !sameStrings(keys, nextKeys) || !sameStrings(placeholders, nextPlaceholders) {
+			break
+		}
+		rows = append(rows, nextRow)
+	}
+	var onDuplicate string
+	if b.upsert {
+		if onDuplicate, err = upsertClause(m.Namespace(), keys, e); err != nil {
+			return 0, err
+		}
+	}
+	log.With("table", m.Namespace()).With("rows", len(rows)).Debugln("INSERT")
+	return len(rows), insertRows(e, m.Namespace(), keys, placeholders, rows, onDuplicate)
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,87 @@
+package mysql
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+)
+
+var (
+	batchTestData = &TestData{"writer_batch_test", "batch_test_table", basicSchema, 0}
+)
+
+func TestBatch(t *testing.T) {
+	confirms, cleanup := adaptor.MockConfirmWrites()
+	defer adaptor.VerifyWriteConfirmed(cleanup, t)
+	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", batchTestData.DB)))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to mysql, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to mysql, %s", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	b := newBatcher(5, time.Minute, false, nil, done, &wg)
+	ns := fmt.Sprintf("%s.%s", batchTestData.DB, batchTestData.Table)
+	for i := 0; i < 12; i++ {
+		if _, err := b.Write(message.WithConfirms(
+			confirms,
+			message.From(ops.Insert, ns, data.Data{"id": i, "colvar": "hello world", "coltimestamp": time.Now().Format("2006-01-02 15:04:05.000000")}),
+		))(s); err != nil {
+			t.Errorf("unexpected Insert error, %s\n", err)
+		}
+	}
+	if _, err := b.Write(message.WithConfirms(
+		confirms,
+		message.From(ops.Update, ns, data.Data{"id": 3, "colvar": "updated", "coltimestamp": time.Now().Format("2006-01-02 15:04:05.000000")}),
+	))(s); err != nil {
+		t.Errorf("unexpected Update error, %s\n", err)
+	}
+	if _, err := b.Write(message.WithConfirms(
+		confirms,
+		message.From(ops.Delete, ns, data.Data{"id": 4}),
+	))(s); err != nil {
+		t.Errorf("unexpected Delete error, %s\n", err)
+	}
+
+	var count int
+	if err := s.(*Session).mysqlSession.
+		QueryRow(fmt.Sprintf("SELECT COUNT(id) FROM %s;", batchTestData.Table)).
+		Scan(&count); err != nil {
+		t.Errorf("unable to count table, %s", err)
+	}
+	if count != 10 {
+		t.Errorf("wrong row count before the final flush, expected 10, got %d", count)
+	}
+
+	close(done)
+	wg.Wait()
+
+	if err := s.(*Session).mysqlSession.
+		QueryRow(fmt.Sprintf("SELECT COUNT(id) FROM %s;", batchTestData.Table)).
+		Scan(&count); err != nil {
+		t.Errorf("unable to count table, %s", err)
+	}
+	if count != 11 {
+		t.Errorf("wrong row count, expected 11, got %d", count)
+	}
+	var colvar string
+	if err := s.(*Session).mysqlSession.
+		QueryRow(fmt.Sprintf("SELECT colvar FROM %s WHERE id = 3;", batchTestData.Table)).
+		Scan(&colvar); err != nil {
+		t.Errorf("unable to query updated row, %s", err)
+	}
+	if colvar != "updated" {
+		t.Errorf("wrong colvar, expected updated, got %s", colvar)
+	}
+}
@@ -0,0 +1,756 @@
+package mysql
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/compose/transporter/log"
+)
+
+// column is a column of a table read from the binlog, dataType is the lower case base type as
+// found in INFORMATION_SCHEMA.COLUMNS.DATA_TYPE.
+type column struct {
+	name     string
+	dataType string
+}
+
+// tableSchema holds the columns of a table in the order the binlog sends row values, version
+// is incremented every time the columns change.
+type tableSchema struct {
+	version int
+	columns []column
+}
+
+// schemaCache keeps the columns of every table read from the binlog. Tables are loaded from
+// INFORMATION_SCHEMA the first time they're seen and DDL read from the binlog is applied
+// afterwards, so rows are mapped with the columns the table had when they were written.
+type schemaCache struct {
+	db querier
+
+	mu       sync.Mutex
+	tables   map[string]*tableSchema
+	versions map[string]int
+}
+
+func newSchemaCache(db querier) *schemaCache {
+	return &schemaCache{
+		db:       db,
+		tables:   make(map[string]*tableSchema),
+		versions: make(map[string]int),
+	}
+}
+
+// columns returns the columns of the table, n is the number of values in the rows being
+// mapped. The table is loaded again when the number of columns doesn't match, which happens
+// when a change was made with DDL that couldn't be parsed.
+func (c *schemaCache) columns(schema, table string, n int) ([]column, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ns := fmt.Sprintf("%s.%s", schema, table)
+	ts, ok := c.tables[ns]
+	if ok && len(ts.columns) == n {
+		return ts.columns, nil
+	}
+	if ok {
+		log.With("table", ns).With("version", ts.version).Infof("cached schema has %d columns but the row has %d, reloading", len(ts.columns), n)
+	}
+	columns, err := loadColumns(c.db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	ts = c.set(ns, columns)
+	if len(ts.columns) != n {
+		return nil, fmt.Errorf("table %s has %d columns but the row has %d", ns, len(ts.columns), n)
+	}
+	return ts.columns, nil
+}
+
+func (c *schemaCache) set(ns string, columns []column) *tableSchema {
+	c.versions[ns]++
+	ts := &tableSchema{version: c.versions[ns], columns: columns}
+	c.tables[ns] = ts
+	return ts
+}
+
+// apply updates the cached tables changed by the statement. Tables which aren't cached are
+// loaded when they're next used so only their removal matters.
+func (c *schemaCache) apply(stmt *ddlStatement) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ns := stmt.tables[0].namespace()
+	switch stmt.kind {
+	case ddlCreate:
+		if _, ok := c.tables[ns]; ok && stmt.ifNotExists {
+			break
+		}
+		switch {
+		case stmt.like:
+			if src, ok := c.tables[stmt.tables[1].namespace()]; ok {
+				c.set(ns, append([]column(nil), src.columns...))
+				break
+			}
+			delete(c.tables, ns)
+		case stmt.unknown:
+			delete(c.tables, ns)
+		default:
+			c.set(ns, stmt.columns)
+		}
+	case ddlAlter:
+		ts, ok := c.tables[ns]
+		if !ok {
+			break
+		}
+		if len(stmt.changes) > 0 {
+			columns, err := alterColumns(ts.columns, stmt.changes)
+			if err != nil {
+				log.With("table", ns).Errorf("unable to apply schema change, the table will be reloaded, %s", err)
+				delete(c.tables, ns)
+				break
+			}
+			ts = c.set(ns, columns)
+		}
+		if len(stmt.tables) > 1 {
+			delete(c.tables, ns)
+			c.set(stmt.tables[1].namespace(), ts.columns)
+		}
+	case ddlDrop:
+		for _, t := range stmt.tables {
+			delete(c.tables, t.namespace())
+		}
+	case ddlRename:
+		for i := 0; i+1 < len(stmt.tables); i += 2 {
+			from, to := stmt.tables[i].namespace(), stmt.tables[i+1].namespace()
+			delete(c.tables, to)
+			if ts, ok := c.tables[from]; ok {
+				delete(c.tables, from)
+				c.set(to, ts.columns)
+			}
+		}
+	}
+	if ts, ok := c.tables[ns]; ok {
+		log.With("table", ns).With("version", ts.version).Debugln("schema changed")
+	}
+}
+
+// reset drops every cached table, it's used when a statement changing tables can't be parsed.
+func (c *schemaCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables = make(map[string]*tableSchema)
+}
+
+func loadColumns(db querier, schema, table string) ([]column, error) {
+	rows, err := db.Query(`SELECT COLUMN_NAME, DATA_TYPE FROM INFORMATION_SCHEMA.COLUMNS
+		WHERE TABLE_SCHEMA = ? AND TABLE_NAME = ?
+		ORDER BY ORDINAL_POSITION;`, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []column
+	for rows.Next() {
+		var c column
+		if err := rows.Scan(&c.name, &c.dataType); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+// alterColumns returns a copy of the columns with the changes applied in order.
+func alterColumns(columns []column, changes []columnChange) ([]column, error) {
+	columns = append([]column(nil), columns...)
+	for _, ch := range changes {
+		i := columnIndex(columns, ch.name)
+		switch ch.op {
+		case addColumn:
+			if columnIndex(columns, ch.column.name) >= 0 {
+				return nil, fmt.Errorf("column %s already exists", ch.column.name)
+			}
+		case dropColumn:
+			if i < 0 {
+				return nil, fmt.Errorf("column %s not found", ch.name)
+			}
+			columns = append(columns[:i], columns[i+1:]...)
+			continue
+		case changeColumn:
+			if i < 0 {
+				return nil, fmt.Errorf("column %s not found", ch.name)
+			}
+			if !ch.first && ch.after == "" {
+				columns[i] = ch.column
+				continue
+			}
+			columns = append(columns[:i], columns[i+1:]...)
+		case renameColumn:
+			if i < 0 {
+				return nil, fmt.Errorf("column %s not found", ch.name)
+			}
+			columns[i].name = ch.column.name
+			continue
+		}
+
+		at := len(columns)
+		if ch.first {
+			at = 0
+		} else if ch.after != "" {
+			if at = columnIndex(columns, ch.after); at < 0 {
+				return nil, fmt.Errorf("column %s not found", ch.after)
+			}
+			at++
+		}
+		columns = append(columns[:at], append([]column{ch.column}, columns[at:]...)...)
+	}
+	return columns, nil
+}
+
+// columnIndex finds the column by name, column names aren't case sensitive in MySQL.
+func columnIndex(columns []column, name string) int {
+	for i, c := range columns {
+		if strings.EqualFold(c.name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+type ddlKind int
+
+const (
+	ddlCreate ddlKind = iota
+	ddlAlter
+	ddlDrop
+	ddlRename
+)
+
+type changeOp int
+
+const (
+	addColumn changeOp = iota
+	dropColumn
+	changeColumn
+	renameColumn
+)
+
+// columnChange is a column specification of ALTER TABLE, name is the existing column being
+// dropped, changed or renamed and column is its new definition.
+type columnChange struct {
+	op     changeOp
+	name   string
+	column column
+	first  bool
+	after  string
+}
+
+// tableRef is a table named in a statement, start and end are the offsets of the name in the
+// statement so it can be replaced with the qualified name.
+type tableRef struct {
+	schema     string
+	table      string
+	start, end int
+}
+
+func (t tableRef) namespace() string {
+	return fmt.Sprintf("%s.%s", t.schema, t.table)
+}
+
+// ddlStatement is a statement changing tables read from the binlog. The tables are:
+//
+//	CREATE TABLE: the created table, followed by the source table for CREATE TABLE ... LIKE
+//	ALTER TABLE:  the altered table, followed by its new name when it's renamed
+//	DROP TABLE:   every dropped table
+//	RENAME TABLE: the old and new name of every renamed table
+//
+// unknown is set by CREATE TABLE ... SELECT since the columns depend on the query.
+type ddlStatement struct {
+	kind        ddlKind
+	query       string
+	tables      []tableRef
+	columns     []column
+	changes     []columnChange
+	like        bool
+	ifNotExists bool
+	unknown     bool
+}
+
+// qualified returns the statement with every table name qualified by its schema, so it can
+// be applied without the default database of the session it was run in.
+func (s *ddlStatement) qualified() string {
+	var (
+		b    strings.Builder
+		last int
+	)
+	for _, t := range s.tables {
+		b.WriteString(s.query[last:t.start])
+		fmt.Fprintf(&b, "%s.%s", quoteIdentifier(t.schema), quoteIdentifier(t.table))
+		last = t.end
+	}
+	b.WriteString(s.query[last:])
+	return b.String()
+}
+
+func quoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	identToken
+	stringToken
+	punctToken
+)
+
+type token struct {
+	kind       tokenKind
+	text       string
+	start, end int
+}
+
+// tokenize splits a statement into words, backtick quoted identifiers, strings and single
+// punctuation characters, comments are skipped.
+func tokenize(query string) []token {
+	var tokens []token
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return tokens
+			}
+			i += end + 4
+		case c == '#' || strings.HasPrefix(query[i:], "-- "):
+			end := strings.IndexByte(query[i:], '\n')
+			if end < 0 {
+				return tokens
+			}
+			i += end + 1
+		case c == '`' || c == '\'' || c == '"':
+			start := i
+			var text strings.Builder
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' && c != '`' && i+1 < len(query) {
+					i++
+				} else if query[i] == c {
+					if i+1 < len(query) && query[i+1] == c {
+						i++
+					} else {
+						break
+					}
+				}
+				text.WriteByte(query[i])
+			}
+			i++
+			kind := stringToken
+			if c == '`' {
+				kind = identToken
+			}
+			tokens = append(tokens, token{kind: kind, text: text.String(), start: start, end: i})
+		case isWordChar(c):
+			start := i
+			for i < len(query) && isWordChar(query[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: wordToken, text: query[start:i], start: start, end: i})
+		default:
+			tokens = append(tokens, token{kind: punctToken, text: string(c), start: i, end: i + 1})
+			i++
+		}
+	}
+	return tokens
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= 0x80
+}
+
+type ddlParser struct {
+	tokens        []token
+	pos           int
+	defaultSchema string
+}
+
+// parseDDL parses the statements changing the columns or names of tables, CREATE, ALTER, DROP
+// and RENAME TABLE. Unqualified table names belong to defaultSchema. nil is returned for any
+// other statement, including ones on temporary tables which aren't in the binlog.
+func parseDDL(query, defaultSchema string) (*ddlStatement, error) {
+	p := &ddlParser{tokens: tokenize(query), defaultSchema: defaultSchema}
+	stmt := &ddlStatement{query: query}
+	var err error
+	switch {
+	case p.accept("CREATE", "TABLE"):
+		stmt.kind = ddlCreate
+		err = p.createTable(stmt)
+	case p.accept("ALTER"):
+		p.accept("ONLINE")
+		p.accept("IGNORE")
+		if !p.accept("TABLE") {
+			return nil, nil
+		}
+		stmt.kind = ddlAlter
+		err = p.alterTable(stmt)
+	case p.accept("DROP", "TABLE"):
+		stmt.kind = ddlDrop
+		err = p.dropTable(stmt)
+	case p.accept("RENAME", "TABLE"):
+		stmt.kind = ddlRename
+		err = p.renameTable(stmt)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %q, %s", query, err)
+	}
+	return stmt, nil
+}
+
+func (p *ddlParser) done() bool {
+	return p.pos >= len(p.tokens) || p.tokens[p.pos].text == ";"
+}
+
+func (p *ddlParser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: punctToken}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *ddlParser) isKeyword(keywords ...string) bool {
+	t := p.peek()
+	if t.kind != wordToken {
+		return false
+	}
+	for _, kw := range keywords {
+		if strings.EqualFold(t.text, kw) {
+			return true
+		}
+	}
+	return false
+}
+
+// accept consumes the keywords when the next tokens match all of them.
+func (p *ddlParser) accept(keywords ...string) bool {
+	for i, kw := range keywords {
+		if p.pos+i >= len(p.tokens) {
+			return false
+		}
+		t := p.tokens[p.pos+i]
+		if t.kind != wordToken || !strings.EqualFold(t.text, kw) {
+			return false
+		}
+	}
+	p.pos += len(keywords)
+	return true
+}
+
+func (p *ddlParser) acceptPunct(s string) bool {
+	if t := p.peek(); t.kind == punctToken && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ddlParser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind != wordToken && t.kind != identToken {
+		return "", fmt.Errorf("expected an identifier at offset %d", t.start)
+	}
+	p.pos++
+	return t.text, nil
+}
+
+func (p *ddlParser) tableName() (tableRef, error) {
+	start := p.peek().start
+	name, err := p.identifier()
+	if err != nil {
+		return tableRef{}, err
+	}
+	ref := tableRef{schema: p.defaultSchema, table: name, start: start, end: p.tokens[p.pos-1].end}
+	if p.acceptPunct(".") {
+		if ref.table, err = p.identifier(); err != nil {
+			return tableRef{}, err
+		}
+		ref.schema = name
+		ref.end = p.tokens[p.pos-1].end
+	}
+	return ref, nil
+}
+
+// skip moves past the rest of the current definition, up to the next comma or closing
+// parenthesis outside of any parentheses.
+func (p *ddlParser) skip() {
+	depth := 0
+	for ; !p.done(); p.pos++ {
+		t := p.tokens[p.pos]
+		if t.kind != punctToken {
+			continue
+		}
+		switch t.text {
+		case "(":
+			depth++
+		case ")":
+			if depth == 0 {
+				return
+			}
+			depth--
+		case ",":
+			if depth == 0 {
+				return
+			}
+		}
+	}
+}
+
+// columnDefinition parses the name and type of a column and skips its attributes, FIRST and
+// AFTER are returned for ALTER TABLE.
+func (p *ddlParser) columnDefinition(ch *columnChange) error {
+	name, err := p.identifier()
+	if err != nil {
+		return err
+	}
+	t := p.peek()
+	if t.kind != wordToken {
+		return fmt.Errorf("expected the type of column %s", name)
+	}
+	ch.column = column{name: name, dataType: dataType(t.text)}
+	depth := 0
+	for ; !p.done(); p.pos++ {
+		t := p.tokens[p.pos]
+		if t.kind == punctToken {
+			switch {
+			case t.text == "(":
+				depth++
+			case t.text == ")" && depth == 0, t.text == "," && depth == 0:
+				return nil
+			case t.text == ")":
+				depth--
+			}
+			continue
+		}
+		if depth > 0 || t.kind != wordToken {
+			continue
+		}
+		if strings.EqualFold(t.text, "FIRST") {
+			ch.first = true
+		} else if strings.EqualFold(t.text, "AFTER") && p.pos+1 < len(p.tokens) {
+			p.pos++
+			ch.after = p.tokens[p.pos].text
+		}
+	}
+	return nil
+}
+
+// dataType normalizes a column type to the name used by INFORMATION_SCHEMA.
+func dataType(t string) string {
+	t = strings.ToLower(t)
+	switch t {
+	case "integer":
+		return "int"
+	case "bool", "boolean":
+		return "tinyint"
+	case "dec", "numeric", "fixed":
+		return "decimal"
+	case "real":
+		return "double"
+	case "character":
+		return "char"
+	case "serial":
+		return "bigint"
+	}
+	return t
+}
+
+var indexKeywords = []string{"PRIMARY", "KEY", "INDEX", "UNIQUE", "CONSTRAINT", "FOREIGN", "FULLTEXT", "SPATIAL", "CHECK"}
+
+func (p *ddlParser) createTable(stmt *ddlStatement) error {
+	stmt.ifNotExists = p.accept("IF", "NOT", "EXISTS")
+	ref, err := p.tableName()
+	if err != nil {
+		return err
+	}
+	stmt.tables = append(stmt.tables, ref)
+	paren := p.acceptPunct("(")
+	if p.accept("LIKE") {
+		src, err := p.tableName()
+		if err != nil {
+			return err
+		}
+		stmt.tables = append(stmt.tables, src)
+		stmt.like = true
+		return nil
+	}
+	if !paren {
+		stmt.unknown = true
+		return nil
+	}
+	for !p.done() {
+		if p.isKeyword(indexKeywords...) {
+			p.skip()
+		} else {
+			var ch columnChange
+			if err := p.columnDefinition(&ch); err != nil {
+				return err
+			}
+			stmt.columns = append(stmt.columns, ch.column)
+		}
+		if p.acceptPunct(")") {
+			break
+		}
+		if !p.acceptPunct(",") {
+			return fmt.Errorf("expected ',' at offset %d", p.peek().start)
+		}
+	}
+	// columns selected by CREATE TABLE ... SELECT are added after the defined ones
+	for ; !p.done(); p.pos++ {
+		if p.isKeyword("SELECT") {
+			stmt.unknown = true
+		}
+	}
+	return nil
+}
+
+func (p *ddlParser) alterTable(stmt *ddlStatement) error {
+	ref, err := p.tableName()
+	if err != nil {
+		return err
+	}
+	stmt.tables = append(stmt.tables, ref)
+	for !p.done() {
+		if err := p.alterSpecification(stmt); err != nil {
+			return err
+		}
+		p.skip()
+		if !p.acceptPunct(",") && !p.done() {
+			return fmt.Errorf("unexpected %q at offset %d", p.peek().text, p.peek().start)
+		}
+	}
+	return nil
+}
+
+// alterSpecification parses a single specification of ALTER TABLE, the ones not changing
+// columns or the table name are left for the caller to skip.
+func (p *ddlParser) alterSpecification(stmt *ddlStatement) error {
+	switch {
+	case p.accept("ADD"):
+		if p.isKeyword(indexKeywords...) || p.isKeyword("PARTITION") {
+			return nil
+		}
+		p.accept("COLUMN")
+		if !p.acceptPunct("(") {
+			ch := columnChange{op: addColumn}
+			if err := p.columnDefinition(&ch); err != nil {
+				return err
+			}
+			stmt.changes = append(stmt.changes, ch)
+			return nil
+		}
+		for {
+			ch := columnChange{op: addColumn}
+			if err := p.columnDefinition(&ch); err != nil {
+				return err
+			}
+			stmt.changes = append(stmt.changes, ch)
+			if !p.acceptPunct(",") {
+				break
+			}
+		}
+		if !p.acceptPunct(")") {
+			return fmt.Errorf("expected ')' at offset %d", p.peek().start)
+		}
+	case p.accept("DROP"):
+		if p.isKeyword(indexKeywords...) || p.isKeyword("PARTITION", "DEFAULT") {
+			return nil
+		}
+		p.accept("COLUMN")
+		name, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		stmt.changes = append(stmt.changes, columnChange{op: dropColumn, name: name})
+	case p.accept("CHANGE"):
+		p.accept("COLUMN")
+		name, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		ch := columnChange{op: changeColumn, name: name}
+		if err := p.columnDefinition(&ch); err != nil {
+			return err
+		}
+		stmt.changes = append(stmt.changes, ch)
+	case p.accept("MODIFY"):
+		p.accept("COLUMN")
+		ch := columnChange{op: changeColumn, name: p.peek().text}
+		if err := p.columnDefinition(&ch); err != nil {
+			return err
+		}
+		stmt.changes = append(stmt.changes, ch)
+	case p.accept("RENAME", "COLUMN"):
+		name, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		if !p.accept("TO") {
+			return fmt.Errorf("expected TO at offset %d", p.peek().start)
+		}
+		to, err := p.identifier()
+		if err != nil {
+			return err
+		}
+		stmt.changes = append(stmt.changes, columnChange{op: renameColumn, name: name, column: column{name: to}})
+	case p.accept("RENAME"):
+		if p.isKeyword("INDEX", "KEY") {
+			return nil
+		}
+		if !p.accept("TO") {
+			p.accept("AS")
+		}
+		ref, err := p.tableName()
+		if err != nil {
+			return err
+		}
+		stmt.tables = append(stmt.tables, ref)
+	}
+	return nil
+}
+
+func (p *ddlParser) dropTable(stmt *ddlStatement) error {
+	p.accept("IF", "EXISTS")
+	for {
+		ref, err := p.tableName()
+		if err != nil {
+			return err
+		}
+		stmt.tables = append(stmt.tables, ref)
+		if !p.acceptPunct(",") {
+			return nil
+		}
+	}
+}
+
+func (p *ddlParser) renameTable(stmt *ddlStatement) error {
+	for {
+		from, err := p.tableName()
+		if err != nil {
+			return err
+		}
+		if !p.accept("TO") {
+			return fmt.Errorf("expected TO at offset %d", p.peek().start)
+		}
+		to, err := p.tableName()
+		if err != nil {
+			return err
+		}
+		stmt.tables = append(stmt.tables, from, to)
+		if !p.acceptPunct(",") {
+			return nil
+		}
+	}
+}
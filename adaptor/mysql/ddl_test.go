@@ -0,0 +1,152 @@
+package mysql
+
+import (
+	"reflect"
+	"testing"
+)
+
+var (
+	recipeColumns = []column{{"recipe_id", "int"}, {"recipe_name", "varchar"}, {"recipe_rating", "int"}}
+)
+
+var parseDDLTests = []struct {
+	name      string
+	query     string
+	qualified string
+	columns   []column
+}{
+	{
+		"not ddl",
+		"INSERT INTO recipes VALUES (1, 'Tacos', 5)",
+		"",
+		nil,
+	},
+	{
+		"temporary table",
+		"CREATE TEMPORARY TABLE scratch (id INT)",
+		"",
+		nil,
+	},
+	{
+		"create table",
+		"CREATE TABLE recipes (recipe_id INTEGER NOT NULL AUTO_INCREMENT, recipe_name VARCHAR(255) DEFAULT 'a, b', recipe_rating DECIMAL(3,1), PRIMARY KEY (recipe_id), KEY name (recipe_name))",
+		"CREATE TABLE `test`.`recipes` (recipe_id INTEGER NOT NULL AUTO_INCREMENT, recipe_name VARCHAR(255) DEFAULT 'a, b', recipe_rating DECIMAL(3,1), PRIMARY KEY (recipe_id), KEY name (recipe_name))",
+		[]column{{"recipe_id", "int"}, {"recipe_name", "varchar"}, {"recipe_rating", "decimal"}},
+	},
+	{
+		"add column",
+		"ALTER TABLE recipes ADD COLUMN recipe_author TEXT AFTER recipe_name, ADD INDEX rating (recipe_rating)",
+		"ALTER TABLE `test`.`recipes` ADD COLUMN recipe_author TEXT AFTER recipe_name, ADD INDEX rating (recipe_rating)",
+		[]column{{"recipe_id", "int"}, {"recipe_name", "varchar"}, {"recipe_author", "text"}, {"recipe_rating", "int"}},
+	},
+	{
+		"add columns",
+		"alter table `test`.`recipes` add (created DATETIME, `updated` DATETIME) /* comment */",
+		"alter table `test`.`recipes` add (created DATETIME, `updated` DATETIME) /* comment */",
+		[]column{{"recipe_id", "int"}, {"recipe_name", "varchar"}, {"recipe_rating", "int"}, {"created", "datetime"}, {"updated", "datetime"}},
+	},
+	{
+		"drop column",
+		"ALTER TABLE recipes DROP COLUMN recipe_name, DROP PRIMARY KEY",
+		"ALTER TABLE `test`.`recipes` DROP COLUMN recipe_name, DROP PRIMARY KEY",
+		[]column{{"recipe_id", "int"}, {"recipe_rating", "int"}},
+	},
+	{
+		"change column",
+		"ALTER TABLE recipes CHANGE recipe_rating rating FLOAT FIRST, MODIFY recipe_name TEXT",
+		"ALTER TABLE `test`.`recipes` CHANGE recipe_rating rating FLOAT FIRST, MODIFY recipe_name TEXT",
+		[]column{{"rating", "float"}, {"recipe_id", "int"}, {"recipe_name", "text"}},
+	},
+	{
+		"rename column",
+		"ALTER TABLE recipes RENAME COLUMN recipe_name TO name, ALTER COLUMN recipe_rating SET DEFAULT 0",
+		"ALTER TABLE `test`.`recipes` RENAME COLUMN recipe_name TO name, ALTER COLUMN recipe_rating SET DEFAULT 0",
+		[]column{{"recipe_id", "int"}, {"name", "varchar"}, {"recipe_rating", "int"}},
+	},
+	{
+		"rename table",
+		"RENAME TABLE recipes TO other.dishes",
+		"RENAME TABLE `test`.`recipes` TO `other`.`dishes`",
+		nil,
+	},
+	{
+		"drop table",
+		"DROP TABLE IF EXISTS `recipes`, `test`.`other` /* generated by server */",
+		"DROP TABLE IF EXISTS `test`.`recipes`, `test`.`other` /* generated by server */",
+		nil,
+	},
+}
+
+func TestParseDDL(t *testing.T) {
+	for _, pt := range parseDDLTests {
+		t.Run(pt.name, func(t *testing.T) {
+			stmt, err := parseDDL(pt.query, "test")
+			if err != nil {
+				t.Fatalf("unexpected parseDDL error, %s", err)
+			}
+			if pt.qualified == "" {
+				if stmt != nil {
+					t.Fatalf("expected no statement, got %+v", stmt)
+				}
+				return
+			}
+			if stmt == nil {
+				t.Fatal("expected a statement, got nil")
+			}
+			if got := stmt.qualified(); got != pt.qualified {
+				t.Errorf("wrong qualified statement, expected %s, got %s", pt.qualified, got)
+			}
+
+			c := newSchemaCache(nil)
+			c.set("test.recipes", recipeColumns)
+			c.apply(stmt)
+			ts, ok := c.tables["test.recipes"]
+			if pt.columns == nil {
+				if ok {
+					t.Errorf("expected test.recipes to be removed, got %+v", ts.columns)
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected test.recipes to be cached")
+			}
+			if !reflect.DeepEqual(ts.columns, pt.columns) {
+				t.Errorf("wrong columns, expected %+v, got %+v", pt.columns, ts.columns)
+			}
+			if ts.version != 2 {
+				t.Errorf("wrong version, expected 2, got %d", ts.version)
+			}
+		})
+	}
+}
+
+func TestSchemaCacheRename(t *testing.T) {
+	c := newSchemaCache(nil)
+	c.set("test.recipes", recipeColumns)
+	for _, query := range []string{
+		"RENAME TABLE recipes TO dishes",
+		"ALTER TABLE dishes DROP recipe_rating, RENAME TO meals",
+	} {
+		stmt, err := parseDDL(query, "test")
+		if err != nil {
+			t.Fatalf("unexpected parseDDL error, %s", err)
+		}
+		c.apply(stmt)
+	}
+	if _, ok := c.tables["test.recipes"]; ok {
+		t.Error("expected test.recipes to be removed")
+	}
+	ts, ok := c.tables["test.meals"]
+	if !ok {
+		t.Fatal("expected test.meals to be cached")
+	}
+	if !reflect.DeepEqual(ts.columns, recipeColumns[:2]) {
+		t.Errorf("wrong columns, expected %+v, got %+v", recipeColumns[:2], ts.columns)
+	}
+}
+
+func TestParseDDLError(t *testing.T) {
+	if _, err := parseDDL("ALTER TABLE recipes CHANGE COLUMN", "test"); err == nil {
+		t.Error("expected an error for an incomplete statement")
+	}
+}
@@ -2,9 +2,12 @@ package mysql
 
 import (
 	"sync"
+	"time"
 
 	"github.com/compose/transporter/adaptor"
 	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/offset"
 
 	//_ "github.com/go-sql-driver/mysql" // import mysql driver
 	_ "github.com/go-mysql-org/go-mysql/driver" // import alternative mysql driver
@@ -16,22 +19,43 @@ const (
 	sampleConfig = `{
   "uri": "${MYSQL_URI}",
   // "tail": false,
+  // "server_id": 0,
+  // "emit_ddl": false,
   // "cacert": "/path/to/cert.pem",
   // "servername": "${MYSQL_DOMAIN}",
+  // "batch_size": 0,
+  // "batch_timeout": "1s",
+  // "upsert": false,
+  // "auto_schema": false,
+  // "apply_ddl": false,
+  // "type_overrides": {"mydb.users": {"age": "INT"}}
 }`
 )
 
 var (
-	_ adaptor.Adaptor = &mysql{}
+	_ adaptor.Adaptor       = &mysql{}
+	_ adaptor.Snapshotter   = &mysql{}
+	_ adaptor.OffsetTracker = &mysql{}
 )
 
 // MySQL is an adaptor to read / write to mysql.
 // it works as a source by copying files, and then optionally tailing the binlog
 type mysql struct {
 	adaptor.BaseConfig
-	Tail       bool   `json:"tail" doc:"if tail is true, then the mysql source will tail the binlog after copying the namespace"`
-	CACert     string `json:"cacert" doc:"path to CA cert"`
-	ServerName string `json:"servername" doc:"if a separate servername is needed to verify the certificate against. Requires cacert"`
+	Tail          bool                         `json:"tail" doc:"if tail is true, then the mysql source will tail the binlog after copying the namespace"`
+	ServerID      uint32                       `json:"server_id" doc:"server_id the tailer registers with as a replica, it must differ from the source and any other replica, a random one is used when 0"`
+	EmitDDL       bool                         `json:"emit_ddl" doc:"if emit_ddl is true, DDL read from the binlog is sent as command messages"`
+	CACert        string                       `json:"cacert" doc:"path to CA cert"`
+	ServerName    string                       `json:"servername" doc:"if a separate servername is needed to verify the certificate against. Requires cacert"`
+	OffsetTable   string                       `json:"offset_table" doc:"table used to store the sink offsets when the offset_store is sink, defaults to transporter_offsets"`
+	BatchSize     int                          `json:"batch_size" doc:"number of messages written in a single transaction, batching is disabled when 0"`
+	BatchTimeout  string                       `json:"batch_timeout" doc:"how long messages are buffered before the transaction is committed, defaults to 1s"`
+	Upsert        bool                         `json:"upsert" doc:"if upsert is true, inserts update the existing row when the primary key already exists"`
+	AutoSchema    bool                         `json:"auto_schema" doc:"if auto_schema is true, missing tables and columns are created based on the messages written"`
+	TypeOverrides map[string]map[string]string `json:"type_overrides" doc:"column types used by auto_schema instead of the inferred ones, keyed by namespace and then field"`
+	ApplyDDL      bool                         `json:"apply_ddl" doc:"if apply_ddl is true, DDL received in command messages is run against the sink"`
+
+	offsets *offsetStore
 }
 
 func init() {
@@ -50,13 +74,56 @@ func (m *mysql) Client() (client.Client, error) {
 
 func (m *mysql) Reader() (client.Reader, error) {
 	if m.Tail {
-		return newTailer(m.URI), nil
+		return newTailer(m.URI, m.ServerID, m.EmitDDL), nil
 	}
 	return newReader(), nil
 }
 
+// SnapshotReader returns a Reader which copies every table without tailing changes.
+func (m *mysql) SnapshotReader() (client.Reader, error) {
+	return newReader(), nil
+}
+
 func (m *mysql) Writer(done chan struct{}, wg *sync.WaitGroup) (client.Writer, error) {
-	return newWriter(), nil
+	var sc *schema
+	if m.AutoSchema {
+		sc = newSchema(m.TypeOverrides)
+	}
+	if m.BatchSize > 0 {
+		interval := DefaultBatchTimeout
+		if m.BatchTimeout != "" {
+			t, err := time.ParseDuration(m.BatchTimeout)
+			if err != nil {
+				return nil, client.InvalidTimeoutError{Timeout: m.BatchTimeout}
+			}
+			interval = t
+		}
+		b := newBatcher(m.BatchSize, interval, m.Upsert, m.offsets, done, wg)
+		b.schema = sc
+		if m.ApplyDDL {
+			b.writeMap[ops.Command] = ddlMsg
+		}
+		return b, nil
+	}
+	w := newWriter(m.Upsert)
+	w.offsets = m.offsets
+	w.schema = sc
+	if m.ApplyDDL {
+		w.writeMap[ops.Command] = ddlMsg
+	}
+	return w, nil
+}
+
+// OffsetManager stores the offsets of the named sink in the offset_table, the Writer
+// created afterwards saves each offset in the same transaction as its message.
+func (m *mysql) OffsetManager(name string) (offset.Manager, error) {
+	c, err := NewClient(WithURI(m.URI),
+		WithCustomTLS(m.URI, m.CACert, m.ServerName))
+	if err != nil {
+		return nil, err
+	}
+	m.offsets = newOffsetStore(c, m.OffsetTable, name)
+	return offset.NewStoreManager(m.offsets)
 }
 
 // Description for mysql adaptor
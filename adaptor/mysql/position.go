@@ -0,0 +1,171 @@
+package mysql
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/log"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+// BinlogPurgedError is returned when the position the Tailer resumes from is no longer in the
+// binlog kept by the server.
+type BinlogPurgedError struct {
+	Position string
+}
+
+func (e BinlogPurgedError) Error() string {
+	return fmt.Sprintf("binlog position %s has been purged, remove the commit log to copy the tables again", e.Position)
+}
+
+// binlogPosition is where a transaction ends in the binlog, it's stored as JSON in the Position
+// of every message read from the binlog. GTID is the set of transactions executed up to the
+// position, it's only set when the server uses GTIDs and takes precedence over File and Pos
+// when resuming.
+type binlogPosition struct {
+	File string `json:"file"`
+	Pos  uint32 `json:"pos"`
+	GTID string `json:"gtid,omitempty"`
+
+	gset gomysql.GTIDSet
+	next string
+}
+
+func (p *binlogPosition) String() string {
+	if p.GTID != "" {
+		return p.GTID
+	}
+	return fmt.Sprintf("%s:%d", p.File, p.Pos)
+}
+
+func (p *binlogPosition) position() gomysql.Position {
+	return gomysql.Position{Name: p.File, Pos: p.Pos}
+}
+
+func (p *binlogPosition) marshal() []byte {
+	b, _ := json.Marshal(p)
+	return b
+}
+
+func parseBinlogPosition(b []byte) (*binlogPosition, error) {
+	p := &binlogPosition{}
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, fmt.Errorf("invalid binlog position %s, %s", b, err)
+	}
+	if p.GTID != "" {
+		gset, err := gomysql.ParseMysqlGTIDSet(p.GTID)
+		if err != nil {
+			return nil, err
+		}
+		p.gset = gset
+	}
+	return p, nil
+}
+
+// update moves the position to the end of the event. GTIDs are only added to the set once
+// their transaction is committed.
+func (p *binlogPosition) update(event *replication.BinlogEvent) {
+	if event.Header.LogPos > 0 {
+		p.Pos = event.Header.LogPos
+	}
+	switch e := event.Event.(type) {
+	case *replication.RotateEvent:
+		p.File = string(e.NextLogName)
+		p.Pos = uint32(e.Position)
+	case *replication.GTIDEvent:
+		if e.GNO > 0 && len(e.SID) == 16 {
+			sid := e.SID
+			p.next = fmt.Sprintf("%x-%x-%x-%x-%x:%d", sid[0:4], sid[4:6], sid[6:8], sid[8:10], sid[10:16], e.GNO)
+		}
+	}
+}
+
+// commit adds the GTID of the transaction which just ended to the set.
+func (p *binlogPosition) commit() error {
+	if p.next == "" || p.gset == nil {
+		return nil
+	}
+	if err := p.gset.Update(p.next); err != nil {
+		return err
+	}
+	p.next = ""
+	p.GTID = p.gset.String()
+	return nil
+}
+
+// resumePosition returns the newest position found in the resumeMap, nil is returned when no
+// message read from the binlog has been stored so the tables need to be copied.
+func resumePosition(resumeMap map[string]client.MessageSet) (*binlogPosition, error) {
+	var newest *binlogPosition
+	for _, m := range resumeMap {
+		if len(m.Position) == 0 {
+			continue
+		}
+		p, err := parseBinlogPosition(m.Position)
+		if err != nil {
+			return nil, err
+		}
+		if newest == nil || newer(p, newest) {
+			newest = p
+		}
+	}
+	return newest, nil
+}
+
+func newer(p, o *binlogPosition) bool {
+	if p.gset != nil && o.gset != nil {
+		return p.gset.Contain(o.gset) && !p.gset.Equal(o.gset)
+	}
+	return p.position().Compare(o.position()) > 0
+}
+
+// checkPurged returns a BinlogPurgedError when the server no longer has the binlog needed to
+// resume from p. With GTIDs, every purged transaction needs to be in the set already executed.
+func checkPurged(db *sql.DB, p *binlogPosition) error {
+	if p.gset != nil {
+		var purged string
+		if err := db.QueryRow("SELECT @@GLOBAL.gtid_purged;").Scan(&purged); err != nil {
+			return err
+		}
+		purgedSet, err := gomysql.ParseMysqlGTIDSet(strings.Replace(purged, "\n", "", -1))
+		if err != nil {
+			return err
+		}
+		if !p.gset.Contain(purgedSet) {
+			return BinlogPurgedError{Position: p.String()}
+		}
+		return nil
+	}
+	rows, err := db.Query("SHOW BINARY LOGS;")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		if string(values[0]) == p.File {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	log.With("file", p.File).Errorln("binlog file not found in SHOW BINARY LOGS")
+	return BinlogPurgedError{Position: p.String()}
+}
@@ -0,0 +1,85 @@
+package mysql
+
+import (
+	"testing"
+
+	"github.com/compose/transporter/client"
+	"github.com/go-mysql-org/go-mysql/replication"
+)
+
+var (
+	sid = []byte{0xa8, 0x52, 0x98, 0x9a, 0x18, 0x94, 0x4f, 0xcb, 0xa0, 0x60, 0xa4, 0xaa, 0xaf, 0x06, 0xb9, 0xf0}
+
+	binlogEvents = []*replication.BinlogEvent{
+		{Header: &replication.EventHeader{}, Event: &replication.RotateEvent{Position: 4, NextLogName: []byte("master-bin.000002")}},
+		{Header: &replication.EventHeader{LogPos: 200}, Event: &replication.GTIDEvent{SID: sid, GNO: 56}},
+		{Header: &replication.EventHeader{LogPos: 300}, Event: &replication.QueryEvent{Query: []byte("BEGIN")}},
+		{Header: &replication.EventHeader{LogPos: 400}, Event: &replication.XIDEvent{XID: 1}},
+	}
+)
+
+func TestBinlogPosition(t *testing.T) {
+	p, err := parseBinlogPosition([]byte(`{"file":"master-bin.000001","pos":163739,"gtid":"a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-55"}`))
+	if err != nil {
+		t.Fatalf("unexpected parseBinlogPosition error, %s", err)
+	}
+	for _, e := range binlogEvents {
+		p.update(e)
+		if isCommit(e) {
+			if err := p.commit(); err != nil {
+				t.Fatalf("unexpected commit error, %s", err)
+			}
+		}
+	}
+	expected := binlogPosition{File: "master-bin.000002", Pos: 400, GTID: "a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-56"}
+	if p.File != expected.File || p.Pos != expected.Pos || p.GTID != expected.GTID {
+		t.Errorf("wrong position, expected %+v, got %+v", expected, p)
+	}
+
+	if _, err := parseBinlogPosition([]byte("16/B374D848")); err == nil {
+		t.Error("expected an error for an invalid position")
+	}
+}
+
+var resumePositionTests = []struct {
+	name      string
+	positions []string
+	expected  string
+}{
+	{
+		"no positions",
+		[]string{"", ""},
+		"",
+	},
+	{
+		"file positions",
+		[]string{`{"file":"bin.000002","pos":4}`, `{"file":"bin.000001","pos":900}`, ""},
+		"bin.000002:4",
+	},
+	{
+		"gtid",
+		[]string{`{"file":"bin.000001","pos":900,"gtid":"a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-55"}`, `{"file":"bin.000001","pos":100,"gtid":"a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-20"}`},
+		"a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-55",
+	},
+}
+
+func TestResumePosition(t *testing.T) {
+	for _, rt := range resumePositionTests {
+		resumeMap := make(map[string]client.MessageSet)
+		for i, p := range rt.positions {
+			resumeMap[string(rune('a'+i))] = client.MessageSet{Position: []byte(p)}
+		}
+		p, err := resumePosition(resumeMap)
+		if err != nil {
+			t.Errorf("[%s] unexpected resumePosition error, %s", rt.name, err)
+			continue
+		}
+		var actual string
+		if p != nil {
+			actual = p.String()
+		}
+		if actual != rt.expected {
+			t.Errorf("[%s] wrong position, expected %s, got %s", rt.name, rt.expected, actual)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package mysql
 
 import (
+	"context"
 	"encoding/hex"
 	"database/sql"
 	"fmt"
@@ -24,6 +25,10 @@ var (
 
 // Reader implements the behaviour defined by client.Reader for interfacing with MySQL.
 type Reader struct {
+	// conn is a connection with a consistent snapshot transaction started, as returned by
+	// consistentSnapshot. The tables are read from it when set and it's closed once the copy
+	// is done.
+	conn *sql.Conn
 }
 
 func newReader() client.Reader {
@@ -34,15 +39,23 @@ func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 	return func(s client.Session, done chan struct{}) (chan client.MessageSet, error) {
 		out := make(chan client.MessageSet)
 		session := s.(*Session)
+		var q querier = session.mysqlSession
+		if r.conn != nil {
+			q = connQuerier{r.conn}
+		}
 		go func() {
 			defer close(out)
+			if r.conn != nil {
+				defer r.conn.Close()
+				defer r.conn.ExecContext(context.Background(), "ROLLBACK;")
+			}
 			log.With("db", session.db).Infoln("starting Read func")
-			tables, err := r.listTables(session.db, session.mysqlSession, filterFn)
+			tables, err := r.listTables(session.db, q, filterFn)
 			if err != nil {
 				log.With("db", session.db).Errorf("unable to list tables, %s", err)
 				return
 			}
-			results := r.iterateTable(session.db, session.mysqlSession, tables, done)
+			results := r.iterateTable(session.db, q, tables, done)
 			for {
 				select {
 				case <-done:
@@ -63,23 +76,28 @@ func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 	}
 }
 
-func (r *Reader) listTables(db string, session *sql.DB, filterFn func(name string) bool) (<-chan string, error) {
-	out := make(chan string)
+// listTables reads every table name before sending them since a single connection can't run
+// the queries of iterateTable while the tables are still being read.
+func (r *Reader) listTables(db string, session querier, filterFn func(name string) bool) (<-chan string, error) {
 	tablesResult, err := session.Query("SELECT table_schema, table_name FROM INFORMATION_SCHEMA.TABLES")
 	if err != nil {
 		return nil, err
 	}
+	var names []string
+	for tablesResult.Next() {
+		var schema string
+		var tname string
+		err = tablesResult.Scan(&schema, &tname)
+		if err != nil {
+			log.With("db", db).Infoln("error scanning table name...")
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s.%s", schema, tname))
+	}
+	out := make(chan string)
 	go func() {
 		defer close(out)
-		for tablesResult.Next() {
-			var schema string
-			var tname string
-			err = tablesResult.Scan(&schema, &tname)
-			if err != nil {
-				log.With("db", db).Infoln("error scanning table name...")
-				continue
-			}
-			name := fmt.Sprintf("%s.%s", schema, tname)
+		for _, name := range names {
 			if filterFn(name) && matchFunc(name) {
 				log.With("db", db).With("table", name).Infoln("sending for iteration...")
 				out <- name
@@ -108,7 +126,7 @@ type doc struct {
 	data  data.Data
 }
 
-func (r *Reader) iterateTable(db string, session *sql.DB, in <-chan string, done chan struct{}) <-chan doc {
+func (r *Reader) iterateTable(db string, session querier, in <-chan string, done chan struct{}) <-chan doc {
 	out := make(chan doc)
 	go func() {
 		defer close(out)
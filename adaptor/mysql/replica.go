@@ -0,0 +1,107 @@
+package mysql
+
+import (
+	"database/sql"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/compose/transporter/log"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// minServerID is the lowest server_id picked for the Tailer when none is configured, lower ids
+// are usually given to the servers of a replication topology.
+const minServerID = 1001
+
+// BinlogFormatError is returned when the server doesn't log the values of every changed row,
+// which the Tailer needs to build messages.
+type BinlogFormatError struct {
+	Format string
+}
+
+func (e BinlogFormatError) Error() string {
+	return fmt.Sprintf("binlog_format is %s, tailing requires binlog_format=ROW", e.Format)
+}
+
+// PrivilegeError is returned when the user can't read the binlog, Privilege is the one needed
+// for the operation which failed.
+type PrivilegeError struct {
+	Privilege string
+	Err       error
+}
+
+func (e PrivilegeError) Error() string {
+	return fmt.Sprintf("the %s privilege is required to tail the binlog, %s", e.Privilege, e.Err)
+}
+
+// ServerIDError is returned when the server_id configured for the Tailer is the server_id of
+// the source, the source refuses replicas using its own server_id.
+type ServerIDError struct {
+	ServerID uint32
+}
+
+func (e ServerIDError) Error() string {
+	return fmt.Sprintf("server_id %d is the server_id of the source, set server_id to a value unique among its replicas", e.ServerID)
+}
+
+// checkBinlogFormat returns a BinlogFormatError unless rows are logged.
+func checkBinlogFormat(db *sql.DB) error {
+	var format string
+	if err := db.QueryRow("SELECT @@GLOBAL.binlog_format;").Scan(&format); err != nil {
+		return fmt.Errorf("unable to read binlog_format, %s", err)
+	}
+	if !strings.EqualFold(format, "ROW") {
+		return BinlogFormatError{Format: format}
+	}
+	return nil
+}
+
+// replicaServerID returns the server_id the Tailer registers with, a random one is picked when
+// serverID is 0. Replicas of the same source need distinct ids, so the picked id is logged for
+// it to be set in the configuration when running more than one pipeline.
+func replicaServerID(db *sql.DB, serverID uint32) (uint32, error) {
+	var sourceID uint32
+	if err := db.QueryRow("SELECT @@server_id;").Scan(&sourceID); err != nil {
+		return 0, fmt.Errorf("unable to read the server_id of the source, %s", err)
+	}
+	if serverID != 0 && serverID == sourceID {
+		return 0, ServerIDError{ServerID: serverID}
+	}
+	if serverID != 0 {
+		return serverID, nil
+	}
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	for serverID == 0 || serverID == sourceID {
+		serverID = minServerID + uint32(r.Int31n(1<<31-minServerID))
+	}
+	log.With("server_id", serverID).Infoln("server_id not set, using a random one")
+	return serverID, nil
+}
+
+// causer is implemented by the errors returned by go-mysql, which wrap the error returned by
+// the server.
+type causer interface {
+	Cause() error
+}
+
+// privilegeError returns a PrivilegeError when the server denied the operation for lack of the
+// privilege, any other error is returned as is.
+func privilegeError(err error, privilege string) error {
+	for e := err; e != nil; {
+		if me, ok := e.(*gomysql.MyError); ok {
+			if me.Code == gomysql.ER_SPECIFIC_ACCESS_DENIED_ERROR {
+				return PrivilegeError{Privilege: privilege, Err: me}
+			}
+			break
+		}
+		c, ok := e.(causer)
+		if !ok {
+			break
+		}
+		e = c.Cause()
+	}
+	return err
+}
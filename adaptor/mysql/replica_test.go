@@ -0,0 +1,60 @@
+package mysql
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+)
+
+type tracedError struct {
+	error
+}
+
+func (e tracedError) Cause() error {
+	return e.error
+}
+
+var (
+	accessDenied = &gomysql.MyError{Code: gomysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, Message: "Access denied; you need (at least one of) the REPLICATION SLAVE privilege(s) for this operation"}
+	otherError   = &gomysql.MyError{Code: gomysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, Message: "Could not find first log file name in binary log index file"}
+
+	privilegeErrorTests = []struct {
+		name     string
+		err      error
+		expected error
+	}{
+		{
+			"access denied",
+			accessDenied,
+			PrivilegeError{Privilege: "REPLICATION SLAVE", Err: accessDenied},
+		},
+		{
+			"wrapped access denied",
+			tracedError{accessDenied},
+			PrivilegeError{Privilege: "REPLICATION SLAVE", Err: accessDenied},
+		},
+		{
+			"other server error",
+			tracedError{otherError},
+			tracedError{otherError},
+		},
+		{
+			"other error",
+			errors.New("connection refused"),
+			errors.New("connection refused"),
+		},
+	}
+)
+
+func TestPrivilegeError(t *testing.T) {
+	for _, pt := range privilegeErrorTests {
+		t.Run(pt.name, func(t *testing.T) {
+			err := privilegeError(pt.err, "REPLICATION SLAVE")
+			if !reflect.DeepEqual(err, pt.expected) {
+				t.Errorf("wrong error, expected %#v, got %#v", pt.expected, err)
+			}
+		})
+	}
+}
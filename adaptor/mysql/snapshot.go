@@ -0,0 +1,114 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/compose/transporter/log"
+
+	gomysql "github.com/go-mysql-org/go-mysql/mysql"
+)
+
+// ErrNoBinlog is returned when the server doesn't have binary logging enabled.
+var ErrNoBinlog = errors.New("binary logging is not enabled, unable to tail")
+
+// querier is implemented by both *sql.DB and connQuerier.
+type querier interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
+// connQuerier runs every query on the same connection so they read from the transaction
+// started on it.
+type connQuerier struct {
+	*sql.Conn
+}
+
+func (c connQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.QueryContext(context.Background(), query, args...)
+}
+
+// consistentSnapshot starts a transaction with a consistent snapshot on a dedicated connection
+// and returns it along with the binlog position matching the snapshot. Writes are blocked by
+// FLUSH TABLES WITH READ LOCK only until the snapshot is started and the position is read,
+// which requires the RELOAD privilege. Without it the position is read right after starting
+// the snapshot, so changes committed in between are also sent by the tailer.
+func consistentSnapshot(db *sql.DB) (*sql.Conn, *binlogPosition, error) {
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	locked := true
+	if _, err := conn.ExecContext(ctx, "FLUSH TABLES WITH READ LOCK;"); err != nil {
+		log.Errorf("unable to lock tables, the copy may include changes made after the binlog position, %s", err)
+		locked = false
+	}
+	pos, err := func() (*binlogPosition, error) {
+		if locked {
+			defer conn.ExecContext(ctx, "UNLOCK TABLES;")
+		}
+		if _, err := conn.ExecContext(ctx, "SET TRANSACTION ISOLATION LEVEL REPEATABLE READ;"); err != nil {
+			return nil, err
+		}
+		if _, err := conn.ExecContext(ctx, "START TRANSACTION WITH CONSISTENT SNAPSHOT;"); err != nil {
+			return nil, err
+		}
+		return masterStatus(connQuerier{conn})
+	}()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, pos, nil
+}
+
+// masterStatus returns the current binlog position along with the executed GTID set when the
+// server uses GTIDs. The columns returned by SHOW MASTER STATUS differ between MySQL and MariaDB,
+// only the first two are the same.
+//
+//	mysql> show master status;
+//	+-------------------+----------+--------------+------------------+-------------------------------------------+
+//	| File              | Position | Binlog_Do_DB | Binlog_Ignore_DB | Executed_Gtid_Set                         |
+//	+-------------------+----------+--------------+------------------+-------------------------------------------+
+//	| master-bin.000001 |   163739 |              |                  | a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-55 |
+//	+-------------------+----------+--------------+------------------+-------------------------------------------+
+func masterStatus(q querier) (*binlogPosition, error) {
+	rows, err := q.Query("SHOW MASTER STATUS;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoBinlog
+	}
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, err
+	}
+	pos, err := strconv.ParseUint(string(values[1]), 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	p := &binlogPosition{File: string(values[0]), Pos: uint32(pos)}
+	if len(values) > 4 && len(values[4]) > 0 {
+		p.GTID = strings.Replace(string(values[4]), "\n", "", -1)
+		if p.gset, err = gomysql.ParseMysqlGTIDSet(p.GTID); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
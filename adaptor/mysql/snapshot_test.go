@@ -0,0 +1,50 @@
+package mysql
+
+import (
+	"fmt"
+	"testing"
+)
+
+var snapshotTestData = &TestData{"snapshot_test", "snapshot_test_table", basicSchema, 10}
+
+func TestConsistentSnapshot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping ConsistentSnapshot in short mode")
+	}
+	conn, pos, err := consistentSnapshot(defaultSession.mysqlSession)
+	if err != nil {
+		t.Fatalf("unexpected consistentSnapshot error, %s", err)
+	}
+	defer conn.Close()
+
+	table := fmt.Sprintf("%s.%s", snapshotTestData.DB, snapshotTestData.Table)
+	if _, err := defaultSession.mysqlSession.Exec(fmt.Sprintf(`INSERT INTO %s VALUES (100, 'Robin', now());`, table)); err != nil {
+		t.Fatalf("unexpected INSERT error, %s", err)
+	}
+
+	var count int
+	if err := defaultSession.mysqlSession.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM %s;", table)).Scan(&count); err != nil {
+		t.Fatalf("unexpected COUNT error, %s", err)
+	}
+	if count != snapshotTestData.InsertCount+1 {
+		t.Errorf("wrong count outside the snapshot, expected %d, got %d", snapshotTestData.InsertCount+1, count)
+	}
+	rows, err := connQuerier{conn}.Query(fmt.Sprintf("SELECT COUNT(*) FROM %s;", table))
+	if err != nil {
+		t.Fatalf("unexpected COUNT error, %s", err)
+	}
+	rows.Next()
+	rows.Scan(&count)
+	rows.Close()
+	if count != snapshotTestData.InsertCount {
+		t.Errorf("wrong count in the snapshot, expected %d, got %d", snapshotTestData.InsertCount, count)
+	}
+
+	after, err := masterStatus(defaultSession.mysqlSession)
+	if err != nil {
+		t.Fatalf("unexpected masterStatus error, %s", err)
+	}
+	if after.position().Compare(pos.position()) <= 0 {
+		t.Errorf("binlog position didn't move past the snapshot, snapshot %s, after insert %s", pos, after)
+	}
+}
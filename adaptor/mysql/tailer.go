@@ -5,9 +5,9 @@ import (
 	"database/sql"
 	"fmt"
 	"net/url"
-	"os"
 	"regexp"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/compose/transporter/client"
@@ -17,35 +17,49 @@ import (
 	"github.com/compose/transporter/message/data"
 	"github.com/compose/transporter/message/ops"
 
-	// Naming conflict with Transporter adaptor itself
-	gomysql "github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/go-mysql-org/go-mysql/replication"
 )
 
 var (
-	_ client.Reader = &Tailer{}
+	_ client.Reader        = &Tailer{}
+	_ client.ErrorReporter = &Tailer{}
 )
 
+// StreamError is returned by Err when reading the binlog fails once streaming started,
+// Position is the last position read.
+type StreamError struct {
+	Position string
+	Err      error
+}
+
+func (e StreamError) Error() string {
+	return fmt.Sprintf("error streaming the binlog at %s, %s", e.Position, e.Err)
+}
+
 // Tailer implements the behaviour defined by client.Tailer for interfacing with the MySQL binlog.
 // We'll have to pass through the dsn so that we can use it to configure the sync client
 type Tailer struct {
-	reader client.Reader
-	dsn    string
+	dsn      string
+	serverID uint32
+	emitDDL  bool
+	schemas  *schemaCache
+
+	mu  sync.Mutex
+	err error // why the Tailer stopped before done was closed
 }
 
-func newTailer(dsn string) client.Reader {
-	return &Tailer{newReader(), dsn}
+func newTailer(dsn string, serverID uint32, emitDDL bool) client.Reader {
+	return &Tailer{dsn: dsn, serverID: serverID, emitDDL: emitDDL}
 }
 
-// Tail does the things
+// Read copies every table from a consistent snapshot and then streams the binlog from the
+// position matching the snapshot, so every change committed after the copy is sent once and
+// in order. Messages read from the binlog carry the position their transaction ends at, when
+// one is found in the resumeMap the copy is skipped and streaming resumes from it. DDL read
+// from the binlog keeps the columns of each table up to date and, with emit_ddl, is sent as a
+// command message in the order it was run.
 func (t *Tailer) Read(resumeMap map[string]client.MessageSet, filterFn client.NsFilterFunc) client.MessageChanFunc {
 	return func(s client.Session, done chan struct{}) (chan client.MessageSet, error) {
-		// How is resuming supposed to work?
-		readFunc := t.reader.Read(resumeMap, filterFn)
-		msgChan, err := readFunc(s, done)
-		if err != nil {
-			return nil, err
-		}
 		session := s.(*Session)
 
 		// TODO: This could go in a separate function and return a cfg?
@@ -65,38 +79,12 @@ func (t *Tailer) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 		//path := parsedDSN.Path[1:]
 		scheme := parsedDSN.Scheme
 
-		// Find binlog info
-		var binFile string
-		var binPosition int
-		var _binBinlogDoDB string
-		var _binBinlogIgnoreDB string
-		var _binExecutedGtidSet string
-		result := session.mysqlSession.QueryRow("SHOW MASTER STATUS")
-		// We need to scan all columns... even though we don't care about them all.
-		// mysql> show master status;
-		// +-------------------+----------+--------------+------------------+-------------------------------------------+
-		// | File              | Position | Binlog_Do_DB | Binlog_Ignore_DB | Executed_Gtid_Set                         |
-		// +-------------------+----------+--------------+------------------+-------------------------------------------+
-		// | master-bin.000001 |   163739 |              |                  | a852989a-1894-4fcb-a060-a4aaaf06b9f0:1-55 |
-		// +-------------------+----------+--------------+------------------+-------------------------------------------+
-		// 1 row in set (0.04 sec)
-		//
-		scanErr := result.Scan(&binFile, &binPosition, &_binBinlogDoDB, &_binBinlogIgnoreDB, &_binExecutedGtidSet)
-		log.Debugf("binFile: %s, binPosition: %d", binFile, binPosition)
-		if scanErr != nil {
-			// Quit gracefully since can't tail?
-			log.Errorln("Can't find binFile or binPosition. Unable to tail")
-			os.Exit(1)
+		serverID, err := replicaServerID(session.mysqlSession, t.serverID)
+		if err != nil {
+			return nil, err
 		}
-
-		// Find serverID
-		var serverID uint32
-		result = session.mysqlSession.QueryRow("SELECT @@server_id as SERVER_ID")
-		scanErr = result.Scan(&serverID)
-		if scanErr != nil {
-			// Quit gracefully since can't tail?
-			log.Errorln("Can't find source server ID")
-			os.Exit(1)
+		if err := checkBinlogFormat(session.mysqlSession); err != nil {
+			return nil, err
 		}
 
 		// Configure sync client
@@ -109,87 +97,247 @@ func (t *Tailer) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 			Password: pass,
 		}
 
-		// Create syncer
-		syncer := replication.NewBinlogSyncer(cfg)
+		t.schemas = newSchemaCache(session.mysqlSession)
 
-		// Start streamer
-		streamer, _ := syncer.StartSync(gomysql.Position{Name: binFile, Pos: uint32(binPosition)})
-		// How to properly close this?
-		// There is no EndSync, but there is a close we can call on the `done` channel
+		binPosition, err := resumePosition(resumeMap)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			msgChan  chan client.MessageSet
+			syncer   *replication.BinlogSyncer
+			streamer *replication.BinlogStreamer
+		)
+		if binPosition != nil {
+			if err := checkPurged(session.mysqlSession, binPosition); err != nil {
+				return nil, privilegeError(err, "REPLICATION CLIENT")
+			}
+			log.With("db", session.db).With("position", binPosition.String()).Infoln("resuming from binlog position")
+			if syncer, streamer, err = startSync(cfg, binPosition); err != nil {
+				return nil, err
+			}
+		} else {
+			var conn *sql.Conn
+			conn, binPosition, err = consistentSnapshot(session.mysqlSession)
+			if err != nil {
+				return nil, privilegeError(err, "REPLICATION CLIENT")
+			}
+			log.With("db", session.db).With("position", binPosition.String()).Debugln("snapshot started")
+			// the binlog is only streamed once the copy is done, registering as a replica
+			// now reports a missing privilege before copying
+			probe, _, err := startSync(cfg, binPosition)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+			probe.Close()
+			readFunc := (&Reader{conn: conn}).Read(resumeMap, filterFn)
+			msgChan, err = readFunc(s, done)
+			if err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
 
 		out := make(chan client.MessageSet)
-		// Will we have to pass things (such as streamer) into this function?
-		go func() {
-			defer close(out)
-			// read until reader done
-			for msg := range msgChan {
-				out <- msg
+		start := func() (*replication.BinlogSyncer, *replication.BinlogStreamer, error) {
+			if syncer != nil {
+				return syncer, streamer, nil
 			}
-			// start tailing/streaming
-			log.With("db", session.db).Infoln("Listening for changes...")
-			for {
-				// Use timeout context (for now at least)
-				// If we are using a timeout I think we can happily sit there for a bit
-				ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-				defer cancel()
-				select {
-				// Notes to self on what this is doing...
-				// From reading around, e.g: https://golangbyexample.com/select-statement-golang/
-				// I _think_ the blocking 1 sec sleep is there just to give the "done" channel a chance to
-				// execute otherwise there is no guarantee it would close because the "tailing"
-				// channel could also be executing and if both are ready it'll select one at random.
-				// For Postgresql this works because each call pulls all the logical decoding messages
-				// since the last call.
-				// For MySQL this isn't going to work correctly because we are pulling/streaming one
-				// event at a time. A 1 second sleep is no good.
-				// Historically, way back, channels weren't used:
-				//
-				// - https://github.com/compose/transporter/pull/281/files
-				// - https://github.com/compose/transporter/blob/7875ce0a2343fe94d7d6f9703e2e578cd6b77cba/pkg/adaptor/postgres/postgres.go#L305-L318
-				//
-				// We need to stick with channels, but need to do this a bit differently
-				// Can we do outside of the select/case?
-				// Unless we can use DumpEvents instead of GetEvent?
-				// Or we use default? That way it doesn't block but should still close
-				case <-done:
-					log.With("db", session.db).Infoln("tailing stopping...")
-					syncer.Close()
-					// ?? this doesn't use the cancel above. Need to fix.
-					return
-				default:
-					// This blocks until an event is received which will still prevent the done channel from executing so use a timeout
-					event, ctxerr := streamer.GetEvent(ctx)
-					// Can't easily use below with `log.` so leaving commented out for debugging
-					//event.Dump(os.Stdout)
-
-					// Do not really understand this next bit yet
-					// Cancels existing/current context?
-					cancel()
-					if ctxerr == context.DeadlineExceeded {
-						// Allow `done` to execute
-						continue
-					}
-
-					msgSlice, skip, err := t.processEvent(s, event, filterFn)
-					if err != nil {
-						log.With("db", session.db).Errorf("error processing event from binlog %v", err)
-						continue
-					}
-					// send processed events to the channel
-					// What if there is an event we want to skip? Need a way to process that?
-					if skip {
-						log.With("db", session.db).Debugf("skipping event from binlog %v", msgSlice)
-						continue
-					}
-					for _, msg := range msgSlice {
-						out <- msg
-					}
+			// the binlog is kept by the server so it's only streamed once the copy is done
+			return startSync(cfg, binPosition)
+		}
+		go t.tail(s, msgChan, start, binPosition, filterFn, out, done)
+
+		return out, nil
+	}
+}
+
+// tail sends the messages copied from the snapshot, when msgChan isn't nil, and then streams
+// the binlog from p with the syncer returned by start until done is closed. out is closed once
+// it stops, the error which stopped it is returned by Err.
+func (t *Tailer) tail(s client.Session, msgChan chan client.MessageSet, start func() (*replication.BinlogSyncer, *replication.BinlogStreamer, error),
+	binPosition *binlogPosition, filterFn client.NsFilterFunc, out chan<- client.MessageSet, done chan struct{}) {
+	defer close(out)
+	db := s.(*Session).db
+	// read until reader done
+	if msgChan != nil {
+		for msg := range msgChan {
+			out <- msg
+		}
+	}
+	syncer, streamer, err := start()
+	if err != nil {
+		log.With("db", db).Errorf("unable to start binlog sync, %s", err)
+		t.setErr(err)
+		return
+	}
+	defer syncer.Close()
+	if err := t.stream(streamer, binPosition, s, filterFn, out, done); err != nil {
+		log.With("db", db).Errorln(err)
+		t.setErr(err)
+	}
+}
+
+// stream sends the changes read from the binlog until done is closed, an error is returned when
+// the binlog can't be read or an event can't be turned into messages.
+func (t *Tailer) stream(streamer *replication.BinlogStreamer, binPosition *binlogPosition, s client.Session, filterFn client.NsFilterFunc,
+	out chan<- client.MessageSet, done chan struct{}) error {
+	db := s.(*Session).db
+	// start tailing/streaming
+	log.With("db", db).Infoln("Listening for changes...")
+	// messages are sent once their transaction is committed since its end position
+	// is only known then
+	var txn []client.MessageSet
+	for {
+		// Use timeout context (for now at least)
+		// If we are using a timeout I think we can happily sit there for a bit
+		ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+		select {
+		// Notes to self on what this is doing...
+		// From reading around, e.g: https://golangbyexample.com/select-statement-golang/
+		// I _think_ the blocking 1 sec sleep is there just to give the "done" channel a chance to
+		// execute otherwise there is no guarantee it would close because the "tailing"
+		// channel could also be executing and if both are ready it'll select one at random.
+		// For Postgresql this works because each call pulls all the logical decoding messages
+		// since the last call.
+		// For MySQL this isn't going to work correctly because we are pulling/streaming one
+		// event at a time. A 1 second sleep is no good.
+		// Historically, way back, channels weren't used:
+		//
+		// - https://github.com/compose/transporter/pull/281/files
+		// - https://github.com/compose/transporter/blob/7875ce0a2343fe94d7d6f9703e2e578cd6b77cba/pkg/adaptor/postgres/postgres.go#L305-L318
+		//
+		// We need to stick with channels, but need to do this a bit differently
+		// Can we do outside of the select/case?
+		// Unless we can use DumpEvents instead of GetEvent?
+		// Or we use default? That way it doesn't block but should still close
+		case <-done:
+			cancel()
+			log.With("db", db).Infoln("tailing stopping...")
+			return nil
+		default:
+			// This blocks until an event is received which will still prevent the done channel from executing so use a timeout
+			event, ctxerr := streamer.GetEvent(ctx)
+			// Can't easily use below with `log.` so leaving commented out for debugging
+			//event.Dump(os.Stdout)
+
+			// Do not really understand this next bit yet
+			// Cancels existing/current context?
+			cancel()
+			if ctxerr == context.DeadlineExceeded {
+				// Allow `done` to execute
+				continue
+			}
+			if ctxerr != nil {
+				return StreamError{Position: binPosition.String(), Err: ctxerr}
+			}
+
+			binPosition.update(event)
+			// DDL commits implicitly so it's sent right away along with its position
+			var ddl bool
+			if q, ok := event.Event.(*replication.QueryEvent); ok {
+				var msgSlice []client.MessageSet
+				msgSlice, ddl = t.processQuery(q, filterFn)
+				txn = append(txn, msgSlice...)
+			}
+			if ddl || isCommit(event) {
+				if err := binPosition.commit(); err != nil {
+					return StreamError{Position: binPosition.String(), Err: fmt.Errorf("unable to update GTID set, %s", err)}
 				}
+				position := binPosition.marshal()
+				for _, msg := range txn {
+					msg.Position = position
+					out <- msg
+				}
+				txn = txn[:0]
+				continue
 			}
-		}()
 
-		return out, nil
+			msgSlice, skip, err := t.processEvent(s, event, filterFn)
+			// send processed events to the channel
+			// What if there is an event we want to skip? Need a way to process that?
+			if skip {
+				log.With("db", db).Debugf("skipping event from binlog %v", msgSlice)
+				continue
+			}
+			if err != nil {
+				return StreamError{Position: binPosition.String(), Err: err}
+			}
+			txn = append(txn, msgSlice...)
+		}
+	}
+}
+
+// Err returns the error which stopped the Tailer once the channel returned by Read is closed,
+// nil when it stopped because done was closed.
+func (t *Tailer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
+
+func (t *Tailer) setErr(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+}
+
+// startSync registers as a replica and starts streaming the binlog from p.
+func startSync(cfg replication.BinlogSyncerConfig, p *binlogPosition) (*replication.BinlogSyncer, *replication.BinlogStreamer, error) {
+	syncer := replication.NewBinlogSyncer(cfg)
+	var (
+		streamer *replication.BinlogStreamer
+		err      error
+	)
+	if p.gset != nil {
+		streamer, err = syncer.StartSyncGTID(p.gset.Clone())
+	} else {
+		streamer, err = syncer.StartSync(p.position())
+	}
+	if err != nil {
+		syncer.Close()
+		return nil, nil, privilegeError(err, "REPLICATION SLAVE")
+	}
+	return syncer, streamer, nil
+}
+
+// isCommit returns true for the event ending a transaction, the COMMIT query is used instead
+// of an XID event for tables which don't support transactions.
+func isCommit(event *replication.BinlogEvent) bool {
+	switch e := event.Event.(type) {
+	case *replication.XIDEvent:
+		return true
+	case *replication.QueryEvent:
+		return string(e.Query) == "COMMIT"
+	}
+	return false
+}
+
+// processQuery applies DDL to the schema cache and returns the command message sent for it
+// when emit_ddl is set, the statement is qualified with the schema of every table it names.
+// The returned bool is true when the query is DDL.
+func (t *Tailer) processQuery(q *replication.QueryEvent, filterFn client.NsFilterFunc) ([]client.MessageSet, bool) {
+	stmt, err := parseDDL(string(q.Query), string(q.Schema))
+	if err != nil {
+		log.Errorf("%s, reloading the schema of every table", err)
+		t.schemas.reset()
+		return nil, true
+	}
+	if stmt == nil {
+		return nil, false
+	}
+	t.schemas.apply(stmt)
+	ns := stmt.tables[0].namespace()
+	if !t.emitDDL || !filterFn(ns) {
+		return nil, true
 	}
+	ddl := stmt.qualified()
+	log.With("table", ns).With("ddl", ddl).Debugln("received DDL")
+	return []client.MessageSet{{
+		Msg:  message.From(ops.Command, ns, data.Data{"ddl": ddl}),
+		Mode: commitlog.Sync,
+	}}, true
 }
 
 // For a statement like this:
@@ -272,41 +420,9 @@ func (t *Tailer) processEvent(s client.Session, event *replication.BinlogEvent,
 			return result, skip, fmt.Errorf("Error processing action from string: %v", rowsEvent.Rows)
 		}
 		// Fetch column / data-type info before we can do 4.
-
-		session := s.(*Session)
-		// Copied from reader.go `iterateTable`
-		// TODO: Use a common function for both
-		// TODO: Do we really want to do this _every_ time? Seems ultra inefficient
-		columnsResult, err := session.mysqlSession.Query(fmt.Sprintf(`
-                SELECT COLUMN_NAME AS column_name, DATA_TYPE as data_type, "" as element_type
-                FROM INFORMATION_SCHEMA.COLUMNS
-                WHERE
-                    TABLE_SCHEMA = '%v'
-                AND TABLE_NAME = '%v'
-                ORDER BY ORDINAL_POSITION;
-                `, schema, table))
-		// No element_types in mysql since no ARRAY data type
-		// at the moment we add an empty column to get the same layout as Postgres
-		// TODO: Update this code so we don't need that empty column?
+		columns, err := t.schemas.columns(schema, table, int(rowsEvent.ColumnCount))
 		if err != nil {
-			log.With("schema", schema).With("table", table).Errorf("error getting columns %v", err)
-		}
-		var columns [][]string
-		for columnsResult.Next() {
-			var columnName string
-			var columnType string
-			var columnArrayType sql.NullString // this value may be nil
-
-			err = columnsResult.Scan(&columnName, &columnType, &columnArrayType)
-			recoveredRegex := regexp.MustCompile("recovered")
-			if err != nil && !recoveredRegex.MatchString(err.Error()) {
-				log.With("table", table).Errorf("error scanning columns %v", err)
-				continue
-			}
-
-			column := []string{columnName, columnType}
-			columns = append(columns, column)
-			log.With("db", session.db).Debugln(columnName + ": " + columnType)
+			return result, skip, err
 		}
 		// 4. Remaining stuff / data
 		for i, row := range rowsEvent.Rows {
@@ -336,7 +452,7 @@ func (t *Tailer) processEvent(s client.Session, event *replication.BinlogEvent,
 	return result, skip, err
 }
 
-func parseEventRow(columns [][]string, d []interface{}) data.Data {
+func parseEventRow(columns []column, d []interface{}) data.Data {
 	// The main issue with MySQL is that we don't get the column names!!! So we need to fill those in...
 	// We can use `TableMapEvent`s or Transporter itself since it has read the table. `iterateTable`?
 
@@ -380,15 +496,15 @@ func parseEventRow(columns [][]string, d []interface{}) data.Data {
 		switch value := value.(type) {
 		// Seems everything is []uint8
 		case []uint8:
-			data[columns[i][0]] = casifyValue(string(value), columns[i][1])
+			data[columns[i].name] = casifyValue(string(value), columns[i].dataType)
 		case string:
-			data[columns[i][0]] = casifyValue(string(value), columns[i][1])
+			data[columns[i].name] = casifyValue(string(value), columns[i].dataType)
 		default:
 			// TODO: This is probably a Postgresql thing and needs removing here and in reader.go
 			arrayRegexp := regexp.MustCompile("[[]]$")
-			if arrayRegexp.MatchString(columns[i][1]) {
+			if arrayRegexp.MatchString(columns[i].dataType) {
 			} else {
-				data[columns[i][0]] = value
+				data[columns[i].name] = value
 			}
 		}
 	}
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
@@ -9,6 +10,11 @@ import (
 	"time"
 
 	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+
+	"github.com/go-mysql-org/go-mysql/replication"
 )
 
 func checkBinLogReadable(s *sql.DB) error {
@@ -47,7 +53,7 @@ func TestTailer(t *testing.T) {
 
 	// There is no t.Debug unfortunately so retaining below but commented out
 	//t.Log("DEBUG: Starting tailer...")
-	r := newTailer(dsn)
+	r := newTailer(dsn, 0, false)
 	// There is no t.Debug unfortunately so retaining below but commented out
 	//t.Log("DEBUG: Tailer running")
 	readFunc := r.Read(map[string]client.MessageSet{}, func(table string) bool {
@@ -142,3 +148,28 @@ func checkCount(desc string, expected int, msgChan <-chan client.MessageSet, t *
 		t.Logf("[%s] message count ok", desc)
 	}
 }
+
+func TestTailSyncErrorAfterCopy(t *testing.T) {
+	copied := make(chan client.MessageSet, 2)
+	for i := 0; i < 2; i++ {
+		copied <- client.MessageSet{Msg: message.From(ops.Insert, "test.foo", data.Data{"id": i})}
+	}
+	close(copied)
+	syncErr := PrivilegeError{Privilege: "REPLICATION SLAVE", Err: errors.New("access denied")}
+	start := func() (*replication.BinlogSyncer, *replication.BinlogStreamer, error) {
+		return nil, nil, syncErr
+	}
+	r := newTailer("", 0, false).(*Tailer)
+	out := make(chan client.MessageSet)
+	go r.tail(&Session{db: "test"}, copied, start, &binlogPosition{}, func(string) bool { return true }, out, make(chan struct{}))
+	var count int
+	for range out {
+		count++
+	}
+	if count != 2 {
+		t.Errorf("wrong number of copied messages, expected 2, got %d", count)
+	}
+	if err := r.Err(); err != syncErr {
+		t.Errorf("expected %s once the channel is closed, got %v", syncErr, err)
+	}
+}
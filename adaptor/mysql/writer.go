@@ -1,9 +1,9 @@
 package mysql
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -20,21 +20,30 @@ var _ client.Writer = &Writer{}
 
 // Writer implements client.Writer for use with MySQL
 type Writer struct {
-	writeMap map[ops.Op]func(message.Msg, *sql.DB) error
+	writeMap map[ops.Op]func(message.Msg, executor) error
+	offsets  *offsetStore
+	schema   *schema
 }
 
-func newWriter() *Writer {
+func newWriter(upsert bool) *Writer {
 	w := &Writer{}
-	w.writeMap = map[ops.Op]func(message.Msg, *sql.DB) error{
+	w.writeMap = map[ops.Op]func(message.Msg, executor) error{
 		ops.Insert: insertMsg,
 		ops.Update: updateMsg,
 		ops.Delete: deleteMsg,
 	}
+	if upsert {
+		w.writeMap[ops.Insert] = upsertMsg
+	}
 	return w
 }
 
 func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
 	return func(s client.Session) (message.Msg, error) {
+		msg, err := ensureSchema(w.schema, s.(*Session).mysqlSession, msg)
+		if err != nil {
+			return nil, err
+		}
 		writeFunc, ok := w.writeMap[msg.OP()]
 		if !ok {
 			log.Infof("no function registered for operation, %s", msg.OP())
@@ -43,9 +52,20 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 			}
 			return msg, nil
 		}
-		if err := writeFunc(msg, s.(*Session).mysqlSession); err != nil {
+		db := s.(*Session).mysqlSession
+		if ns, logOffset, ok := message.Offset(msg); ok && w.offsets != nil {
+			err = w.offsets.writeWithOffset(db, ns, logOffset, func(e executor) error {
+				return writeFunc(msg, e)
+			})
+		} else {
+			err = writeFunc(msg, db)
+		}
+		if err != nil {
 			return nil, err
 		}
+		if msg.OP() == ops.Command {
+			w.schema.forget(msg.Namespace())
+		}
 		if msg.Confirms() != nil {
 			msg.Confirms() <- struct{}{}
 		}
@@ -53,8 +73,56 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 	}
 }
 
-func insertMsg(m message.Msg, s *sql.DB) error {
+func insertMsg(m message.Msg, s executor) error {
 	log.With("table", m.Namespace()).Debugln("INSERT")
+	keys, placeholders, data, err := insertRow(m)
+	if err != nil {
+		return err
+	}
+	return insertRows(s, m.Namespace(), keys, placeholders, [][]interface{}{data}, "")
+}
+
+// upsertMsg inserts the message or, when a row with the same primary key exists, updates it
+// so messages can be written more than once.
+func upsertMsg(m message.Msg, s executor) error {
+	log.With("table", m.Namespace()).Debugln("UPSERT")
+	keys, placeholders, data, err := insertRow(m)
+	if err != nil {
+		return err
+	}
+	onDuplicate, err := upsertClause(m.Namespace(), keys, s)
+	if err != nil {
+		return err
+	}
+	return insertRows(s, m.Namespace(), keys, placeholders, [][]interface{}{data}, onDuplicate)
+}
+
+// upsertClause returns the ON DUPLICATE KEY UPDATE clause updating every column which isn't
+// part of the table's primary key.
+func upsertClause(namespace string, keys []string, s executor) (string, error) {
+	pkeys, err := primaryKeys(namespace, s)
+	if err != nil {
+		return "", err
+	}
+	if len(pkeys) == 0 {
+		return "", fmt.Errorf("upsert requires a primary key, none found for %s", namespace)
+	}
+	var ukeys []string
+	for _, key := range keys {
+		if !pkeys[key] {
+			ukeys = append(ukeys, fmt.Sprintf("%v=VALUES(%v)", key, key))
+		}
+	}
+	if len(ukeys) == 0 && len(keys) > 0 {
+		// every column is part of the primary key so there is nothing to update
+		ukeys = append(ukeys, fmt.Sprintf("%v=%v", keys[0], keys[0]))
+	}
+	return fmt.Sprintf(" ON DUPLICATE KEY UPDATE %v", strings.Join(ukeys, ", ")), nil
+}
+
+// insertRow returns the sorted columns of the message along with the placeholder and value
+// of each one.
+func insertRow(m message.Msg) ([]string, []string, []interface{}, error) {
 	var (
 		keys         []string
 		placeholders []string
@@ -62,9 +130,13 @@ func insertMsg(m message.Msg, s *sql.DB) error {
 		err          error
 	)
 
-	i := 1
-	for key, value := range m.Data() {
+	for key := range m.Data() {
 		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := m.Data()[key]
 		// Mysql uses "?, ?, ?" instead of "$1, $2, $3"
 		// Wrap placeholder for geometry types
 		// Overkill using switch/case for just geometry,
@@ -89,13 +161,13 @@ func insertMsg(m message.Msg, s *sql.DB) error {
 			_ = t
 			value, err = wkt.Marshal(value.(geom.T))
 			if err != nil {
-				return err
+				return nil, nil, nil, err
 			}
 			value = value.(string)
 		case time.Time:
 			// MySQL can write this format into DATE, DATETIME and TIMESTAMP
 			value = value.(time.Time).Format("2006-01-02 15:04:05.000000")
-		case map[string]interface{}, mejson.M, []map[string]interface{}, mejson.S:
+		case map[string]interface{}, mejson.M, []map[string]interface{}, mejson.S, []interface{}:
 			// This is used so we can write values like the following to json fields:
 			//
 			//     map[string]interface{}{"name": "batman"},
@@ -104,15 +176,29 @@ func insertMsg(m message.Msg, s *sql.DB) error {
 			// With MySQL we can just write a json string.
 			value, err = json.Marshal(value)
 			if err != nil {
-				return err
+				return nil, nil, nil, err
 			}
 		}
 		data = append(data, value)
+	}
+	return keys, placeholders, data, nil
+}
 
-		i = i + 1
+// insertRows inserts every row with a single statement, each row must contain a value for
+// every key and use the same placeholders. onDuplicate is appended to the statement when not
+// empty.
+func insertRows(s executor, table string, keys, placeholders []string, rows [][]interface{}, onDuplicate string) error {
+	var (
+		values []string
+		data   []interface{}
+	)
+	value := fmt.Sprintf("(%v)", strings.Join(placeholders, ", "))
+	for _, row := range rows {
+		values = append(values, value)
+		data = append(data, row...)
 	}
 
-	query := fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v);", m.Namespace(), strings.Join(keys, ", "), strings.Join(placeholders, ", "))
+	query := fmt.Sprintf("INSERT INTO %v (%v) VALUES %v%v;", table, strings.Join(keys, ", "), strings.Join(values, ", "), onDuplicate)
 	log.Debugf("query: %s", query)
 	log.Debugf("data: %s", data)
 
@@ -122,12 +208,12 @@ func insertMsg(m message.Msg, s *sql.DB) error {
 	//		log.With("table", m.Namespace()).Debugf("data: %s", data[i])
 	//	}
 	//}
-	// INSERT INTO writer_insert_test.simple_test_table (id, colvar, coltimestamp) VALUES ($1, $2, $3);
-	_, err = s.Exec(query, data...)
+	// INSERT INTO writer_insert_test.simple_test_table (id, colvar, coltimestamp) VALUES (?, ?, ?);
+	_, err := s.Exec(query, data...)
 	return err
 }
 
-func deleteMsg(m message.Msg, s *sql.DB) error {
+func deleteMsg(m message.Msg, s executor) error {
 	log.With("table", m.Namespace()).With("values", m.Data()).Debugln("DELETE")
 	var (
 		ckeys []string
@@ -170,7 +256,20 @@ func deleteMsg(m message.Msg, s *sql.DB) error {
 	return err
 }
 
-func updateMsg(m message.Msg, s *sql.DB) error {
+// ddlMsg runs the DDL sent by a mysql source with emit_ddl, it's only registered when the
+// sink is configured with apply_ddl.
+func ddlMsg(m message.Msg, s executor) error {
+	ddl, ok := m.Data().Get("ddl").(string)
+	if !ok {
+		log.With("table", m.Namespace()).Infoln("command has no ddl, skipping")
+		return nil
+	}
+	log.With("table", m.Namespace()).With("ddl", ddl).Infoln("applying DDL")
+	_, err := s.Exec(ddl)
+	return err
+}
+
+func updateMsg(m message.Msg, s executor) error {
 	log.With("table", m.Namespace()).Debugln("UPDATE")
 	var (
 		ckeys []string
@@ -262,7 +361,7 @@ func updateMsg(m message.Msg, s *sql.DB) error {
 	return err
 }
 
-func primaryKeys(namespace string, db *sql.DB) (primaryKeys map[string]bool, err error) {
+func primaryKeys(namespace string, db executor) (primaryKeys map[string]bool, err error) {
 	primaryKeys = map[string]bool{}
 	namespaceArray := strings.SplitN(namespace, ".", 2)
 	var (
@@ -26,7 +26,7 @@ var optests = []struct {
 }
 
 func TestOpFunc(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	for _, ot := range optests {
 		if _, ok := w.writeMap[ot.op]; ok != ot.registered {
 			t.Errorf("op (%s) registration incorrect, expected %+v, got %+v\n", ot.op.String(), ot.registered, ok)
@@ -41,7 +41,7 @@ var (
 func TestInsert(t *testing.T) {
 	confirms, cleanup := adaptor.MockConfirmWrites()
 	defer adaptor.VerifyWriteConfirmed(cleanup, t)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -135,7 +135,7 @@ func wktToGeom(wktForm string) geom.T {
 }
 
 func TestComplexInsert(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerComplexTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -219,7 +219,7 @@ var (
 )
 
 func TestUpdate(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerUpdateTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -281,7 +281,7 @@ var (
 
 func TestComplexUpdate(t *testing.T) {
 	ranInt := rand.Intn(writerComplexUpdateTestData.InsertCount)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerComplexUpdateTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -356,7 +356,7 @@ var (
 )
 
 func TestDelete(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerDeleteTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -405,7 +405,7 @@ var (
 
 func TestComplexDelete(t *testing.T) {
 	ranInt := rand.Intn(writerComplexDeleteTestData.InsertCount)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerComplexDeleteTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -452,7 +452,7 @@ func TestComplexDeleteWithoutAllPrimarykeys(t *testing.T) {
 	// This checks for an expected failure. I.e. should not be possible to delete
 	// the row without all primary keys
 	ranInt := rand.Intn(writerComplexDeletePkTestData.InsertCount)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerComplexDeletePkTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to mysql, %s", err)
@@ -492,3 +492,52 @@ func TestComplexDeleteWithoutAllPrimarykeys(t *testing.T) {
 		t.Errorf("wrong document count, expected 10, got %d", count)
 	}
 }
+
+var (
+	writerUpsertTestData = &TestData{"writer_upsert_test", "upsert_test_table", basicSchema, 0}
+)
+
+func TestUpsert(t *testing.T) {
+	w := newWriter(true)
+	c, err := NewClient(WithURI(fmt.Sprintf("mysql://root@localhost:3306?%s", writerUpsertTestData.DB)))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to mysql, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to mysql, %s", err)
+	}
+	for _, colvar := range []string{"hello world", "hello again"} {
+		for i := 0; i < 10; i++ {
+			msg := message.From(
+				ops.Insert,
+				fmt.Sprintf("%s.%s", writerUpsertTestData.DB, writerUpsertTestData.Table),
+				data.Data{"id": i, "colvar": colvar, "coltimestamp": time.Now().Format("2006-01-02 15:04:05.000000")})
+			if _, err := w.Write(msg)(s); err != nil {
+				t.Errorf("unexpected Insert error, %s\n", err)
+			}
+		}
+	}
+
+	var stringValue string
+	if err := s.(*Session).mysqlSession.
+		QueryRow(fmt.Sprintf("SELECT colvar FROM %s WHERE id = 4", writerUpsertTestData.Table)).
+		Scan(&stringValue); err != nil {
+		t.Fatalf("Error on test query: %v", err)
+	}
+	if stringValue != "hello again" {
+		t.Errorf("wrong colvar, expected hello again, got %s", stringValue)
+	}
+
+	var count int
+	err = s.(*Session).mysqlSession.
+		QueryRow(fmt.Sprintf("SELECT COUNT(id) FROM %s;", writerUpsertTestData.Table)).
+		Scan(&count)
+	if err != nil {
+		t.Errorf("unable to count table, %s", err)
+	}
+	if count != 10 {
+		t.Errorf("wrong document count, expected 10, got %d", count)
+	}
+}
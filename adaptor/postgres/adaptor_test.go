@@ -77,6 +77,12 @@ var (
 		writerComplexUpdateTestData,
 		writerComplexDeleteTestData,
 		writerComplexDeletePkTestData,
+		batchTestData,
+		writerUpsertTestData,
+		copyTestData,
+		schemaTestData,
+		documentTestData,
+		slotTestData,
 	}
 
 	randomHeros = []string{"Superwoman", "Wonder Woman", "Batman", "Superman",
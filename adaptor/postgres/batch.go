@@ -0,0 +1,229 @@
+package postgres
+
+import (
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
+)
+
+const (
+	// DefaultBatchTimeout is how long messages are buffered when batch_timeout is not set.
+	DefaultBatchTimeout = time.Second
+
+	// maxParams is the most bind parameters allowed in a single statement.
+	maxParams = 65535
+)
+
+var _ client.Writer = &Batch{}
+
+// Batch implements client.Writer by applying messages in transactions of up to size messages,
+// the buffered messages are also committed every interval so they never wait long on a quiet
+// source. Consecutive inserts into the same table with the same columns are combined into a
+// multi-row INSERT and Confirms is signaled once per transaction.
+type Batch struct {
+	writeMap  map[ops.Op]func(message.Msg, executor) error
+	offsets   *offsetStore
+	copier    *copier
+	schema    *schema
+	documents *documents
+	size      int
+	upsert    bool
+
+	sync.Mutex
+	db          *sql.DB
+	msgs        []message.Msg
+	confirmChan chan struct{}
+	err         error
+}
+
+func newBatcher(size int, interval time.Duration, upsert bool, offsets *offsetStore, done chan struct{}, wg *sync.WaitGroup) *Batch {
+	b := &Batch{
+		writeMap: newWriter(upsert).writeMap,
+		offsets:  offsets,
+		size:     size,
+		upsert:   upsert,
+		msgs:     make([]message.Msg, 0, size),
+	}
+	wg.Add(1)
+	go b.run(interval, done, wg)
+	return b
+}
+
+func (b *Batch) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
+	return func(s client.Session) (message.Msg, error) {
+		b.Lock()
+		defer b.Unlock()
+		if b.err != nil {
+			return nil, b.err
+		}
+		b.db = s.(*Session).pqSession
+		msg, err := ensureSchema(b.schema, b.copier, b.db, msg)
+		if err != nil {
+			return nil, err
+		}
+		if b.copier != nil && isCopy(msg) {
+			// keep the buffered messages ahead of the copied rows
+			if len(b.msgs) > 0 {
+				if b.err = b.flush(); b.err != nil {
+					return nil, b.err
+				}
+			}
+			if err := b.copier.write(b.db, msg); err != nil {
+				return nil, err
+			}
+			return msg, nil
+		}
+		if msg.Confirms() != nil {
+			b.confirmChan = msg.Confirms()
+		}
+		b.msgs = append(b.msgs, msg)
+		if len(b.msgs) >= b.size {
+			if b.err = b.flush(); b.err != nil {
+				return nil, b.err
+			}
+		}
+		return msg, nil
+	}
+}
+
+func (b *Batch) run(interval time.Duration, done chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-time.After(interval):
+			b.Lock()
+			if b.err == nil {
+				if b.err = b.flush(); b.err != nil {
+					log.Errorf("flush error, %s", b.err)
+				}
+			}
+			b.Unlock()
+		case <-done:
+			log.Infoln("received done channel")
+			b.Lock()
+			if b.err == nil {
+				if err := b.flush(); err != nil {
+					log.Errorf("flush error, %s", err)
+				}
+			}
+			b.Unlock()
+			return
+		}
+	}
+}
+
+// flush applies the buffered messages, and their offsets when they are stored in the sink,
+// in a single transaction.
+func (b *Batch) flush() error {
+	// any copied rows need to be committed first since confirming the batch confirms every
+	// message written before it
+	if b.copier != nil {
+		if err := b.copier.flush(); err != nil {
+			return err
+		}
+	}
+	if len(b.msgs) == 0 {
+		return nil
+	}
+	log.With("messages", len(b.msgs)).Debugln("flushing batch")
+	tx, err := b.db.Begin()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(b.msgs); {
+		n, err := b.apply(tx, b.msgs[i:])
+		if err != nil {
+			b.rollback(tx)
+			return err
+		}
+		i += n
+	}
+
+	offsets := make(map[string]uint64)
+	if b.offsets != nil {
+		for _, msg := range b.msgs {
+			if ns, logOffset, ok := message.Offset(msg); ok && logOffset >= offsets[ns] {
+				offsets[ns] = logOffset
+			}
+		}
+		for ns, logOffset := range offsets {
+			if err := b.offsets.save(tx, ns, logOffset); err != nil {
+				b.rollback(tx)
+				return err
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.rollback(tx)
+		return err
+	}
+	if b.offsets != nil {
+		b.offsets.markCommitted(offsets)
+	}
+	b.msgs = make([]message.Msg, 0, b.size)
+	if b.confirmChan != nil {
+		b.confirmChan <- struct{}{}
+	}
+	return nil
+}
+
+// rollback rolls the transaction back along with the tables document_mode created in it.
+func (b *Batch) rollback(tx *sql.Tx) {
+	tx.Rollback()
+	if b.documents != nil {
+		b.documents.forget()
+	}
+}
+
+// apply writes the first message along with any inserts directly following it into the
+// same table with the same columns, the number of messages written is returned.
+func (b *Batch) apply(e executor, msgs []message.Msg) (int, error) {
+	m := msgs[0]
+	if m.OP() != ops.Insert || b.documents != nil {
+		writeFunc, ok := b.writeMap[m.OP()]
+		if !ok {
+			log.Infof("no function registered for operation, %s", m.OP())
+			return 1, nil
+		}
+		return 1, writeFunc(m, e)
+	}
+
+	keys, row := insertRow(m)
+	rows := [][]interface{}{row}
+	for _, next := range msgs[1:] {
+		if next.OP() != ops.Insert || next.Namespace() != m.Namespace() || (len(rows)+1)*len(keys) > maxParams {
+			break
+		}
+		nextKeys, nextRow := insertRow(next)
+		if !sameKeys(keys, nextKeys) {
+			break
+		}
+		rows = append(rows, nextRow)
+	}
+	var onConflict string
+	if b.upsert {
+		var err error
+		if onConflict, err = upsertClause(m.Namespace(), keys, e); err != nil {
+			return 0, err
+		}
+	}
+	log.With("table", m.Namespace()).With("rows", len(rows)).Debugln("INSERT")
+	return len(rows), insertRows(e, m.Namespace(), keys, rows, onConflict)
+}
+
+func sameKeys(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,259 @@
+package postgres
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// connInfo holds the settings of a connection string, keyed by the libpq setting names such as
+// host, dbname or sslmode.
+type connInfo map[string]string
+
+// parseConnInfo reads the settings of uri the same way lib/pq does for the pqSession, so the
+// replication connection ends up on the same server with the same credentials and TLS settings.
+// A postgres:// URL is converted with pq.ParseURL, any other uri is read as key=value pairs.
+// Settings missing from the uri are read from the PG* variables found in environ, which holds
+// "key=value" strings as returned by os.Environ.
+func parseConnInfo(uri string, environ []string) (connInfo, error) {
+	o := connInfo{
+		"host": "localhost",
+		"port": "5432",
+	}
+	for k, v := range parseEnviron(environ) {
+		o[k] = v
+	}
+	if strings.HasPrefix(uri, "postgres://") || strings.HasPrefix(uri, "postgresql://") {
+		var err error
+		if uri, err = pq.ParseURL(uri); err != nil {
+			return nil, err
+		}
+	}
+	if err := parseOpts(uri, o); err != nil {
+		return nil, err
+	}
+	if o["user"] == "" {
+		o["user"] = "postgres"
+		if u, err := user.Current(); err == nil {
+			o["user"] = u.Username
+		}
+	}
+	if o["dbname"] == "" {
+		o["dbname"] = o["user"]
+	}
+	return o, nil
+}
+
+// address returns the network and address to dial, a host starting with a / is the directory
+// of a unix socket.
+func (o connInfo) address() (string, string) {
+	if strings.HasPrefix(o["host"], "/") {
+		return "unix", filepath.Join(o["host"], ".s.PGSQL."+o["port"])
+	}
+	return "tcp", o["host"] + ":" + o["port"]
+}
+
+// parseEnviron returns the settings of the PG* environment variables lib/pq supports.
+func parseEnviron(environ []string) map[string]string {
+	keys := map[string]string{
+		"PGHOST":            "host",
+		"PGPORT":            "port",
+		"PGDATABASE":        "dbname",
+		"PGUSER":            "user",
+		"PGPASSWORD":        "password",
+		"PGAPPNAME":         "application_name",
+		"PGSSLMODE":         "sslmode",
+		"PGSSLCERT":         "sslcert",
+		"PGSSLKEY":          "sslkey",
+		"PGSSLROOTCERT":     "sslrootcert",
+		"PGCONNECT_TIMEOUT": "connect_timeout",
+	}
+	out := make(map[string]string)
+	for _, v := range environ {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if key, ok := keys[parts[0]]; ok {
+			out[key] = parts[1]
+		}
+	}
+	return out
+}
+
+// parseOpts reads the key=value pairs of a connection string into o, values may be single
+// quoted and backslash escaped as with libpq's conninfo_parse.
+func parseOpts(s string, o connInfo) error {
+	r := []rune(s)
+	i := 0
+	skipSpaces := func() {
+		for i < len(r) && unicode.IsSpace(r[i]) {
+			i++
+		}
+	}
+	for {
+		skipSpaces()
+		if i == len(r) {
+			return nil
+		}
+		start := i
+		for i < len(r) && !unicode.IsSpace(r[i]) && r[i] != '=' {
+			i++
+		}
+		key := string(r[start:i])
+		skipSpaces()
+		if i == len(r) || r[i] != '=' {
+			return fmt.Errorf(`missing "=" after %q in connection info string`, key)
+		}
+		i++
+		skipSpaces()
+
+		var val []rune
+		if i < len(r) && r[i] == '\'' {
+			i++
+			for {
+				if i == len(r) {
+					return errors.New("unterminated quoted string literal in connection string")
+				}
+				c := r[i]
+				i++
+				if c == '\'' {
+					break
+				}
+				if c == '\\' && i < len(r) {
+					c = r[i]
+					i++
+				}
+				val = append(val, c)
+			}
+		} else {
+			for i < len(r) && !unicode.IsSpace(r[i]) {
+				if r[i] == '\\' {
+					i++
+					if i == len(r) {
+						return errors.New("missing character after backslash")
+					}
+				}
+				val = append(val, r[i])
+				i++
+			}
+		}
+		o[key] = string(val)
+	}
+}
+
+// tlsConfig returns the TLS configuration for the sslmode of the connection, nil is returned
+// when TLS is disabled. As with libpq, require only checks the certificate when sslrootcert
+// exists, verify-ca checks it was signed by sslrootcert (or the system roots) and verify-full
+// also checks the host name. allow and prefer are accepted without checking the certificate,
+// the connection falls back to plain text when the server doesn't support TLS.
+func (o connInfo) tlsConfig() (*tls.Config, error) {
+	cfg := &tls.Config{}
+	verifyCA := false
+	switch mode := o["sslmode"]; mode {
+	case "disable":
+		return nil, nil
+	case "allow", "prefer":
+		cfg.InsecureSkipVerify = true
+	case "", "require":
+		cfg.InsecureSkipVerify = true
+		if rootcert := o["sslrootcert"]; rootcert != "" {
+			if _, err := os.Stat(rootcert); err == nil {
+				verifyCA = true
+			} else {
+				delete(o, "sslrootcert")
+			}
+		}
+	case "verify-ca":
+		// the certificate is checked by verifyChain, the handshake would check the host name
+		cfg.InsecureSkipVerify = true
+		verifyCA = true
+	case "verify-full":
+		cfg.ServerName = o["host"]
+	default:
+		return nil, fmt.Errorf("unsupported sslmode %q", mode)
+	}
+
+	if rootcert := o["sslrootcert"]; rootcert != "" {
+		pem, err := ioutil.ReadFile(rootcert)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in sslrootcert %s", rootcert)
+		}
+	}
+	if verifyCA {
+		cfg.VerifyPeerCertificate = verifyChain(cfg.RootCAs)
+	}
+	if err := o.clientCertificate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// clientCertificate loads sslcert and sslkey, which default to the files in ~/.postgresql as
+// with lib/pq. Without a certificate file the connection is made without one.
+func (o connInfo) clientCertificate(cfg *tls.Config) error {
+	cert, key := o["sslcert"], o["sslkey"]
+	if u, err := user.Current(); err == nil {
+		if cert == "" {
+			cert = filepath.Join(u.HomeDir, ".postgresql", "postgresql.crt")
+		}
+		if key == "" {
+			key = filepath.Join(u.HomeDir, ".postgresql", "postgresql.key")
+		}
+	}
+	if cert == "" {
+		return nil
+	}
+	if _, err := os.Stat(cert); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	pair, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return err
+	}
+	cfg.Certificates = []tls.Certificate{pair}
+	return nil
+}
+
+// verifyChain returns a VerifyPeerCertificate func checking the server certificate was signed
+// by roots, the system roots when nil, without checking the host name.
+func verifyChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no certificate sent by the server")
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		var leaf *x509.Certificate
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			if i == 0 {
+				leaf = cert
+				continue
+			}
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(opts)
+		return err
+	}
+}
@@ -0,0 +1,203 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compose/mejson"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
+)
+
+const (
+	// DefaultCopySize is the number of rows loaded in a single transaction with COPY when
+	// batch_size is not set.
+	DefaultCopySize = 10000
+)
+
+// copier loads inserts read during the initial copy of a namespace with COPY ... FROM STDIN.
+// A connection can only run one COPY at a time so the rows of a single table and set of
+// columns are streamed until a message for a different table or columns arrives, at which
+// point a new COPY is started in the same transaction. The transaction is committed, and
+// Confirms signaled, once size rows are loaded, every interval, and before any message not
+// belonging to the copy is written.
+type copier struct {
+	offsets *offsetStore
+	size    int
+
+	sync.Mutex
+	tx          *sql.Tx
+	stmt        *sql.Stmt
+	table       string
+	keys        []string
+	rows        int
+	offsetMap   map[string]uint64
+	confirmChan chan struct{}
+	err         error
+}
+
+func newCopier(size int, interval time.Duration, offsets *offsetStore, done chan struct{}, wg *sync.WaitGroup) *copier {
+	c := &copier{
+		offsets:   offsets,
+		size:      size,
+		offsetMap: make(map[string]uint64),
+	}
+	wg.Add(1)
+	go c.run(interval, done, wg)
+	return c
+}
+
+// isCopy reports whether the message is an insert read during the initial copy.
+func isCopy(msg message.Msg) bool {
+	mode, ok := message.Mode(msg)
+	return ok && mode == commitlog.Copy && msg.OP() == ops.Insert
+}
+
+func (c *copier) run(interval time.Duration, done chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+	for {
+		select {
+		case <-time.After(interval):
+			if err := c.flush(); err != nil {
+				log.Errorf("copy flush error, %s", err)
+			}
+		case <-done:
+			if err := c.flush(); err != nil {
+				log.Errorf("copy flush error, %s", err)
+			}
+			return
+		}
+	}
+}
+
+// write adds the message to the current COPY.
+func (c *copier) write(db *sql.DB, msg message.Msg) error {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	keys, row := copyRow(msg)
+	if c.stmt != nil && (c.table != msg.Namespace() || !sameKeys(c.keys, keys)) {
+		if c.err = c.endCopy(); c.err != nil {
+			return c.err
+		}
+	}
+	if c.stmt == nil {
+		if c.err = c.startCopy(db, msg.Namespace(), keys); c.err != nil {
+			return c.err
+		}
+	}
+	if _, c.err = c.stmt.Exec(row...); c.err != nil {
+		return c.err
+	}
+	c.rows++
+	if ns, logOffset, ok := message.Offset(msg); ok && logOffset >= c.offsetMap[ns] {
+		c.offsetMap[ns] = logOffset
+	}
+	if msg.Confirms() != nil {
+		c.confirmChan = msg.Confirms()
+	}
+	if c.rows >= c.size {
+		c.err = c.commit()
+	}
+	return c.err
+}
+
+// flush commits any rows loaded since the last commit.
+func (c *copier) flush() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	c.err = c.commit()
+	return c.err
+}
+
+func (c *copier) startCopy(db *sql.DB, table string, keys []string) error {
+	if c.tx == nil {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		c.tx = tx
+	}
+	log.With("table", table).Debugln("COPY")
+	stmt, err := c.tx.Prepare(fmt.Sprintf("COPY %v (%v) FROM STDIN", table, strings.Join(keys, ", ")))
+	if err != nil {
+		c.tx.Rollback()
+		c.tx = nil
+		return err
+	}
+	c.stmt = stmt
+	c.table = table
+	c.keys = keys
+	return nil
+}
+
+func (c *copier) endCopy() error {
+	defer func() {
+		c.stmt = nil
+	}()
+	if _, err := c.stmt.Exec(); err != nil {
+		c.stmt.Close()
+		c.tx.Rollback()
+		c.tx = nil
+		return err
+	}
+	return c.stmt.Close()
+}
+
+func (c *copier) commit() error {
+	if c.tx == nil {
+		return nil
+	}
+	if c.stmt != nil {
+		if err := c.endCopy(); err != nil {
+			return err
+		}
+	}
+	if c.offsets != nil {
+		for ns, logOffset := range c.offsetMap {
+			if err := c.offsets.save(c.tx, ns, logOffset); err != nil {
+				c.tx.Rollback()
+				c.tx = nil
+				return err
+			}
+		}
+	}
+	tx := c.tx
+	c.tx = nil
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.With("rows", c.rows).Debugln("copy committed")
+	if c.offsets != nil {
+		c.offsets.markCommitted(c.offsetMap)
+	}
+	c.rows = 0
+	c.offsetMap = make(map[string]uint64)
+	if c.confirmChan != nil {
+		c.confirmChan <- struct{}{}
+	}
+	return nil
+}
+
+// copyRow returns the columns and values of the message like insertRow, JSON values are
+// passed as strings since COPY would otherwise encode them as bytea.
+func copyRow(msg message.Msg) ([]string, []interface{}) {
+	keys, row := insertRow(msg)
+	for i, key := range keys {
+		switch msg.Data()[key].(type) {
+		case map[string]interface{}, mejson.M, []map[string]interface{}, mejson.S:
+			row[i] = string(row[i].([]byte))
+		}
+	}
+	return keys, row
+}
@@ -0,0 +1,65 @@
+package postgres
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+)
+
+var (
+	copyTestData = &TestData{"writer_copy_test", "copy_test_table", basicSchema, 0}
+)
+
+func countRows(t *testing.T, s *Session, table string) int {
+	var count int
+	if err := s.pqSession.QueryRow(fmt.Sprintf("SELECT COUNT(id) FROM %s;", table)).Scan(&count); err != nil {
+		t.Errorf("unable to count table, %s", err)
+	}
+	return count
+}
+
+func TestCopy(t *testing.T) {
+	confirms, cleanup := adaptor.MockConfirmWrites()
+	defer adaptor.VerifyWriteConfirmed(cleanup, t)
+	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", copyTestData.DB)))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to postgres, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to postgres, %s", err)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	w := newWriter(false)
+	w.copier = newCopier(5, time.Minute, nil, done, &wg)
+	ns := fmt.Sprintf("public.%s", copyTestData.Table)
+	for i := 0; i < 12; i++ {
+		msg := message.WithMode(commitlog.Copy, message.From(ops.Insert, ns, data.Data{"id": i, "colvar": "hello world", "coltimestamp": time.Now().UTC()}))
+		if _, err := w.Write(message.WithConfirms(confirms, msg))(s); err != nil {
+			t.Errorf("unexpected Insert error, %s\n", err)
+		}
+	}
+	if count := countRows(t, s.(*Session), copyTestData.Table); count != 10 {
+		t.Errorf("wrong row count while copying, expected 10, got %d", count)
+	}
+
+	msg := message.WithMode(commitlog.Sync, message.From(ops.Insert, ns, data.Data{"id": 100, "colvar": "hello world", "coltimestamp": time.Now().UTC()}))
+	if _, err := w.Write(message.WithConfirms(confirms, msg))(s); err != nil {
+		t.Errorf("unexpected Insert error, %s\n", err)
+	}
+	if count := countRows(t, s.(*Session), copyTestData.Table); count != 13 {
+		t.Errorf("wrong row count after the copy, expected 13, got %d", count)
+	}
+	close(done)
+	wg.Wait()
+}
@@ -0,0 +1,123 @@
+package postgres
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
+)
+
+// documents writes every message as a single JSONB document into a table per namespace with
+// the columns (id, doc, op, source_ts), which lets schemaless collections be written without
+// mapping their fields to columns. The tables are created the first time a namespace is
+// written.
+type documents struct {
+	softDelete bool
+
+	mu      sync.Mutex
+	created map[string]bool
+}
+
+func newDocuments(softDelete bool) *documents {
+	return &documents{
+		softDelete: softDelete,
+		created:    make(map[string]bool),
+	}
+}
+
+func (d *documents) writeMap() map[ops.Op]func(message.Msg, executor) error {
+	return map[ops.Op]func(message.Msg, executor) error{
+		ops.Insert: d.upsertMsg,
+		ops.Update: d.upsertMsg,
+		ops.Delete: d.deleteMsg,
+	}
+}
+
+func (d *documents) createTable(namespace string, e executor) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.created[namespace] {
+		return nil
+	}
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %v (
+		id TEXT PRIMARY KEY,
+		doc JSONB,
+		op TEXT NOT NULL,
+		source_ts TIMESTAMPTZ
+	);`, namespace)
+	if _, err := e.Exec(query); err != nil {
+		return err
+	}
+	d.created[namespace] = true
+	return nil
+}
+
+// forget clears the tables known to be created, it's called when the transaction they may have
+// been created in is rolled back.
+func (d *documents) forget() {
+	d.mu.Lock()
+	d.created = make(map[string]bool)
+	d.mu.Unlock()
+}
+
+// upsertMsg replaces the document stored for the message's ID, inserts and updates are
+// handled the same since updates carry the entire document.
+func (d *documents) upsertMsg(m message.Msg, e executor) error {
+	log.With("table", m.Namespace()).With("id", m.ID()).Debugln("UPSERT document")
+	id, err := documentID(m)
+	if err != nil {
+		return err
+	}
+	if err := d.createTable(m.Namespace(), e); err != nil {
+		return err
+	}
+	doc, err := json.Marshal(m.Data())
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf(`INSERT INTO %v (id, doc, op, source_ts) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (id) DO UPDATE SET doc=EXCLUDED.doc, op=EXCLUDED.op, source_ts=EXCLUDED.source_ts;`, m.Namespace())
+	_, err = e.Exec(query, id, string(doc), m.OP().String(), sourceTime(m))
+	return err
+}
+
+// deleteMsg removes the document's row or, with soft_delete, keeps the last document and
+// marks the row as deleted.
+func (d *documents) deleteMsg(m message.Msg, e executor) error {
+	log.With("table", m.Namespace()).With("id", m.ID()).Debugln("DELETE document")
+	id, err := documentID(m)
+	if err != nil {
+		return err
+	}
+	if err := d.createTable(m.Namespace(), e); err != nil {
+		return err
+	}
+	if d.softDelete {
+		query := fmt.Sprintf("UPDATE %v SET op = $2, source_ts = $3 WHERE id = $1;", m.Namespace())
+		_, err = e.Exec(query, id, m.OP().String(), sourceTime(m))
+		return err
+	}
+	_, err = e.Exec(fmt.Sprintf("DELETE FROM %v WHERE id = $1;", m.Namespace()), id)
+	return err
+}
+
+func documentID(m message.Msg) (string, error) {
+	if id := m.ID(); id != "" {
+		return id, nil
+	}
+	return "", fmt.Errorf("document_mode requires an _id, none found in message for %s", m.Namespace())
+}
+
+// sourceTime returns the time the source recorded the change at, nil is returned for sources
+// which don't provide one so the column is NULL rather than the time transporter read it.
+func sourceTime(m message.Msg) interface{} {
+	ts, ok := message.SourceTimestamp(m)
+	if !ok {
+		return nil
+	}
+	return time.Unix(ts, 0).UTC()
+}
@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	documentTestData = &TestData{"writer_document_test", "document_test_table", basicSchema, 0}
+)
+
+var documentTests = []struct {
+	name       string
+	softDelete bool
+	table      string
+	count      int
+	op         string
+}{
+	{"hard delete", false, "hard_delete_docs", 1, ""},
+	{"soft delete", true, "soft_delete_docs", 2, "delete"},
+}
+
+func TestDocumentMode(t *testing.T) {
+	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", documentTestData.DB)))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to postgres, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to postgres, %s", err)
+	}
+	db := s.(*Session).pqSession
+
+	for _, dt := range documentTests {
+		t.Run(dt.name, func(t *testing.T) {
+			w := newWriter(false)
+			w.writeMap = newDocuments(dt.softDelete).writeMap()
+			ns := fmt.Sprintf("public.%s", dt.table)
+			kept, deleted := bson.NewObjectId(), bson.NewObjectId()
+			for _, msg := range []message.Msg{
+				message.From(ops.Insert, ns, data.Data{"_id": kept, "name": "alice", "tags": []interface{}{"a", "b"}}),
+				message.From(ops.Insert, ns, data.Data{"_id": deleted, "name": "bob"}),
+				message.From(ops.Update, ns, data.Data{"_id": kept, "name": "alice", "address": map[string]interface{}{"city": "Paris"}}),
+				message.From(ops.Delete, ns, data.Data{"_id": deleted}),
+			} {
+				if _, err := w.Write(msg)(s); err != nil {
+					t.Fatalf("unexpected %s error, %s", msg.OP(), err)
+				}
+			}
+
+			var (
+				city string
+				op   string
+			)
+			if err := db.QueryRow(fmt.Sprintf("SELECT doc->'address'->>'city', op FROM %s WHERE id = $1;", dt.table), kept.Hex()).
+				Scan(&city, &op); err != nil {
+				t.Fatalf("Error on test query: %v", err)
+			}
+			if city != "Paris" || op != "update" {
+				t.Errorf("wrong document, expected city Paris and op update, got %s and %s", city, op)
+			}
+
+			if count := countRows(t, s.(*Session), dt.table); count != dt.count {
+				t.Errorf("wrong row count, expected %d, got %d", dt.count, count)
+			}
+			if dt.op != "" {
+				if err := db.QueryRow(fmt.Sprintf("SELECT op FROM %s WHERE id = $1;", dt.table), deleted.Hex()).Scan(&op); err != nil {
+					t.Fatalf("Error on test query: %v", err)
+				}
+				if op != dt.op {
+					t.Errorf("wrong op for deleted document, expected %s, got %s", dt.op, op)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceTime(t *testing.T) {
+	msg := message.From(ops.Insert, "public.docs", data.Data{"_id": 1})
+	if ts := sourceTime(msg); ts != nil {
+		t.Errorf("expected no source time, got %v", ts)
+	}
+	expected := time.Unix(1494946820, 0).UTC()
+	if ts := sourceTime(message.WithSourceTimestamp(1494946820, msg)); ts != expected {
+		t.Errorf("wrong source time, expected %v, got %v", expected, ts)
+	}
+}
+
+// countingExecutor counts the statements executed without running them.
+type countingExecutor struct {
+	execs int
+}
+
+func (e *countingExecutor) Exec(string, ...interface{}) (sql.Result, error) {
+	e.execs++
+	return nil, nil
+}
+
+func (e *countingExecutor) Query(string, ...interface{}) (*sql.Rows, error) {
+	return nil, nil
+}
+
+func TestDocumentsForget(t *testing.T) {
+	d := newDocuments(false)
+	e := &countingExecutor{}
+	d.createTable("public.docs", e)
+	d.createTable("public.docs", e)
+	if e.execs != 1 {
+		t.Errorf("expected the table to be created once, got %d statements", e.execs)
+	}
+	// a rolled back transaction may have created the table
+	d.forget()
+	d.createTable("public.docs", e)
+	if e.execs != 2 {
+		t.Errorf("expected the table to be created again after forget, got %d statements", e.execs)
+	}
+}
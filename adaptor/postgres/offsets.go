@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	"github.com/compose/transporter/offset"
+)
+
+const (
+	// DefaultOffsetTable is the table used for storing offsets when offset_table is not set.
+	DefaultOffsetTable = "transporter_offsets"
+)
+
+var _ offset.Store = &offsetStore{}
+
+// executor is satisfied by both *sql.DB and *sql.Tx so messages can be written with or
+// without a transaction.
+type executor interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// offsetStore implements offset.Store with a table in the sink database. The Writer saves
+// the offset of each message in the same transaction as the message so the offsets always
+// match the data in the table.
+type offsetStore struct {
+	client *Client
+	table  string
+	sink   string
+
+	mu sync.Mutex
+	// committed holds the offsets saved by the Writer so they aren't saved a second time when
+	// the offset.Manager commits them.
+	committed map[string]uint64
+}
+
+func newOffsetStore(c *Client, table, sink string) *offsetStore {
+	if table == "" {
+		table = DefaultOffsetTable
+	}
+	return &offsetStore{
+		client:    c,
+		table:     table,
+		sink:      sink,
+		committed: make(map[string]uint64),
+	}
+}
+
+func (s *offsetStore) db() (*sql.DB, error) {
+	sess, err := s.client.Connect()
+	if err != nil {
+		return nil, err
+	}
+	return sess.(*Session).pqSession, nil
+}
+
+// Load creates the offset table if needed and reads the offsets of the sink.
+func (s *offsetStore) Load() (map[string]uint64, error) {
+	db, err := s.db()
+	if err != nil {
+		return nil, err
+	}
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		sink TEXT NOT NULL,
+		namespace TEXT NOT NULL,
+		log_offset BIGINT NOT NULL,
+		PRIMARY KEY (sink, namespace)
+	);`, s.table))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT namespace, log_offset FROM %s WHERE sink = $1;", s.table), s.sink)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	nsMap := make(map[string]uint64)
+	for rows.Next() {
+		var (
+			ns string
+			o  int64
+		)
+		if err := rows.Scan(&ns, &o); err != nil {
+			return nil, err
+		}
+		nsMap[ns] = uint64(o)
+	}
+	return nsMap, rows.Err()
+}
+
+// Save upserts the offset unless the Writer already saved it with the message.
+func (s *offsetStore) Save(o offset.Offset) error {
+	s.mu.Lock()
+	committed, ok := s.committed[o.Namespace]
+	s.mu.Unlock()
+	if ok && committed == o.LogOffset {
+		return nil
+	}
+	db, err := s.db()
+	if err != nil {
+		return err
+	}
+	return s.save(db, o.Namespace, o.LogOffset)
+}
+
+func (s *offsetStore) save(e executor, ns string, logOffset uint64) error {
+	_, err := e.Exec(fmt.Sprintf(`INSERT INTO %s (sink, namespace, log_offset) VALUES ($1, $2, $3)
+		ON CONFLICT (sink, namespace) DO UPDATE SET log_offset = EXCLUDED.log_offset;`, s.table),
+		s.sink, ns, int64(logOffset))
+	return err
+}
+
+// writeWithOffset runs writeFunc and saves the offset in a single transaction.
+func (s *offsetStore) writeWithOffset(db *sql.DB, ns string, logOffset uint64, writeFunc func(executor) error) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := writeFunc(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := s.save(tx, ns, logOffset); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.markCommitted(map[string]uint64{ns: logOffset})
+	return nil
+}
+
+// markCommitted records offsets saved in a committed transaction.
+func (s *offsetStore) markCommitted(offsets map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ns, logOffset := range offsets {
+		s.committed[ns] = logOffset
+	}
+}
@@ -0,0 +1,294 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"github.com/lib/pq"
+)
+
+// type OIDs of the values decoded by decodeValue, any other type is returned as a string.
+const (
+	boolOID         = 16
+	byteaOID        = 17
+	int8OID         = 20
+	int2OID         = 21
+	int4OID         = 23
+	oidOID          = 26
+	jsonOID         = 114
+	float4OID       = 700
+	float8OID       = 701
+	moneyOID        = 790
+	boolArrayOID    = 1000
+	int2ArrayOID    = 1005
+	int4ArrayOID    = 1007
+	textArrayOID    = 1009
+	bpcharArrayOID  = 1014
+	varcharArrayOID = 1015
+	int8ArrayOID    = 1016
+	float4ArrayOID  = 1021
+	float8ArrayOID  = 1022
+	dateOID         = 1082
+	timestampOID    = 1114
+	timestamptzOID  = 1184
+	numericOID      = 1700
+	jsonbOID        = 3802
+)
+
+var errShortMessage = errors.New("short pgoutput message")
+
+// relation describes a table as sent by pgoutput before the first change to it.
+type relation struct {
+	namespace string
+	columns   []column
+}
+
+type column struct {
+	name    string
+	typeOID uint32
+}
+
+// change is a row inserted, updated or deleted by a transaction.
+type change struct {
+	op        ops.Op
+	namespace string
+	data      data.Data
+}
+
+// pgoutputDecoder decodes the messages of the pgoutput plugin, the relations sent by the
+// server are kept to decode the rows of later changes.
+type pgoutputDecoder struct {
+	relations map[uint32]relation
+}
+
+func newPgoutputDecoder() *pgoutputDecoder {
+	return &pgoutputDecoder{relations: make(map[uint32]relation)}
+}
+
+// pgoutputMessage is the decoded form of one pgoutput message, only one field is set
+// depending on its type.
+type pgoutputMessage struct {
+	begin  bool
+	commit *commitInfo
+	change *change
+}
+
+type commitInfo struct {
+	endLSN    uint64
+	timestamp time.Time
+}
+
+func (d *pgoutputDecoder) decode(msg []byte) (pgoutputMessage, error) {
+	if len(msg) == 0 {
+		return pgoutputMessage{}, errShortMessage
+	}
+	r := &pgReader{b: msg[1:]}
+	switch msg[0] {
+	case 'B':
+		return pgoutputMessage{begin: true}, nil
+	case 'C':
+		r.uint8()  // flags
+		r.uint64() // commit LSN
+		info := &commitInfo{endLSN: r.uint64(), timestamp: postgresTime(r.uint64())}
+		return pgoutputMessage{commit: info}, r.err
+	case 'R':
+		id := r.uint32()
+		rel := relation{namespace: r.string() + "." + r.string()}
+		r.uint8() // replica identity
+		n := int(r.uint16())
+		for i := 0; i < n && r.err == nil; i++ {
+			r.uint8() // flags
+			c := column{name: r.string(), typeOID: r.uint32()}
+			r.uint32() // type modifier
+			rel.columns = append(rel.columns, c)
+		}
+		if r.err == nil {
+			d.relations[id] = rel
+		}
+		return pgoutputMessage{}, r.err
+	case 'I':
+		return d.decodeChange(ops.Insert, r, "N")
+	case 'U':
+		return d.decodeChange(ops.Update, r, "KON")
+	case 'D':
+		return d.decodeChange(ops.Delete, r, "KO")
+	}
+	// origin, type, truncate and logical decoding messages aren't needed
+	return pgoutputMessage{}, nil
+}
+
+// decodeChange reads the tuples of a change, the last one found is used as its data so
+// updates use the new row and deletes the key or old row.
+func (d *pgoutputDecoder) decodeChange(op ops.Op, r *pgReader, tuples string) (pgoutputMessage, error) {
+	id := r.uint32()
+	rel, ok := d.relations[id]
+	if !ok {
+		return pgoutputMessage{}, fmt.Errorf("unknown relation %d", id)
+	}
+	c := &change{op: op, namespace: rel.namespace}
+	for r.err == nil && len(r.b) > 0 && strings.IndexByte(tuples, r.b[0]) >= 0 {
+		r.uint8()
+		tuple, err := decodeTuple(rel, r)
+		if err != nil {
+			return pgoutputMessage{}, err
+		}
+		c.data = tuple
+	}
+	return pgoutputMessage{change: c}, r.err
+}
+
+// decodeTuple decodes the columns of a row, unchanged TOASTed values aren't sent by the
+// server so they are left out of the data.
+func decodeTuple(rel relation, r *pgReader) (data.Data, error) {
+	d := make(data.Data)
+	n := int(r.uint16())
+	for i := 0; i < n && r.err == nil; i++ {
+		if i >= len(rel.columns) {
+			return nil, fmt.Errorf("too many columns for %s", rel.namespace)
+		}
+		col := rel.columns[i]
+		switch r.uint8() {
+		case 'n':
+			d[col.name] = nil
+		case 'u':
+		case 't':
+			v, err := decodeValue(col.typeOID, string(r.bytes(int(r.uint32()))))
+			if err != nil {
+				return nil, fmt.Errorf("unable to decode %s.%s, %s", rel.namespace, col.name, err)
+			}
+			d[col.name] = v
+		}
+	}
+	return d, r.err
+}
+
+// decodeValue converts the text form of a value into the Go type matching its column type.
+func decodeValue(oid uint32, s string) (interface{}, error) {
+	switch oid {
+	case boolOID:
+		return s == "t", nil
+	case int2OID, int4OID, int8OID, oidOID:
+		i, err := strconv.ParseInt(s, 10, 64)
+		return int(i), err
+	case float4OID, float8OID, numericOID:
+		return strconv.ParseFloat(s, 64)
+	case moneyOID:
+		return strconv.ParseFloat(strings.Replace(strings.TrimLeft(s, "$"), ",", "", -1), 64)
+	case byteaOID:
+		return hex.DecodeString(strings.TrimPrefix(s, `\x`))
+	case jsonOID, jsonbOID:
+		var v interface{}
+		err := json.Unmarshal([]byte(s), &v)
+		return v, err
+	case dateOID:
+		return time.Parse("2006-01-02", s)
+	case timestampOID:
+		return time.Parse("2006-01-02 15:04:05.999999999", s)
+	case timestamptzOID:
+		for _, layout := range []string{"-07", "-07:00", "-07:00:00"} {
+			if t, err := time.Parse("2006-01-02 15:04:05.999999999"+layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("invalid timestamp %s", s)
+	case boolArrayOID:
+		var a pq.BoolArray
+		err := a.Scan([]byte(s))
+		return toInterfaces(len(a), func(i int) interface{} { return a[i] }), err
+	case int2ArrayOID, int4ArrayOID, int8ArrayOID:
+		var a pq.Int64Array
+		err := a.Scan([]byte(s))
+		return toInterfaces(len(a), func(i int) interface{} { return int(a[i]) }), err
+	case float4ArrayOID, float8ArrayOID:
+		var a pq.Float64Array
+		err := a.Scan([]byte(s))
+		return toInterfaces(len(a), func(i int) interface{} { return a[i] }), err
+	case textArrayOID, varcharArrayOID, bpcharArrayOID:
+		var a pq.StringArray
+		err := a.Scan([]byte(s))
+		return toInterfaces(len(a), func(i int) interface{} { return a[i] }), err
+	}
+	return s, nil
+}
+
+func toInterfaces(n int, get func(int) interface{}) []interface{} {
+	values := make([]interface{}, n)
+	for i := range values {
+		values[i] = get(i)
+	}
+	return values
+}
+
+func postgresTime(micros uint64) time.Time {
+	return postgresEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
+// pgReader reads the big endian values of a pgoutput message, the first error is kept and
+// every later read returns zero values.
+type pgReader struct {
+	b   []byte
+	err error
+}
+
+func (r *pgReader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = errShortMessage
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *pgReader) uint8() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *pgReader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *pgReader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *pgReader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *pgReader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	i := strings.IndexByte(string(r.b), 0)
+	if i < 0 {
+		r.err = errShortMessage
+		return ""
+	}
+	s := string(r.b[:i])
+	r.b = r.b[i+1:]
+	return s
+}
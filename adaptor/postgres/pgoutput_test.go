@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+)
+
+type pgMessage []byte
+
+func (m pgMessage) byte(b byte) pgMessage { return append(m, b) }
+
+func (m pgMessage) uint16(v uint16) pgMessage {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	return append(m, buf[:]...)
+}
+
+func (m pgMessage) uint32(v uint32) pgMessage { return appendUint32(m, v) }
+
+func (m pgMessage) uint64(v uint64) pgMessage { return appendUint64(m, v) }
+
+func (m pgMessage) string(s string) pgMessage { return append(append(m, s...), 0) }
+
+func (m pgMessage) text(s string) pgMessage { return append(m.byte('t').uint32(uint32(len(s))), s...) }
+
+var (
+	relationMsg = pgMessage{'R'}.uint32(1).string("public").string("users").byte('d').uint16(4).
+			byte(1).string("id").uint32(int4OID).uint32(0).
+			byte(0).string("name").uint32(25).uint32(0).
+			byte(0).string("tags").uint32(textArrayOID).uint32(0).
+			byte(0).string("bio").uint32(25).uint32(0)
+
+	pgoutputTests = []struct {
+		name     string
+		msg      pgMessage
+		expected *change
+	}{
+		{
+			"insert",
+			pgMessage{'I'}.uint32(1).byte('N').uint16(4).text("1").text("alice").text(`{a,"b c"}`).byte('n'),
+			&change{ops.Insert, "public.users", data.Data{"id": 1, "name": "alice", "tags": []interface{}{"a", "b c"}, "bio": nil}},
+		},
+		{
+			"update with unchanged toast",
+			pgMessage{'U'}.uint32(1).byte('K').uint16(4).text("1").byte('n').byte('n').byte('n').
+				byte('N').uint16(4).text("1").text("bob").text("{}").byte('u'),
+			&change{ops.Update, "public.users", data.Data{"id": 1, "name": "bob", "tags": []interface{}{}}},
+		},
+		{
+			"delete",
+			pgMessage{'D'}.uint32(1).byte('K').uint16(4).text("1").byte('n').byte('n').byte('n'),
+			&change{ops.Delete, "public.users", data.Data{"id": 1, "name": nil, "tags": nil, "bio": nil}},
+		},
+	}
+)
+
+func TestPgoutputDecode(t *testing.T) {
+	d := newPgoutputDecoder()
+	if _, err := d.decode(relationMsg); err != nil {
+		t.Fatalf("unexpected relation error, %s", err)
+	}
+	for _, pt := range pgoutputTests {
+		m, err := d.decode(pt.msg)
+		if err != nil {
+			t.Errorf("[%s] unexpected decode error, %s", pt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(m.change, pt.expected) {
+			t.Errorf("[%s] wrong change, expected %+v, got %+v", pt.name, pt.expected, m.change)
+		}
+	}
+
+	ts := time.Date(2020, time.March, 1, 12, 0, 0, 0, time.UTC)
+	commit := pgMessage{'C'}.byte(0).uint64(0x16B3748).uint64(0x16B3780).uint64(uint64(ts.Sub(postgresEpoch) / time.Microsecond))
+	m, err := d.decode(commit)
+	if err != nil {
+		t.Fatalf("unexpected commit error, %s", err)
+	}
+	if m.commit.endLSN != 0x16B3780 || !m.commit.timestamp.Equal(ts) {
+		t.Errorf("wrong commit, expected %X at %s, got %X at %s", 0x16B3780, ts, m.commit.endLSN, m.commit.timestamp)
+	}
+
+	if _, err := d.decode(pgMessage{'I'}.uint32(2).byte('N').uint16(0)); err == nil {
+		t.Error("expected an error for an unknown relation")
+	}
+}
+
+var decodeValueTests = []struct {
+	oid      uint32
+	value    string
+	expected interface{}
+}{
+	{boolOID, "t", true},
+	{int8OID, "9007199254740993", 9007199254740993},
+	{float8OID, "1.5", 1.5},
+	{numericOID, "12.25", 12.25},
+	{byteaOID, `\x6869`, []byte("hi")},
+	{jsonbOID, `{"a": [1, "b"]}`, map[string]interface{}{"a": []interface{}{float64(1), "b"}}},
+	{dateOID, "2020-03-01", time.Date(2020, time.March, 1, 0, 0, 0, 0, time.UTC)},
+	{timestampOID, "2020-03-01 12:30:45.5", time.Date(2020, time.March, 1, 12, 30, 45, 500000000, time.UTC)},
+	{timestamptzOID, "2020-03-01 12:30:45+00", time.Date(2020, time.March, 1, 12, 30, 45, 0, time.FixedZone("", 0))},
+	{int4ArrayOID, "{1,2}", []interface{}{1, 2}},
+	{25, "hello", "hello"},
+}
+
+func TestDecodeValue(t *testing.T) {
+	for _, dt := range decodeValueTests {
+		v, err := decodeValue(dt.oid, dt.value)
+		if err != nil {
+			t.Errorf("unexpected decodeValue error for %s, %s", dt.value, err)
+			continue
+		}
+		if tm, ok := v.(time.Time); ok {
+			if !tm.Equal(dt.expected.(time.Time)) {
+				t.Errorf("wrong time for %s, expected %s, got %s", dt.value, dt.expected, tm)
+			}
+			continue
+		}
+		if !reflect.DeepEqual(v, dt.expected) {
+			t.Errorf("wrong value for %s, expected %#v, got %#v", dt.value, dt.expected, v)
+		}
+	}
+}
+
+func TestLSN(t *testing.T) {
+	lsn, err := parseLSN("16/B374D848")
+	if err != nil {
+		t.Fatalf("unexpected parseLSN error, %s", err)
+	}
+	if lsn != 0x16B374D848 {
+		t.Errorf("wrong LSN, expected %X, got %X", 0x16B374D848, lsn)
+	}
+	if s := formatLSN(lsn); s != "16/B374D848" {
+		t.Errorf("wrong formatLSN, expected 16/B374D848, got %s", s)
+	}
+	if _, err := parseLSN("16B374D848"); err == nil {
+		t.Error("expected an error for an invalid LSN")
+	}
+}
@@ -2,9 +2,11 @@ package postgres
 
 import (
 	"sync"
+	"time"
 
 	"github.com/compose/transporter/adaptor"
 	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/offset"
 
 	_ "github.com/lib/pq" // import pq driver
 )
@@ -16,21 +18,46 @@ const (
   "uri": "${POSTGRESQL_URI}"
   // "debug": false,
   // "tail": false,
-  // "replication_slot": "slot"
+  // "replication_slot": "slot",
+  // "publication": "transporter",
+  // "create_slot": false,
+  // "batch_size": 0,
+  // "batch_timeout": "1s",
+  // "upsert": false,
+  // "bulk_copy": false,
+  // "auto_schema": false,
+  // "type_overrides": {"public.users": {"age": "INTEGER"}},
+  // "document_mode": false,
+  // "soft_delete": false
 }`
 )
 
 var (
-	_ adaptor.Adaptor = &postgres{}
+	_ adaptor.Adaptor       = &postgres{}
+	_ adaptor.Snapshotter   = &postgres{}
+	_ adaptor.OffsetTracker = &postgres{}
 )
 
 // Postgres is an adaptor to read / write to postgres.
 // it works as a source by copying files, and then optionally tailing the oplog
 type postgres struct {
 	adaptor.BaseConfig
-	Debug           bool   `json:"debug" doc:"display debug information"`
-	Tail            bool   `json:"tail" doc:"if tail is true, then the postgres source will tail the oplog after copying the namespace"`
-	ReplicationSlot string `json:"replication_slot" doc:"required if tail is true; sets the replication slot to use for logical decoding"`
+	Debug           bool                         `json:"debug" doc:"display debug information"`
+	Tail            bool                         `json:"tail" doc:"if tail is true, then the postgres source will tail the oplog after copying the namespace"`
+	ReplicationSlot string                       `json:"replication_slot" doc:"required if tail is true; sets the replication slot to use for logical decoding, it must use the pgoutput plugin"`
+	Publication     string                       `json:"publication" doc:"publication streamed when tail is true, defaults to transporter"`
+	CreateSlot      bool                         `json:"create_slot" doc:"if create_slot is true, the replication_slot and publication are created on start when they don't exist"`
+	OffsetTable     string                       `json:"offset_table" doc:"table used to store the sink offsets when the offset_store is sink, defaults to transporter_offsets"`
+	BatchSize       int                          `json:"batch_size" doc:"number of messages written in a single transaction, batching is disabled when 0"`
+	BatchTimeout    string                       `json:"batch_timeout" doc:"how long messages are buffered before the transaction is committed, defaults to 1s"`
+	Upsert          bool                         `json:"upsert" doc:"if upsert is true, inserts update the existing row when the primary key already exists"`
+	BulkCopy        bool                         `json:"bulk_copy" doc:"if bulk_copy is true, inserts read during the initial copy are loaded with COPY, ignored when upsert is true"`
+	AutoSchema      bool                         `json:"auto_schema" doc:"if auto_schema is true, missing tables and columns are created based on the messages written"`
+	TypeOverrides   map[string]map[string]string `json:"type_overrides" doc:"column types used by auto_schema instead of the inferred ones, keyed by namespace and then field"`
+	DocumentMode    bool                         `json:"document_mode" doc:"if document_mode is true, every message is written as a JSONB document to a table with the columns (id, doc, op, source_ts)"`
+	SoftDelete      bool                         `json:"soft_delete" doc:"if soft_delete is true, deletes in document_mode mark the row as deleted instead of removing it"`
+
+	offsets *offsetStore
 }
 
 func init() {
@@ -48,13 +75,72 @@ func (p *postgres) Client() (client.Client, error) {
 
 func (p *postgres) Reader() (client.Reader, error) {
 	if p.Tail {
-		return newTailer(p.ReplicationSlot), nil
+		return newTailer(p.URI, p.ReplicationSlot, p.Publication, p.CreateSlot), nil
 	}
 	return newReader(), nil
 }
 
+// SnapshotReader returns a Reader which copies every table without tailing changes.
+func (p *postgres) SnapshotReader() (client.Reader, error) {
+	return newReader(), nil
+}
+
 func (p *postgres) Writer(done chan struct{}, wg *sync.WaitGroup) (client.Writer, error) {
-	return newWriter(), nil
+	interval := DefaultBatchTimeout
+	if p.BatchTimeout != "" {
+		t, err := time.ParseDuration(p.BatchTimeout)
+		if err != nil {
+			return nil, client.InvalidTimeoutError{Timeout: p.BatchTimeout}
+		}
+		interval = t
+	}
+	if p.DocumentMode {
+		d := newDocuments(p.SoftDelete)
+		if p.BatchSize > 0 {
+			b := newBatcher(p.BatchSize, interval, false, p.offsets, done, wg)
+			b.writeMap = d.writeMap()
+			b.documents = d
+			return b, nil
+		}
+		w := newWriter(false)
+		w.writeMap = d.writeMap()
+		w.offsets = p.offsets
+		return w, nil
+	}
+	var c *copier
+	if p.BulkCopy && !p.Upsert {
+		size := DefaultCopySize
+		if p.BatchSize > 0 {
+			size = p.BatchSize
+		}
+		c = newCopier(size, interval, p.offsets, done, wg)
+	}
+	var sc *schema
+	if p.AutoSchema {
+		sc = newSchema(p.TypeOverrides)
+	}
+	if p.BatchSize > 0 {
+		b := newBatcher(p.BatchSize, interval, p.Upsert, p.offsets, done, wg)
+		b.copier = c
+		b.schema = sc
+		return b, nil
+	}
+	w := newWriter(p.Upsert)
+	w.offsets = p.offsets
+	w.copier = c
+	w.schema = sc
+	return w, nil
+}
+
+// OffsetManager stores the offsets of the named sink in the offset_table, the Writer
+// created afterwards saves each offset in the same transaction as its message.
+func (p *postgres) OffsetManager(name string) (offset.Manager, error) {
+	c, err := NewClient(WithURI(p.URI))
+	if err != nil {
+		return nil, err
+	}
+	p.offsets = newOffsetStore(c, p.OffsetTable, name)
+	return offset.NewStoreManager(p.offsets)
 }
 
 // Description for postgres adaptor
@@ -1,10 +1,15 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/compose/transporter/client"
 	"github.com/compose/transporter/log"
@@ -19,25 +24,46 @@ var (
 
 // Reader implements the behavior defined by client.Reader for interfacing with MongoDB.
 type Reader struct {
+	// snapshot is the name of an exported snapshot the tables are copied from, such as the one
+	// exported when a replication slot is created. The current data is copied when it's empty.
+	snapshot string
 }
 
 func newReader() client.Reader {
 	return &Reader{}
 }
 
+// querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(string, ...interface{}) (*sql.Rows, error)
+}
+
 func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.NsFilterFunc) client.MessageChanFunc {
 	return func(s client.Session, done chan struct{}) (chan client.MessageSet, error) {
 		out := make(chan client.MessageSet)
 		session := s.(*Session)
+		var q querier = session.pqSession
+		var tx *sql.Tx
+		if r.snapshot != "" {
+			var err error
+			tx, err = importSnapshot(session.pqSession, r.snapshot)
+			if err != nil {
+				return nil, err
+			}
+			q = tx
+		}
 		go func() {
 			defer close(out)
+			if tx != nil {
+				defer tx.Rollback()
+			}
 			log.With("db", session.db).Infoln("starting Read func")
-			tables, err := r.listTables(session.db, session.pqSession, filterFn)
+			tables, err := r.listTables(session.db, q, filterFn)
 			if err != nil {
 				log.With("db", session.db).Errorf("unable to list tables, %s", err)
 				return
 			}
-			results := r.iterateTable(session.db, session.pqSession, tables, done)
+			results := r.iterateTable(session.db, q, tables, done)
 			for {
 				select {
 				case <-done:
@@ -58,23 +84,28 @@ func (r *Reader) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 	}
 }
 
-func (r *Reader) listTables(db string, session *sql.DB, filterFn func(name string) bool) (<-chan string, error) {
-	out := make(chan string)
+// listTables reads every table name before sending them since a transaction can't run the
+// queries of iterateTable while the tables are still being read.
+func (r *Reader) listTables(db string, session querier, filterFn func(name string) bool) (<-chan string, error) {
 	tablesResult, err := session.Query("SELECT table_schema,table_name FROM information_schema.tables")
 	if err != nil {
 		return nil, err
 	}
+	var names []string
+	for tablesResult.Next() {
+		var schema string
+		var tname string
+		err = tablesResult.Scan(&schema, &tname)
+		if err != nil {
+			log.With("db", db).Infoln("error scanning table name...")
+			continue
+		}
+		names = append(names, fmt.Sprintf("%s.%s", schema, tname))
+	}
+	out := make(chan string)
 	go func() {
 		defer close(out)
-		for tablesResult.Next() {
-			var schema string
-			var tname string
-			err = tablesResult.Scan(&schema, &tname)
-			if err != nil {
-				log.With("db", db).Infoln("error scanning table name...")
-				continue
-			}
-			name := fmt.Sprintf("%s.%s", schema, tname)
+		for _, name := range names {
 			if filterFn(name) && matchFunc(name) {
 				log.With("db", db).With("table", name).Infoln("sending for iteration...")
 				out <- name
@@ -87,6 +118,19 @@ func (r *Reader) listTables(db string, session *sql.DB, filterFn func(name strin
 	return out, nil
 }
 
+// importSnapshot starts a read only transaction seeing the data of the exported snapshot.
+func importSnapshot(db *sql.DB, snapshot string) (*sql.Tx, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", strings.Replace(snapshot, "'", "''", -1))); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return tx, nil
+}
+
 func matchFunc(table string) bool {
 	if strings.HasPrefix(table, "information_schema.") || strings.HasPrefix(table, "pg_catalog.") {
 		return false
@@ -99,7 +143,7 @@ type doc struct {
 	data  data.Data
 }
 
-func (r *Reader) iterateTable(db string, session *sql.DB, in <-chan string, done chan struct{}) <-chan doc {
+func (r *Reader) iterateTable(db string, session querier, in <-chan string, done chan struct{}) <-chan doc {
 	out := make(chan doc)
 	go func() {
 		defer close(out)
@@ -188,3 +232,57 @@ func (r *Reader) iterateTable(db string, session *sql.DB, in <-chan string, done
 	}()
 	return out
 }
+
+func casifyValue(value string, valueType string) interface{} {
+	findArray := regexp.MustCompile("[[]]$")
+
+	switch {
+	case value == "null":
+		return nil
+	case valueType == "integer" || valueType == "smallint" || valueType == "bigint":
+		i, _ := strconv.Atoi(value)
+		return i
+	case valueType == "double precision" || valueType == "numeric" || valueType == "money":
+		if valueType == "money" { // remove the dollar sign for money
+			value = value[1:]
+		}
+		f, _ := strconv.ParseFloat(value, 64)
+		return f
+	case valueType == "boolean":
+		return value == "true"
+	case valueType == "jsonb[]" || valueType == "json":
+		var m map[string]interface{}
+		json.Unmarshal([]byte(value), &m)
+		return m
+	case len(findArray.FindAllString(valueType, 1)) > 0:
+		var result []interface{}
+		arrayValueType := findArray.ReplaceAllString(valueType, "")
+
+		r := csv.NewReader(strings.NewReader(value[1 : len(value)-1]))
+		arrayValues, err := r.ReadAll()
+		if err != nil {
+			return value
+		}
+
+		for _, arrayValue := range arrayValues[0] {
+			result = append(result, casifyValue(arrayValue, arrayValueType))
+		}
+
+		return result
+	case valueType == "timestamp without time zone":
+		// parse time like 2015-08-21 16:09:02.988058
+		t, err := time.Parse("2006-01-02 15:04:05.9", value)
+		if err != nil {
+			fmt.Printf("\nTime (%v) parse error: %v\n\n", value, err)
+		}
+		return t
+	case valueType == "date":
+		t, err := time.Parse("2006-01-02", value)
+		if err != nil {
+			fmt.Printf("\nTime (%v) parse error: %v\n\n", value, err)
+		}
+		return t
+	}
+
+	return value
+}
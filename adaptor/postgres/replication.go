@@ -0,0 +1,505 @@
+package postgres
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq/scram"
+)
+
+const (
+	protocolVersion = 196608
+	sslRequestCode  = 80877103
+
+	// the XLogData, keepalive and standby status update messages sent inside CopyData
+	xLogDataByte      = 'w'
+	keepaliveByte     = 'k'
+	standbyStatusByte = 'r'
+
+	// maxMessageSize is the largest message accepted from the server, a larger length means
+	// the stream is corrupted.
+	maxMessageSize = 1 << 30
+)
+
+// postgresEpoch is the epoch used for the timestamps of the replication protocol.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// ErrUnsupportedAuth is returned when the server asks for an authentication method the
+// replication connection doesn't implement.
+var ErrUnsupportedAuth = errors.New("unsupported authentication method for replication connection")
+
+// ServerError is an ErrorResponse returned by the server on the replication connection.
+type ServerError struct {
+	Code    string
+	Message string
+}
+
+func (e ServerError) Error() string {
+	return fmt.Sprintf("replication error %s, %s", e.Code, e.Message)
+}
+
+// ConnInfoError is returned when the settings of the replication connection, such as the
+// sslmode or the certificates, are invalid.
+type ConnInfoError struct {
+	Err error
+}
+
+func (e ConnInfoError) Error() string {
+	return fmt.Sprintf("invalid connection settings, %s", e.Err)
+}
+
+// replConn is a connection speaking the streaming replication protocol, which database/sql
+// and lib/pq don't support. It only implements what is needed to run replication commands
+// and stream a logical replication slot.
+type replConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// xLogData is a chunk of WAL sent by the server, for logical replication Data holds a single
+// message of the output plugin.
+type xLogData struct {
+	WALStart uint64
+	WALEnd   uint64
+	Data     []byte
+}
+
+// keepalive is sent by the server periodically, ReplyRequested means a standby status
+// update must be sent right away to avoid being disconnected.
+type keepalive struct {
+	WALEnd         uint64
+	ReplyRequested bool
+}
+
+// dialReplication opens a logical replication connection to the database in uri, which is
+// read along with the PG* environment variables as lib/pq does, see parseConnInfo.
+func dialReplication(uri string) (*replConn, error) {
+	o, err := parseConnInfo(uri, os.Environ())
+	if err != nil {
+		return nil, ConnInfoError{err}
+	}
+	cfg, err := o.tlsConfig()
+	if err != nil {
+		return nil, ConnInfoError{err}
+	}
+	timeout := 30 * time.Second
+	if t, err := strconv.Atoi(o["connect_timeout"]); err == nil && t > 0 {
+		timeout = time.Duration(t) * time.Second
+	}
+	network, address := o.address()
+	conn, err := net.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if network == "tcp" {
+		if conn, err = startTLS(conn, cfg, o["sslmode"]); err != nil {
+			return nil, err
+		}
+	}
+	c := &replConn{conn: conn, r: bufio.NewReader(conn)}
+
+	appName := o["application_name"]
+	if appName == "" {
+		appName = "transporter"
+	}
+	var startup []byte
+	startup = appendUint32(startup, protocolVersion)
+	for _, param := range []string{"user", o["user"], "database", o["dbname"], "replication", "database", "application_name", appName} {
+		startup = append(startup, param...)
+		startup = append(startup, 0)
+	}
+	startup = append(startup, 0)
+	if err := c.send(0, startup); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if err := c.authenticate(o["user"], o["password"]); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if _, err := c.readUntilReady(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// startTLS upgrades the connection with cfg, see connInfo.tlsConfig, a nil cfg leaves it as it
+// is. The handshake is done here so a certificate failing verification is reported right away.
+func startTLS(conn net.Conn, cfg *tls.Config, sslmode string) (net.Conn, error) {
+	if cfg == nil {
+		return conn, nil
+	}
+	var req []byte
+	req = appendUint32(req, 8)
+	req = appendUint32(req, sslRequestCode)
+	if _, err := conn.Write(req); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp := make([]byte, 1)
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp[0] != 'S' {
+		if sslmode == "allow" || sslmode == "prefer" {
+			return conn, nil
+		}
+		conn.Close()
+		return nil, errors.New("SSL is not enabled on the server")
+	}
+	client := tls.Client(conn, cfg)
+	if err := client.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return client, nil
+}
+
+func (c *replConn) authenticate(user, password string) error {
+	for {
+		t, msg, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case 'E':
+			return parseError(msg)
+		case 'R':
+		default:
+			return fmt.Errorf("unexpected message %q during authentication", t)
+		}
+		if len(msg) < 4 {
+			return errors.New("short authentication message")
+		}
+		switch binary.BigEndian.Uint32(msg) {
+		case 0: // AuthenticationOk
+			return nil
+		case 3: // AuthenticationCleartextPassword
+			if err := c.send('p', append([]byte(password), 0)); err != nil {
+				return err
+			}
+		case 5: // AuthenticationMD5Password
+			if len(msg) < 8 {
+				return errors.New("short AuthenticationMD5Password message")
+			}
+			inner := md5.Sum([]byte(password + user))
+			outer := md5.Sum(append([]byte(hex.EncodeToString(inner[:])), msg[4:8]...))
+			if err := c.send('p', append([]byte("md5"+hex.EncodeToString(outer[:])), 0)); err != nil {
+				return err
+			}
+		case 10: // AuthenticationSASL
+			if err := c.authenticateSASL(user, password, msg[4:]); err != nil {
+				return err
+			}
+		default:
+			return ErrUnsupportedAuth
+		}
+	}
+}
+
+func (c *replConn) authenticateSASL(user, password string, mechanisms []byte) error {
+	if !strings.Contains(string(mechanisms), "SCRAM-SHA-256\x00") {
+		return ErrUnsupportedAuth
+	}
+	sc := scram.NewClient(sha256.New, user, password)
+	sc.Step(nil)
+	if sc.Err() != nil {
+		return sc.Err()
+	}
+	out := append([]byte("SCRAM-SHA-256"), 0)
+	out = appendUint32(out, uint32(len(sc.Out())))
+	out = append(out, sc.Out()...)
+	if err := c.send('p', out); err != nil {
+		return err
+	}
+	for {
+		t, msg, err := c.receive()
+		if err != nil {
+			return err
+		}
+		if t == 'E' {
+			return parseError(msg)
+		}
+		if t != 'R' {
+			return fmt.Errorf("unexpected message %q during authentication", t)
+		}
+		if len(msg) < 4 {
+			return errors.New("short authentication message")
+		}
+		switch binary.BigEndian.Uint32(msg) {
+		case 11: // AuthenticationSASLContinue
+			sc.Step(msg[4:])
+			if sc.Err() != nil {
+				return sc.Err()
+			}
+			if err := c.send('p', sc.Out()); err != nil {
+				return err
+			}
+		case 12: // AuthenticationSASLFinal
+			sc.Step(msg[4:])
+			return sc.Err()
+		default:
+			return fmt.Errorf("unexpected SASL authentication message %d", binary.BigEndian.Uint32(msg))
+		}
+	}
+}
+
+// exec runs a replication command, such as IDENTIFY_SYSTEM, and returns the rows it
+// returned as text.
+func (c *replConn) exec(query string) ([][]string, error) {
+	if err := c.send('Q', append([]byte(query), 0)); err != nil {
+		return nil, err
+	}
+	return c.readUntilReady()
+}
+
+// readUntilReady reads the rows returned until the server is ready for the next query.
+func (c *replConn) readUntilReady() ([][]string, error) {
+	var (
+		rows   [][]string
+		result error
+	)
+	for {
+		t, msg, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case 'D':
+			row, err := parseDataRow(msg)
+			if err != nil {
+				return nil, err
+			}
+			rows = append(rows, row)
+		case 'E':
+			result = parseError(msg)
+		case 'Z':
+			return rows, result
+		}
+	}
+}
+
+// createSlot creates a logical replication slot using pgoutput and returns the name of the
+// snapshot it exported along with the slot's consistent point, the LSN of the first change not
+// visible in the snapshot. The snapshot can be imported until the next command is sent on the
+// connection or it is closed, a temporary slot is dropped once the connection is closed.
+func (c *replConn) createSlot(slot string, temporary bool) (string, uint64, error) {
+	kind := "LOGICAL"
+	if temporary {
+		kind = "TEMPORARY LOGICAL"
+	}
+	rows, err := c.exec(fmt.Sprintf(`CREATE_REPLICATION_SLOT %s %s pgoutput EXPORT_SNAPSHOT`, quoteIdentifier(slot), kind))
+	if err != nil {
+		return "", 0, err
+	}
+	// slot_name, consistent_point, snapshot_name, output_plugin
+	if len(rows) != 1 || len(rows[0]) != 4 {
+		return "", 0, fmt.Errorf("unexpected CREATE_REPLICATION_SLOT result %v", rows)
+	}
+	lsn, err := parseLSN(rows[0][1])
+	return rows[0][2], lsn, err
+}
+
+// startReplication streams the changes of the logical replication slot using pgoutput,
+// starting after lsn or from the slot's confirmed position when lsn is 0.
+func (c *replConn) startReplication(slot, publication string, lsn uint64) error {
+	query := fmt.Sprintf(`START_REPLICATION SLOT %s LOGICAL %s (proto_version '1', publication_names '%s')`,
+		quoteIdentifier(slot), formatLSN(lsn), strings.Replace(quoteIdentifier(publication), "'", "''", -1))
+	if err := c.send('Q', append([]byte(query), 0)); err != nil {
+		return err
+	}
+	for {
+		t, msg, err := c.receive()
+		if err != nil {
+			return err
+		}
+		switch t {
+		case 'W': // CopyBothResponse
+			return nil
+		case 'E':
+			err := parseError(msg)
+			c.readUntilReady()
+			return err
+		}
+	}
+}
+
+// receiveCopyData blocks until the server sends the next xLogData or keepalive, io.EOF is
+// returned when the server ends the replication stream.
+func (c *replConn) receiveCopyData() (interface{}, error) {
+	for {
+		t, msg, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch t {
+		case 'd':
+			if len(msg) == 0 {
+				continue
+			}
+			switch msg[0] {
+			case xLogDataByte:
+				if len(msg) < 25 {
+					return nil, errors.New("short XLogData message")
+				}
+				return xLogData{
+					WALStart: binary.BigEndian.Uint64(msg[1:9]),
+					WALEnd:   binary.BigEndian.Uint64(msg[9:17]),
+					Data:     msg[25:],
+				}, nil
+			case keepaliveByte:
+				if len(msg) < 18 {
+					return nil, errors.New("short keepalive message")
+				}
+				return keepalive{
+					WALEnd:         binary.BigEndian.Uint64(msg[1:9]),
+					ReplyRequested: msg[17] == 1,
+				}, nil
+			}
+		case 'E':
+			return nil, parseError(msg)
+		case 'c', 'C', 'Z':
+			return nil, io.EOF
+		}
+	}
+}
+
+// sendStandbyStatus tells the server every change up to lsn has been processed so the slot
+// can release the WAL before it.
+func (c *replConn) sendStandbyStatus(lsn uint64) error {
+	msg := []byte{standbyStatusByte}
+	for i := 0; i < 3; i++ { // written, flushed and applied positions
+		msg = appendUint64(msg, lsn)
+	}
+	msg = appendUint64(msg, uint64(time.Since(postgresEpoch)/time.Microsecond))
+	msg = append(msg, 0)
+	return c.send('d', msg)
+}
+
+// Close ends the replication session.
+func (c *replConn) Close() error {
+	c.send('X', nil)
+	return c.conn.Close()
+}
+
+// send writes a message of type t, the startup message is sent with a t of 0 since it
+// doesn't have a type.
+func (c *replConn) send(t byte, body []byte) error {
+	var msg []byte
+	if t != 0 {
+		msg = append(msg, t)
+	}
+	msg = appendUint32(msg, uint32(len(body)+4))
+	msg = append(msg, body...)
+	_, err := c.conn.Write(msg)
+	return err
+}
+
+func (c *replConn) receive() (byte, []byte, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size < 4 || size-4 > maxMessageSize {
+		return 0, nil, fmt.Errorf("invalid message length %d", size)
+	}
+	msg := make([]byte, size-4)
+	if _, err := io.ReadFull(c.r, msg); err != nil {
+		return 0, nil, err
+	}
+	return header[0], msg, nil
+}
+
+func parseDataRow(msg []byte) ([]string, error) {
+	if len(msg) < 2 {
+		return nil, errors.New("short DataRow message")
+	}
+	n := int(binary.BigEndian.Uint16(msg))
+	row := make([]string, n)
+	pos := 2
+	for i := 0; i < n; i++ {
+		if len(msg)-pos < 4 {
+			return nil, errors.New("short DataRow message")
+		}
+		size := int(int32(binary.BigEndian.Uint32(msg[pos:])))
+		pos += 4
+		if size < 0 {
+			continue
+		}
+		if size > len(msg)-pos {
+			return nil, errors.New("short DataRow message")
+		}
+		row[i] = string(msg[pos : pos+size])
+		pos += size
+	}
+	return row, nil
+}
+
+func parseError(msg []byte) error {
+	var e ServerError
+	for _, field := range strings.Split(string(msg), "\x00") {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'C':
+			e.Code = field[1:]
+		case 'M':
+			e.Message = field[1:]
+		}
+	}
+	return e
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[:]...)
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// formatLSN returns the text form of a WAL location, i.e. 16/B374D848.
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", uint32(lsn>>32), uint32(lsn))
+}
+
+// parseLSN parses the text form of a WAL location.
+func parseLSN(s string) (uint64, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid LSN %s", s)
+	}
+	hi, err := strconv.ParseUint(parts[0], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %s", s)
+	}
+	lo, err := strconv.ParseUint(parts[1], 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid LSN %s", s)
+	}
+	return hi<<32 | lo, nil
+}
@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestReceiveInvalidLength(t *testing.T) {
+	for _, length := range []uint32{0, 3, maxMessageSize + 5, 0xFFFFFFFF} {
+		c := &replConn{r: bufio.NewReader(bytes.NewReader(pgMessage{'D'}.uint32(length)))}
+		if _, _, err := c.receive(); err == nil || err == io.ErrUnexpectedEOF {
+			t.Errorf("expected an invalid length error for %d, got %v", length, err)
+		}
+	}
+	c := &replConn{r: bufio.NewReader(bytes.NewReader(pgMessage{'Z'}.uint32(5).byte('I')))}
+	if typ, msg, err := c.receive(); err != nil || typ != 'Z' || string(msg) != "I" {
+		t.Errorf("wrong message, got %q %q %v", typ, msg, err)
+	}
+}
+
+var parseDataRowTests = []struct {
+	name     string
+	msg      pgMessage
+	expected []string
+}{
+	{"row", pgMessage{}.uint16(3).uint32(1).byte('a').uint32(0xFFFFFFFF).uint32(2).byte('b').byte('c'), []string{"a", "", "bc"}},
+	{"empty", pgMessage{}, nil},
+	{"missing field", pgMessage{}.uint16(2).uint32(1).byte('a'), nil},
+	{"short field size", pgMessage{}.uint16(1).byte(0).byte(0), nil},
+	{"truncated field", pgMessage{}.uint16(1).uint32(10).byte('a'), nil},
+	{"field size overflow", pgMessage{}.uint16(1).uint32(0x7FFFFFFF).byte('a'), nil},
+}
+
+func TestParseDataRow(t *testing.T) {
+	for _, dt := range parseDataRowTests {
+		t.Run(dt.name, func(t *testing.T) {
+			row, err := parseDataRow(dt.msg)
+			if dt.expected == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %v", row)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error, %s", err)
+			}
+			if !reflect.DeepEqual(row, dt.expected) {
+				t.Errorf("wrong row, expected %v, got %v", dt.expected, row)
+			}
+		})
+	}
+}
+
+func TestAuthenticateShortMessage(t *testing.T) {
+	for _, msg := range []pgMessage{{}, pgMessage{}.uint32(5).byte(1)} {
+		resp := append(pgMessage{'R'}.uint32(uint32(len(msg)+4)), msg...)
+		c := &replConn{r: bufio.NewReader(bytes.NewReader(resp))}
+		if err := c.authenticate("postgres", "secret"); err == nil {
+			t.Errorf("expected an error for %v", msg)
+		}
+	}
+}
+
+var permanentErrorTests = []struct {
+	err       error
+	permanent bool
+}{
+	{ServerError{Code: "42704", Message: `replication slot "foo" does not exist`}, true},
+	{ServerError{Code: "28P01", Message: "password authentication failed"}, true},
+	{ServerError{Code: "42501", Message: "must be superuser or replication role"}, true},
+	{ServerError{Code: "3D000", Message: `database "foo" does not exist`}, true},
+	{ServerError{Code: "55006", Message: "replication slot is active"}, false},
+	{ServerError{Code: "57P01", Message: "terminating connection due to administrator command"}, false},
+	{ErrUnsupportedAuth, true},
+	{ConnInfoError{errors.New(`unsupported sslmode "always"`)}, true},
+	{x509.UnknownAuthorityError{}, true},
+	{io.EOF, false},
+	{errors.New("connection reset by peer"), false},
+}
+
+func TestPermanentError(t *testing.T) {
+	for _, pt := range permanentErrorTests {
+		if permanentError(pt.err) != pt.permanent {
+			t.Errorf("wrong classification of %v, expected permanent %t", pt.err, pt.permanent)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package postgres
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/compose/mejson"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// schema creates the tables and columns needed to write a message when auto_schema is
+// enabled. The columns of every table are loaded once and cached, so it assumes nothing else
+// drops columns from the tables while the pipeline runs.
+type schema struct {
+	overrides map[string]map[string]string
+
+	mu     sync.Mutex
+	tables map[string]map[string]bool
+}
+
+func newSchema(overrides map[string]map[string]string) *schema {
+	return &schema{
+		overrides: overrides,
+		tables:    make(map[string]map[string]bool),
+	}
+}
+
+// changes returns the DDL statements needed before the message can be written, it is empty
+// when the table already has every column of the message.
+func (s *schema) changes(e executor, msg message.Msg) ([]string, error) {
+	if msg.OP() != ops.Insert && msg.OP() != ops.Update {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	columns, ok := s.tables[msg.Namespace()]
+	if !ok {
+		var err error
+		if columns, err = tableColumns(msg.Namespace(), e); err != nil {
+			return nil, err
+		}
+		s.tables[msg.Namespace()] = columns
+	}
+
+	var keys []string
+	for key := range msg.Data() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(columns) == 0 {
+		var defs []string
+		for _, key := range keys {
+			defs = append(defs, fmt.Sprintf("%v %v", key, s.columnType(msg.Namespace(), key, msg.Data()[key])))
+		}
+		if pkey := primaryKeyField(msg); pkey != "" {
+			defs = append(defs, fmt.Sprintf("PRIMARY KEY (%v)", pkey))
+		}
+		return []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %v (%v);", msg.Namespace(), strings.Join(defs, ", "))}, nil
+	}
+
+	var stmts []string
+	for _, key := range keys {
+		if !columns[key] {
+			stmts = append(stmts, fmt.Sprintf("ALTER TABLE %v ADD COLUMN IF NOT EXISTS %v %v;", msg.Namespace(), key, s.columnType(msg.Namespace(), key, msg.Data()[key])))
+		}
+	}
+	return stmts, nil
+}
+
+// apply runs the statements returned by changes and records the columns of the message as
+// created.
+func (s *schema) apply(e executor, msg message.Msg, stmts []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, stmt := range stmts {
+		log.With("table", msg.Namespace()).With("ddl", stmt).Infoln("applying schema change")
+		if _, err := e.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	columns, ok := s.tables[msg.Namespace()]
+	if !ok {
+		columns = make(map[string]bool)
+		s.tables[msg.Namespace()] = columns
+	}
+	for key := range msg.Data() {
+		columns[key] = true
+	}
+	return nil
+}
+
+// columnType returns the type configured in type_overrides for the field or infers one from
+// the value.
+func (s *schema) columnType(namespace, key string, value interface{}) string {
+	if t, ok := s.overrides[namespace][key]; ok {
+		return t
+	}
+	switch value.(type) {
+	case bool:
+		return "BOOLEAN"
+	case int, int8, int16, int32, int64, uint8, uint16, uint32:
+		return "BIGINT"
+	case uint, uint64:
+		return "NUMERIC"
+	case float32, float64:
+		return "DOUBLE PRECISION"
+	case time.Time:
+		return "TIMESTAMPTZ"
+	case []byte:
+		return "BYTEA"
+	case map[string]interface{}, mejson.M, []map[string]interface{}, mejson.S:
+		return "JSONB"
+	case []interface{}:
+		return "TEXT[]"
+	}
+	return "TEXT"
+}
+
+// primaryKeyField returns the field used as the primary key of a created table, _id for
+// documents coming from MongoDB and id otherwise.
+func primaryKeyField(msg message.Msg) string {
+	for _, key := range []string{"_id", "id"} {
+		if _, ok := msg.Data()[key]; ok {
+			return key
+		}
+	}
+	return ""
+}
+
+func tableColumns(namespace string, e executor) (map[string]bool, error) {
+	tableSchema, tableName := "public", namespace
+	if i := strings.Index(namespace, "."); i >= 0 {
+		tableSchema, tableName = namespace[:i], namespace[i+1:]
+	}
+	rows, err := e.Query(`SELECT column_name FROM information_schema.columns
+		WHERE table_schema = $1 AND table_name = $2;`, tableSchema, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var column string
+		if err := rows.Scan(&column); err != nil {
+			return nil, err
+		}
+		columns[column] = true
+	}
+	return columns, rows.Err()
+}
+
+// ensureSchema applies any schema changes needed by the message and returns the message to
+// write. An open COPY holds a lock on its table which would block the DDL so the copier is
+// flushed first.
+func ensureSchema(s *schema, c *copier, db *sql.DB, msg message.Msg) (message.Msg, error) {
+	if s == nil {
+		return msg, nil
+	}
+	stmts, err := s.changes(db, msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(stmts) > 0 {
+		if c != nil {
+			if err := c.flush(); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.apply(db, msg, stmts); err != nil {
+			return nil, err
+		}
+	}
+	return hexObjectIDs(msg), nil
+}
+
+// hexObjectIDs returns the message with its ObjectIds replaced by their hex string, the form
+// stored in the text column created for them. The data of msg is left untouched since it may
+// be shared with other sinks.
+func hexObjectIDs(msg message.Msg) message.Msg {
+	var d data.Data
+	for key, value := range msg.Data() {
+		id, ok := value.(bson.ObjectId)
+		if !ok {
+			continue
+		}
+		if d == nil {
+			d = make(data.Data, len(msg.Data()))
+			for k, v := range msg.Data() {
+				d[k] = v
+			}
+		}
+		d[key] = id.Hex()
+	}
+	m, ok := message.Copy(msg).(*message.Base)
+	if d == nil || !ok {
+		return msg
+	}
+	m.MapData = d
+	return m
+}
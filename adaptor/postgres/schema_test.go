@@ -0,0 +1,88 @@
+package postgres
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	schemaTestData = &TestData{"writer_schema_test", "schema_test_table", basicSchema, 0}
+)
+
+func TestAutoSchema(t *testing.T) {
+	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", schemaTestData.DB)))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to postgres, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to postgres, %s", err)
+	}
+
+	// the table is not part of the test data so it must be created by the writer
+	ns := "public.auto_schema_table"
+	w := newWriter(false)
+	w.schema = newSchema(map[string]map[string]string{ns: {"age": "INTEGER"}})
+	for _, d := range []data.Data{
+		{"_id": "a", "name": "alice"},
+		{"_id": "b", "name": "bob", "age": 30, "tags": map[string]interface{}{"admin": true}},
+	} {
+		if _, err := w.Write(message.From(ops.Insert, ns, d))(s); err != nil {
+			t.Fatalf("unexpected Insert error, %s\n", err)
+		}
+	}
+
+	var age int
+	if err := s.(*Session).pqSession.QueryRow("SELECT age FROM auto_schema_table WHERE _id = 'b';").Scan(&age); err != nil {
+		t.Fatalf("Error on test query: %v", err)
+	}
+	if age != 30 {
+		t.Errorf("wrong age, expected 30, got %d", age)
+	}
+
+	columnTests := map[string]string{
+		"_id":  "text",
+		"name": "text",
+		"age":  "integer",
+		"tags": "jsonb",
+	}
+	for column, expected := range columnTests {
+		var dataType string
+		if err := s.(*Session).pqSession.QueryRow(`SELECT data_type FROM information_schema.columns
+			WHERE table_name = 'auto_schema_table' AND column_name = $1;`, column).Scan(&dataType); err != nil {
+			t.Fatalf("unable to find column %s, %s", column, err)
+		}
+		if dataType != expected {
+			t.Errorf("wrong type for %s, expected %s, got %s", column, expected, dataType)
+		}
+	}
+}
+
+func TestHexObjectIDs(t *testing.T) {
+	id := bson.NewObjectId()
+	confirms := make(chan struct{})
+	in := message.WithConfirms(confirms, message.From(ops.Insert, "public.hex_ids", data.Data{"_id": id, "name": "alice"}))
+	out := hexObjectIDs(in)
+	expected := data.Data{"_id": id.Hex(), "name": "alice"}
+	if !reflect.DeepEqual(out.Data(), expected) {
+		t.Errorf("wrong data, expected %#v, got %#v", expected, out.Data())
+	}
+	if out.Confirms() != confirms {
+		t.Error("expected the confirms of the message to be kept")
+	}
+	if in.Data()["_id"] != id {
+		t.Errorf("data of the original message was modified, %#v", in.Data())
+	}
+
+	noIDs := message.From(ops.Insert, "public.hex_ids", data.Data{"_id": "a"})
+	if out := hexObjectIDs(noIDs); out != noIDs {
+		t.Error("expected a message without ObjectIds to be returned as is")
+	}
+}
@@ -0,0 +1,182 @@
+package postgres
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/log"
+)
+
+// ErrNoSlot is returned by DropSlot when no slot is named and replication_slot isn't set.
+var ErrNoSlot = errors.New("no replication slot given and replication_slot is not set")
+
+// SlotNotOwnedError is returned by DropSlot for a slot the pipeline doesn't use, which may belong
+// to a replica or another tool, unless the drop is forced.
+type SlotNotOwnedError struct {
+	Name string
+}
+
+func (e SlotNotOwnedError) Error() string {
+	return fmt.Sprintf("replication slot %s is not the replication_slot of the pipeline, force the drop to drop it anyway", e.Name)
+}
+
+var _ adaptor.SlotManager = &postgres{}
+
+// exportSnapshot creates a replication slot to export a snapshot the initial copy reads from,
+// the connection it was created on is returned along with the name of the snapshot and the LSN
+// streaming continues from so the copy and the changes streamed afterwards don't overlap. The
+// snapshot can only be imported until the connection is closed.
+//
+// With createSlot, the publication and the replication_slot are created when they don't exist.
+// Otherwise a temporary slot is created only for its snapshot, it's dropped once the connection
+// is closed. When the temporary slot can't be created, such as when max_replication_slots is
+// reached, a nil connection is returned and the copy reads the current data.
+func (t *Tailer) exportSnapshot(s *Session, filterFn client.NsFilterFunc) (*replConn, string, uint64, error) {
+	var exists bool
+	if t.createSlot {
+		if err := ensurePublication(s, t.publication, filterFn); err != nil {
+			return nil, "", 0, err
+		}
+		var err error
+		if exists, err = slotExists(s, t.replicationSlot); err != nil {
+			return nil, "", 0, err
+		}
+	}
+	name, temporary := t.replicationSlot, !t.createSlot || exists
+	if temporary {
+		name += "_snapshot"
+	}
+	conn, snapshot, lsn, err := createSlot(t.uri, name, temporary)
+	if err == nil {
+		if !temporary {
+			log.With("db", s.db).With("replication_slot", name).Infoln("created replication slot")
+		}
+		return conn, snapshot, lsn, nil
+	}
+	if !temporary {
+		return nil, "", 0, err
+	}
+	log.With("db", s.db).Errorf("unable to export a snapshot, the copy may include changes streamed afterwards, %s", err)
+	return nil, "", 0, nil
+}
+
+func slotExists(s *Session, name string) (bool, error) {
+	var exists bool
+	err := s.pqSession.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1);", name).Scan(&exists)
+	return exists, err
+}
+
+// createSlot creates the slot on a new replication connection which is closed on error.
+func createSlot(uri, name string, temporary bool) (*replConn, string, uint64, error) {
+	conn, err := dialReplication(uri)
+	if err != nil {
+		return nil, "", 0, err
+	}
+	snapshot, lsn, err := conn.createSlot(name, temporary)
+	if err != nil {
+		conn.Close()
+		return nil, "", 0, err
+	}
+	return conn, snapshot, lsn, nil
+}
+
+// ensurePublication creates the publication for the tables matching filterFn when it doesn't
+// exist. Tables created afterwards need to be added to the publication manually.
+func ensurePublication(s *Session, publication string, filterFn client.NsFilterFunc) error {
+	var exists bool
+	err := s.pqSession.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1);", publication).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+	tables, err := publicationTables(s.pqSession, filterFn)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("CREATE PUBLICATION %s", quoteIdentifier(publication))
+	if len(tables) > 0 {
+		query += " FOR TABLE " + strings.Join(tables, ", ")
+	}
+	if _, err := s.pqSession.Exec(query); err != nil {
+		return err
+	}
+	log.With("db", s.db).With("publication", publication).With("num_tables", len(tables)).Infoln("created publication")
+	return nil
+}
+
+// publicationTables returns the quoted names of the tables matching filterFn.
+func publicationTables(db *sql.DB, filterFn client.NsFilterFunc) ([]string, error) {
+	rows, err := db.Query("SELECT schemaname, tablename FROM pg_tables WHERE schemaname NOT IN ('pg_catalog', 'information_schema');")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var schema, table string
+		if err := rows.Scan(&schema, &table); err != nil {
+			return nil, err
+		}
+		if filterFn(schema + "." + table) {
+			tables = append(tables, quoteIdentifier(schema)+"."+quoteIdentifier(table))
+		}
+	}
+	return tables, rows.Err()
+}
+
+// ownsSlot reports whether the slot is the replication_slot or the temporary slot created to
+// export its snapshot, see exportSnapshot.
+func (p *postgres) ownsSlot(name string) bool {
+	return p.ReplicationSlot != "" && (name == p.ReplicationSlot || name == p.ReplicationSlot+"_snapshot")
+}
+
+// Slots lists the logical replication slots of the database, the slot set as replication_slot
+// and its snapshot slot are marked as owned.
+func (p *postgres) Slots() ([]adaptor.Slot, error) {
+	db, err := sql.Open("postgres", p.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	rows, err := db.Query(`SELECT slot_name, plugin, active, COALESCE(pg_wal_lsn_diff(pg_current_wal_lsn(), restart_lsn), 0)::bigint
+    FROM pg_replication_slots WHERE slot_type = 'logical' AND database = current_database() ORDER BY slot_name;`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var slots []adaptor.Slot
+	for rows.Next() {
+		var s adaptor.Slot
+		if err := rows.Scan(&s.Name, &s.Plugin, &s.Active, &s.RetainedBytes); err != nil {
+			return nil, err
+		}
+		s.Owned = p.ownsSlot(s.Name)
+		slots = append(slots, s)
+	}
+	return slots, rows.Err()
+}
+
+// DropSlot drops the named replication slot, or the replication_slot when name is empty. Only
+// the slots the pipeline owns are dropped unless force is set. The server refuses to drop a slot
+// while a pipeline is streaming from it.
+func (p *postgres) DropSlot(name string, force bool) error {
+	if name == "" {
+		name = p.ReplicationSlot
+	}
+	if name == "" {
+		return ErrNoSlot
+	}
+	if !force && !p.ownsSlot(name) {
+		return SlotNotOwnedError{name}
+	}
+	db, err := sql.Open("postgres", p.URI)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.Exec("SELECT pg_drop_replication_slot($1);", name)
+	return err
+}
@@ -0,0 +1,24 @@
+package postgres
+
+import "testing"
+
+func TestDropSlotNotOwned(t *testing.T) {
+	p := &postgres{ReplicationSlot: "transporter_slot"}
+	for _, name := range []string{"replica_slot", "other_snapshot", "transporter"} {
+		if err := p.DropSlot(name, false); err != (SlotNotOwnedError{name}) {
+			t.Errorf("expected %s to be refused, got %v", name, err)
+		}
+	}
+	for _, name := range []string{"transporter_slot", "transporter_slot_snapshot"} {
+		if !p.ownsSlot(name) {
+			t.Errorf("expected %s to be owned", name)
+		}
+	}
+	p.ReplicationSlot = ""
+	if p.ownsSlot("_snapshot") {
+		t.Error("expected no slot to be owned without replication_slot")
+	}
+	if err := p.DropSlot("", true); err != ErrNoSlot {
+		t.Errorf("expected ErrNoSlot, got %v", err)
+	}
+}
@@ -1,68 +1,161 @@
 package postgres
 
 import (
-	"encoding/csv"
-	"encoding/json"
-	"fmt"
-	"regexp"
-	"strconv"
-	"strings"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/compose/transporter/client"
 	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/log"
 	"github.com/compose/transporter/message"
-	"github.com/compose/transporter/message/data"
-	"github.com/compose/transporter/message/ops"
+)
+
+const (
+	// DefaultPublication is the publication streamed when publication is not set.
+	DefaultPublication = "transporter"
+
+	// standbyTimeout is how often the confirmed LSN is sent to the server, it needs to be
+	// lower than the server's wal_sender_timeout (60s by default).
+	standbyTimeout = 10 * time.Second
+
+	// reconnectInterval is how long the Tailer waits before reconnecting after the
+	// replication stream failed.
+	reconnectInterval = 5 * time.Second
 )
 
 var (
-	_ client.Reader = &Tailer{}
+	_ client.Reader            = &Tailer{}
+	_ client.PositionConfirmer = &Tailer{}
+	_ client.ErrorReporter     = &Tailer{}
 )
 
 // Tailer implements the behavior defined by client.Tailer for interfacing with the MongoDB oplog.
 type Tailer struct {
 	reader          client.Reader
+	uri             string
 	replicationSlot string
+	publication     string
+	createSlot      bool
+
+	mu        sync.Mutex
+	confirmed uint64 // newest LSN committed by every sink
+	sent      uint64 // end LSN of the last transaction sent
+	processed uint64 // end LSN of the last transaction read, including ones with nothing to send
+	err       error  // why the Tailer stopped before done was closed
 }
 
-func newTailer(replicationSlot string) client.Reader {
-	return &Tailer{newReader(), replicationSlot}
+func newTailer(uri, replicationSlot, publication string, createSlot bool) client.Reader {
+	if publication == "" {
+		publication = DefaultPublication
+	}
+	return &Tailer{
+		reader:          newReader(),
+		uri:             uri,
+		replicationSlot: replicationSlot,
+		publication:     publication,
+		createSlot:      createSlot,
+	}
 }
 
-// Tail does the things
+// Read copies every table and then streams the changes of the replication slot using the
+// pgoutput plugin. Each message carries the end LSN of its transaction as its Position, the
+// largest one in the resumeMap is where streaming continues from after a restart.
+//
+// The slot is only advanced to the LSN every sink has committed, as reported by
+// ConfirmPosition, so changes which haven't reached the sinks are sent again by the server
+// if the commit log is lost.
+//
+// The tables are copied from a snapshot exported by creating a slot, see exportSnapshot, and
+// streaming starts from the snapshot's consistent point. The copied messages carry that LSN
+// as their Position. The tables are only copied when the resumeMap has no Position or the
+// replication_slot doesn't exist, otherwise the slot retained every change made since and
+// streaming continues from the Position.
 func (t *Tailer) Read(resumeMap map[string]client.MessageSet, filterFn client.NsFilterFunc) client.MessageChanFunc {
 	return func(s client.Session, done chan struct{}) (chan client.MessageSet, error) {
-		readFunc := t.reader.Read(resumeMap, filterFn)
-		msgChan, err := readFunc(s, done)
-		if err != nil {
-			return nil, err
-		}
 		session := s.(*Session)
+		lsn := resumeLSN(resumeMap)
+		copyTables := true
+		if lsn > 0 {
+			exists, err := slotExists(session, t.replicationSlot)
+			if err != nil {
+				return nil, err
+			}
+			copyTables = !exists
+		}
+		var msgChan chan client.MessageSet
+		var snapshotLSN uint64
+		if copyTables {
+			conn, snapshot, l, err := t.exportSnapshot(session, filterFn)
+			if err != nil {
+				return nil, err
+			}
+			snapshotLSN = l
+			reader := t.reader
+			if conn != nil {
+				// the snapshot is imported before readFunc returns
+				defer conn.Close()
+				reader = &Reader{snapshot: snapshot}
+			}
+			readFunc := reader.Read(resumeMap, filterFn)
+			if msgChan, err = readFunc(s, done); err != nil {
+				return nil, err
+			}
+		} else {
+			log.With("db", session.db).With("replication_slot", t.replicationSlot).Infoln("resuming from the replication slot, skipping copy")
+		}
 		out := make(chan client.MessageSet)
 		go func() {
 			defer close(out)
 			// read until reader done
-			for msg := range msgChan {
-				out <- msg
+			var position []byte
+			if snapshotLSN > 0 {
+				position = []byte(formatLSN(snapshotLSN))
+			}
+			if msgChan != nil {
+				for msg := range msgChan {
+					msg.Position = position
+					out <- msg
+				}
 			}
 			// start tailing
-			log.With("db", session.db).With("logical_decoding_slot", t.replicationSlot).Infoln("Listening for changes...")
+			if snapshotLSN > lsn {
+				lsn = snapshotLSN
+			}
+			slotLSN, err := confirmedFlushLSN(session, t.replicationSlot)
+			if err != nil {
+				log.With("db", session.db).Errorf("unable to read replication slot, %s", err)
+				t.setErr(err)
+				return
+			}
+			t.mu.Lock()
+			t.sent = lsn
+			if slotLSN > t.confirmed {
+				t.confirmed = slotLSN
+			}
+			t.mu.Unlock()
 			for {
+				log.With("db", session.db).
+					With("replication_slot", t.replicationSlot).
+					With("publication", t.publication).
+					With("lsn", formatLSN(lsn)).
+					Infoln("Listening for changes...")
+				lsn, err = t.stream(lsn, filterFn, out, done)
+				if err == nil {
+					log.With("db", session.db).Infoln("tailing stopping...")
+					return
+				}
+				log.With("db", session.db).Errorf("error streaming logical replication, %s", err)
+				if permanentError(err) {
+					t.setErr(err)
+					return
+				}
 				select {
 				case <-done:
-					log.With("db", session.db).Infoln("tailing stopping...")
 					return
-				case <-time.After(time.Second):
-					msgSlice, err := t.pluckFromLogicalDecoding(s.(*Session), filterFn)
-					if err != nil {
-						log.With("db", session.db).Errorf("error plucking from logical decoding %v", err)
-						continue
-					}
-					for _, msg := range msgSlice {
-						out <- msg
-					}
+				case <-time.After(reconnectInterval):
 				}
 			}
 		}()
@@ -71,218 +164,202 @@ func (t *Tailer) Read(resumeMap map[string]client.MessageSet, filterFn client.Ns
 	}
 }
 
-// Use Postgres logical decoding to retrieve the latest changes
-func (t *Tailer) pluckFromLogicalDecoding(s *Session, filterFn client.NsFilterFunc) ([]client.MessageSet, error) {
-	var result []client.MessageSet
-	dataMatcher := regexp.MustCompile(`(?s)^table ([^\.]+)\.([^:]+): (INSERT|DELETE|UPDATE): (.+)$`) // 1 - schema, 2 - table, 3 - action, 4 - remaining
-
-	changesResult, err := s.pqSession.Query(fmt.Sprintf("SELECT * FROM pg_logical_slot_get_changes('%v', NULL, NULL);", t.replicationSlot))
+// stream sends the changes committed after lsn until done is closed or the connection fails,
+// the end LSN of the last transaction sent is returned so streaming can be restarted from it.
+// Transactions are buffered until their commit since the LSN is only known then.
+func (t *Tailer) stream(lsn uint64, filterFn client.NsFilterFunc, out chan<- client.MessageSet, done chan struct{}) (uint64, error) {
+	conn, err := dialReplication(t.uri)
 	if err != nil {
-		return result, err
+		return lsn, err
 	}
-
-	for changesResult.Next() {
-		var (
-			location string
-			xid      string
-			d        string
-		)
-
-		err = changesResult.Scan(&location, &xid, &d)
-		if err != nil {
-			return result, err
-		}
-
-		// Ensure we are getting a data change row
-		dataMatches := dataMatcher.FindStringSubmatch(d)
-		if len(dataMatches) == 0 {
-			continue
-		}
-
-		// Skippable because no primary key on record
-		// Make sure we are getting changes on valid tables
-		schemaAndTable := fmt.Sprintf("%v.%v", dataMatches[1], dataMatches[2])
-		if !filterFn(schemaAndTable) {
-			continue
-		}
-
-		if dataMatches[4] == "(no-tuple-data)" {
-			log.With("op", dataMatches[3]).With("schema", schemaAndTable).Infoln("no tuple data")
-			continue
-		}
-
-		// normalize the action
-		var action ops.Op
-		switch dataMatches[3] {
-		case "INSERT":
-			action = ops.Insert
-		case "DELETE":
-			action = ops.Delete
-		case "UPDATE":
-			action = ops.Update
-		default:
-			return result, fmt.Errorf("Error processing action from string: %v", d)
-		}
-
-		log.With("op", action).With("table", schemaAndTable).Debugln("received")
-
-		docMap := parseLogicalDecodingData(dataMatches[4])
-		result = append(result, client.MessageSet{
-			Msg:  message.From(action, schemaAndTable, docMap),
-			Mode: commitlog.Sync,
-		})
+	defer conn.Close()
+	if err := conn.startReplication(t.replicationSlot, t.publication, lsn); err != nil {
+		return lsn, err
 	}
 
-	return result, err
-}
-
-func parseLogicalDecodingData(d string) data.Data {
-	data := make(data.Data)
-	var (
-		label                  string
-		labelFinished          bool
-		valueType              string
-		valueTypeFinished      bool
-		openBracketInValueType bool
-		skippedColon           bool
-		value                  string // will type switch later
-		valueEndCharacter      string
-		deferredSingleQuote    bool
-		valueFinished          bool
-	)
-
-	valueTypeFinished = false
-	labelFinished = false
-	skippedColon = false
-	deferredSingleQuote = false
-	openBracketInValueType = false
-	valueFinished = false
-
-	for _, character := range d {
-		if !labelFinished {
-			if string(character) == "[" {
-				labelFinished = true
-				continue
+	stop := make(chan struct{})
+	defer close(stop)
+	msgs := make(chan interface{})
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			msg, err := conn.receiveCopyData()
+			if err != nil {
+				errc <- err
+				return
 			}
-			label = fmt.Sprintf("%v%v", label, string(character))
-			continue
-		}
-		if !valueTypeFinished {
-			if openBracketInValueType && string(character) == "]" { // if a bracket is open, close it
-				openBracketInValueType = false
-			} else if string(character) == "]" { // if a bracket is not open, finish valueType
-				valueTypeFinished = true
-				continue
-			} else if string(character) == "[" {
-				openBracketInValueType = true
+			select {
+			case msgs <- msg:
+			case <-stop:
+				return
 			}
-			valueType = fmt.Sprintf("%v%v", valueType, string(character))
-			continue
-		}
-
-		if !skippedColon && string(character) == ":" {
-			skippedColon = true
-			continue
 		}
-
-		if len(valueEndCharacter) == 0 {
-			if string(character) == "'" {
-				valueEndCharacter = "'"
-				continue
+	}()
+
+	decoder := newPgoutputDecoder()
+	var txn []*change
+	ticker := time.NewTicker(standbyTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return lsn, t.sendStandbyStatus(conn)
+		case err := <-errc:
+			return lsn, err
+		case <-ticker.C:
+			if err := t.sendStandbyStatus(conn); err != nil {
+				return lsn, err
+			}
+		case msg := <-msgs:
+			switch msg := msg.(type) {
+			case keepalive:
+				if msg.ReplyRequested {
+					if err := t.sendStandbyStatus(conn); err != nil {
+						return lsn, err
+					}
+				}
+			case xLogData:
+				m, err := decoder.decode(msg.Data)
+				if err != nil {
+					return lsn, err
+				}
+				switch {
+				case m.begin:
+					txn = txn[:0]
+				case m.change != nil:
+					if filterFn(m.change.namespace) {
+						log.With("op", m.change.op).With("table", m.change.namespace).Debugln("received")
+						txn = append(txn, m.change)
+					}
+				case m.commit != nil:
+					if len(txn) > 0 {
+						t.mu.Lock()
+						t.sent = m.commit.endLSN
+						t.mu.Unlock()
+					}
+					position := []byte(formatLSN(m.commit.endLSN))
+					for _, c := range txn {
+						msg := message.From(c.op, c.namespace, c.data).(*message.Base)
+						msg.TS = m.commit.timestamp.Unix()
+						select {
+						case out <- client.MessageSet{Msg: msg, Timestamp: msg.TS, Mode: commitlog.Sync, Position: position}:
+						case <-done:
+							return lsn, nil
+						}
+					}
+					txn = txn[:0]
+					lsn = m.commit.endLSN
+					t.mu.Lock()
+					t.processed = lsn
+					t.mu.Unlock()
+				}
 			}
-			valueEndCharacter = " "
 		}
+	}
+}
 
-		// ending with '
-		if deferredSingleQuote && string(character) == " " { // we hit an unescaped single quote
-			valueFinished = true
-		} else if deferredSingleQuote && string(character) == "'" { // we hit an escaped single quote ''
-			deferredSingleQuote = false
-		} else if string(character) == "'" && !deferredSingleQuote { // we hit a first single quote
-			deferredSingleQuote = true
-			continue
-		}
+// Err returns the error which stopped the Tailer, nil when it stopped because done was closed.
+func (t *Tailer) Err() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.err
+}
 
-		// ending with space
-		if valueEndCharacter == " " && string(character) == valueEndCharacter {
-			valueFinished = true
-		}
+func (t *Tailer) setErr(err error) {
+	t.mu.Lock()
+	t.err = err
+	t.mu.Unlock()
+}
 
-		// continue parsing
-		if !valueFinished {
-			value = fmt.Sprintf("%v%v", value, string(character))
-			continue
+// permanentError reports whether reconnecting can't fix err, such as when the slot or the
+// publication doesn't exist, the authentication fails or the certificate of the server isn't
+// trusted. Other errors, such as the connection being reset, are retried.
+func permanentError(err error) bool {
+	switch e := err.(type) {
+	case ServerError:
+		if len(e.Code) < 2 {
+			return false
 		}
+		switch e.Code[:2] {
+		case "28", // invalid authorization specification
+			"3D", // invalid catalog name, the database doesn't exist
+			"42", // undefined object or insufficient privilege
+			"0A": // feature not supported
+			return true
+		}
+		return false
+	case ConnInfoError:
+		return true
+	}
+	var (
+		authorityErr x509.UnknownAuthorityError
+		invalidErr   x509.CertificateInvalidError
+		hostnameErr  x509.HostnameError
+	)
+	return err == ErrUnsupportedAuth ||
+		errors.As(err, &authorityErr) || errors.As(err, &invalidErr) || errors.As(err, &hostnameErr)
+}
 
-		// Set and reset
-		data[label] = casifyValue(value, valueType)
-
-		label = ""
-		labelFinished = false
-		valueType = ""
-		valueTypeFinished = false
-		skippedColon = false
-		deferredSingleQuote = false
-		value = ""
-		valueEndCharacter = ""
-		valueFinished = false
+// ConfirmPosition records the LSN every sink has committed, it is sent to the server with
+// the next standby status update.
+func (t *Tailer) ConfirmPosition(position []byte) {
+	lsn, err := parseLSN(string(position))
+	if err != nil {
+		log.Errorln(err)
+		return
 	}
-	if len(label) > 0 { // ensure we process any line ending abruptly
-		data[label] = casifyValue(value, valueType)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if lsn > t.confirmed {
+		t.confirmed = lsn
 	}
-	return data
 }
 
-func casifyValue(value string, valueType string) interface{} {
-	findArray := regexp.MustCompile("[[]]$")
-
-	switch {
-	case value == "null":
+// sendStandbyStatus reports the LSN the slot can be advanced to. Once every transaction sent
+// has been committed by the sinks, the transactions read since without any message to send
+// can be skipped as well so an idle pipeline doesn't hold back the WAL.
+func (t *Tailer) sendStandbyStatus(conn *replConn) error {
+	t.mu.Lock()
+	lsn := t.confirmed
+	if t.confirmed >= t.sent && t.processed > lsn {
+		lsn = t.processed
+	}
+	t.mu.Unlock()
+	if lsn == 0 {
 		return nil
-	case valueType == "integer" || valueType == "smallint" || valueType == "bigint":
-		i, _ := strconv.Atoi(value)
-		return i
-	case valueType == "double precision" || valueType == "numeric" || valueType == "money":
-		if valueType == "money" { // remove the dollar sign for money
-			value = value[1:]
-		}
-		f, _ := strconv.ParseFloat(value, 64)
-		return f
-	case valueType == "boolean":
-		return value == "true"
-	case valueType == "jsonb[]" || valueType == "json":
-		var m map[string]interface{}
-		json.Unmarshal([]byte(value), &m)
-		return m
-	case len(findArray.FindAllString(valueType, 1)) > 0:
-		var result []interface{}
-		arrayValueType := findArray.ReplaceAllString(valueType, "")
+	}
+	return conn.sendStandbyStatus(lsn)
+}
 
-		r := csv.NewReader(strings.NewReader(value[1 : len(value)-1]))
-		arrayValues, err := r.ReadAll()
-		if err != nil {
-			return value
-		}
+// confirmedFlushLSN returns the LSN the slot has been advanced to.
+func confirmedFlushLSN(s *Session, slot string) (uint64, error) {
+	var lsn sql.NullString
+	err := s.pqSession.QueryRow("SELECT confirmed_flush_lsn FROM pg_replication_slots WHERE slot_name = $1;", slot).Scan(&lsn)
+	if err != nil {
+		return 0, err
+	}
+	if !lsn.Valid {
+		return 0, nil
+	}
+	return parseLSN(lsn.String)
+}
 
-		for _, arrayValue := range arrayValues[0] {
-			result = append(result, casifyValue(arrayValue, arrayValueType))
+// resumeLSN returns the newest LSN found in the Position of the resumeMap, 0 starts from the
+// slot's confirmed position.
+func resumeLSN(resumeMap map[string]client.MessageSet) uint64 {
+	var lsn uint64
+	for ns, m := range resumeMap {
+		if len(m.Position) == 0 {
+			continue
 		}
-
-		return result
-	case valueType == "timestamp without time zone":
-		// parse time like 2015-08-21 16:09:02.988058
-		t, err := time.Parse("2006-01-02 15:04:05.9", value)
+		l, err := parseLSN(string(m.Position))
 		if err != nil {
-			fmt.Printf("\nTime (%v) parse error: %v\n\n", value, err)
+			log.With("ns", ns).Errorln(err)
+			continue
 		}
-		return t
-	case valueType == "date":
-		t, err := time.Parse("2006-01-02", value)
-		if err != nil {
-			fmt.Printf("\nTime (%v) parse error: %v\n\n", value, err)
+		if l > lsn {
+			lsn = l
 		}
-		return t
 	}
-
-	return value
+	return lsn
 }
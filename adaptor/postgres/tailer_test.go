@@ -8,17 +8,22 @@ import (
 	"testing"
 	"time"
 
+	"github.com/compose/transporter/adaptor"
 	"github.com/compose/transporter/client"
 )
 
 func addTestReplicationSlot(s *sql.DB) error {
+	if _, err := s.Exec(`CREATE PUBLICATION test_pub FOR ALL TABLES;`); err != nil {
+		return err
+	}
 	_, err := s.Exec(`
-    SELECT * FROM pg_create_logical_replication_slot('test_slot', 'test_decoding');
+    SELECT * FROM pg_create_logical_replication_slot('test_slot', 'pgoutput');
   `)
 	return err
 }
 
 func dropTestReplicationSlot(s *sql.DB) error {
+	s.Exec(`DROP PUBLICATION IF EXISTS test_pub;`)
 	_, err := s.Exec(`
     SELECT * FROM pg_drop_replication_slot('test_slot');
   `)
@@ -27,13 +32,15 @@ func dropTestReplicationSlot(s *sql.DB) error {
 
 var (
 	tailerTestData = &TestData{"tailer_test", "tailer_test_table", basicSchema, 10}
+	slotTestData   = &TestData{"slot_test", "slot_test_table", basicSchema, 10}
 )
 
 func TestTailer(t *testing.T) {
 	if testing.Short() {
 		t.Skip("skipping Tailer in short mode")
 	}
-	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", tailerTestData.DB)))
+	uri := fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", tailerTestData.DB)
+	c, err := NewClient(WithURI(uri))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
 	}
@@ -49,7 +56,7 @@ func TestTailer(t *testing.T) {
 	}
 	time.Sleep(1 * time.Second)
 
-	r := newTailer("test_slot")
+	r := newTailer(uri, "test_slot", "test_pub", false)
 	readFunc := r.Read(map[string]client.MessageSet{}, func(table string) bool {
 		if strings.HasPrefix(table, "information_schema.") || strings.HasPrefix(table, "pg_catalog.") {
 			return false
@@ -86,6 +93,89 @@ func TestTailer(t *testing.T) {
 	close(done)
 }
 
+func TestTailerCreateSlot(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping TestTailerCreateSlot in short mode")
+	}
+	uri := fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", slotTestData.DB)
+	c, err := NewClient(WithURI(uri))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to postgres, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to postgres, %s", err)
+	}
+	db := s.(*Session).pqSession
+	db.Exec(`DROP PUBLICATION IF EXISTS create_pub;`)
+	db.Exec(`SELECT pg_drop_replication_slot('create_slot');`)
+
+	table := fmt.Sprintf("public.%s", slotTestData.Table)
+	filterFn := func(ns string) bool { return ns == table }
+	r := newTailer(uri, "create_slot", "create_pub", true)
+	done := make(chan struct{})
+	msgChan, err := r.Read(map[string]client.MessageSet{}, filterFn)(s, done)
+	if err != nil {
+		t.Fatalf("unexpected Read error, %s\n", err)
+	}
+	checkCount("initial drain", slotTestData.InsertCount, msgChan, t)
+
+	var tables []string
+	rows, err := db.Query(`SELECT schemaname || '.' || tablename FROM pg_publication_tables WHERE pubname = 'create_pub';`)
+	if err != nil {
+		t.Fatalf("unable to list publication tables, %s", err)
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		tables = append(tables, name)
+	}
+	if len(tables) != 1 || tables[0] != table {
+		t.Errorf("wrong publication tables, expected [%s], got %v", table, tables)
+	}
+
+	db.Exec(fmt.Sprintf(`INSERT INTO %s VALUES (100, 'Robin', now() at time zone 'utc');`, slotTestData.Table))
+	var tailed client.MessageSet
+	select {
+	case tailed = <-msgChan:
+	case <-time.After(20 * time.Second):
+		t.Fatal("timed out waiting for the tailed insert")
+	}
+	close(done)
+	time.Sleep(1 * time.Second)
+
+	// resuming streams the delete made while stopped from the slot instead of copying the table again
+	db.Exec(fmt.Sprintf(`DELETE FROM %s WHERE id = 100;`, slotTestData.Table))
+	done = make(chan struct{})
+	msgChan, err = newTailer(uri, "create_slot", "create_pub", true).Read(map[string]client.MessageSet{table: tailed}, filterFn)(s, done)
+	if err != nil {
+		t.Fatalf("unexpected Read error, %s\n", err)
+	}
+	checkCount("resumed data", 1, msgChan, t)
+	close(done)
+	time.Sleep(1 * time.Second)
+
+	a := &postgres{BaseConfig: adaptor.BaseConfig{URI: uri}, ReplicationSlot: "create_slot"}
+	slots, err := a.Slots()
+	if err != nil {
+		t.Fatalf("unexpected Slots error, %s", err)
+	}
+	var found bool
+	for _, slot := range slots {
+		if slot.Name == "create_slot" {
+			found = slot.Owned && slot.Plugin == "pgoutput"
+		}
+	}
+	if !found {
+		t.Errorf("create_slot not listed as an owned pgoutput slot, %+v", slots)
+	}
+	if err := a.DropSlot("", false); err != nil {
+		t.Errorf("unexpected DropSlot error, %s", err)
+	}
+	db.Exec(`DROP PUBLICATION IF EXISTS create_pub;`)
+}
+
 func checkCount(desc string, expected int, msgChan <-chan client.MessageSet, t *testing.T) {
 	var numMsgs int
 	var wg sync.WaitGroup
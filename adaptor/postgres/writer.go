@@ -1,9 +1,9 @@
 package postgres
 
 import (
-	"database/sql"
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/compose/mejson"
@@ -17,21 +17,43 @@ var _ client.Writer = &Writer{}
 
 // Writer implements client.Writer for use with MongoDB
 type Writer struct {
-	writeMap map[ops.Op]func(message.Msg, *sql.DB) error
+	writeMap map[ops.Op]func(message.Msg, executor) error
+	offsets  *offsetStore
+	copier   *copier
+	schema   *schema
 }
 
-func newWriter() *Writer {
+func newWriter(upsert bool) *Writer {
 	w := &Writer{}
-	w.writeMap = map[ops.Op]func(message.Msg, *sql.DB) error{
+	w.writeMap = map[ops.Op]func(message.Msg, executor) error{
 		ops.Insert: insertMsg,
 		ops.Update: updateMsg,
 		ops.Delete: deleteMsg,
 	}
+	if upsert {
+		w.writeMap[ops.Insert] = upsertMsg
+	}
 	return w
 }
 
 func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
 	return func(s client.Session) (message.Msg, error) {
+		msg, err := ensureSchema(w.schema, w.copier, s.(*Session).pqSession, msg)
+		if err != nil {
+			return nil, err
+		}
+		if w.copier != nil {
+			if isCopy(msg) {
+				if err := w.copier.write(s.(*Session).pqSession, msg); err != nil {
+					return nil, err
+				}
+				return msg, nil
+			}
+			// the copied rows need to be committed before this message can be confirmed
+			if err := w.copier.flush(); err != nil {
+				return nil, err
+			}
+		}
 		writeFunc, ok := w.writeMap[msg.OP()]
 		if !ok {
 			log.Infof("no function registered for operation, %s", msg.OP())
@@ -40,7 +62,15 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 			}
 			return msg, nil
 		}
-		if err := writeFunc(msg, s.(*Session).pqSession); err != nil {
+		db := s.(*Session).pqSession
+		if ns, logOffset, ok := message.Offset(msg); ok && w.offsets != nil {
+			err = w.offsets.writeWithOffset(db, ns, logOffset, func(e executor) error {
+				return writeFunc(msg, e)
+			})
+		} else {
+			err = writeFunc(msg, db)
+		}
+		if err != nil {
 			return nil, err
 		}
 		if msg.Confirms() != nil {
@@ -50,19 +80,66 @@ func (w *Writer) Write(msg message.Msg) func(client.Session) (message.Msg, error
 	}
 }
 
-func insertMsg(m message.Msg, s *sql.DB) error {
+func insertMsg(m message.Msg, s executor) error {
 	log.With("table", m.Namespace()).Debugln("INSERT")
+	keys, row := insertRow(m)
+	return insertRows(s, m.Namespace(), keys, [][]interface{}{row}, "")
+}
+
+// upsertMsg inserts the message or, when a row with the same primary key exists, updates it
+// so messages can be written more than once.
+func upsertMsg(m message.Msg, s executor) error {
+	log.With("table", m.Namespace()).Debugln("UPSERT")
+	keys, row := insertRow(m)
+	onConflict, err := upsertClause(m.Namespace(), keys, s)
+	if err != nil {
+		return err
+	}
+	return insertRows(s, m.Namespace(), keys, [][]interface{}{row}, onConflict)
+}
+
+// upsertClause returns the ON CONFLICT clause updating every column which isn't part of the
+// table's primary key.
+func upsertClause(namespace string, keys []string, s executor) (string, error) {
+	pkeys, err := primaryKeys(namespace, s)
+	if err != nil {
+		return "", err
+	}
+	if len(pkeys) == 0 {
+		return "", fmt.Errorf("upsert requires a primary key, none found for %s", namespace)
+	}
 	var (
-		keys         []string
-		placeholders []string
-		data         []interface{}
+		ckeys []string
+		ukeys []string
 	)
+	for key := range pkeys {
+		ckeys = append(ckeys, key)
+	}
+	sort.Strings(ckeys)
+	for _, key := range keys {
+		if !pkeys[key] {
+			ukeys = append(ukeys, fmt.Sprintf("%v=EXCLUDED.%v", key, key))
+		}
+	}
+	if len(ukeys) == 0 {
+		return fmt.Sprintf(" ON CONFLICT (%v) DO NOTHING", strings.Join(ckeys, ", ")), nil
+	}
+	return fmt.Sprintf(" ON CONFLICT (%v) DO UPDATE SET %v", strings.Join(ckeys, ", "), strings.Join(ukeys, ", ")), nil
+}
 
-	i := 1
-	for key, value := range m.Data() {
+// insertRow returns the sorted columns of the message along with their values.
+func insertRow(m message.Msg) ([]string, []interface{}) {
+	var (
+		keys []string
+		data []interface{}
+	)
+	for key := range m.Data() {
 		keys = append(keys, key)
-		placeholders = append(placeholders, fmt.Sprintf("$%v", i))
+	}
+	sort.Strings(keys)
 
+	for _, key := range keys {
+		value := m.Data()[key]
 		switch value.(type) {
 		case map[string]interface{}, mejson.M, []map[string]interface{}, mejson.S:
 			value, _ = json.Marshal(value)
@@ -72,16 +149,34 @@ func insertMsg(m message.Msg, s *sql.DB) error {
 			value = fmt.Sprintf("{%v}", value.(string)[1:len(value.(string))-1])
 		}
 		data = append(data, value)
+	}
+	return keys, data
+}
 
-		i = i + 1
+// insertRows inserts every row with a single statement, each row must contain a value for
+// every key. onConflict is appended to the statement when not empty.
+func insertRows(s executor, table string, keys []string, rows [][]interface{}, onConflict string) error {
+	var (
+		values []string
+		data   []interface{}
+	)
+	i := 1
+	for _, row := range rows {
+		placeholders := make([]string, len(row))
+		for j := range row {
+			placeholders[j] = fmt.Sprintf("$%v", i)
+			i = i + 1
+		}
+		values = append(values, fmt.Sprintf("(%v)", strings.Join(placeholders, ", ")))
+		data = append(data, row...)
 	}
 
-	query := fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v);", m.Namespace(), strings.Join(keys, ", "), strings.Join(placeholders, ", "))
+	query := fmt.Sprintf("INSERT INTO %v (%v) VALUES %v%v;", table, strings.Join(keys, ", "), strings.Join(values, ", "), onConflict)
 	_, err := s.Exec(query, data...)
 	return err
 }
 
-func deleteMsg(m message.Msg, s *sql.DB) error {
+func deleteMsg(m message.Msg, s executor) error {
 	log.With("table", m.Namespace()).With("values", m.Data()).Debugln("DELETE")
 	var (
 		ckeys []string
@@ -117,7 +212,7 @@ func deleteMsg(m message.Msg, s *sql.DB) error {
 	return err
 }
 
-func updateMsg(m message.Msg, s *sql.DB) error {
+func updateMsg(m message.Msg, s executor) error {
 	log.With("table", m.Namespace()).Debugln("UPDATE")
 	var (
 		ckeys []string
@@ -159,7 +254,7 @@ func updateMsg(m message.Msg, s *sql.DB) error {
 	return err
 }
 
-func primaryKeys(namespace string, db *sql.DB) (primaryKeys map[string]bool, err error) {
+func primaryKeys(namespace string, db executor) (primaryKeys map[string]bool, err error) {
 	primaryKeys = map[string]bool{}
 	namespaceArray := strings.SplitN(namespace, ".", 2)
 	var (
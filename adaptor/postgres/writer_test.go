@@ -24,7 +24,7 @@ var optests = []struct {
 }
 
 func TestOpFunc(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	for _, ot := range optests {
 		if _, ok := w.writeMap[ot.op]; ok != ot.registered {
 			t.Errorf("op (%s) registration incorrect, expected %+v, got %+v\n", ot.op.String(), ot.registered, ok)
@@ -39,7 +39,7 @@ var (
 func TestInsert(t *testing.T) {
 	confirms, cleanup := adaptor.MockConfirmWrites()
 	defer adaptor.VerifyWriteConfirmed(cleanup, t)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -113,7 +113,7 @@ var (
 )
 
 func TestComplexInsert(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerComplexTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -199,7 +199,7 @@ var (
 )
 
 func TestUpdate(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerUpdateTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -258,7 +258,7 @@ var (
 
 func TestComplexUpdate(t *testing.T) {
 	ranInt := rand.Intn(writerComplexUpdateTestData.InsertCount)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerComplexUpdateTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -343,7 +343,7 @@ var (
 )
 
 func TestDelete(t *testing.T) {
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerDeleteTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -392,7 +392,7 @@ var (
 
 func TestComplexDelete(t *testing.T) {
 	ranInt := rand.Intn(writerComplexDeleteTestData.InsertCount)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerComplexDeleteTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -424,7 +424,7 @@ var (
 
 func TestComplexDeleteWithoutAllPrimarykeys(t *testing.T) {
 	ranInt := rand.Intn(writerComplexDeletePkTestData.InsertCount)
-	w := newWriter()
+	w := newWriter(false)
 	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerComplexDeletePkTestData.DB)))
 	if err != nil {
 		t.Fatalf("unable to initialize connection to postgres, %s", err)
@@ -448,3 +448,52 @@ func TestComplexDeleteWithoutAllPrimarykeys(t *testing.T) {
 		t.Fatalf("Expected to find values, but none were found: %v", err)
 	}
 }
+
+var (
+	writerUpsertTestData = &TestData{"writer_upsert_test", "upsert_test_table", basicSchema, 0}
+)
+
+func TestUpsert(t *testing.T) {
+	w := newWriter(true)
+	c, err := NewClient(WithURI(fmt.Sprintf("postgres://postgres@transporter-db:5432/%s?sslmode=disable", writerUpsertTestData.DB)))
+	if err != nil {
+		t.Fatalf("unable to initialize connection to postgres, %s", err)
+	}
+	defer c.Close()
+	s, err := c.Connect()
+	if err != nil {
+		t.Fatalf("unable to obtain session to postgres, %s", err)
+	}
+	for _, colvar := range []string{"hello world", "hello again"} {
+		for i := 0; i < 10; i++ {
+			msg := message.From(
+				ops.Insert,
+				fmt.Sprintf("public.%s", writerUpsertTestData.Table),
+				data.Data{"id": i, "colvar": colvar, "coltimestamp": time.Now().UTC()})
+			if _, err := w.Write(msg)(s); err != nil {
+				t.Errorf("unexpected Insert error, %s\n", err)
+			}
+		}
+	}
+
+	var stringValue string
+	if err := s.(*Session).pqSession.
+		QueryRow(fmt.Sprintf("SELECT colvar FROM %s WHERE id = 4", writerUpsertTestData.Table)).
+		Scan(&stringValue); err != nil {
+		t.Fatalf("Error on test query: %v", err)
+	}
+	if stringValue != "hello again" {
+		t.Errorf("wrong colvar, expected hello again, got %s", stringValue)
+	}
+
+	var count int
+	err = s.(*Session).pqSession.
+		QueryRow(fmt.Sprintf("SELECT COUNT(id) FROM %s;", writerUpsertTestData.Table)).
+		Scan(&count)
+	if err != nil {
+		t.Errorf("unable to count table, %s", err)
+	}
+	if count != 10 {
+		t.Errorf("wrong document count, expected 10, got %d", count)
+	}
+}
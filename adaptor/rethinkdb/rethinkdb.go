@@ -20,7 +20,8 @@ const (
 )
 
 var (
-	_ adaptor.Adaptor = &rethinkDB{}
+	_ adaptor.Adaptor     = &rethinkDB{}
+	_ adaptor.Snapshotter = &rethinkDB{}
 )
 
 // RethinkDB is an adaptor that writes metrics to rethinkdb (http://rethinkdb.com/)
@@ -55,6 +56,11 @@ func (r *rethinkDB) Reader() (client.Reader, error) {
 	return newReader(r.Tail), nil
 }
 
+// SnapshotReader returns a Reader which copies every table without tailing changes.
+func (r *rethinkDB) SnapshotReader() (client.Reader, error) {
+	return newReader(false), nil
+}
+
 func (r *rethinkDB) Writer(done chan struct{}, wg *sync.WaitGroup) (client.Writer, error) {
 	return newWriter(done, wg), nil
 }
@@ -13,6 +13,9 @@ type MessageSet struct {
 	Msg       message.Msg
 	Timestamp int64
 	Mode      commitlog.Mode
+	// Position is where the source read the message from, such as a Postgres LSN. It is stored
+	// in the commit log and returned in the resume map so a Reader can continue from it.
+	Position []byte
 }
 
 // NsFilterFunc represents the func signature needed to filter while Read()ing.
@@ -38,6 +41,21 @@ type Reader interface {
 	Read(map[string]MessageSet, NsFilterFunc) MessageChanFunc
 }
 
+// PositionConfirmer is implemented by a Reader which can release changes held by the source,
+// such as a replication slot, once they're no longer needed. ConfirmPosition is called with the
+// Position of the newest message every sink has committed.
+type PositionConfirmer interface {
+	ConfirmPosition([]byte)
+}
+
+// ErrorReporter is implemented by a Reader which keeps sending messages after its
+// MessageChanFunc returns, such as a tailer streaming changes once the copy is done. Err is
+// checked once the channel is closed, a non-nil error fails the source instead of the closed
+// channel being treated as the end of the data.
+type ErrorReporter interface {
+	Err() error
+}
+
 // Writer represents all possible functions needing to be implemented to handle messages.
 type Writer interface {
 	Write(message.Msg) func(Session) (message.Msg, error)
@@ -57,9 +57,9 @@ func (r *MockReader) Read(_ map[string]MessageSet, filterFn NsFilterFunc) Messag
 			defer close(out)
 			for i := 0; i < r.MsgCount; i++ {
 				out <- MessageSet{
-					message.From(ops.Insert, "test", map[string]interface{}{"id": i}),
-					time.Now().Unix(),
-					commitlog.Copy,
+					Msg:       message.From(ops.Insert, "test", map[string]interface{}{"id": i}),
+					Timestamp: time.Now().Unix(),
+					Mode:      commitlog.Copy,
 				}
 			}
 		}()
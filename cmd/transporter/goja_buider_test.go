@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -133,3 +134,50 @@ func TestNewBuilderWithEnv(t *testing.T) {
 		t.Errorf("misconfigured transporter\nexpected:\n%s\ngot:\n%s", expected, actual)
 	}
 }
+
+func TestBuildAdaptorStartFrom(t *testing.T) {
+	args := map[string]interface{}{"uri": "stdout://", "start_from": "snapshot"}
+	a := buildAdaptor("file")(args)
+	if a.startFrom != "snapshot" {
+		t.Errorf("wrong startFrom, expected snapshot, got %s", a.startFrom)
+	}
+	if _, ok := args["start_from"]; ok {
+		t.Error("start_from should not be passed to the adaptor config")
+	}
+}
+
+var offsetManagerTests = []struct {
+	name        string
+	offsetStore string
+	expectType  string
+	expectPanic bool
+}{
+	{"default", "", "*offset.LogManager", false},
+	{"log", "log", "*offset.LogManager", false},
+	{"file", "file", "*offset.StoreManager", false},
+	{"sink not supported", "sink", "", true},
+	{"unknown", "bogus", "", true},
+}
+
+func TestConfigOffsetManager(t *testing.T) {
+	for _, ot := range offsetManagerTests {
+		t.Run(ot.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "offset_store")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			defer func() {
+				if r := recover(); (r != nil) != ot.expectPanic {
+					t.Errorf("unexpected panic state, expected %v, got %v", ot.expectPanic, r)
+				}
+			}()
+			c := &config{LogDir: dir, OffsetStore: ot.offsetStore}
+			a := buildAdaptor("file")(map[string]interface{}{"uri": "stdout://"})
+			om := c.offsetManager("sink", a.a)
+			if got := fmt.Sprintf("%T", om); got != ot.expectType {
+				t.Errorf("wrong offset manager, expected %s, got %s", ot.expectType, got)
+			}
+		})
+	}
+}
@@ -78,6 +78,7 @@ type Transporter struct {
 	vm *goja.Runtime
 
 	config     *config
+	source     *Adaptor
 	sourceNode *pipeline.Node
 }
 
@@ -86,6 +87,51 @@ type config struct {
 	MaxSegmentBytes    int    `json:"max_segment_bytes"`
 	CompactionInterval string `json:"compaction_interval"`
 	WriteTimeout       string `json:"write_timeout"`
+	EncryptionKeyFile  string `json:"encryption_key_file"`
+	EncryptionKeyEnv   string `json:"encryption_key_env"`
+	EncryptKeys        bool   `json:"encrypt_keys"`
+	OffsetStore        string `json:"offset_store"`
+}
+
+// encryptionOptions loads the configured keys and returns the options needed to encrypt
+// the commit log and offsets.
+func (c *config) encryptionOptions() []commitlog.OptionFunc {
+	opts, err := encryptionOptions(c.EncryptionKeyFile, c.EncryptionKeyEnv, c.EncryptKeys)
+	if err != nil {
+		panic(err)
+	}
+	return opts
+}
+
+// offsetManager creates the offset.Manager for the named sink based on the offset_store,
+// nil is returned when no log_dir is configured since offsets are only tracked with a
+// commit log.
+func (c *config) offsetManager(name string, a adaptor.Adaptor) offset.Manager {
+	if c.LogDir == "" {
+		return nil
+	}
+	var (
+		om  offset.Manager
+		err error
+	)
+	switch c.OffsetStore {
+	case "", "log":
+		om, err = offset.NewLogManager(c.LogDir, name, c.encryptionOptions()...)
+	case "file":
+		om, err = offset.NewFileManager(c.LogDir, name)
+	case "sink":
+		t, ok := a.(adaptor.OffsetTracker)
+		if !ok {
+			panic(adaptor.ErrFuncNotSupported{Name: name, Func: "OffsetManager()"})
+		}
+		om, err = t.OffsetManager(name)
+	default:
+		err = fmt.Errorf("unknown offset_store %s, expected log, file, or sink", c.OffsetStore)
+	}
+	if err != nil {
+		panic(err)
+	}
+	return om
 }
 
 // Node encapsulates a sink/source node in the pipeline.
@@ -103,10 +149,12 @@ type Transformer struct {
 	config     *config
 }
 
-// Adaptor wraps the underlyig adaptor.Adaptor to be exposed in the JS.
+// Adaptor wraps the underlyig adaptor.Adaptor to be exposed in the JS. startFrom is taken
+// from the "start_from" key of the adaptor config and only applies when used as a sink.
 type Adaptor struct {
-	name string
-	a    adaptor.Adaptor
+	name      string
+	a         adaptor.Adaptor
+	startFrom string
 }
 
 func (t *Transporter) run() error {
@@ -153,11 +201,13 @@ func (t *Transporter) String() string {
 
 func buildAdaptor(name string) func(map[string]interface{}) Adaptor {
 	return func(args map[string]interface{}) Adaptor {
+		startFrom, _ := args["start_from"].(string)
+		delete(args, "start_from")
 		a, err := adaptor.GetAdaptor(name, args)
 		if err != nil {
 			panic(err)
 		}
-		return Adaptor{name, a}
+		return Adaptor{name, a, startFrom}
 	}
 }
 
@@ -200,16 +250,17 @@ func (t *Transporter) Source(call goja.FunctionCall) goja.Value {
 	}
 	if t.config.LogDir != "" {
 		options = append(options, pipeline.WithCommitLog(
-			[]commitlog.OptionFunc{
+			append([]commitlog.OptionFunc{
 				commitlog.WithPath(t.config.LogDir),
 				commitlog.WithMaxSegmentBytes(int64(t.config.MaxSegmentBytes)),
-			}...))
+			}, t.config.encryptionOptions()...)...))
 	}
 
 	n, err := pipeline.NewNodeWithOptions(name, a.name, namespace, options...)
 	if err != nil {
 		panic(err)
 	}
+	t.source = &a
 	t.sourceNode = n
 	return t.vm.ToValue(&Node{t.vm, n, t.config})
 }
@@ -249,13 +300,10 @@ func (n *Node) Save(call goja.FunctionCall) goja.Value {
 		pipeline.WithClient(a.a),
 		pipeline.WithWriter(a.a),
 		pipeline.WithWriteTimeout(n.config.WriteTimeout),
+		pipeline.WithStartFrom(a.startFrom),
 	}
 
-	if n.config.LogDir != "" {
-		om, err := offset.NewLogManager(n.config.LogDir, name)
-		if err != nil {
-			panic(err)
-		}
+	if om := n.config.offsetManager(name, a.a); om != nil {
 		options = append(options, pipeline.WithOffsetManager(om))
 	}
 
@@ -275,13 +323,10 @@ func (tf *Transformer) Save(call goja.FunctionCall) goja.Value {
 		pipeline.WithWriter(a.a),
 		pipeline.WithTransforms(tf.transforms),
 		pipeline.WithWriteTimeout(tf.config.WriteTimeout),
+		pipeline.WithStartFrom(a.startFrom),
 	}
 
-	if tf.config.LogDir != "" {
-		om, err := offset.NewLogManager(tf.config.LogDir, name)
-		if err != nil {
-			panic(err)
-		}
+	if om := tf.config.offsetManager(name, a.a); om != nil {
 		options = append(options, pipeline.WithOffsetManager(om))
 	}
 
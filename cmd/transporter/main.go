@@ -29,6 +29,9 @@ func usage() {
 	fmt.Fprintf(os.Stderr, "  init      initialize a config and pipeline file based from provided adaptors\n")
 	fmt.Fprintf(os.Stderr, "  xlog      manage the commit log\n")
 	fmt.Fprintf(os.Stderr, "  offset    manage the offset for sinks\n")
+	fmt.Fprintf(os.Stderr, "  replay    apply the commit log up to a point in time to a sink\n")
+	fmt.Fprintf(os.Stderr, "  standby   serve the commit log to, or follow it from, a standby host\n")
+	fmt.Fprintf(os.Stderr, "  slot      list and drop the replication slots of the source\n")
 	fmt.Fprintf(os.Stderr, "\n")
 	fmt.Fprintf(os.Stderr, "VERSION\n")
 	fmt.Fprintf(os.Stderr, "  %s\n", version)
@@ -55,6 +58,12 @@ func main() {
 		run = runXlog
 	case "offset":
 		run = runOffset
+	case "replay":
+		run = runReplay
+	case "standby":
+		run = runStandby
+	case "slot":
+		run = runSlot
 	default:
 		usage()
 		os.Exit(1)
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -11,6 +14,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/log"
 	"github.com/compose/transporter/offset"
 	"github.com/olekukonko/tablewriter"
@@ -23,7 +27,10 @@ const (
 func runOffset(args []string) error {
 	flagset := baseFlagSet("offset")
 	logDir := flagset.String("xlog_dir", "", "path to commit log directory")
-	flagset.Usage = usageFor(flagset, "transporter offset --xlog_dir=/path/to/log list|show|mark|delete [SINK] [OFFSET]")
+	keyFile := flagset.String("encryption_key_file", "", "path to file containing ID:KEY pairs for encrypting offsets")
+	keyEnv := flagset.String("encryption_key_env", "", "environment variable containing ID:KEY pairs for encrypting offsets")
+	encryptKeys := flagset.Bool("encrypt_keys", false, "encrypt namespaces when writing offsets")
+	flagset.Usage = usageFor(flagset, "transporter offset --xlog_dir=/path/to/log list|show|mark|delete|set|diff|export|import|rewind [SINK] [OFFSET]")
 	if err := flagset.Parse(args); err != nil {
 		return err
 	}
@@ -34,11 +41,16 @@ func runOffset(args []string) error {
 
 	args = flagset.Args()
 	if len(args) <= 0 {
-		return errors.New("missing subcommand list|show|mark|delete")
+		return errors.New("missing subcommand list|show|mark|delete|set|diff|export|import|rewind")
 	}
 
 	log.Orig().Out = ioutil.Discard
 
+	encOpts, err := encryptionOptions(*keyFile, *keyEnv, *encryptKeys)
+	if err != nil {
+		return err
+	}
+
 	switch args[0] {
 	case "list":
 		files, err := ioutil.ReadDir(*logDir)
@@ -51,14 +63,20 @@ func runOffset(args []string) error {
 		for _, file := range files {
 			if file.IsDir() && strings.HasPrefix(file.Name(), consumerDirPrefix) {
 				name := strings.TrimPrefix(file.Name(), consumerDirPrefix)
-				om, _ := offset.NewLogManager(*logDir, name)
+				om, err := offset.NewLogManager(*logDir, name, encOpts...)
+				if err != nil {
+					return err
+				}
 				table.Append([]string{name, strconv.Itoa(int(om.NewestOffset()))})
 			}
 		}
 		table.Render()
 	case "show":
 		sinkName := args[1]
-		om, _ := offset.NewLogManager(*logDir, sinkName)
+		om, err := offset.NewLogManager(*logDir, sinkName, encOpts...)
+		if err != nil {
+			return err
+		}
 		table := tablewriter.NewWriter(os.Stdout)
 		table.SetHeader([]string{"namespace", "offset"})
 		for ns, nsOffset := range om.OffsetMap() {
@@ -75,7 +93,7 @@ func runOffset(args []string) error {
 			return err
 		}
 
-		om, err := offset.NewLogManager(*logDir, sinkName)
+		om, err := offset.NewLogManager(*logDir, sinkName, encOpts...)
 		if err != nil {
 			return err
 		}
@@ -106,38 +124,255 @@ func runOffset(args []string) error {
 			break
 		}
 
-		swapOffsetDir := fmt.Sprintf("%s_swap", sinkName)
-		om, err = offset.NewLogManager(*logDir, swapOffsetDir)
+		if err := rewriteOffsets(*logDir, sinkName, toKeep, encOpts); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "OK")
+	case "delete":
+		offsetDir := filepath.Join(*logDir, fmt.Sprintf("%s%s", consumerDirPrefix, args[1]))
+		err := os.RemoveAll(offsetDir)
+		if err == nil {
+			fmt.Fprintf(os.Stdout, "OK")
+		}
+		return err
+	case "set":
+		if len(args) != 4 {
+			return errors.New("wrong number of arguments, expected set SINK NAMESPACE OFFSET")
+		}
+		o, err := strconv.ParseUint(args[3], 10, 64)
 		if err != nil {
 			return err
 		}
-		sort.Slice(toKeep, func(i, j int) bool {
-			return toKeep[i].LogOffset < toKeep[j].LogOffset
-		})
-		for _, off := range toKeep {
-			if err := om.CommitOffset(off, true); err != nil {
+		if err := offsetSet(*logDir, args[1], args[2], o, encOpts); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "OK")
+	case "diff":
+		if len(args) != 3 {
+			return errors.New("wrong number of arguments, expected diff SINK_A SINK_B")
+		}
+		return offsetDiff(*logDir, args[1], args[2], encOpts, os.Stdout)
+	case "export":
+		if len(args) != 2 {
+			return errors.New("wrong number of arguments, expected export SINK")
+		}
+		return offsetExport(*logDir, args[1], encOpts, os.Stdout)
+	case "import":
+		if len(args) != 3 {
+			return errors.New("wrong number of arguments, expected import SINK FILE")
+		}
+		in := os.Stdin
+		if args[2] != "-" {
+			f, err := os.Open(args[2])
+			if err != nil {
 				return err
 			}
+			defer f.Close()
+			in = f
 		}
-
-		offsetDir := filepath.Join(*logDir, fmt.Sprintf("%s%s", consumerDirPrefix, sinkName))
-		if err := os.RemoveAll(offsetDir); err != nil {
+		if err := offsetImport(*logDir, args[1], encOpts, in); err != nil {
 			return err
 		}
-
-		replaceDir := filepath.Join(*logDir, fmt.Sprintf("%s%s", consumerDirPrefix, swapOffsetDir))
-		if err := os.Rename(replaceDir, offsetDir); err != nil {
+		fmt.Fprintf(os.Stdout, "OK")
+	case "rewind":
+		rewindFlags := flag.NewFlagSet("offset rewind", flag.ExitOnError)
+		toTime := rewindFlags.String("to-time", "", "RFC3339 timestamp to rewind the sink to")
+		rewindFlags.Usage = usageFor(rewindFlags, "transporter offset --xlog_dir=/path/to/log rewind SINK --to-time=TIMESTAMP")
+		if err := parseInterspersed(rewindFlags, args[1:]); err != nil {
+			return err
+		}
+		if rewindFlags.NArg() != 1 || *toTime == "" {
+			return errors.New("wrong number of arguments, expected rewind SINK --to-time=TIMESTAMP")
+		}
+		to, err := time.Parse(time.RFC3339, *toTime)
+		if err != nil {
+			return err
+		}
+		if err := offsetRewind(*logDir, rewindFlags.Arg(0), to, encOpts); err != nil {
 			return err
 		}
 		fmt.Fprintf(os.Stdout, "OK")
-	case "delete":
-		offsetDir := filepath.Join(*logDir, fmt.Sprintf("%s%s", consumerDirPrefix, args[1]))
-		err := os.RemoveAll(offsetDir)
-		if err == nil {
-			fmt.Fprintf(os.Stdout, "OK")
+	default:
+		return fmt.Errorf("unknown subcommand %s", args[0])
+	}
+
+	return nil
+}
+
+// parseInterspersed parses flags which may appear before or after the positional arguments.
+func parseInterspersed(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+// openSink returns the offset.LogManager of an existing sink.
+func openSink(logDir, sink string, encOpts []commitlog.OptionFunc) (*offset.LogManager, error) {
+	if _, err := os.Stat(filepath.Join(logDir, fmt.Sprintf("%s%s", consumerDirPrefix, sink))); err != nil {
+		return nil, fmt.Errorf("no offsets found for sink %s", sink)
+	}
+	return offset.NewLogManager(logDir, sink, encOpts...)
+}
+
+// rewriteOffsets replaces every offset of the sink with the provided offsets. The offsets are
+// written to a swap directory first which is then renamed over the existing directory.
+func rewriteOffsets(logDir, sink string, offsets []offset.Offset, encOpts []commitlog.OptionFunc) error {
+	swapOffsetDir := fmt.Sprintf("%s_swap", sink)
+	replaceDir := filepath.Join(logDir, fmt.Sprintf("%s%s", consumerDirPrefix, swapOffsetDir))
+	if err := os.RemoveAll(replaceDir); err != nil {
+		return err
+	}
+	om, err := offset.NewLogManager(logDir, swapOffsetDir, encOpts...)
+	if err != nil {
+		return err
+	}
+	sort.Slice(offsets, func(i, j int) bool {
+		return offsets[i].LogOffset < offsets[j].LogOffset
+	})
+	for _, off := range offsets {
+		if err := om.CommitOffset(off, true); err != nil {
+			return err
 		}
+	}
+
+	offsetDir := filepath.Join(logDir, fmt.Sprintf("%s%s", consumerDirPrefix, sink))
+	if err := os.RemoveAll(offsetDir); err != nil {
+		return err
+	}
+	return os.Rename(replaceDir, offsetDir)
+}
+
+// offsetSet overrides the offset of a single namespace, the newest entry for a namespace
+// always wins so this doesn't require rewriting the other offsets.
+func offsetSet(logDir, sink, ns string, o uint64, encOpts []commitlog.OptionFunc) error {
+	om, err := openSink(logDir, sink, encOpts)
+	if err != nil {
 		return err
 	}
+	return om.CommitOffset(offset.Offset{Namespace: ns, LogOffset: o, Timestamp: time.Now().Unix()}, true)
+}
 
+func offsetDiff(logDir, sinkA, sinkB string, encOpts []commitlog.OptionFunc, out io.Writer) error {
+	omA, err := openSink(logDir, sinkA, encOpts)
+	if err != nil {
+		return err
+	}
+	omB, err := openSink(logDir, sinkB, encOpts)
+	if err != nil {
+		return err
+	}
+	mapA, mapB := omA.OffsetMap(), omB.OffsetMap()
+	namespaces := make([]string, 0)
+	for ns := range mapA {
+		namespaces = append(namespaces, ns)
+	}
+	for ns := range mapB {
+		if _, ok := mapA[ns]; !ok {
+			namespaces = append(namespaces, ns)
+		}
+	}
+	sort.Strings(namespaces)
+
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"namespace", sinkA, sinkB, "diff"})
+	for _, ns := range namespaces {
+		a, okA := mapA[ns]
+		b, okB := mapB[ns]
+		row := []string{ns, "-", "-", "-"}
+		if okA {
+			row[1] = strconv.FormatUint(a, 10)
+		}
+		if okB {
+			row[2] = strconv.FormatUint(b, 10)
+		}
+		if okA && okB {
+			row[3] = strconv.FormatInt(int64(b)-int64(a), 10)
+		}
+		table.Append(row)
+	}
+	table.Render()
 	return nil
 }
+
+// offsetsDoc is the JSON document written by export and read by import.
+type offsetsDoc struct {
+	Sink    string            `json:"sink"`
+	Offsets map[string]uint64 `json:"offsets"`
+}
+
+func offsetExport(logDir, sink string, encOpts []commitlog.OptionFunc, out io.Writer) error {
+	om, err := openSink(logDir, sink, encOpts)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(offsetsDoc{Sink: sink, Offsets: om.OffsetMap()})
+}
+
+// offsetImport replaces the offsets of the sink with the ones in the document, the sink
+// name in the document is informational so offsets can be imported under a different name.
+func offsetImport(logDir, sink string, encOpts []commitlog.OptionFunc, in io.Reader) error {
+	var doc offsetsDoc
+	if err := json.NewDecoder(in).Decode(&doc); err != nil {
+		return err
+	}
+	offsets := make([]offset.Offset, 0, len(doc.Offsets))
+	for ns, o := range doc.Offsets {
+		offsets = append(offsets, offset.Offset{Namespace: ns, LogOffset: o, Timestamp: time.Now().Unix()})
+	}
+	return rewriteOffsets(logDir, sink, offsets, encOpts)
+}
+
+// errStopIteration is returned by an iterateEntries func to end the iteration early.
+var errStopIteration = errors.New("stop iteration")
+
+// offsetRewind moves the sink back to the state it would have been in when the first
+// entry with a timestamp at or after to was appended to the commit log. Namespaces are set
+// to their last entry before that point and namespaces without one are removed. Offsets
+// that are already older are kept.
+func offsetRewind(logDir, sink string, to time.Time, encOpts []commitlog.OptionFunc) error {
+	om, err := openSink(logDir, sink, encOpts)
+	if err != nil {
+		return err
+	}
+	l, err := commitlog.New(append([]commitlog.OptionFunc{commitlog.WithPath(logDir)}, encOpts...)...)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	ts := uint64(to.Unix())
+	before := make(map[string]uint64)
+	err = iterateEntries(l, -1, -1, func(o uint64, e commitlog.LogEntry) error {
+		if e.Timestamp >= ts {
+			return errStopIteration
+		}
+		before[string(e.Key)] = o
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return err
+	}
+
+	offsets := make([]offset.Offset, 0)
+	for ns, current := range om.OffsetMap() {
+		o, ok := before[ns]
+		if !ok {
+			continue
+		}
+		if current < o {
+			o = current
+		}
+		offsets = append(offsets, offset.Offset{Namespace: ns, LogOffset: o, Timestamp: time.Now().Unix()})
+	}
+	return rewriteOffsets(logDir, sink, offsets, encOpts)
+}
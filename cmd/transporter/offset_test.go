@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/offset"
+)
+
+func setupOffsets(t *testing.T, offsets map[string]map[string]uint64) string {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("offsettest%d", rand.Int63()))
+	for sink, nsOffsets := range offsets {
+		om, err := offset.NewLogManager(path, sink)
+		if err != nil {
+			t.Fatalf("unexpected NewLogManager error, %s", err)
+		}
+		for ns, o := range nsOffsets {
+			om.CommitOffset(offset.Offset{Namespace: ns, LogOffset: o, Timestamp: time.Now().Unix()}, true)
+		}
+	}
+	return path
+}
+
+func sinkOffsets(t *testing.T, path, sink string) map[string]uint64 {
+	om, err := offset.NewLogManager(path, sink)
+	if err != nil {
+		t.Fatalf("unexpected NewLogManager error, %s", err)
+	}
+	return om.OffsetMap()
+}
+
+func TestOffsetSet(t *testing.T) {
+	path := setupOffsets(t, map[string]map[string]uint64{"sink": {"foo": 10, "bar": 12}})
+	defer os.RemoveAll(path)
+	if err := offsetSet(path, "sink", "foo", 3, nil); err != nil {
+		t.Fatalf("unexpected offsetSet error, %s", err)
+	}
+	expected := map[string]uint64{"foo": 3, "bar": 12}
+	if actual := sinkOffsets(t, path, "sink"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("wrong offsets, expected %v, got %v", expected, actual)
+	}
+	if err := offsetSet(path, "missing", "foo", 3, nil); err == nil {
+		t.Error("expected error for missing sink but didn't receive one")
+	}
+}
+
+func TestOffsetDiff(t *testing.T) {
+	path := setupOffsets(t, map[string]map[string]uint64{
+		"a": {"foo": 10, "bar": 12},
+		"b": {"foo": 15, "baz": 1},
+	})
+	defer os.RemoveAll(path)
+	var out bytes.Buffer
+	if err := offsetDiff(path, "a", "b", nil, &out); err != nil {
+		t.Fatalf("unexpected offsetDiff error, %s", err)
+	}
+	for _, expected := range [][]string{
+		{"bar", "12", "-", "-"},
+		{"baz", "-", "1", "-"},
+		{"foo", "10", "15", "5"},
+	} {
+		if !containsRow(out.String(), expected) {
+			t.Errorf("expected output to contain row %v, got\n%s", expected, out.String())
+		}
+	}
+}
+
+// containsRow checks if any row of the rendered table has the expected cells.
+func containsRow(table string, expected []string) bool {
+	for _, line := range strings.Split(table, "\n") {
+		cells := make([]string, 0)
+		for _, cell := range strings.Split(strings.Trim(line, "|"), "|") {
+			cells = append(cells, strings.TrimSpace(cell))
+		}
+		if reflect.DeepEqual(cells, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestOffsetExportImport(t *testing.T) {
+	path := setupOffsets(t, map[string]map[string]uint64{
+		"sink":  {"foo": 10, "bar": 12},
+		"other": {"foo": 1, "old": 2},
+	})
+	defer os.RemoveAll(path)
+	var out bytes.Buffer
+	if err := offsetExport(path, "sink", nil, &out); err != nil {
+		t.Fatalf("unexpected offsetExport error, %s", err)
+	}
+	if err := offsetImport(path, "other", nil, &out); err != nil {
+		t.Fatalf("unexpected offsetImport error, %s", err)
+	}
+	expected := map[string]uint64{"foo": 10, "bar": 12}
+	if actual := sinkOffsets(t, path, "other"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("wrong offsets, expected %v, got %v", expected, actual)
+	}
+}
+
+func TestOffsetRewind(t *testing.T) {
+	l, logPath := setupXlog(t)
+	defer os.RemoveAll(logPath)
+	l.Close()
+	om, err := offset.NewLogManager(logPath, "sink")
+	if err != nil {
+		t.Fatalf("unexpected NewLogManager error, %s", err)
+	}
+	om.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 8}, false)
+	om.CommitOffset(offset.Offset{Namespace: "bar", LogOffset: 9}, false)
+	om.CommitOffset(offset.Offset{Namespace: "baz", LogOffset: 1}, false)
+
+	// entries in setupXlog have a timestamp matching their offset
+	if err := offsetRewind(logPath, "sink", time.Unix(5, 0), nil); err != nil {
+		t.Fatalf("unexpected offsetRewind error, %s", err)
+	}
+	expected := map[string]uint64{"foo": 4, "bar": 3}
+	if actual := sinkOffsets(t, logPath, "sink"); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("wrong offsets, expected %v, got %v", expected, actual)
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	for _, args := range [][]string{
+		{"sink", "--to-time", "now"},
+		{"--to-time", "now", "sink"},
+	} {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		toTime := fs.String("to-time", "", "")
+		if err := parseInterspersed(fs, args); err != nil {
+			t.Fatalf("unexpected error, %s", err)
+		}
+		if *toTime != "now" || !reflect.DeepEqual(fs.Args(), []string{"sink"}) {
+			t.Errorf("wrong result for %v, got %s %v", args, *toTime, fs.Args())
+		}
+	}
+}
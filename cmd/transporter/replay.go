@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/pipeline"
+)
+
+func runReplay(args []string) error {
+	flagset := baseFlagSet("replay")
+	logDir := flagset.String("xlog_dir", "", "path to commit log directory")
+	until := flagset.String("until", "", "last OFFSET or RFC3339 TIMESTAMP to apply, defaults to the end of the log")
+	to := flagset.String("to", "", `JSON config of the sink adaptor including its type, i.e. {"type":"mongodb","uri":"mongodb://localhost/restore"}`)
+	ns := flagset.String("ns", "", "regex the namespace must match to be replayed")
+	keyFile := flagset.String("encryption_key_file", "", "path to file containing ID:KEY pairs for decrypting the commit log")
+	keyEnv := flagset.String("encryption_key_env", "", "environment variable containing ID:KEY pairs for decrypting the commit log")
+	flagset.Usage = usageFor(flagset, "transporter replay --xlog_dir=/path/to/log --to=CONFIG [--until=OFFSET|TIMESTAMP] [--ns=REGEX]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	if *logDir == "" {
+		return errors.New("missing required flag --xlog_dir")
+	}
+	if *to == "" {
+		return errors.New("missing required flag --to")
+	}
+
+	opts, err := replayOptions(*until, *ns)
+	if err != nil {
+		return err
+	}
+	a, err := adaptorFromConfig(*to)
+	if err != nil {
+		return err
+	}
+
+	encOpts, err := encryptionOptions(*keyFile, *keyEnv, false)
+	if err != nil {
+		return err
+	}
+	l, err := commitlog.New(append([]commitlog.OptionFunc{commitlog.WithPath(*logDir)}, encOpts...)...)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	count, err := replay(l, a, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("replayed %d messages\n", count)
+	return nil
+}
+
+// replay writes the commit log to the adaptor and waits for any buffered writes to be
+// flushed before returning.
+func replay(l *commitlog.CommitLog, a adaptor.Adaptor, opts pipeline.ReplayOptions) (int, error) {
+	c, err := a.Client()
+	if err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	w, err := a.Writer(done, &wg)
+	if err != nil {
+		return 0, err
+	}
+	count, err := pipeline.Replay(l, c, w, opts)
+	close(done)
+	wg.Wait()
+	return count, err
+}
+
+// replayOptions builds the pipeline.ReplayOptions from the --until and --ns flags, until is
+// treated as an offset when it is an integer and as an RFC3339 timestamp otherwise.
+func replayOptions(until, ns string) (pipeline.ReplayOptions, error) {
+	opts := pipeline.ReplayOptions{UntilOffset: -1}
+	if until != "" {
+		if offset, err := strconv.ParseInt(until, 10, 64); err == nil {
+			opts.UntilOffset = offset
+		} else if ts, err := time.Parse(time.RFC3339, until); err == nil {
+			opts.UntilTimestamp = ts
+		} else {
+			return opts, fmt.Errorf("invalid --until %s, expected an offset or RFC3339 timestamp", until)
+		}
+	}
+	if ns != "" {
+		nsFilter, err := regexp.Compile(ns)
+		if err != nil {
+			return opts, err
+		}
+		opts.NsFilter = nsFilter
+	}
+	return opts, nil
+}
+
+// adaptorFromConfig builds an adaptor from a JSON object whose "type" field names the adaptor,
+// the remaining fields are the same as in a pipeline file.
+func adaptorFromConfig(s string) (adaptor.Adaptor, error) {
+	var conf adaptor.Config
+	if err := json.Unmarshal([]byte(s), &conf); err != nil {
+		return nil, fmt.Errorf("invalid --to config, %s", err)
+	}
+	name := conf.GetString("type")
+	if name == "" {
+		return nil, errors.New(`invalid --to config, missing "type"`)
+	}
+	delete(conf, "type")
+	return adaptor.GetAdaptor(name, conf)
+}
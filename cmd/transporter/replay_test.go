@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/pipeline"
+)
+
+var replayOptionsTests = []struct {
+	name        string
+	until, ns   string
+	expected    pipeline.ReplayOptions
+	expectedErr bool
+}{
+	{"defaults", "", "", pipeline.ReplayOptions{UntilOffset: -1}, false},
+	{"offset", "12", "", pipeline.ReplayOptions{UntilOffset: 12}, false},
+	{
+		"timestamp",
+		"2017-05-16T11:00:20Z",
+		"^foo$",
+		pipeline.ReplayOptions{
+			UntilOffset:    -1,
+			UntilTimestamp: time.Date(2017, 5, 16, 11, 0, 20, 0, time.UTC),
+			NsFilter:       regexp.MustCompile("^foo$"),
+		},
+		false,
+	},
+	{"bad_until", "last tuesday", "", pipeline.ReplayOptions{UntilOffset: -1}, true},
+	{"bad_ns", "", "(", pipeline.ReplayOptions{UntilOffset: -1}, true},
+}
+
+func TestReplayOptions(t *testing.T) {
+	for _, rt := range replayOptionsTests {
+		opts, err := replayOptions(rt.until, rt.ns)
+		if (err != nil) != rt.expectedErr {
+			t.Errorf("[%s] wrong error, expected error %v, got %v", rt.name, rt.expectedErr, err)
+		}
+		if !reflect.DeepEqual(opts, rt.expected) {
+			t.Errorf("[%s] wrong options, expected %+v, got %+v", rt.name, rt.expected, opts)
+		}
+	}
+}
+
+var adaptorFromConfigTests = []struct {
+	name        string
+	config      string
+	expectedErr error
+}{
+	{"valid", `{"type":"file","uri":"stdout://"}`, nil},
+	{"unknown_adaptor", `{"type":"nope"}`, adaptor.ErrNotFound{Name: "nope"}},
+}
+
+func TestAdaptorFromConfig(t *testing.T) {
+	for _, at := range adaptorFromConfigTests {
+		_, err := adaptorFromConfig(at.config)
+		if !reflect.DeepEqual(err, at.expectedErr) {
+			t.Errorf("[%s] wrong error, expected %v, got %v", at.name, at.expectedErr, err)
+		}
+	}
+	for _, config := range []string{`{"uri":"stdout://"}`, `not json`} {
+		if _, err := adaptorFromConfig(config); err == nil {
+			t.Errorf("expected error for config %s but didn't receive one", config)
+		}
+	}
+}
+
+func TestReplayToFile(t *testing.T) {
+	l, path := setupXlog(t)
+	defer os.RemoveAll(path)
+	defer l.Close()
+
+	outFile := filepath.Join(os.TempDir(), fmt.Sprintf("replaytest%d.json", rand.Int63()))
+	defer os.Remove(outFile)
+	a, err := adaptorFromConfig(fmt.Sprintf(`{"type":"file","uri":"file://%s"}`, outFile))
+	if err != nil {
+		t.Fatalf("unexpected adaptorFromConfig error, %s", err)
+	}
+	count, err := replay(l, a, pipeline.ReplayOptions{UntilOffset: 6, NsFilter: regexp.MustCompile("^foo$")})
+	if err != nil || count != 4 {
+		t.Fatalf("expected 4 messages replayed, got %d, %v", count, err)
+	}
+
+	f, err := os.Open(outFile)
+	if err != nil {
+		t.Fatalf("unable to open output, %s", err)
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	expected := []string{`{"i":0,"name":"doc0"}`, `{"i":2,"name":"doc2"}`, `{"i":4,"name":"doc4"}`, `{"i":6,"name":"doc6"}`}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("wrong output, expected %v, got %v", expected, lines)
+	}
+}
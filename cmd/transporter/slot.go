@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/log"
+	"github.com/olekukonko/tablewriter"
+)
+
+func runSlot(args []string) error {
+	flagset := baseFlagSet("slot")
+	file := flagset.String("pipeline", defaultPipelineFile, "path to the pipeline file whose source owns the slots")
+	force := flagset.Bool("force", false, "drop a slot the pipeline doesn't own, such as one used by a replica")
+	flagset.Usage = usageFor(flagset, "transporter slot [--pipeline=pipeline.js] [--force] list|drop [SLOT]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	args = flagset.Args()
+	if len(args) <= 0 {
+		return errors.New("missing subcommand list|drop")
+	}
+
+	log.Orig().Out = ioutil.Discard
+
+	builder, err := newBuilder(*file)
+	if err != nil {
+		return err
+	}
+	if builder.source == nil {
+		return errors.New("pipeline has no source")
+	}
+	sm, ok := builder.source.a.(adaptor.SlotManager)
+	if !ok {
+		return adaptor.ErrFuncNotSupported{Name: builder.source.name, Func: "Slots()"}
+	}
+
+	switch args[0] {
+	case "list":
+		return listSlots(os.Stdout, sm)
+	case "drop":
+		var name string
+		if len(args) > 1 {
+			name = args[1]
+		}
+		if err := sm.DropSlot(name, *force); err != nil {
+			return err
+		}
+		fmt.Println("slot dropped")
+	default:
+		return fmt.Errorf("unknown subcommand %s, expected list|drop", args[0])
+	}
+	return nil
+}
+
+// listSlots writes a table of the source's slots, owned slots are the ones the pipeline uses
+// and any other inactive slot is likely left over from a removed pipeline.
+func listSlots(w io.Writer, sm adaptor.SlotManager) error {
+	slots, err := sm.Slots()
+	if err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(w)
+	table.SetHeader([]string{"slot", "plugin", "active", "owned", "retained bytes"})
+	for _, s := range slots {
+		table.Append([]string{
+			s.Name,
+			s.Plugin,
+			strconv.FormatBool(s.Active),
+			strconv.FormatBool(s.Owned),
+			strconv.FormatInt(s.RetainedBytes, 10),
+		})
+	}
+	table.Render()
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/compose/transporter/adaptor"
+)
+
+type mockSlotManager struct {
+	slots []adaptor.Slot
+	err   error
+}
+
+func (m *mockSlotManager) Slots() ([]adaptor.Slot, error) { return m.slots, m.err }
+
+func (m *mockSlotManager) DropSlot(string, bool) error { return m.err }
+
+func TestListSlots(t *testing.T) {
+	var buf bytes.Buffer
+	sm := &mockSlotManager{slots: []adaptor.Slot{
+		{Name: "transporter_slot", Plugin: "pgoutput", Active: true, Owned: true, RetainedBytes: 1024},
+		{Name: "old_slot", Plugin: "pgoutput", RetainedBytes: 52428800},
+	}}
+	if err := listSlots(&buf, sm); err != nil {
+		t.Fatalf("unexpected listSlots error, %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 6 {
+		t.Fatalf("wrong number of lines, expected 6, got %d\n%s", len(lines), buf.String())
+	}
+	for i, expected := range [][]string{
+		{"transporter_slot", "true", "true", "1024"},
+		{"old_slot", "false", "false", "52428800"},
+	} {
+		for _, field := range expected {
+			if !strings.Contains(lines[i+3], field) {
+				t.Errorf("line %q missing %s", lines[i+3], field)
+			}
+		}
+	}
+
+	sm.err = errors.New("connection refused")
+	if err := listSlots(&buf, sm); err != sm.err {
+		t.Errorf("wrong error, expected %s, got %v", sm.err, err)
+	}
+}
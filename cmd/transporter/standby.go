@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/offset"
+	"github.com/compose/transporter/replication"
+	"github.com/olekukonko/tablewriter"
+)
+
+func runStandby(args []string) error {
+	flagset := baseFlagSet("standby")
+	logDir := flagset.String("xlog_dir", "", "path to commit log directory")
+	listen := flagset.String("listen", "127.0.0.1:7071", "address the primary serves the commit log on")
+	primary := flagset.String("primary", "", "URL of the primary to follow, i.e. https://primary:7071")
+	tlsCert := flagset.String("tls_cert", "", "path to the certificate the primary serves the commit log with, or the follower presents to the primary")
+	tlsKey := flagset.String("tls_key", "", "path to the private key of tls_cert")
+	tlsCA := flagset.String("tls_ca", "", "path to the CA certificates verifying followers when serving, or the primary when following")
+	tokenFile := flagset.String("token_file", "", "path to file containing the token shared by the primary and its followers")
+	tokenEnv := flagset.String("token_env", "", "environment variable containing the token shared by the primary and its followers")
+	interval := flagset.Duration("interval", time.Second, "how often the standby copies changes from the primary")
+	keyFile := flagset.String("encryption_key_file", "", "path to file containing ID:KEY pairs for decrypting the commit log")
+	keyEnv := flagset.String("encryption_key_env", "", "environment variable containing ID:KEY pairs for decrypting the commit log")
+	flagset.Usage = usageFor(flagset, "transporter standby --xlog_dir=/path/to/log serve|follow|promote")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	if *logDir == "" {
+		return errors.New("missing required flag --xlog_dir")
+	}
+
+	args = flagset.Args()
+	if len(args) <= 0 {
+		return errors.New("missing subcommand serve|follow|promote")
+	}
+
+	switch args[0] {
+	case "serve":
+		if *tlsCert == "" || *tlsKey == "" {
+			return errors.New("serve requires --tls_cert and --tls_key")
+		}
+		token, err := loadToken(*tokenFile, *tokenEnv)
+		if err != nil {
+			return err
+		}
+		if token == "" && *tlsCA == "" {
+			return errors.New("serve requires a token (--token_file or --token_env) or client certificates (--tls_ca)")
+		}
+		tlsConfig, err := replication.ServerTLSConfig(*tlsCA)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{
+			Addr:      *listen,
+			Handler:   replication.NewServer(*logDir, token),
+			TLSConfig: tlsConfig,
+		}
+		log.With("listen", *listen).With("xlog_dir", *logDir).Infoln("serving commit log to standbys")
+		errc := make(chan error, 1)
+		go func() {
+			errc <- srv.ListenAndServeTLS(*tlsCert, *tlsKey)
+		}()
+		select {
+		case err := <-errc:
+			return err
+		case <-waitInterrupt():
+			return srv.Close()
+		}
+	case "follow":
+		if *primary == "" {
+			return errors.New("missing required flag --primary")
+		}
+		token, err := loadToken(*tokenFile, *tokenEnv)
+		if err != nil {
+			return err
+		}
+		f, err := replication.NewFollower(*primary, *logDir,
+			replication.WithToken(token),
+			replication.WithTLS(*tlsCA, *tlsCert, *tlsKey))
+		if err != nil {
+			return err
+		}
+		log.With("primary", *primary).With("xlog_dir", *logDir).Infoln("following primary")
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			f.Run(*interval, done)
+			close(stopped)
+		}()
+		<-waitInterrupt()
+		close(done)
+		<-stopped
+		return nil
+	case "promote":
+		log.Orig().Out = ioutil.Discard
+		encOpts, err := encryptionOptions(*keyFile, *keyEnv, false)
+		if err != nil {
+			return err
+		}
+		return standbyPromote(*logDir, encOpts, os.Stdout)
+	default:
+		return fmt.Errorf("unknown subcommand %s, expected serve|follow|promote", args[0])
+	}
+}
+
+// loadToken reads the token shared by the primary and its followers from the file or the
+// environment variable, surrounding whitespace is ignored.
+func loadToken(file, env string) (string, error) {
+	switch {
+	case file != "":
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	case env != "":
+		return strings.TrimSpace(os.Getenv(env)), nil
+	}
+	return "", nil
+}
+
+// waitInterrupt returns a channel closed once the process receives SIGINT or SIGTERM.
+func waitInterrupt() chan struct{} {
+	c := make(chan struct{})
+	go func() {
+		interrupt(nil)
+		close(c)
+	}()
+	return c
+}
+
+// standbyPromote repairs the standby's copy of the log directory and prints how many
+// messages each sink will receive again when the pipeline is started from it.
+func standbyPromote(logDir string, encOpts []commitlog.OptionFunc, out io.Writer) error {
+	removed, err := replication.Promote(logDir)
+	if err != nil {
+		return err
+	}
+	l, err := commitlog.New(append([]commitlog.OptionFunc{commitlog.WithPath(logDir)}, encOpts...)...)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	newest := l.NewestOffset() - 1
+	fmt.Fprintf(out, "removed %d bytes of partially copied entries\n", removed)
+	fmt.Fprintf(out, "newest offset: %d\n", newest)
+
+	files, err := ioutil.ReadDir(logDir)
+	if err != nil {
+		return err
+	}
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"sink", "offset", "replay window"})
+	for _, file := range files {
+		var (
+			name string
+			om   offset.Manager
+		)
+		switch {
+		case file.IsDir() && strings.HasPrefix(file.Name(), consumerDirPrefix):
+			name = strings.TrimPrefix(file.Name(), consumerDirPrefix)
+			om, err = offset.NewLogManager(logDir, name, encOpts...)
+		case !file.IsDir() && strings.HasPrefix(file.Name(), consumerDirPrefix) && strings.HasSuffix(file.Name(), ".json"):
+			name = strings.TrimSuffix(strings.TrimPrefix(file.Name(), consumerDirPrefix), ".json")
+			om, err = offset.NewFileManager(logDir, name)
+		default:
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		sinkOffset := om.NewestOffset()
+		table.Append([]string{name, strconv.FormatInt(sinkOffset, 10), strconv.FormatInt(newest-sinkOffset, 10)})
+	}
+	table.Render()
+	return nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/offset"
+)
+
+func TestStandbyPromote(t *testing.T) {
+	path := setupOffsets(t, map[string]map[string]uint64{"sink": {"foo": 7}})
+	defer os.RemoveAll(path)
+	l, err := commitlog.New(commitlog.WithPath(path))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	for i := 0; i < 15; i++ {
+		if _, err := l.Append(commitlog.NewLogFromEntry(commitlog.LogEntry{
+			Key:   []byte("foo"),
+			Value: []byte(`{}`),
+			Op:    ops.Insert,
+			Mode:  commitlog.Sync,
+		})); err != nil {
+			t.Fatalf("unexpected Append error, %s", err)
+		}
+	}
+	l.Close()
+	fm, err := offset.NewFileManager(path, "file_sink")
+	if err != nil {
+		t.Fatalf("unexpected NewFileManager error, %s", err)
+	}
+	fm.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 12}, false)
+
+	var out bytes.Buffer
+	if err := standbyPromote(path, nil, &out); err != nil {
+		t.Fatalf("unexpected standbyPromote error, %s", err)
+	}
+	if !strings.Contains(out.String(), "newest offset: 14") {
+		t.Errorf("missing newest offset, got %s", out.String())
+	}
+	for _, row := range [][]string{{"sink", "7", "7"}, {"file_sink", "12", "2"}} {
+		if !containsRow(out.String(), row) {
+			t.Errorf("missing row %v, got %s", row, out.String())
+		}
+	}
+}
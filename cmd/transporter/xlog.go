@@ -1,22 +1,36 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/compose/mejson"
 	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/pipeline"
+	"github.com/olekukonko/tablewriter"
 )
 
 func runXlog(args []string) error {
 	flagset := baseFlagSet("xlog")
 	logDir := flagset.String("xlog_dir", "", "path to commit log directory")
-	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/log oldest|current|show [OFFSET]")
+	keyFile := flagset.String("encryption_key_file", "", "path to file containing ID:KEY pairs for decrypting the commit log")
+	keyEnv := flagset.String("encryption_key_env", "", "environment variable containing ID:KEY pairs for decrypting the commit log")
+	encryptKeys := flagset.Bool("encrypt_keys", false, "encrypt the namespace of entries written by import")
+	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/log oldest|current|show|dump|tail|grep|stats|export|import [OFFSET]")
 	if err := flagset.Parse(args); err != nil {
 		return err
 	}
@@ -27,12 +41,16 @@ func runXlog(args []string) error {
 
 	args = flagset.Args()
 	if len(args) <= 0 {
-		return errors.New("missing subcommand oldest|current|show")
+		return errors.New("missing subcommand oldest|current|show|dump|tail|grep|stats|export|import")
 	}
 
 	log.Orig().Out = ioutil.Discard
 
-	l, err := commitlog.New(commitlog.WithPath(*logDir))
+	encOpts, err := encryptionOptions(*keyFile, *keyEnv, *encryptKeys)
+	if err != nil {
+		return err
+	}
+	l, err := commitlog.New(append([]commitlog.OptionFunc{commitlog.WithPath(*logDir)}, encOpts...)...)
 	if err != nil {
 		return err
 	}
@@ -64,8 +82,491 @@ func runXlog(args []string) error {
 		fmt.Fprintf(os.Stdout, "%-10s: %s\n", "mode", e.Mode.String())
 		fmt.Fprintf(os.Stdout, "%-10s: %s\n", "op", strings.ToUpper(e.Op.String()))
 		fmt.Fprintf(os.Stdout, "%-10s: %s\n", "key", string(e.Key))
-		fmt.Fprintf(os.Stdout, "%-10s: %s\n", "value", string(e.Value))
+		value, err := entryJSON(e)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stdout, "%-10s: %s\n", "value", value)
+		update, err := updateJSON(e)
+		if err != nil {
+			return err
+		}
+		if update != nil {
+			fmt.Fprintf(os.Stdout, "%-10s: %s\n", "update", update)
+		}
+	case "dump":
+		return runXlogDump(l, args[1:], os.Stdout)
+	case "tail":
+		return runXlogTail(l, args[1:], os.Stdout)
+	case "grep":
+		return runXlogGrep(l, args[1:], os.Stdout)
+	case "stats":
+		return xlogStats(l, *logDir, os.Stdout)
+	case "export":
+		return runXlogExport(l, args[1:])
+	case "import":
+		return runXlogImport(l, args[1:])
+	default:
+		return fmt.Errorf("unknown subcommand %s", args[0])
 	}
 
 	return nil
 }
+
+// xlogEntry is the JSON representation of a commitlog.LogEntry used when dumping the log.
+// Update holds the update operators of the entry, if any, apart from its value. Data and
+// UpdateData are only set by export and hold the value and update as encoded by
+// data.Data.MarshalBinary so that import can restore the exact type of every field.
+type xlogEntry struct {
+	Offset     uint64          `json:"offset"`
+	Timestamp  uint64          `json:"timestamp"`
+	Mode       string          `json:"mode"`
+	Op         string          `json:"op"`
+	Namespace  string          `json:"ns"`
+	Value      json.RawMessage `json:"value"`
+	Update     json.RawMessage `json:"update,omitempty"`
+	Data       []byte          `json:"data,omitempty"`
+	UpdateData []byte          `json:"update_data,omitempty"`
+	Position   string          `json:"position,omitempty"`
+}
+
+func newXlogEntry(offset uint64, e commitlog.LogEntry) (xlogEntry, error) {
+	value, err := entryJSON(e)
+	if err != nil {
+		return xlogEntry{}, err
+	}
+	update, err := updateJSON(e)
+	if err != nil {
+		return xlogEntry{}, err
+	}
+	return xlogEntry{
+		Offset:    offset,
+		Timestamp: e.Timestamp,
+		Mode:      e.Mode.String(),
+		Op:        e.Op.String(),
+		Namespace: string(e.Key),
+		Value:     value,
+		Update:    update,
+		Position:  string(e.Position),
+	}, nil
+}
+
+// entryFilter decides whether an entry is included in the output of dump.
+type entryFilter struct {
+	from, to int64
+	ns       *regexp.Regexp
+	ops      map[ops.Op]bool
+}
+
+func (f entryFilter) match(e commitlog.LogEntry) bool {
+	if f.ns != nil && !f.ns.Match(e.Key) {
+		return false
+	}
+	if len(f.ops) > 0 && !f.ops[e.Op] {
+		return false
+	}
+	return true
+}
+
+func runXlogDump(l *commitlog.CommitLog, args []string, out io.Writer) error {
+	flagset := flag.NewFlagSet("xlog dump", flag.ExitOnError)
+	from := flagset.Int64("from", -1, "first offset to include, defaults to the oldest offset")
+	to := flagset.Int64("to", -1, "last offset to include, defaults to the newest offset")
+	ns := flagset.String("ns", "", "regex the namespace must match")
+	op := flagset.String("op", "", "comma separated list of operations to include (i.e. insert,update)")
+	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/log dump [-from OFFSET] [-to OFFSET] [-ns REGEX] [-op OPS]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+
+	f := entryFilter{from: *from, to: *to, ops: make(map[ops.Op]bool)}
+	if *ns != "" {
+		compiledNs, err := regexp.Compile(strings.Trim(*ns, "/"))
+		if err != nil {
+			return err
+		}
+		f.ns = compiledNs
+	}
+	for _, o := range strings.Split(*op, ",") {
+		if o = strings.TrimSpace(o); o == "" {
+			continue
+		}
+		parsed := ops.OpTypeFromString(strings.ToLower(o))
+		if parsed == ops.Unknown {
+			return fmt.Errorf("unknown op %s", o)
+		}
+		f.ops[parsed] = true
+	}
+	return xlogDump(l, f, out)
+}
+
+func xlogDump(l *commitlog.CommitLog, f entryFilter, out io.Writer) error {
+	enc := json.NewEncoder(out)
+	return iterateEntries(l, f.from, f.to, func(offset uint64, e commitlog.LogEntry) error {
+		if !f.match(e) {
+			return nil
+		}
+		xe, err := newXlogEntry(offset, e)
+		if err != nil {
+			return err
+		}
+		return enc.Encode(xe)
+	})
+}
+
+// iterateEntries calls fn for every entry with an offset between from and to, inclusive. A
+// negative from or to leaves the range open on that side.
+func iterateEntries(l *commitlog.CommitLog, from, to int64, fn func(uint64, commitlog.LogEntry) error) error {
+	r, err := l.NewReaderFrom(from)
+	if err != nil {
+		return err
+	}
+	for {
+		offset, e, err := r.NextEntry()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if to >= 0 && int64(offset) > to {
+			return nil
+		}
+		if err := fn(offset, e); err != nil {
+			return err
+		}
+	}
+}
+
+func runXlogTail(l *commitlog.CommitLog, args []string, out io.Writer) error {
+	flagset := flag.NewFlagSet("xlog tail", flag.ExitOnError)
+	n := flagset.Int64("n", 10, "number of entries from the end of the log to start with")
+	follow := flagset.Bool("f", false, "keep printing entries as they are appended to the log")
+	interval := flagset.Duration("interval", time.Second, "how often to check for new entries when following")
+	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/log tail [-n NUM] [-f]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+	var done chan struct{}
+	if *follow {
+		done = make(chan struct{})
+		go func() {
+			interrupt(nil)
+			close(done)
+		}()
+	}
+	return xlogTail(l, *n, *interval, done, out)
+}
+
+// xlogTail prints the last n entries and, if done is not nil, continues printing new
+// entries until done is closed.
+func xlogTail(l *commitlog.CommitLog, n int64, interval time.Duration, done chan struct{}, out io.Writer) error {
+	r, err := l.NewReaderFrom(l.NewestOffset() - n)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(out)
+	for {
+		offset, e, err := r.NextEntry()
+		if err == nil {
+			xe, err := newXlogEntry(offset, e)
+			if err != nil {
+				return err
+			}
+			if err := enc.Encode(xe); err != nil {
+				return err
+			}
+			continue
+		} else if err != io.EOF {
+			return err
+		}
+		if done == nil {
+			return nil
+		}
+		select {
+		case <-done:
+			return nil
+		case <-time.After(interval):
+		}
+		if err := l.Reload(); err != nil {
+			return err
+		}
+	}
+}
+
+func runXlogGrep(l *commitlog.CommitLog, args []string, out io.Writer) error {
+	flagset := flag.NewFlagSet("xlog grep", flag.ExitOnError)
+	keyOnly := flagset.Bool("key", false, "only match against the key (i.e. namespace)")
+	valueOnly := flagset.Bool("value", false, "only match against the value rendered as JSON")
+	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/log grep [-key|-value] PATTERN")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+	if flagset.NArg() != 1 {
+		return errors.New("wrong number of arguments, expected grep PATTERN")
+	}
+	re, err := regexp.Compile(flagset.Arg(0))
+	if err != nil {
+		return err
+	}
+	matchKey, matchValue := !*valueOnly, !*keyOnly
+	return xlogGrep(l, re, matchKey, matchValue, out)
+}
+
+func xlogGrep(l *commitlog.CommitLog, re *regexp.Regexp, matchKey, matchValue bool, out io.Writer) error {
+	enc := json.NewEncoder(out)
+	return iterateEntries(l, -1, -1, func(offset uint64, e commitlog.LogEntry) error {
+		xe, err := newXlogEntry(offset, e)
+		if err != nil {
+			return err
+		}
+		if (matchKey && re.Match(e.Key)) || (matchValue && re.Match(xe.Value)) {
+			return enc.Encode(xe)
+		}
+		return nil
+	})
+}
+
+func xlogStats(l *commitlog.CommitLog, logDir string, out io.Writer) error {
+	var total int
+	nsCounts := make(map[string]int)
+	opCounts := make(map[string]int)
+	modeCounts := make(map[string]int)
+	err := iterateEntries(l, -1, -1, func(offset uint64, e commitlog.LogEntry) error {
+		total++
+		nsCounts[string(e.Key)]++
+		opCounts[e.Op.String()]++
+		modeCounts[e.Mode.String()]++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "entries: %d\n", total)
+	for _, counts := range []struct {
+		header string
+		m      map[string]int
+	}{
+		{"namespace", nsCounts},
+		{"op", opCounts},
+		{"mode", modeCounts},
+	} {
+		renderCounts(out, counts.header, counts.m)
+	}
+
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{"segment", "base offset", "key id", "bytes"})
+	for _, s := range l.Segments() {
+		name := fmt.Sprintf(commitlog.LogNameFormat, s.BaseOffset)
+		stat, err := os.Stat(filepath.Join(logDir, name))
+		if err != nil {
+			return err
+		}
+		table.Append([]string{name, strconv.FormatInt(s.BaseOffset, 10), s.KeyID(), strconv.FormatInt(stat.Size(), 10)})
+	}
+	table.Render()
+	return nil
+}
+
+func renderCounts(out io.Writer, header string, m map[string]int) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	table := tablewriter.NewWriter(out)
+	table.SetHeader([]string{header, "entries"})
+	for _, k := range keys {
+		table.Append([]string{k, strconv.Itoa(m[k])})
+	}
+	table.Render()
+}
+
+func runXlogExport(l *commitlog.CommitLog, args []string) error {
+	flagset := flag.NewFlagSet("xlog export", flag.ExitOnError)
+	from := flagset.Int64("from", -1, "first offset to include, defaults to the oldest offset")
+	to := flagset.Int64("to", -1, "last offset to include, defaults to the newest offset")
+	output := flagset.String("o", "", "file to write the entries to, defaults to stdout")
+	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/log export [-from OFFSET] [-to OFFSET] [-o FILE]")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		return xlogExport(l, *from, *to, os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := xlogExport(l, *from, *to, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// xlogExport writes every entry between from and to as a JSON line which includes the typed
+// value needed by xlogImport.
+func xlogExport(l *commitlog.CommitLog, from, to int64, out io.Writer) error {
+	enc := json.NewEncoder(out)
+	return iterateEntries(l, from, to, func(offset uint64, e commitlog.LogEntry) error {
+		xe, err := newXlogEntry(offset, e)
+		if err != nil {
+			return err
+		}
+		d, err := pipeline.EntryData(e)
+		if err != nil {
+			return err
+		}
+		if xe.Data, err = d.MarshalBinary(); err != nil {
+			return err
+		}
+		// the update is already stored as data.Data.MarshalBinary
+		xe.UpdateData = e.Update
+		return enc.Encode(xe)
+	})
+}
+
+func runXlogImport(l *commitlog.CommitLog, args []string) error {
+	flagset := flag.NewFlagSet("xlog import", flag.ExitOnError)
+	flagset.Usage = usageFor(flagset, "transporter xlog --xlog_dir=/path/to/new/log import FILE")
+	if err := flagset.Parse(args); err != nil {
+		return err
+	}
+	if flagset.NArg() != 1 {
+		return errors.New("wrong number of arguments, expected import FILE")
+	}
+	in := os.Stdin
+	if flagset.Arg(0) != "-" {
+		f, err := os.Open(flagset.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	n, err := xlogImport(l, in)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d entries\n", n)
+	return nil
+}
+
+// xlogImport appends the entries written by xlogExport to l, which must be empty. Offsets
+// are assigned by the commit log so the first imported entry is always at offset 0. Lines
+// without a typed value (i.e. written by hand or by dump) are decoded from the extended JSON value.
+func xlogImport(l *commitlog.CommitLog, in io.Reader) (int, error) {
+	if l.NewestOffset() != 0 {
+		return 0, fmt.Errorf("import requires an empty commit log, found %d entries", l.NewestOffset())
+	}
+	dec := json.NewDecoder(in)
+	var n int
+	for {
+		var xe xlogEntry
+		if err := dec.Decode(&xe); err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		le, err := xe.logEntry()
+		if err != nil {
+			return n, fmt.Errorf("invalid entry at offset %d, %s", xe.Offset, err)
+		}
+		if _, err := l.Append(commitlog.NewLogFromEntry(le)); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+func (xe xlogEntry) logEntry() (commitlog.LogEntry, error) {
+	mode, err := commitlog.ModeFromString(xe.Mode)
+	if err != nil {
+		return commitlog.LogEntry{}, err
+	}
+	op := ops.Unknown
+	if xe.Op != "" {
+		op = ops.OpTypeFromString(strings.ToLower(xe.Op))
+	}
+	if op == ops.Unknown {
+		return commitlog.LogEntry{}, fmt.Errorf("unknown op %s", xe.Op)
+	}
+	value := xe.Data
+	if len(value) == 0 {
+		d, err := pipeline.EntryData(commitlog.LogEntry{Value: xe.Value, Encoding: commitlog.JSONEncoding})
+		if err != nil {
+			return commitlog.LogEntry{}, err
+		}
+		if value, err = d.MarshalBinary(); err != nil {
+			return commitlog.LogEntry{}, err
+		}
+	} else {
+		var d data.Data
+		if err := d.UnmarshalBinary(value); err != nil {
+			return commitlog.LogEntry{}, err
+		}
+	}
+	update := xe.UpdateData
+	if len(update) == 0 && len(xe.Update) > 0 {
+		u, err := pipeline.EntryData(commitlog.LogEntry{Value: xe.Update, Encoding: commitlog.JSONEncoding})
+		if err != nil {
+			return commitlog.LogEntry{}, err
+		}
+		if update, err = u.MarshalBinary(); err != nil {
+			return commitlog.LogEntry{}, err
+		}
+	} else if len(update) > 0 {
+		var u data.Data
+		if err := u.UnmarshalBinary(update); err != nil {
+			return commitlog.LogEntry{}, err
+		}
+	}
+	return commitlog.LogEntry{
+		Key:       []byte(xe.Namespace),
+		Value:     value,
+		Timestamp: xe.Timestamp,
+		Mode:      mode,
+		Op:        op,
+		Encoding:  commitlog.BinaryEncoding,
+		Position:  []byte(xe.Position),
+		Update:    update,
+	}, nil
+}
+
+// entryJSON renders the value of the LogEntry as extended JSON regardless of how it was encoded.
+func entryJSON(e commitlog.LogEntry) ([]byte, error) {
+	d, err := pipeline.EntryData(e)
+	if err != nil {
+		return nil, err
+	}
+	return dataJSON(d)
+}
+
+// updateJSON renders the update operators of the LogEntry as extended JSON, nil is returned
+// when it has none.
+func updateJSON(e commitlog.LogEntry) ([]byte, error) {
+	u, err := pipeline.EntryUpdate(e)
+	if err != nil || u == nil {
+		return nil, err
+	}
+	return dataJSON(u)
+}
+
+func dataJSON(d data.Data) ([]byte, error) {
+	m, err := mejson.Marshal(d.AsMap())
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(m)
+}
+
+// encryptionOptions loads a commitlog.Keyring from the provided key file and environment
+// variable, no options are returned when neither is set.
+func encryptionOptions(keyFile, keyEnv string, encryptKeys bool) ([]commitlog.OptionFunc, error) {
+	kr, err := commitlog.LoadKeyring(keyFile, keyEnv)
+	if err != nil || kr == nil {
+		return nil, err
+	}
+	return []commitlog.OptionFunc{commitlog.WithEncryption(kr, encryptKeys)}, nil
+}
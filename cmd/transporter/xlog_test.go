@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/pipeline"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func setupXlog(t *testing.T) (*commitlog.CommitLog, string) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("xlogtest%d", rand.Int63()))
+	l, err := commitlog.New(commitlog.WithPath(path), commitlog.WithMaxSegmentBytes(200))
+	if err != nil {
+		t.Fatalf("unexpected commitlog.New error, %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		ns, op := "foo", ops.Insert
+		if i%2 == 1 {
+			ns, op = "bar", ops.Update
+		}
+		b, _ := data.Data{"i": i, "name": fmt.Sprintf("doc%d", i)}.MarshalBinary()
+		_, err := l.Append(commitlog.NewLogFromEntry(commitlog.LogEntry{
+			Key:       []byte(ns),
+			Value:     b,
+			Timestamp: uint64(i),
+			Mode:      commitlog.Sync,
+			Op:        op,
+			Encoding:  commitlog.BinaryEncoding,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected Append error, %s", err)
+		}
+	}
+	return l, path
+}
+
+func dumpedOffsets(t *testing.T, out *bytes.Buffer) []uint64 {
+	offsets := make([]uint64, 0)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var e xlogEntry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("unable to unmarshal line %s, %s", line, err)
+		}
+		offsets = append(offsets, e.Offset)
+	}
+	return offsets
+}
+
+var xlogDumpTests = []struct {
+	name     string
+	args     []string
+	expected []uint64
+}{
+	{"all", []string{}, []uint64{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	{"from_to", []string{"-from", "3", "-to", "5"}, []uint64{3, 4, 5}},
+	{"ns", []string{"-ns", "/^b/"}, []uint64{1, 3, 5, 7, 9}},
+	{"op", []string{"-op", "insert", "-from", "4"}, []uint64{4, 6, 8}},
+}
+
+func TestXlogDump(t *testing.T) {
+	l, path := setupXlog(t)
+	defer os.RemoveAll(path)
+	defer l.Close()
+	for _, dt := range xlogDumpTests {
+		var out bytes.Buffer
+		if err := runXlogDump(l, dt.args, &out); err != nil {
+			t.Fatalf("[%s] unexpected error, %s", dt.name, err)
+		}
+		if offsets := dumpedOffsets(t, &out); !reflect.DeepEqual(offsets, dt.expected) {
+			t.Errorf("[%s] wrong offsets, expected %v, got %v", dt.name, dt.expected, offsets)
+		}
+	}
+
+	var out bytes.Buffer
+	runXlogDump(l, []string{"-from", "2", "-to", "2"}, &out)
+	expected := `{"offset":2,"timestamp":2,"mode":"SYNC","op":"insert","ns":"foo","value":{"i":2,"name":"doc2"}}` + "\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %s, got %s", expected, out.String())
+	}
+}
+
+func TestXlogTail(t *testing.T) {
+	l, path := setupXlog(t)
+	defer os.RemoveAll(path)
+	defer l.Close()
+	var out bytes.Buffer
+	if err := xlogTail(l, 3, 0, nil, &out); err != nil {
+		t.Fatalf("unexpected error, %s", err)
+	}
+	if offsets := dumpedOffsets(t, &out); !reflect.DeepEqual(offsets, []uint64{7, 8, 9}) {
+		t.Errorf("wrong offsets, expected [7 8 9], got %v", offsets)
+	}
+}
+
+var xlogGrepTests = []struct {
+	name                 string
+	pattern              string
+	matchKey, matchValue bool
+	expected             []uint64
+}{
+	{"key_and_value", "doc3|foo", true, true, []uint64{0, 2, 3, 4, 6, 8}},
+	{"key_only", "doc3|foo", true, false, []uint64{0, 2, 4, 6, 8}},
+	{"value_only", "doc3|foo", false, true, []uint64{3}},
+}
+
+func TestXlogGrep(t *testing.T) {
+	l, path := setupXlog(t)
+	defer os.RemoveAll(path)
+	defer l.Close()
+	for _, gt := range xlogGrepTests {
+		var out bytes.Buffer
+		if err := xlogGrep(l, regexp.MustCompile(gt.pattern), gt.matchKey, gt.matchValue, &out); err != nil {
+			t.Fatalf("[%s] unexpected error, %s", gt.name, err)
+		}
+		if offsets := dumpedOffsets(t, &out); !reflect.DeepEqual(offsets, gt.expected) {
+			t.Errorf("[%s] wrong offsets, expected %v, got %v", gt.name, gt.expected, offsets)
+		}
+	}
+}
+
+func TestXlogStats(t *testing.T) {
+	l, path := setupXlog(t)
+	defer os.RemoveAll(path)
+	defer l.Close()
+	var out bytes.Buffer
+	if err := xlogStats(l, path, &out); err != nil {
+		t.Fatalf("unexpected error, %s", err)
+	}
+	for _, expected := range []string{"entries: 10", "| foo       |       5 |", "| update |       5 |", "| SYNC |      10 |", fmt.Sprintf(commitlog.LogNameFormat, 0)} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected output to contain %q, got\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestXlogExportImport(t *testing.T) {
+	l, path := setupXlog(t)
+	defer os.RemoveAll(path)
+	defer l.Close()
+	var out bytes.Buffer
+	if err := xlogExport(l, 2, 6, &out); err != nil {
+		t.Fatalf("unexpected export error, %s", err)
+	}
+
+	importPath := filepath.Join(os.TempDir(), fmt.Sprintf("xlogimporttest%d", rand.Int63()))
+	defer os.RemoveAll(importPath)
+	imported, err := commitlog.New(commitlog.WithPath(importPath))
+	if err != nil {
+		t.Fatalf("unexpected commitlog.New error, %s", err)
+	}
+	defer imported.Close()
+	if n, err := xlogImport(imported, &out); err != nil || n != 5 {
+		t.Fatalf("expected 5 entries imported, got %d, %v", n, err)
+	}
+
+	r, _ := l.NewReaderFrom(2)
+	ir, _ := imported.NewReaderFrom(-1)
+	for i := 0; i < 5; i++ {
+		_, expected, err := r.NextEntry()
+		if err != nil {
+			t.Fatalf("unexpected error reading original log, %s", err)
+		}
+		offset, actual, err := ir.NextEntry()
+		if err != nil {
+			t.Fatalf("unexpected error reading imported log, %s", err)
+		}
+		if offset != uint64(i) {
+			t.Errorf("wrong offset, expected %d, got %d", i, offset)
+		}
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("wrong entry at offset %d, expected %+v, got %+v", offset, expected, actual)
+		}
+	}
+
+	if _, err := xlogImport(imported, strings.NewReader("")); err == nil {
+		t.Error("expected error importing into a non-empty log but didn't receive one")
+	}
+}
+
+func TestXlogUpdate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("xlogtest%d", rand.Int63()))
+	defer os.RemoveAll(path)
+	l, err := commitlog.New(commitlog.WithPath(path))
+	if err != nil {
+		t.Fatalf("unexpected commitlog.New error, %s", err)
+	}
+	defer l.Close()
+	b, _ := data.Data{"_id": 1}.MarshalBinary()
+	u, _ := data.Data{"$set": map[string]interface{}{"name": "doc1"}}.MarshalBinary()
+	l.Append(commitlog.NewLogFromEntry(commitlog.LogEntry{
+		Key:       []byte("foo"),
+		Value:     b,
+		Update:    u,
+		Timestamp: 1,
+		Mode:      commitlog.Sync,
+		Op:        ops.Update,
+		Encoding:  commitlog.BinaryEncoding,
+	}))
+
+	var out bytes.Buffer
+	if err := runXlogDump(l, []string{}, &out); err != nil {
+		t.Fatalf("unexpected dump error, %s", err)
+	}
+	expected := `{"offset":0,"timestamp":1,"mode":"SYNC","op":"update","ns":"foo","value":{"_id":1},"update":{"$set":{"name":"doc1"}}}` + "\n"
+	if out.String() != expected {
+		t.Errorf("wrong output, expected %s, got %s", expected, out.String())
+	}
+
+	out.Reset()
+	if err := xlogExport(l, -1, -1, &out); err != nil {
+		t.Fatalf("unexpected export error, %s", err)
+	}
+	importPath := filepath.Join(os.TempDir(), fmt.Sprintf("xlogimporttest%d", rand.Int63()))
+	defer os.RemoveAll(importPath)
+	imported, err := commitlog.New(commitlog.WithPath(importPath))
+	if err != nil {
+		t.Fatalf("unexpected commitlog.New error, %s", err)
+	}
+	defer imported.Close()
+	if n, err := xlogImport(imported, &out); err != nil || n != 1 {
+		t.Fatalf("expected 1 entry imported, got %d, %v", n, err)
+	}
+	r, _ := imported.NewReaderFrom(-1)
+	_, e, err := r.NextEntry()
+	if err != nil {
+		t.Fatalf("unexpected error reading imported log, %s", err)
+	}
+	if !bytes.Equal(e.Update, u) {
+		t.Errorf("wrong update, expected %v, got %v", u, e.Update)
+	}
+}
+
+var xlogImportJSONTests = []struct {
+	name        string
+	line        string
+	expected    data.Data
+	expectedErr bool
+}{
+	{
+		"extended_json",
+		`{"offset":7,"timestamp":1,"mode":"COPY","op":"insert","ns":"foo","value":{"_id":{"$oid":"58efd14b60d271d7457b4f24"},"i":1}}`,
+		data.Data{"_id": bson.ObjectIdHex("58efd14b60d271d7457b4f24"), "i": float64(1)},
+		false,
+	},
+	{
+		"bad_mode",
+		`{"offset":0,"timestamp":1,"mode":"WRONG","op":"insert","ns":"foo","value":{}}`,
+		nil,
+		true,
+	},
+	{
+		"bad_op",
+		`{"offset":0,"timestamp":1,"mode":"SYNC","op":"","ns":"foo","value":{}}`,
+		nil,
+		true,
+	},
+}
+
+func TestXlogImportJSON(t *testing.T) {
+	for _, it := range xlogImportJSONTests {
+		path := filepath.Join(os.TempDir(), fmt.Sprintf("xlogimporttest%d", rand.Int63()))
+		l, err := commitlog.New(commitlog.WithPath(path))
+		if err != nil {
+			t.Fatalf("[%s] unexpected commitlog.New error, %s", it.name, err)
+		}
+		_, err = xlogImport(l, strings.NewReader(it.line))
+		if (err != nil) != it.expectedErr {
+			t.Errorf("[%s] wrong error, expected error %v, got %v", it.name, it.expectedErr, err)
+		}
+		if err == nil {
+			r, _ := l.NewReaderFrom(-1)
+			_, e, _ := r.NextEntry()
+			d, err := pipeline.EntryData(e)
+			if err != nil || !reflect.DeepEqual(d, it.expected) {
+				t.Errorf("[%s] wrong data, expected %#v, got %#v, %v", it.name, it.expected, d, err)
+			}
+		}
+		l.Close()
+		os.RemoveAll(path)
+	}
+}
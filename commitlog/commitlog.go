@@ -3,6 +3,7 @@
 package commitlog
 
 import (
+	"bytes"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -41,6 +42,8 @@ var (
 type CommitLog struct {
 	path            string
 	maxSegmentBytes int64
+	keyring         *Keyring
+	encryptKeys     bool
 
 	mu             sync.RWMutex
 	segments       []*Segment
@@ -110,6 +113,20 @@ func WithMaxSegmentBytes(max int64) OptionFunc {
 	}
 }
 
+// WithEncryption configures the Keyring used to encrypt entry values with AES-GCM. New
+// segments are encrypted with the active key of the Keyring and the key ID is stored in the
+// segment header so older segments can be decrypted after a key rotation. When encryptKeys
+// is true, entry keys are encrypted as well.
+//
+// A nil Keyring leaves the CommitLog unencrypted.
+func WithEncryption(kr *Keyring, encryptKeys bool) OptionFunc {
+	return func(c *CommitLog) error {
+		c.keyring = kr
+		c.encryptKeys = encryptKeys
+		return nil
+	}
+}
+
 func (c *CommitLog) init() error {
 	return os.MkdirAll(c.path, 0755)
 }
@@ -155,7 +172,7 @@ func (c *CommitLog) open() error {
 		}
 	}
 	if len(c.segments) == 0 {
-		segment, err := NewSegment(c.path, LogNameFormat, 0, c.maxSegmentBytes)
+		segment, err := newSegment(c.path, LogNameFormat, 0, c.maxSegmentBytes, c.segmentHeader())
 		if err != nil {
 			return err
 		}
@@ -173,10 +190,20 @@ func (c *CommitLog) Append(p []byte) (offset int64, err error) {
 		if err := c.split(); err != nil {
 			return offset, err
 		}
+	} else if c.checkRekey() {
+		if err := c.rekey(); err != nil {
+			return offset, err
+		}
+	}
+	segment := c.activeSegment()
+	if segment.KeyID() != "" {
+		if l, err = c.sealLog(segment.header, l); err != nil {
+			return offset, err
+		}
 	}
 	offset = c.NewestOffset()
 	l.PutOffset(offset)
-	if _, err := c.activeSegment().Write(l); err != nil {
+	if _, err := segment.Write(l); err != nil {
 		return offset, err
 	}
 	return offset, nil
@@ -194,6 +221,11 @@ func (c *CommitLog) Close() error {
 	return nil
 }
 
+// Path returns the directory the CommitLog is stored in.
+func (c *CommitLog) Path() string {
+	return c.path
+}
+
 // NewestOffset obtains the NextOffset of the current segment in use.
 func (c *CommitLog) NewestOffset() int64 {
 	return c.activeSegment().NextOffset
@@ -271,6 +303,61 @@ func (c *CommitLog) NewReader(offset int64) (io.Reader, error) {
 	}, nil
 }
 
+// NewReaderFrom returns a Reader positioned at the first entry whose offset is greater than
+// or equal to the provided offset. Unlike NewReader, the offset does not need to exist in
+// the log which makes it suitable for reading ranges from a compacted log. If every entry
+// is older than the offset, the Reader is positioned at the end of the log.
+func (c *CommitLog) NewReaderFrom(offset int64) (*Reader, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var idx int
+	for i, s := range c.segments {
+		if s.BaseOffset <= offset {
+			idx = i
+		}
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	position, err := c.segments[idx].findPosition(uint64(offset), false)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		commitlog: c,
+		idx:       idx,
+		position:  position,
+	}, nil
+}
+
+// Reload picks up any segments created in the path since the CommitLog was opened. It is
+// intended for following a log that is being appended to by another process.
+func (c *CommitLog) Reload() error {
+	files, err := ioutil.ReadDir(c.path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last := c.segments[len(c.segments)-1].BaseOffset
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), logFileSuffix) {
+			continue
+		}
+		baseOffset, err := strconv.ParseInt(strings.TrimSuffix(file.Name(), logFileSuffix), 10, 64)
+		if err != nil || baseOffset <= last {
+			continue
+		}
+		segment, err := NewSegment(c.path, LogNameFormat, baseOffset, c.maxSegmentBytes)
+		if err != nil {
+			return err
+		}
+		c.segments = append(c.segments, segment)
+	}
+	c.vActiveSegment.Store(c.segments[len(c.segments)-1])
+	return nil
+}
+
 func (c *CommitLog) replaceSegment(newSegment, oldSegment *Segment) error {
 	log.With("new_segment", newSegment.path).
 		With("old_segment", oldSegment.path).
@@ -317,8 +404,76 @@ func (c *CommitLog) checkSplit() bool {
 	return c.activeSegment().IsFull()
 }
 
+// checkRekey determines whether the active segment was written with a different key
+// than the active one, in which case new entries need to go to a new segment.
+func (c *CommitLog) checkRekey() bool {
+	return c.segmentHeader() != c.activeSegment().header
+}
+
+// rekey starts a new segment using the current segment header, if the active segment
+// has not been written to yet, it is replaced instead.
+func (c *CommitLog) rekey() error {
+	active := c.activeSegment()
+	if active.Position > 0 {
+		return c.split()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := active.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(active.path); err != nil {
+		return err
+	}
+	segment, err := newSegment(c.path, LogNameFormat, active.BaseOffset, c.maxSegmentBytes, c.segmentHeader())
+	if err != nil {
+		return err
+	}
+	log.With("segment", segment.path).With("key_id", segment.KeyID()).Infoln("segment recreated with new header")
+	c.segments[len(c.segments)-1] = segment
+	c.vActiveSegment.Store(segment)
+	return nil
+}
+
+func (c *CommitLog) segmentHeader() SegmentHeader {
+	if c.keyring == nil {
+		return SegmentHeader{}
+	}
+	return SegmentHeader{KeyID: c.keyring.Active(), EncryptKeys: c.encryptKeys}
+}
+
+// sealLog rebuilds l with its key and value encrypted based on the provided header.
+func (c *CommitLog) sealLog(h SegmentHeader, l Log) (Log, error) {
+	if c.keyring == nil {
+		return nil, KeyNotFoundError{h.KeyID}
+	}
+	_, le, err := ReadEntry(bytes.NewReader(l))
+	if err != nil {
+		return nil, err
+	}
+	le, err = c.keyring.sealEntry(h, le)
+	if err != nil {
+		return nil, err
+	}
+	return NewLogFromEntry(le), nil
+}
+
+// openEntry decrypts le if the segment at idx is encrypted.
+func (c *CommitLog) openEntry(idx int, le LogEntry) (LogEntry, error) {
+	c.mu.RLock()
+	h := c.segments[idx].header
+	c.mu.RUnlock()
+	if h.KeyID == "" {
+		return le, nil
+	}
+	if c.keyring == nil {
+		return le, KeyNotFoundError{h.KeyID}
+	}
+	return c.keyring.openEntry(h, le)
+}
+
 func (c *CommitLog) split() error {
-	segment, err := NewSegment(c.path, LogNameFormat, c.NewestOffset(), c.maxSegmentBytes)
+	segment, err := newSegment(c.path, LogNameFormat, c.NewestOffset(), c.maxSegmentBytes, c.segmentHeader())
 	log.With("segment", segment.path).Infoln("new segment created")
 	if err != nil {
 		return err
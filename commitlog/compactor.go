@@ -69,10 +69,11 @@ func (c *namespaceCompactor) compactSegment(offset uint64, wg *sync.WaitGroup, s
 		}
 		entryMap[string(e.Key)] = compactedEntry{e, o}
 	}
-	newSegment, err := NewSegment(c.log.path,
+	cleaned, err := newSegment(c.log.path,
 		cleanNameFormat,
 		segment.BaseOffset,
-		c.log.maxSegmentBytes)
+		c.log.maxSegmentBytes,
+		segment.header)
 	if err != nil {
 		log.Errorf("failed to create cleaned segment, %s", err)
 		return
@@ -87,7 +88,7 @@ func (c *namespaceCompactor) compactSegment(offset uint64, wg *sync.WaitGroup, s
 	for _, em := range entries {
 		l := NewLogFromEntry(em.le)
 		l.PutOffset(int64(em.o))
-		if _, err := newSegment.Write(l); err != nil {
+		if _, err := cleaned.Write(l); err != nil {
 			log.Errorf("failed writing to cleaned segment, %s", err)
 			return
 		}
@@ -96,8 +97,8 @@ func (c *namespaceCompactor) compactSegment(offset uint64, wg *sync.WaitGroup, s
 	if err != nil {
 		log.Infof("unable to get stats for segment, %s", err)
 	}
-	os.Chtimes(newSegment.log.Name(), stat.ModTime(), time.Now())
-	if err := c.log.replaceSegment(newSegment, segment); err != nil {
+	os.Chtimes(cleaned.log.Name(), stat.ModTime(), time.Now())
+	if err := c.log.replaceSegment(cleaned, segment); err != nil {
 		log.Errorf("failed to replace segment, %s", err)
 	}
 	log.With("segment", segment.log.Name()).Infoln("compaction complete")
@@ -0,0 +1,242 @@
+package commitlog
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	nonceLen = 12
+
+	// nonceKeyContext is mixed into the AES key to derive the HMAC key used for
+	// building deterministic nonces when encrypting entry keys.
+	nonceKeyContext = "transporter-commitlog-key-nonce"
+)
+
+var (
+	// ErrEmptyKeyring is returned when a keyring is parsed but contains no keys.
+	ErrEmptyKeyring = errors.New("keyring contains no keys")
+
+	// ErrCiphertextTooShort is returned when an encrypted entry is too small to
+	// contain the nonce.
+	ErrCiphertextTooShort = errors.New("ciphertext too short")
+)
+
+// KeyNotFoundError is returned when a segment was encrypted with a key ID
+// that is not present in the configured Keyring.
+type KeyNotFoundError struct {
+	ID string
+}
+
+func (e KeyNotFoundError) Error() string {
+	return fmt.Sprintf("no encryption key found for key ID (%s)", e.ID)
+}
+
+// InvalidKeyError wraps the underlying reason when a key provided to a Keyring cannot be used.
+type InvalidKeyError struct {
+	ID     string
+	Reason string
+}
+
+func (e InvalidKeyError) Error() string {
+	return fmt.Sprintf("invalid encryption key (%s), %s", e.ID, e.Reason)
+}
+
+type keyringEntry struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// Keyring holds the AES keys used to encrypt and decrypt log entries indexed by key ID.
+// New segments are always encrypted with the active key, older keys are kept so that
+// segments written before a rotation can still be read.
+type Keyring struct {
+	active string
+	keys   map[string]keyringEntry
+}
+
+// NewKeyring creates an empty Keyring.
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]keyringEntry)}
+}
+
+// Add registers the key under the provided ID and makes it the active key. The key must
+// be 16, 24, or 32 bytes to select AES-128, AES-192, or AES-256.
+func (k *Keyring) Add(id string, key []byte) error {
+	if id == "" || len(id) > 255 || strings.ContainsAny(id, ":,\n") {
+		return InvalidKeyError{id, "key ID must be 1-255 characters and cannot contain ':', ',' or newlines"}
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return InvalidKeyError{id, err.Error()}
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return InvalidKeyError{id, err.Error()}
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(nonceKeyContext))
+	k.keys[id] = keyringEntry{aead: aead, nonceKey: mac.Sum(nil)}
+	k.active = id
+	return nil
+}
+
+// Active returns the ID of the key used for encrypting new segments.
+func (k *Keyring) Active() string {
+	return k.active
+}
+
+// ParseKeyring builds a Keyring from a list of ID:KEY pairs where KEY is the base64
+// encoded AES key. Pairs are separated by newlines or commas, blank lines and lines starting
+// with # are ignored. The last key listed becomes the active key so rotating keys
+// only requires appending a new entry.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := NewKeyring()
+	for _, line := range strings.FieldsFunc(s, func(r rune) bool { return r == '\n' || r == ',' }) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, InvalidKeyError{"", "expected ID:KEY"}
+		}
+		id := strings.TrimSpace(parts[0])
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, InvalidKeyError{id, err.Error()}
+		}
+		if err := k.Add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	if len(k.keys) == 0 {
+		return nil, ErrEmptyKeyring
+	}
+	return k, nil
+}
+
+// LoadKeyring reads keys from the provided file and/or the environment variable named
+// by env, keys from the environment variable are added after the file. If both are empty,
+// a nil Keyring is returned meaning no encryption is configured.
+func LoadKeyring(file, env string) (*Keyring, error) {
+	var s string
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	if env != "" {
+		v := os.Getenv(env)
+		if v == "" {
+			return nil, fmt.Errorf("environment variable %s is not set", env)
+		}
+		s += "\n" + v
+	}
+	if s == "" {
+		return nil, nil
+	}
+	return ParseKeyring(s)
+}
+
+func (k *Keyring) entry(id string) (keyringEntry, error) {
+	e, ok := k.keys[id]
+	if !ok {
+		return keyringEntry{}, KeyNotFoundError{id}
+	}
+	return e, nil
+}
+
+// seal encrypts p with a random nonce, the nonce is prepended to the returned ciphertext.
+func (k *Keyring) seal(id string, p []byte) ([]byte, error) {
+	e, err := k.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceLen)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return e.aead.Seal(nonce, nonce, p, nil), nil
+}
+
+// sealDeterministic encrypts p with a nonce derived from the plaintext so equal inputs
+// always produce equal ciphertexts. This is used for entry keys so that compaction can
+// still group entries by key, at the cost of revealing which entries share a key.
+func (k *Keyring) sealDeterministic(id string, p []byte) ([]byte, error) {
+	e, err := k.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, e.nonceKey)
+	mac.Write(p)
+	nonce := mac.Sum(nil)[:nonceLen]
+	return e.aead.Seal(nonce, nonce, p, nil), nil
+}
+
+func (k *Keyring) open(id string, p []byte) ([]byte, error) {
+	e, err := k.entry(id)
+	if err != nil {
+		return nil, err
+	}
+	if len(p) < nonceLen {
+		return nil, ErrCiphertextTooShort
+	}
+	return e.aead.Open(nil, p[:nonceLen], p[nonceLen:], nil)
+}
+
+// sealEntry encrypts the value and update, and optionally the key, of le.
+func (k *Keyring) sealEntry(h SegmentHeader, le LogEntry) (LogEntry, error) {
+	v, err := k.seal(h.KeyID, le.Value)
+	if err != nil {
+		return le, err
+	}
+	le.Value = v
+	if len(le.Update) > 0 {
+		if le.Update, err = k.seal(h.KeyID, le.Update); err != nil {
+			return le, err
+		}
+	}
+	if h.EncryptKeys {
+		key, err := k.sealDeterministic(h.KeyID, le.Key)
+		if err != nil {
+			return le, err
+		}
+		le.Key = key
+	}
+	return le, nil
+}
+
+// openEntry reverses sealEntry.
+func (k *Keyring) openEntry(h SegmentHeader, le LogEntry) (LogEntry, error) {
+	v, err := k.open(h.KeyID, le.Value)
+	if err != nil {
+		return le, err
+	}
+	le.Value = v
+	if len(le.Update) > 0 {
+		if le.Update, err = k.open(h.KeyID, le.Update); err != nil {
+			return le, err
+		}
+	}
+	if h.EncryptKeys {
+		key, err := k.open(h.KeyID, le.Key)
+		if err != nil {
+			return le, err
+		}
+		le.Key = key
+	}
+	return le, nil
+}
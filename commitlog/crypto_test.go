@@ -0,0 +1,299 @@
+package commitlog_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message/ops"
+)
+
+var (
+	key1 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	key2 = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 16))
+)
+
+var parseKeyringTests = []struct {
+	name           string
+	input          string
+	expectedActive string
+	expectedErr    error
+}{
+	{
+		"single",
+		"k1:" + key1,
+		"k1",
+		nil,
+	},
+	{
+		"last_is_active",
+		fmt.Sprintf("# old key\nk1:%s\n\nk2:%s\n", key1, key2),
+		"k2",
+		nil,
+	},
+	{
+		"comma_separated",
+		fmt.Sprintf("k2:%s,k1:%s", key2, key1),
+		"k1",
+		nil,
+	},
+	{
+		"empty",
+		"\n# nothing here\n",
+		"",
+		commitlog.ErrEmptyKeyring,
+	},
+	{
+		"missing_id",
+		key1,
+		"",
+		commitlog.InvalidKeyError{ID: "", Reason: "expected ID:KEY"},
+	},
+	{
+		"bad_key_length",
+		"k1:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"",
+		commitlog.InvalidKeyError{ID: "k1", Reason: "crypto/aes: invalid key size 5"},
+	},
+}
+
+func TestParseKeyring(t *testing.T) {
+	for _, pt := range parseKeyringTests {
+		kr, err := commitlog.ParseKeyring(pt.input)
+		if !reflect.DeepEqual(err, pt.expectedErr) {
+			t.Errorf("[%s] unexpected error, expected %v, got %v", pt.name, pt.expectedErr, err)
+			continue
+		}
+		if err == nil && kr.Active() != pt.expectedActive {
+			t.Errorf("[%s] wrong active key, expected %s, got %s", pt.name, pt.expectedActive, kr.Active())
+		}
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	kr, err := commitlog.LoadKeyring("", "")
+	if err != nil || kr != nil {
+		t.Fatalf("expected nil keyring and error, got %v, %v", kr, err)
+	}
+
+	f, err := ioutil.TempFile("", "keyring")
+	if err != nil {
+		t.Fatalf("unable to create key file, %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("k1:" + key1 + "\n")
+	f.Close()
+
+	os.Setenv("TEST_XLOG_KEYS", "k2:"+key2)
+	defer os.Unsetenv("TEST_XLOG_KEYS")
+	kr, err = commitlog.LoadKeyring(f.Name(), "TEST_XLOG_KEYS")
+	if err != nil {
+		t.Fatalf("unexpected LoadKeyring error, %s", err)
+	}
+	if kr.Active() != "k2" {
+		t.Errorf("wrong active key, expected k2, got %s", kr.Active())
+	}
+
+	if _, err := commitlog.LoadKeyring("", "TEST_XLOG_KEYS_MISSING"); err == nil {
+		t.Error("expected error for unset environment variable but didn't receive one")
+	}
+}
+
+func appendEntries(t *testing.T, l *commitlog.CommitLog, start, count int) {
+	for i := start; i < start+count; i++ {
+		_, err := l.Append(commitlog.NewLogFromEntry(commitlog.LogEntry{
+			Key:       []byte("secret_ns"),
+			Value:     []byte(fmt.Sprintf(`{"secret":%d}`, i)),
+			Timestamp: uint64(i),
+			Op:        ops.Insert,
+			Mode:      commitlog.Sync,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected Append error, %s", err)
+		}
+	}
+}
+
+func readEntries(l *commitlog.CommitLog) ([]commitlog.LogEntry, error) {
+	r, err := l.NewReader(-1)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]commitlog.LogEntry, 0)
+	for {
+		_, e, err := commitlog.ReadEntry(r)
+		if err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
+
+func TestEncryptedCommitLog(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("encryptedtest%d", rand.Int63()))
+	defer cleanup(path, t)
+
+	// start with a plaintext entry to make sure existing logs can switch to encryption
+	l, err := commitlog.New(commitlog.WithPath(path))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	appendEntries(t, l, 0, 1)
+	l.Close()
+
+	kr, _ := commitlog.ParseKeyring("k1:" + key1)
+	l, err = commitlog.New(commitlog.WithPath(path), commitlog.WithEncryption(kr, true))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	appendEntries(t, l, 1, 5)
+	l.Close()
+
+	// rotate to a new key while keeping the old one around for reading
+	kr, _ = commitlog.ParseKeyring(fmt.Sprintf("k1:%s\nk2:%s", key1, key2))
+	l, err = commitlog.New(commitlog.WithPath(path), commitlog.WithEncryption(kr, false))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	appendEntries(t, l, 6, 5)
+
+	expectedKeyIDs := []string{"", "k1", "k2"}
+	segments := l.Segments()
+	if len(segments) != len(expectedKeyIDs) {
+		t.Fatalf("wrong number of segments, expected %d, got %d", len(expectedKeyIDs), len(segments))
+	}
+	for i, s := range segments {
+		if s.KeyID() != expectedKeyIDs[i] {
+			t.Errorf("wrong KeyID for segment %d, expected %s, got %s", i, expectedKeyIDs[i], s.KeyID())
+		}
+	}
+	if l.NewestOffset() != 11 {
+		t.Errorf("wrong NewestOffset, expected 11, got %d", l.NewestOffset())
+	}
+
+	for _, s := range segments[1:] {
+		b, _ := ioutil.ReadFile(filepath.Join(path, fmt.Sprintf(commitlog.LogNameFormat, s.BaseOffset)))
+		if bytes.Contains(b, []byte(`{"secret"`)) {
+			t.Errorf("segment %d contains plaintext", s.BaseOffset)
+		}
+	}
+
+	entries, err := readEntries(l)
+	if err != nil {
+		t.Fatalf("unexpected read error, %s", err)
+	}
+	if len(entries) != 11 {
+		t.Fatalf("wrong number of entries, expected 11, got %d", len(entries))
+	}
+	for i, e := range entries {
+		expected := commitlog.LogEntry{
+			Key:       []byte("secret_ns"),
+			Value:     []byte(fmt.Sprintf(`{"secret":%d}`, i)),
+			Timestamp: uint64(i),
+			Op:        ops.Insert,
+			Mode:      commitlog.Sync,
+		}
+		if !reflect.DeepEqual(e, expected) {
+			t.Errorf("wrong entry %d, expected %+v, got %+v", i, expected, e)
+		}
+	}
+
+	r, err := l.NewReader(8)
+	if err != nil {
+		t.Fatalf("unexpected NewReader error, %s", err)
+	}
+	if _, e, err := commitlog.ReadEntry(r); err != nil || string(e.Value) != `{"secret":8}` {
+		t.Errorf("wrong entry at offset 8, %s, %s", e.Value, err)
+	}
+	l.Close()
+
+	l, err = commitlog.New(commitlog.WithPath(path))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer l.Close()
+	if _, err := readEntries(l); !reflect.DeepEqual(err, commitlog.KeyNotFoundError{ID: "k1"}) {
+		t.Errorf("expected KeyNotFoundError reading without keys, got %v", err)
+	}
+}
+
+func TestCompactEncrypted(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("encryptedcompacttest%d", rand.Int63()))
+	defer cleanup(path, t)
+
+	kr, _ := commitlog.ParseKeyring("k1:" + key1)
+	l, err := commitlog.New(
+		commitlog.WithPath(path),
+		commitlog.WithMaxSegmentBytes(200),
+		commitlog.WithEncryption(kr, true),
+	)
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer l.Close()
+	appendEntries(t, l, 0, 10)
+
+	commitlog.NewNamespaceCompactor(l).Compact(uint64(l.NewestOffset()), l.Segments()[:len(l.Segments())-1])
+
+	entries, err := readEntries(l)
+	if err != nil {
+		t.Fatalf("unexpected read error, %s", err)
+	}
+	// every compacted segment keeps a single entry, the active segment is untouched
+	segments := l.Segments()
+	expected := len(segments) - 1 + int(l.NewestOffset()-segments[len(segments)-1].BaseOffset)
+	if len(entries) != expected {
+		t.Errorf("wrong number of entries after compaction, expected %d, got %d", expected, len(entries))
+	}
+	for _, e := range entries {
+		if string(e.Key) != "secret_ns" {
+			t.Errorf("wrong key after compaction, got %s", e.Key)
+		}
+	}
+}
+
+func TestEncryptedUpdate(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("encryptedupdatetest%d", rand.Int63()))
+	defer cleanup(path, t)
+
+	kr, _ := commitlog.ParseKeyring("k1:" + key1)
+	l, err := commitlog.New(commitlog.WithPath(path), commitlog.WithEncryption(kr, false))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer l.Close()
+	le := commitlog.LogEntry{
+		Key:       []byte("secret_ns"),
+		Value:     []byte(`{"_id":1}`),
+		Timestamp: 1,
+		Op:        ops.Update,
+		Mode:      commitlog.Sync,
+		Position:  []byte("0/1A"),
+		Update:    []byte(`{"$set":{"secret":1}}`),
+	}
+	if _, err := l.Append(commitlog.NewLogFromEntry(le)); err != nil {
+		t.Fatalf("unexpected Append error, %s", err)
+	}
+
+	b, _ := ioutil.ReadFile(filepath.Join(path, fmt.Sprintf(commitlog.LogNameFormat, 0)))
+	if bytes.Contains(b, []byte(`"secret"`)) {
+		t.Error("segment contains the plaintext update")
+	}
+	entries, err := readEntries(l)
+	if err != nil {
+		t.Fatalf("unexpected read error, %s", err)
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0], le) {
+		t.Errorf("wrong entries, expected %+v, got %+v", le, entries)
+	}
+}
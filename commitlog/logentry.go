@@ -1,7 +1,9 @@
 package commitlog
 
 import (
+	"fmt"
 	"io"
+	"strings"
 
 	"github.com/compose/transporter/message/ops"
 )
@@ -13,9 +15,13 @@ const (
 	attrPos           = 20
 	logEntryHeaderLen = 21
 
-	modeMask = 3
-	opMask   = 28
-	opShift  = 2
+	modeMask      = 3
+	opMask        = 28
+	opShift       = 2
+	encodingMask  = 32
+	encodingShift = 5
+	positionMask  = 64
+	updateMask    = 128
 )
 
 // LogEntry represents the high level representation of the message portion of each entry in the commit log.
@@ -25,46 +31,91 @@ type LogEntry struct {
 	Timestamp uint64
 	Mode      Mode
 	Op        ops.Op
+	Encoding  Encoding
+	// Position is the source specific position of the entry, such as a Postgres LSN, which a
+	// reader can continue from after a restart. It is stored after the value and never encrypted.
+	Position []byte
+	// Update holds the encoded update operators the entry applies to the document, see
+	// message.WithUpdate. It is stored after the Position and encrypted along with the value.
+	Update []byte
 }
 
-// ModeOpToByte converts the Mode and Op values into a single byte by performing bitwise operations.
+// ModeOpToByte converts the Mode, Op, and Encoding values into a single byte by performing bitwise operations.
 // Mode is stored in bits 0 - 1
 // Op is stored in bits 2 - 4
-// bits 5 - 7 are currently unused
+// Encoding is stored in bit 5
+// bit 6 is set when the entry has a Position
+// bit 7 is set when the entry has an Update
 func (le LogEntry) ModeOpToByte() byte {
-	return byte(int(le.Mode) | (int(le.Op) << opShift))
+	b := byte(int(le.Mode) | (int(le.Op) << opShift) | (int(le.Encoding) << encodingShift))
+	if len(le.Position) > 0 {
+		b |= positionMask
+	}
+	if len(le.Update) > 0 {
+		b |= updateMask
+	}
+	return b
+}
+
+// entryOpener is implemented by readers capable of decrypting the entries they return.
+type entryOpener interface {
+	openEntry(LogEntry) (LogEntry, error)
 }
 
-// ReadEntry takes an io.Reader and returns a LogEntry.
+// ReadEntry takes an io.Reader and returns a LogEntry. When r is a Reader created from an
+// encrypted CommitLog, the returned LogEntry is decrypted.
 func ReadEntry(r io.Reader) (uint64, LogEntry, error) {
 	header := make([]byte, logEntryHeaderLen)
 	if _, err := r.Read(header); err != nil {
 		return 0, LogEntry{}, err
 	}
-	k, v, err := readKeyValue(encoding.Uint32(header[sizePos:tsPos]), r)
+	k, v, p, u, err := readKeyValue(encoding.Uint32(header[sizePos:tsPos]), header[attrPos], r)
 	if err != nil {
 		return 0, LogEntry{}, err
 	}
 	l := LogEntry{
 		Key:       k,
 		Value:     v,
+		Position:  p,
+		Update:    u,
 		Timestamp: encoding.Uint64(header[tsPos:attrPos]),
 		Mode:      modeFromBytes(header),
 		Op:        opFromBytes(header),
+		Encoding:  encodingFromBytes(header),
+	}
+	if o, ok := r.(entryOpener); ok {
+		if l, err = o.openEntry(l); err != nil {
+			return 0, LogEntry{}, err
+		}
 	}
 	return encoding.Uint64(header[offsetPos:sizePos]), l, nil
 }
 
-// readKeyValue returns the key and value stored given the size and io.Reader.
-func readKeyValue(size uint32, r io.Reader) ([]byte, []byte, error) {
+// readKeyValue returns the key, value and, when flagged in attr, the position and update
+// stored given the size and io.Reader.
+func readKeyValue(size uint32, attr byte, r io.Reader) ([]byte, []byte, []byte, []byte, error) {
 	kvBytes := make([]byte, size)
 	if _, err := r.Read(kvBytes); err != nil {
-		return nil, nil, err
+		return nil, nil, nil, nil, err
 	}
 	keyLen := encoding.Uint32(kvBytes[0:4])
 	// we can grab the key from keyLen and the we know the value is stored
 	// after the keyLen + 8 (4 byte size of key and value)
-	return kvBytes[4 : keyLen+4], kvBytes[keyLen+8:], nil
+	if attr&(positionMask|updateMask) == 0 {
+		return kvBytes[4 : keyLen+4], kvBytes[keyLen+8:], nil, nil, nil
+	}
+	valEnd := keyLen + 8 + encoding.Uint32(kvBytes[keyLen+4:keyLen+8])
+	// the position and then the update follow the value, each prefixed by its 4 byte size
+	var position, update []byte
+	rest := kvBytes[valEnd:]
+	if attr&positionMask != 0 {
+		n := encoding.Uint32(rest[0:4])
+		position, rest = rest[4:4+n], rest[4+n:]
+	}
+	if attr&updateMask != 0 {
+		update = rest[4 : 4+encoding.Uint32(rest[0:4])]
+	}
+	return kvBytes[4 : keyLen+4], kvBytes[keyLen+8 : valEnd], position, update, nil
 }
 
 func modeFromBytes(b []byte) Mode {
@@ -73,6 +124,9 @@ func modeFromBytes(b []byte) Mode {
 func opFromBytes(b []byte) ops.Op {
 	return ops.Op(b[attrPos] & opMask >> opShift)
 }
+func encodingFromBytes(b []byte) Encoding {
+	return Encoding(b[attrPos] & encodingMask >> encodingShift)
+}
 
 // Mode is a representation of where a in the process a reader is with respect to a given namespace.
 type Mode int
@@ -96,11 +150,47 @@ func (m Mode) String() string {
 	return "UNKNOWN"
 }
 
+// ModeFromString returns the Mode represented by s, the inverse of Mode.String.
+func ModeFromString(s string) (Mode, error) {
+	for _, m := range []Mode{Copy, Sync, Complete} {
+		if strings.EqualFold(m.String(), s) {
+			return m, nil
+		}
+	}
+	return Copy, fmt.Errorf("unknown mode %s", s)
+}
+
+// Encoding describes how the Value of a LogEntry was serialized.
+type Encoding int
+
+// JSONEncoding was used by all entries prior to BinaryEncoding and stores values as extended JSON,
+// BinaryEncoding stores values with data.Data.MarshalBinary which preserves the Go type of every value.
+const (
+	JSONEncoding Encoding = iota
+	BinaryEncoding
+)
+
+func (e Encoding) String() string {
+	switch e {
+	case JSONEncoding:
+		return "JSON"
+	case BinaryEncoding:
+		return "BINARY"
+	}
+	return "UNKNOWN"
+}
+
 // NewLogFromEntry takes the LogEntry and builds the underlying []byte to be stored.
 func NewLogFromEntry(le LogEntry) Log {
 	keyLen := len(le.Key)
 	valLen := len(le.Value)
 	kvLen := keyLen + valLen + 8
+	if len(le.Position) > 0 {
+		kvLen += len(le.Position) + 4
+	}
+	if len(le.Update) > 0 {
+		kvLen += len(le.Update) + 4
+	}
 	l := make([]byte, logEntryHeaderLen+kvLen)
 
 	encoding.PutUint64(l[tsPos:attrPos], le.Timestamp)
@@ -114,6 +204,17 @@ func NewLogFromEntry(le LogEntry) Log {
 	encoding.PutUint32(l[kvPosition+keyLen:kvPosition+keyLen+4], uint32(valLen))
 	copy(l[kvPosition+keyLen+4:], le.Value)
 
+	next := kvPosition + keyLen + 4 + valLen
+	if len(le.Position) > 0 {
+		encoding.PutUint32(l[next:next+4], uint32(len(le.Position)))
+		copy(l[next+4:], le.Position)
+		next += 4 + len(le.Position)
+	}
+	if len(le.Update) > 0 {
+		encoding.PutUint32(l[next:next+4], uint32(len(le.Update)))
+		copy(l[next+4:], le.Update)
+	}
+
 	encoding.PutUint32(l[sizePos:tsPos], uint32(kvLen))
 	return l
 }
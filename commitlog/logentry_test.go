@@ -137,6 +137,100 @@ var (
 				118, 97, 108, 117, 101, // value
 			},
 		},
+		{
+			"with_binary_encoding",
+			0,
+			commitlog.LogEntry{
+				Key:       []byte(`key`),
+				Value:     []byte(`value`),
+				Timestamp: uint64(1491252302),
+				Mode:      commitlog.Sync,
+				Op:        ops.Update,
+				Encoding:  commitlog.BinaryEncoding,
+			},
+			commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 16, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				37,         // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+			},
+		},
+		{
+			"with_position",
+			0,
+			commitlog.LogEntry{
+				Key:       []byte(`key`),
+				Value:     []byte(`value`),
+				Timestamp: uint64(1491252302),
+				Mode:      commitlog.Sync,
+				Position:  []byte(`0/1A`),
+			},
+			commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 24, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				65,         // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+				0, 0, 0, 4, // position length
+				48, 47, 49, 65, // position
+			},
+		},
+		{
+			"with_position_and_update",
+			0,
+			commitlog.LogEntry{
+				Key:       []byte(`key`),
+				Value:     []byte(`value`),
+				Timestamp: uint64(1491252302),
+				Mode:      commitlog.Sync,
+				Position:  []byte(`0/1A`),
+				Update:    []byte(`set`),
+			},
+			commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 31, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				193,        // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+				0, 0, 0, 4, // position length
+				48, 47, 49, 65, // position
+				0, 0, 0, 3, // update length
+				115, 101, 116, // update
+			},
+		},
+		{
+			"with_update",
+			0,
+			commitlog.LogEntry{
+				Key:       []byte(`key`),
+				Value:     []byte(`value`),
+				Timestamp: uint64(1491252302),
+				Mode:      commitlog.Sync,
+				Update:    []byte(`set`),
+			},
+			commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 23, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				129,        // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+				0, 0, 0, 3, // update length
+				115, 101, 116, // update
+			},
+		},
 	}
 )
 
@@ -309,6 +403,84 @@ var (
 			},
 			nil,
 		},
+		{
+			"with_position",
+			bytes.NewBuffer(commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 24, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				65,         // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+				0, 0, 0, 4, // position length
+				48, 47, 49, 65, // position
+			}),
+			0,
+			commitlog.LogEntry{
+				Key:       []byte("key"),
+				Value:     []byte("value"),
+				Mode:      commitlog.Sync,
+				Op:        ops.Insert,
+				Timestamp: 1491252302,
+				Position:  []byte("0/1A"),
+			},
+			nil,
+		},
+		{
+			"with_position_and_update",
+			bytes.NewBuffer(commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 31, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				197,        // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+				0, 0, 0, 4, // position length
+				48, 47, 49, 65, // position
+				0, 0, 0, 3, // update length
+				115, 101, 116, // update
+			}),
+			0,
+			commitlog.LogEntry{
+				Key:       []byte("key"),
+				Value:     []byte("value"),
+				Mode:      commitlog.Sync,
+				Op:        ops.Update,
+				Timestamp: 1491252302,
+				Position:  []byte("0/1A"),
+				Update:    []byte("set"),
+			},
+			nil,
+		},
+		{
+			"with_update",
+			bytes.NewBuffer(commitlog.Log{
+				0, 0, 0, 0, 0, 0, 0, 0, // offset
+				0, 0, 0, 23, // size
+				0, 0, 0, 0, 88, 226, 180, 78, // timestamp
+				133,        // mode
+				0, 0, 0, 3, // key length
+				107, 101, 121, // key
+				0, 0, 0, 5, // value length
+				118, 97, 108, 117, 101, // value
+				0, 0, 0, 3, // update length
+				115, 101, 116, // update
+			}),
+			0,
+			commitlog.LogEntry{
+				Key:       []byte("key"),
+				Value:     []byte("value"),
+				Mode:      commitlog.Sync,
+				Op:        ops.Update,
+				Timestamp: 1491252302,
+				Update:    []byte("set"),
+			},
+			nil,
+		},
 		{
 			"with_err",
 			bytes.NewBuffer(commitlog.Log{
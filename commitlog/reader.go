@@ -5,6 +5,10 @@ import (
 	"sync"
 )
 
+var (
+	_ io.Reader = &Reader{}
+)
+
 // Reader implements io.Reader for use with reading from the commit log.
 type Reader struct {
 	commitlog *CommitLog
@@ -49,3 +53,31 @@ func (r *Reader) Read(p []byte) (int, error) {
 
 	return n, err
 }
+
+// openEntry decrypts the entry most recently read, entries never span segments so the
+// current segment is the one the entry was read from.
+func (r *Reader) openEntry(le LogEntry) (LogEntry, error) {
+	r.mu.Lock()
+	idx := r.idx
+	r.mu.Unlock()
+	return r.commitlog.openEntry(idx, le)
+}
+
+// NextEntry reads the next LogEntry from the Reader. If the entry cannot be completely read,
+// such as when it is still being written, the Reader is left where it was so the read can be
+// retried later.
+func (r *Reader) NextEntry() (uint64, LogEntry, error) {
+	r.mu.Lock()
+	idx, position := r.idx, r.position
+	r.mu.Unlock()
+	o, e, err := ReadEntry(r)
+	if err != nil {
+		r.mu.Lock()
+		r.idx, r.position = idx, position
+		r.mu.Unlock()
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+	}
+	return o, e, err
+}
@@ -1,7 +1,11 @@
 package commitlog_test
 
 import (
+	"fmt"
 	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/compose/transporter/commitlog"
@@ -77,3 +81,111 @@ func TestRead(t *testing.T) {
 		}
 	}
 }
+
+var newReaderFromTests = []struct {
+	name           string
+	offset         int64
+	expectedOffset uint64
+	expectedErr    error
+}{
+	{"open_start", -1, 0, nil},
+	{"first_segment", 2, 2, nil},
+	{"segment_base_offset", 3, 3, nil},
+	{"last_entry", 9, 9, nil},
+	{"end_of_log", 10, 0, io.EOF},
+	{"beyond_end_of_log", 100, 0, io.EOF},
+}
+
+func TestNewReaderFrom(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("readerfromtest%d", rand.Int63()))
+	defer cleanup(path, t)
+	l, err := commitlog.New(commitlog.WithPath(path), commitlog.WithMaxSegmentBytes(100))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer l.Close()
+	appendEntries(t, l, 0, 10)
+	if len(l.Segments()) < 2 {
+		t.Fatalf("expected multiple segments, got %d", len(l.Segments()))
+	}
+
+	for _, rt := range newReaderFromTests {
+		r, err := l.NewReaderFrom(rt.offset)
+		if err != nil {
+			t.Fatalf("[%s] unexpected NewReaderFrom error, %s", rt.name, err)
+		}
+		offset, _, err := r.NextEntry()
+		if err != rt.expectedErr {
+			t.Errorf("[%s] wrong error, expected %v, got %v", rt.name, rt.expectedErr, err)
+			continue
+		}
+		if err == nil && offset != rt.expectedOffset {
+			t.Errorf("[%s] wrong offset, expected %d, got %d", rt.name, rt.expectedOffset, offset)
+		}
+	}
+}
+
+func TestFollow(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("followtest%d", rand.Int63()))
+	defer cleanup(path, t)
+	w, err := commitlog.New(commitlog.WithPath(path), commitlog.WithMaxSegmentBytes(100))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer w.Close()
+	appendEntries(t, w, 0, 1)
+
+	l, err := commitlog.New(commitlog.WithPath(path))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer l.Close()
+	r, err := l.NewReaderFrom(-1)
+	if err != nil {
+		t.Fatalf("unexpected NewReaderFrom error, %s", err)
+	}
+	if o, _, err := r.NextEntry(); err != nil || o != 0 {
+		t.Fatalf("expected offset 0, got %d, %v", o, err)
+	}
+	if _, _, err := r.NextEntry(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	appendEntries(t, w, 1, 10)
+	if err := l.Reload(); err != nil {
+		t.Fatalf("unexpected Reload error, %s", err)
+	}
+	if len(l.Segments()) != len(w.Segments()) {
+		t.Errorf("wrong number of segments after Reload, expected %d, got %d", len(w.Segments()), len(l.Segments()))
+	}
+	var count int
+	for {
+		_, _, err := r.NextEntry()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("unexpected NextEntry error, %s", err)
+		}
+		count++
+	}
+	if count != 10 {
+		t.Errorf("wrong number of entries after Reload, expected 10, got %d", count)
+	}
+
+	// write half of an entry directly to the active segment to simulate an in progress write
+	b := commitlog.NewLogFromEntry(commitlog.LogEntry{Key: []byte("partial"), Value: []byte(`{}`)})
+	b.PutOffset(w.NewestOffset())
+	f, err := os.OpenFile(filepath.Join(path, fmt.Sprintf(commitlog.LogNameFormat, w.Segments()[len(w.Segments())-1].BaseOffset)), os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatalf("unable to open segment, %s", err)
+	}
+	defer f.Close()
+	f.Write(b[:len(b)/2])
+	if _, _, err := r.NextEntry(); err != io.EOF {
+		t.Fatalf("expected io.EOF for partial entry, got %v", err)
+	}
+	f.Write(b[len(b)/2:])
+	if o, e, err := r.NextEntry(); err != nil || o != uint64(w.NewestOffset()) || string(e.Key) != "partial" {
+		t.Fatalf("expected partial entry at offset %d, got %d, %s, %v", w.NewestOffset(), o, e.Key, err)
+	}
+}
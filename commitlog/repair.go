@@ -0,0 +1,59 @@
+package commitlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Repair truncates any partially written entry from the end of the segments in path, such as
+// one left behind when a segment is copied while it is being appended to. The number of bytes
+// removed is returned.
+func Repair(path string) (int64, error) {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), logFileSuffix) {
+			continue
+		}
+		n, err := repairSegment(filepath.Join(path, file.Name()), file.Size())
+		if err != nil {
+			return removed, err
+		}
+		removed += n
+	}
+	return removed, nil
+}
+
+func repairSegment(name string, size int64) (int64, error) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	s := &Segment{log: f}
+	if err := s.readHeader(SegmentHeader{}); err != nil {
+		return 0, err
+	}
+	position := s.headerLen
+	header := make([]byte, logEntryHeaderLen)
+	for position+logEntryHeaderLen <= size {
+		if _, err := f.ReadAt(header, position); err != nil {
+			return 0, err
+		}
+		next := position + logEntryHeaderLen + int64(encoding.Uint32(header[sizePos:tsPos]))
+		if next > size {
+			break
+		}
+		position = next
+	}
+	if position >= size {
+		return 0, nil
+	}
+	return size - position, f.Truncate(position)
+}
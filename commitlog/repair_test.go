@@ -0,0 +1,54 @@
+package commitlog_test
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/compose/transporter/commitlog"
+)
+
+func TestRepair(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("repairtest%d", rand.Int63()))
+	defer cleanup(path, t)
+	l, err := commitlog.New(commitlog.WithPath(path), commitlog.WithMaxSegmentBytes(100))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	appendEntries(t, l, 0, 5)
+	segments := l.Segments()
+	active := filepath.Join(path, fmt.Sprintf(commitlog.LogNameFormat, segments[len(segments)-1].BaseOffset))
+	l.Close()
+
+	if removed, err := commitlog.Repair(path); err != nil || removed != 0 {
+		t.Fatalf("expected nothing to repair, got %d, %v", removed, err)
+	}
+
+	b := commitlog.NewLogFromEntry(commitlog.LogEntry{Key: []byte("partial"), Value: []byte(`{}`)})
+	b.PutOffset(5)
+	f, err := os.OpenFile(active, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatalf("unable to open segment, %s", err)
+	}
+	f.Write(b[:len(b)-3])
+	f.Close()
+
+	removed, err := commitlog.Repair(path)
+	if err != nil {
+		t.Fatalf("unexpected Repair error, %s", err)
+	}
+	if removed != int64(len(b)-3) {
+		t.Errorf("wrong number of bytes removed, expected %d, got %d", len(b)-3, removed)
+	}
+
+	l, err = commitlog.New(commitlog.WithPath(path), commitlog.WithMaxSegmentBytes(100))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer l.Close()
+	if l.NewestOffset() != 5 {
+		t.Errorf("wrong NewestOffset after Repair, expected 5, got %d", l.NewestOffset())
+	}
+}
@@ -26,8 +26,37 @@ const (
 var (
 	// ErrOffsetNotFound is returned when the requested offset is not in the segment.
 	ErrOffsetNotFound = errors.New("offset not found")
+
+	// segmentMagic marks the start of a segment header, unencrypted segments have no header
+	// and begin directly with the first entry. An entry would need an offset greater than
+	// 6e18 for its first bytes to collide with the magic.
+	segmentMagic = []byte("TXLE")
+)
+
+const (
+	// segment header layout is magic (4 bytes), flags (1 byte), key ID length (1 byte)
+	// followed by the key ID
+	segmentHeaderFixedLen = 6
+	flagEncryptKeys       = 1
 )
 
+// SegmentHeader describes the encryption settings stored at the start of an encrypted segment.
+type SegmentHeader struct {
+	KeyID       string
+	EncryptKeys bool
+}
+
+func (h SegmentHeader) bytes() []byte {
+	b := make([]byte, segmentHeaderFixedLen+len(h.KeyID))
+	copy(b, segmentMagic)
+	if h.EncryptKeys {
+		b[4] = flagEncryptKeys
+	}
+	b[5] = byte(len(h.KeyID))
+	copy(b[segmentHeaderFixedLen:], h.KeyID)
+	return b
+}
+
 // Segment handles reading and writing to the underlying files on disk.
 type Segment struct {
 	writer   io.Writer
@@ -36,6 +65,9 @@ type Segment struct {
 	path     string
 	maxBytes int64
 
+	header    SegmentHeader
+	headerLen int64
+
 	BaseOffset int64
 	NextOffset int64
 	Position   int64
@@ -46,6 +78,13 @@ type Segment struct {
 // NewSegment creates a new instance of Segment with the provided parameters
 // and initializes its NextOffset and Position should the file be non-empty.
 func NewSegment(path, format string, baseOffset int64, maxBytes int64) (*Segment, error) {
+	return newSegment(path, format, baseOffset, maxBytes, SegmentHeader{})
+}
+
+// newSegment behaves like NewSegment but writes the provided header when the segment file
+// is empty and the header has a KeyID. Existing files always keep the header they were
+// created with.
+func newSegment(path, format string, baseOffset int64, maxBytes int64, header SegmentHeader) (*Segment, error) {
 	logPath := filepath.Join(path, fmt.Sprintf(format, baseOffset))
 	log, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
 	if err != nil {
@@ -62,6 +101,11 @@ func NewSegment(path, format string, baseOffset int64, maxBytes int64) (*Segment
 		NextOffset: baseOffset,
 	}
 
+	if err := s.readHeader(header); err != nil {
+		log.Close()
+		return nil, err
+	}
+
 	err = s.init()
 	if err == io.EOF {
 		return s, nil
@@ -70,8 +114,47 @@ func NewSegment(path, format string, baseOffset int64, maxBytes int64) (*Segment
 	return s, err
 }
 
+// readHeader loads the header from the segment file or writes the provided one if the
+// file is empty.
+func (s *Segment) readHeader(header SegmentHeader) error {
+	stat, err := s.log.Stat()
+	if err != nil {
+		return err
+	}
+	if stat.Size() == 0 {
+		if header.KeyID == "" {
+			return nil
+		}
+		if _, err := s.log.Write(header.bytes()); err != nil {
+			return err
+		}
+		s.header = header
+		s.headerLen = int64(segmentHeaderFixedLen + len(header.KeyID))
+		return nil
+	}
+
+	b := make([]byte, segmentHeaderFixedLen)
+	if _, err := s.log.ReadAt(b, 0); err != nil || !bytes.Equal(b[:4], segmentMagic) {
+		// too short or no magic means a plaintext segment
+		return nil
+	}
+	id := make([]byte, b[5])
+	if _, err := s.log.ReadAt(id, segmentHeaderFixedLen); err != nil {
+		return err
+	}
+	s.header = SegmentHeader{KeyID: string(id), EncryptKeys: b[4]&flagEncryptKeys != 0}
+	s.headerLen = int64(segmentHeaderFixedLen + len(id))
+	return nil
+}
+
+// KeyID returns the ID of the key the segment entries were encrypted with, it is empty
+// for unencrypted segments.
+func (s *Segment) KeyID() string {
+	return s.header.KeyID
+}
+
 func (s *Segment) init() error {
-	if _, err := s.log.Seek(0, 0); err != nil {
+	if _, err := s.log.Seek(s.headerLen, 0); err != nil {
 		return err
 	}
 
@@ -140,11 +223,11 @@ func (s *Segment) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
-// ReadAt calls ReadAt on the underlying log.
+// ReadAt calls ReadAt on the underlying log, off is relative to the end of the segment header.
 func (s *Segment) ReadAt(p []byte, off int64) (n int, err error) {
 	s.Lock()
 	defer s.Unlock()
-	return s.log.ReadAt(p, off)
+	return s.log.ReadAt(p, off+s.headerLen)
 }
 
 // func (s *Segment) Open() error {
@@ -170,7 +253,15 @@ func (s *Segment) Close() error {
 // FindOffsetPosition attempts to find the provided offset position in the
 // Segment.
 func (s *Segment) FindOffsetPosition(offset uint64) (int64, error) {
-	if _, err := s.log.Seek(0, 0); err != nil {
+	return s.findPosition(offset, true)
+}
+
+// findPosition returns the position of the entry with the provided offset. When exact is
+// false, the position of the first entry with a greater offset is returned if the offset
+// is missing (i.e. it was removed by compaction) and the end of the segment is returned
+// when every entry is older than the offset.
+func (s *Segment) findPosition(offset uint64, exact bool) (int64, error) {
+	if _, err := s.log.Seek(s.headerLen, 0); err != nil {
 		return 0, err
 	}
 
@@ -180,6 +271,9 @@ func (s *Segment) FindOffsetPosition(offset uint64) (int64, error) {
 		// get offset and size
 		_, err := io.CopyN(b, s.log, 8)
 		if err != nil {
+			if !exact {
+				return position, nil
+			}
 			return position, ErrOffsetNotFound
 		}
 		o := encoding.Uint64(b.Bytes()[offsetPos:8])
@@ -190,7 +284,7 @@ func (s *Segment) FindOffsetPosition(offset uint64) (int64, error) {
 		}
 		size := int64(encoding.Uint32(b.Bytes()[sizePos:12]))
 
-		if offset == o {
+		if offset == o || (!exact && o > offset) {
 			log.With("position", position).With("offset", o).Infoln("found offset position")
 			return position, nil
 		}
@@ -0,0 +1,612 @@
+package data
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// binaryVersion is the first byte of every encoded Data and allows the format to evolve.
+const binaryVersion byte = 1
+
+// each encoded value is prefixed by one of the following tags so it can be decoded
+// back into the same Go type.
+const (
+	tagNil byte = iota
+	tagBool
+	tagInt
+	tagInt8
+	tagInt16
+	tagInt32
+	tagInt64
+	tagUint
+	tagUint8
+	tagUint16
+	tagUint32
+	tagUint64
+	tagFloat32
+	tagFloat64
+	tagString
+	tagBytes
+	tagTime
+	tagMap
+	tagBSONM
+	tagData
+	tagBSOND
+	tagSlice
+	tagStringSlice
+	tagInt64Slice
+	tagFloat64Slice
+	tagMapSlice
+	tagBSONMSlice
+	tagBSONBinary
+	tagBSON
+	tagIntSlice
+	tagStringMap
+)
+
+var (
+	// ErrUnknownBinaryVersion is returned when decoding data written with an unsupported version.
+	ErrUnknownBinaryVersion = errors.New("unknown binary encoding version")
+)
+
+// UnknownTagError is returned when an encoded value contains an unsupported type tag.
+type UnknownTagError struct {
+	Tag byte
+}
+
+func (e UnknownTagError) Error() string {
+	return fmt.Sprintf("unknown binary encoding tag, %d", e.Tag)
+}
+
+// UnsupportedTypeError is returned when a value cannot be encoded without changing its type.
+type UnsupportedTypeError struct {
+	Type string
+}
+
+func (e UnsupportedTypeError) Error() string {
+	return fmt.Sprintf("unsupported type for binary encoding, %s", e.Type)
+}
+
+// MarshalBinary encodes d into a tagged binary format which, unlike JSON, retains the Go
+// type of every value so that UnmarshalBinary returns an identical Data. Native Go types,
+// time.Time and the BSON types produced by mgo are preserved, any other type results in an
+// UnsupportedTypeError.
+func (d Data) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(binaryVersion)
+	if err := encodeMap(&buf, map[string]interface{}(d)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary decodes data produced by MarshalBinary into d.
+func (d *Data) UnmarshalBinary(b []byte) error {
+	r := bytes.NewReader(b)
+	v, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if v != binaryVersion {
+		return ErrUnknownBinaryVersion
+	}
+	m, err := decodeMap(r)
+	if err != nil {
+		return err
+	}
+	*d = Data(m)
+	return nil
+}
+
+func encodeUvarint(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func encodeVarint(buf *bytes.Buffer, v int64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutVarint(b, v)])
+}
+
+func encodeBytes(buf *bytes.Buffer, b []byte) {
+	encodeUvarint(buf, uint64(len(b)))
+	buf.Write(b)
+}
+
+func encodeMap(buf *bytes.Buffer, m map[string]interface{}) error {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	// sorting keeps the output deterministic
+	sort.Strings(keys)
+	encodeUvarint(buf, uint64(len(keys)))
+	for _, k := range keys {
+		encodeBytes(buf, []byte(k))
+		if err := encodeValue(buf, m[k]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func encodeValue(buf *bytes.Buffer, v interface{}) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(tagNil)
+	case bool:
+		buf.WriteByte(tagBool)
+		if v {
+			buf.WriteByte(1)
+		} else {
+			buf.WriteByte(0)
+		}
+	case int:
+		buf.WriteByte(tagInt)
+		encodeVarint(buf, int64(v))
+	case int8:
+		buf.WriteByte(tagInt8)
+		encodeVarint(buf, int64(v))
+	case int16:
+		buf.WriteByte(tagInt16)
+		encodeVarint(buf, int64(v))
+	case int32:
+		buf.WriteByte(tagInt32)
+		encodeVarint(buf, int64(v))
+	case int64:
+		buf.WriteByte(tagInt64)
+		encodeVarint(buf, v)
+	case uint:
+		buf.WriteByte(tagUint)
+		encodeUvarint(buf, uint64(v))
+	case uint8:
+		buf.WriteByte(tagUint8)
+		encodeUvarint(buf, uint64(v))
+	case uint16:
+		buf.WriteByte(tagUint16)
+		encodeUvarint(buf, uint64(v))
+	case uint32:
+		buf.WriteByte(tagUint32)
+		encodeUvarint(buf, uint64(v))
+	case uint64:
+		buf.WriteByte(tagUint64)
+		encodeUvarint(buf, v)
+	case float32:
+		buf.WriteByte(tagFloat32)
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, math.Float32bits(v))
+		buf.Write(b)
+	case float64:
+		buf.WriteByte(tagFloat64)
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, math.Float64bits(v))
+		buf.Write(b)
+	case string:
+		buf.WriteByte(tagString)
+		encodeBytes(buf, []byte(v))
+	case []byte:
+		buf.WriteByte(tagBytes)
+		encodeBytes(buf, v)
+	case time.Time:
+		b, err := v.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(tagTime)
+		encodeBytes(buf, b)
+		// the binary form of a time only keeps its offset so the location is stored by name
+		encodeBytes(buf, []byte(v.Location().String()))
+	case Data:
+		buf.WriteByte(tagData)
+		return encodeMap(buf, v)
+	case bson.M:
+		buf.WriteByte(tagBSONM)
+		return encodeMap(buf, v)
+	case map[string]interface{}:
+		buf.WriteByte(tagMap)
+		return encodeMap(buf, v)
+	case bson.D:
+		buf.WriteByte(tagBSOND)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			encodeBytes(buf, []byte(e.Name))
+			if err := encodeValue(buf, e.Value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		buf.WriteByte(tagSlice)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			if err := encodeValue(buf, e); err != nil {
+				return err
+			}
+		}
+	case []string:
+		buf.WriteByte(tagStringSlice)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			encodeBytes(buf, []byte(e))
+		}
+	case []int:
+		buf.WriteByte(tagIntSlice)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			encodeVarint(buf, int64(e))
+		}
+	case []int64:
+		buf.WriteByte(tagInt64Slice)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			encodeVarint(buf, e)
+		}
+	case []float64:
+		buf.WriteByte(tagFloat64Slice)
+		encodeUvarint(buf, uint64(len(v)))
+		b := make([]byte, 8)
+		for _, e := range v {
+			binary.BigEndian.PutUint64(b, math.Float64bits(e))
+			buf.Write(b)
+		}
+	case map[string]string:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf.WriteByte(tagStringMap)
+		encodeUvarint(buf, uint64(len(keys)))
+		for _, k := range keys {
+			encodeBytes(buf, []byte(k))
+			encodeBytes(buf, []byte(v[k]))
+		}
+	case []map[string]interface{}:
+		buf.WriteByte(tagMapSlice)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			if err := encodeMap(buf, e); err != nil {
+				return err
+			}
+		}
+	case []bson.M:
+		buf.WriteByte(tagBSONMSlice)
+		encodeUvarint(buf, uint64(len(v)))
+		for _, e := range v {
+			if err := encodeMap(buf, e); err != nil {
+				return err
+			}
+		}
+	case bson.Binary:
+		// mgo decodes the generic binary subtype as []byte so the kind is stored separately
+		buf.WriteByte(tagBSONBinary)
+		buf.WriteByte(v.Kind)
+		encodeBytes(buf, v.Data)
+	case bson.ObjectId, bson.Decimal128, bson.MongoTimestamp, bson.RegEx,
+		bson.JavaScript, bson.Symbol, bson.DBPointer:
+		return encodeBSON(buf, v)
+	default:
+		// MinKey, MaxKey and Undefined have unexported types so they can only be matched by value
+		if v == bson.MinKey || v == bson.MaxKey || v == bson.Undefined {
+			return encodeBSON(buf, v)
+		}
+		return UnsupportedTypeError{fmt.Sprintf("%T", v)}
+	}
+	return nil
+}
+
+// encodeBSON stores v as a single element document, mgo round trips its own types exactly.
+func encodeBSON(buf *bytes.Buffer, v interface{}) error {
+	b, err := bson.Marshal(bson.D{{Name: "v", Value: v}})
+	if err != nil {
+		return err
+	}
+	buf.WriteByte(tagBSON)
+	encodeBytes(buf, b)
+	return nil
+}
+
+func decodeBytes(r *bytes.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, l)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
+
+func decodeLen(r *bytes.Reader) (int, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	// every element takes at least one byte
+	if l > uint64(r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return int(l), nil
+}
+
+func decodeMap(r *bytes.Reader) (map[string]interface{}, error) {
+	l, err := decodeLen(r)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]interface{}, l)
+	for i := 0; i < l; i++ {
+		k, err := decodeBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := decodeValue(r)
+		if err != nil {
+			return nil, err
+		}
+		m[string(k)] = v
+	}
+	return m, nil
+}
+
+func decodeFloat64(r *bytes.Reader) (float64, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b)), nil
+}
+
+func decodeValue(r *bytes.Reader) (interface{}, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case tagNil:
+		return nil, nil
+	case tagBool:
+		b, err := r.ReadByte()
+		return b == 1, err
+	case tagInt, tagInt8, tagInt16, tagInt32, tagInt64:
+		i, err := binary.ReadVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagInt:
+			return int(i), nil
+		case tagInt8:
+			return int8(i), nil
+		case tagInt16:
+			return int16(i), nil
+		case tagInt32:
+			return int32(i), nil
+		}
+		return i, nil
+	case tagUint, tagUint8, tagUint16, tagUint32, tagUint64:
+		i, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case tagUint:
+			return uint(i), nil
+		case tagUint8:
+			return uint8(i), nil
+		case tagUint16:
+			return uint16(i), nil
+		case tagUint32:
+			return uint32(i), nil
+		}
+		return i, nil
+	case tagFloat32:
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.BigEndian.Uint32(b)), nil
+	case tagFloat64:
+		return decodeFloat64(r)
+	case tagString:
+		b, err := decodeBytes(r)
+		return string(b), err
+	case tagBytes:
+		return decodeBytes(r)
+	case tagTime:
+		b, err := decodeBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		name, err := decodeBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		return decodeTime(b, string(name))
+	case tagMap:
+		return decodeMap(r)
+	case tagBSONM:
+		m, err := decodeMap(r)
+		return bson.M(m), err
+	case tagData:
+		m, err := decodeMap(r)
+		return Data(m), err
+	case tagBSOND:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		d := make(bson.D, l)
+		for i := range d {
+			k, err := decodeBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			v, err := decodeValue(r)
+			if err != nil {
+				return nil, err
+			}
+			d[i] = bson.DocElem{Name: string(k), Value: v}
+		}
+		return d, nil
+	case tagSlice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]interface{}, l)
+		for i := range s {
+			if s[i], err = decodeValue(r); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case tagStringSlice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]string, l)
+		for i := range s {
+			b, err := decodeBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = string(b)
+		}
+		return s, nil
+	case tagIntSlice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]int, l)
+		for i := range s {
+			e, err := binary.ReadVarint(r)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = int(e)
+		}
+		return s, nil
+	case tagInt64Slice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]int64, l)
+		for i := range s {
+			if s[i], err = binary.ReadVarint(r); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case tagFloat64Slice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]float64, l)
+		for i := range s {
+			if s[i], err = decodeFloat64(r); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case tagStringMap:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]string, l)
+		for i := 0; i < l; i++ {
+			k, err := decodeBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			m[string(k)] = string(e)
+		}
+		return m, nil
+	case tagMapSlice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]map[string]interface{}, l)
+		for i := range s {
+			if s[i], err = decodeMap(r); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+	case tagBSONMSlice:
+		l, err := decodeLen(r)
+		if err != nil {
+			return nil, err
+		}
+		s := make([]bson.M, l)
+		for i := range s {
+			m, err := decodeMap(r)
+			if err != nil {
+				return nil, err
+			}
+			s[i] = bson.M(m)
+		}
+		return s, nil
+	case tagBSONBinary:
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		b, err := decodeBytes(r)
+		return bson.Binary{Kind: kind, Data: b}, err
+	case tagBSON:
+		b, err := decodeBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		var d bson.D
+		if err := bson.Unmarshal(b, &d); err != nil {
+			return nil, err
+		}
+		if len(d) != 1 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return d[0].Value, nil
+	}
+	return nil, UnknownTagError{tag}
+}
+
+// decodeTime restores a time encoded with time.Time.MarshalBinary in the named location.
+func decodeTime(b []byte, name string) (time.Time, error) {
+	var t time.Time
+	if err := t.UnmarshalBinary(b); err != nil {
+		return t, err
+	}
+	_, offset := t.Zone()
+	switch name {
+	case "UTC":
+		return t.UTC(), nil
+	case "Local":
+		return t.Local(), nil
+	}
+	if loc, err := time.LoadLocation(name); name != "" && err == nil {
+		if _, o := t.In(loc).Zone(); o == offset {
+			return t.In(loc), nil
+		}
+	}
+	return t.In(time.FixedZone(name, offset)), nil
+}
@@ -0,0 +1,142 @@
+package data
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+	_ "time/tzdata"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+var (
+	decimal, _  = bson.ParseDecimal128("1234.5600")
+	newYork, _  = time.LoadLocation("America/New_York")
+	binaryTests = []struct {
+		name string
+		in   Data
+	}{
+		{
+			"empty",
+			Data{},
+		},
+		{
+			"native_types",
+			Data{
+				"nil":     nil,
+				"bool":    true,
+				"int":     42,
+				"int8":    int8(-8),
+				"int16":   int16(16),
+				"int32":   int32(-32),
+				"int64":   int64(1) << 60,
+				"uint":    uint(7),
+				"uint8":   uint8(8),
+				"uint16":  uint16(16),
+				"uint32":  uint32(32),
+				"uint64":  uint64(1) << 63,
+				"float32": float32(1.5),
+				"float64": 1.0,
+				"string":  "hello",
+				"bytes":   []byte{0, 1, 2},
+			},
+		},
+		{
+			"times",
+			Data{
+				"utc":   time.Date(2017, 5, 16, 11, 0, 20, 123456789, time.UTC),
+				"fixed": time.Date(2017, 5, 16, 11, 0, 20, 1, time.FixedZone("", -4*60*60)),
+				"named": time.Date(2017, 5, 16, 11, 0, 20, 1, newYork),
+				"local": time.Date(2017, 5, 16, 11, 0, 20, 1, time.Local),
+			},
+		},
+		{
+			"bson_types",
+			Data{
+				"_id":       bson.ObjectIdHex("58efd14b60d271d7457b4f24"),
+				"decimal":   decimal,
+				"binary":    bson.Binary{Kind: 0x80, Data: []byte("custom")},
+				"generic":   bson.Binary{Kind: 0x00, Data: []byte("generic")},
+				"ts":        bson.MongoTimestamp(6419997015285743617),
+				"regex":     bson.RegEx{Pattern: "^a", Options: "i"},
+				"js":        bson.JavaScript{Code: "function() {}"},
+				"symbol":    bson.Symbol("sym"),
+				"min":       bson.MinKey,
+				"max":       bson.MaxKey,
+				"undefined": bson.Undefined,
+				"doc":       bson.M{"nested": bson.M{"a": 1}},
+				"ordered":   bson.D{{Name: "b", Value: 1}, {Name: "a", Value: "2"}},
+				"docs":      []bson.M{{"a": int64(1)}},
+				"interface": []interface{}{1, "two", bson.M{"three": 3.0}},
+			},
+		},
+		{
+			"nested_maps_and_slices",
+			Data{
+				"map":      map[string]interface{}{"data": Data{"x": []interface{}{}}},
+				"strings":  []string{"a", ""},
+				"ints":     []int{-1, 0, 1},
+				"int64s":   []int64{-1, 0, 1},
+				"labels":   map[string]string{"b": "", "a": "1"},
+				"float64s": []float64{0.1, 2},
+				"maps":     []map[string]interface{}{{"a": nil}},
+			},
+		},
+	}
+)
+
+func TestBinaryRoundTrip(t *testing.T) {
+	for _, bt := range binaryTests {
+		b, err := bt.in.MarshalBinary()
+		if err != nil {
+			t.Fatalf("[%s] unexpected MarshalBinary error, %s", bt.name, err)
+		}
+		var out Data
+		if err := out.UnmarshalBinary(b); err != nil {
+			t.Fatalf("[%s] unexpected UnmarshalBinary error, %s", bt.name, err)
+		}
+		if !reflect.DeepEqual(out, bt.in) {
+			t.Errorf("[%s] round trip mismatch, expected %#v, got %#v", bt.name, bt.in, out)
+		}
+		b2, _ := out.MarshalBinary()
+		if !reflect.DeepEqual(b, b2) {
+			t.Errorf("[%s] re-encoding produced different bytes", bt.name)
+		}
+	}
+}
+
+func TestBinaryUnsupportedType(t *testing.T) {
+	type custom struct {
+		A int `json:"a"`
+	}
+	for _, v := range []interface{}{custom{1}, []float32{1}, map[string]int{"a": 1}} {
+		_, err := Data{"v": v}.MarshalBinary()
+		expectedErr := UnsupportedTypeError{fmt.Sprintf("%T", v)}
+		if !reflect.DeepEqual(err, expectedErr) {
+			t.Errorf("wrong error, expected %v, got %v", expectedErr, err)
+		}
+	}
+}
+
+var binaryErrTests = []struct {
+	name        string
+	in          []byte
+	expectedErr error
+}{
+	{"unknown_version", []byte{9, 0}, ErrUnknownBinaryVersion},
+	{"unknown_tag", []byte{binaryVersion, 1, 1, 'a', 255}, UnknownTagError{255}},
+}
+
+func TestUnmarshalBinaryErrors(t *testing.T) {
+	for _, bt := range binaryErrTests {
+		var d Data
+		if err := d.UnmarshalBinary(bt.in); !reflect.DeepEqual(err, bt.expectedErr) {
+			t.Errorf("[%s] wrong error, expected %v, got %v", bt.name, bt.expectedErr, err)
+		}
+	}
+	var d Data
+	if err := d.UnmarshalBinary([]byte{binaryVersion, 1, 5, 'a'}); err == nil {
+		t.Error("expected error for truncated data but didn't receive one")
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"gopkg.in/mgo.v2/bson"
 
+	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/message/data"
 	"github.com/compose/transporter/message/ops"
 )
@@ -40,6 +41,17 @@ func From(op ops.Op, namespace string, d data.Data) Msg {
 	}
 }
 
+// Copy returns a shallow copy of the message, the data is shared. A message sent to several sinks
+// is copied by each of them before attaching their confirms and offset so they don't overwrite
+// each other. Messages other than a *Base are returned as is.
+func Copy(msg Msg) Msg {
+	if m, ok := msg.(*Base); ok {
+		c := *m
+		return &c
+	}
+	return msg
+}
+
 // WithConfirms attaches a channel to be able to acknowledge message processing.
 func WithConfirms(confirm chan struct{}, msg Msg) Msg {
 	switch m := msg.(type) {
@@ -49,6 +61,85 @@ func WithConfirms(confirm chan struct{}, msg Msg) Msg {
 	return msg
 }
 
+// WithOffset attaches the namespace and commit log offset the message is tracked by so a
+// writer storing offsets in the sink can persist them together with the message.
+func WithOffset(ns string, logOffset uint64, msg Msg) Msg {
+	switch m := msg.(type) {
+	case *Base:
+		m.offsetNS = ns
+		m.logOffset = logOffset
+		m.tracked = true
+	}
+	return msg
+}
+
+// Offset returns the namespace and commit log offset attached by WithOffset, ok is false
+// when the message is not being tracked.
+func Offset(msg Msg) (ns string, logOffset uint64, ok bool) {
+	if m, isBase := msg.(*Base); isBase && m.tracked {
+		return m.offsetNS, m.logOffset, true
+	}
+	return "", 0, false
+}
+
+// WithMode attaches the commit log mode the message was read in so a writer can treat the
+// initial copy of a namespace differently from the changes which follow it.
+func WithMode(mode commitlog.Mode, msg Msg) Msg {
+	switch m := msg.(type) {
+	case *Base:
+		m.mode = mode
+		m.hasMode = true
+	}
+	return msg
+}
+
+// Mode returns the mode attached by WithMode, ok is false when no mode was attached.
+func Mode(msg Msg) (mode commitlog.Mode, ok bool) {
+	if m, isBase := msg.(*Base); isBase && m.hasMode {
+		return m.mode, true
+	}
+	return commitlog.Copy, false
+}
+
+// WithSourceTimestamp attaches the time, in seconds since the epoch, the source recorded the
+// change at, such as the commit time of a transaction. Unlike Timestamp it is kept when the
+// message is replayed from the commit log or rebuilt by a transform. A zero ts attaches nothing.
+func WithSourceTimestamp(ts int64, msg Msg) Msg {
+	switch m := msg.(type) {
+	case *Base:
+		m.sourceTS = ts
+	}
+	return msg
+}
+
+// SourceTimestamp returns the time attached by WithSourceTimestamp, ok is false when none was
+// attached.
+func SourceTimestamp(msg Msg) (ts int64, ok bool) {
+	if m, isBase := msg.(*Base); isBase && m.sourceTS != 0 {
+		return m.sourceTS, true
+	}
+	return 0, false
+}
+
+// WithUpdate attaches the update operators, such as $set and $unset, the source changed the
+// document with so a writer able to apply them can update the document in place instead of
+// replacing it with the message data.
+func WithUpdate(update data.Data, msg Msg) Msg {
+	switch m := msg.(type) {
+	case *Base:
+		m.update = update
+	}
+	return msg
+}
+
+// Update returns the update operators attached by WithUpdate, ok is false when none were attached.
+func Update(msg Msg) (update data.Data, ok bool) {
+	if m, isBase := msg.(*Base); isBase && m.update != nil {
+		return m.update, true
+	}
+	return nil, false
+}
+
 // Base represents a standard message format for transporter data
 // if it does not meet your need, you can embed the struct and override whatever
 // methods needed to accurately represent the data structure.
@@ -58,6 +149,13 @@ type Base struct {
 	Operation ops.Op
 	MapData   data.Data
 	confirm   chan struct{}
+	offsetNS  string
+	logOffset uint64
+	tracked   bool
+	mode      commitlog.Mode
+	hasMode   bool
+	sourceTS  int64
+	update    data.Data
 }
 
 // Timestamp returns the time the object was created in transporter (i.e. it has no correlation
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/compose/transporter/commitlog"
 	_ "github.com/compose/transporter/log"
 	"github.com/compose/transporter/message/ops"
 
@@ -76,3 +77,62 @@ func TestUpdateNamespace(t *testing.T) {
 		t.Errorf("UpdateNamespace failed, expected %s, got %s", "bar", orig.Namespace())
 	}
 }
+
+func TestWithOffset(t *testing.T) {
+	msg := From(ops.Insert, "foo", nil)
+	if _, _, ok := Offset(msg); ok {
+		t.Error("untracked message should not have an offset")
+	}
+	msg = WithOffset("source_ns", 12, msg)
+	if ns, o, ok := Offset(msg); !ok || ns != "source_ns" || o != 12 {
+		t.Errorf("wrong offset, expected source_ns 12, got %s %d %v", ns, o, ok)
+	}
+}
+
+func TestWithMode(t *testing.T) {
+	msg := From(ops.Insert, "foo", nil)
+	if _, ok := Mode(msg); ok {
+		t.Error("message should not have a mode")
+	}
+	msg = WithMode(commitlog.Sync, msg)
+	if mode, ok := Mode(msg); !ok || mode != commitlog.Sync {
+		t.Errorf("wrong mode, expected %s, got %s %v", commitlog.Sync, mode, ok)
+	}
+}
+
+func TestWithSourceTimestamp(t *testing.T) {
+	msg := WithSourceTimestamp(0, From(ops.Insert, "foo", nil))
+	if _, ok := SourceTimestamp(msg); ok {
+		t.Error("message should not have a source timestamp")
+	}
+	msg = WithSourceTimestamp(1494946820, msg)
+	if ts, ok := SourceTimestamp(Copy(msg)); !ok || ts != 1494946820 {
+		t.Errorf("wrong source timestamp, expected 1494946820, got %d %v", ts, ok)
+	}
+}
+
+func TestWithUpdate(t *testing.T) {
+	msg := From(ops.Update, "foo", map[string]interface{}{"_id": 1})
+	if _, ok := Update(msg); ok {
+		t.Error("message should not have an update")
+	}
+	update := map[string]interface{}{"$set": map[string]interface{}{"hello": "world"}}
+	msg = WithUpdate(update, msg)
+	if u, ok := Update(msg); !ok || !reflect.DeepEqual(u.AsMap(), update) {
+		t.Errorf("wrong update, expected %+v, got %+v %v", update, u, ok)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	msg := WithOffset("foo", 1, From(ops.Insert, "foo", map[string]interface{}{"hello": "world"}))
+	c := WithOffset("foo", 2, Copy(msg))
+	if _, o, _ := Offset(msg); o != 1 {
+		t.Errorf("offset of the original message changed, expected 1, got %d", o)
+	}
+	if _, o, _ := Offset(c); o != 2 {
+		t.Errorf("wrong offset for the copy, expected 2, got %d", o)
+	}
+	if !reflect.DeepEqual(c.Data(), msg.Data()) {
+		t.Errorf("wrong data for the copy, expected %+v, got %+v", msg.Data(), c.Data())
+	}
+}
@@ -27,17 +27,18 @@ type LogManager struct {
 }
 
 // NewLogManager creates a new instance of LogManager and initializes its namespace map by reading any
-// existing log files.
-func NewLogManager(path, name string) (*LogManager, error) {
+// existing log files. Any provided commitlog.OptionFunc, such as commitlog.WithEncryption, is
+// applied to the underlying commitlog.
+func NewLogManager(path, name string, options ...commitlog.OptionFunc) (*LogManager, error) {
 	m := &LogManager{
 		name:  name,
 		nsMap: make(map[string]uint64),
 	}
 
-	l, err := commitlog.New(
+	l, err := commitlog.New(append([]commitlog.OptionFunc{
 		commitlog.WithPath(filepath.Join(path, fmt.Sprintf("%s-%s", offsetPrefixDir, name))),
-		commitlog.WithMaxSegmentBytes(1024*1024*1024),
-	)
+		commitlog.WithMaxSegmentBytes(1024 * 1024 * 1024),
+	}, options...)...)
 	if err != nil {
 		return nil, err
 	}
@@ -52,43 +53,18 @@ func NewLogManager(path, name string) (*LogManager, error) {
 }
 
 func (m *LogManager) buildMap() error {
-	var lastError error
-	for _, s := range m.log.Segments() {
-		// s.Open()
-		var readPosition int64
-		for {
-			// skip the offsetHeader
-			readPosition += offsetHeaderLen
-
-			keyLenBytes := make([]byte, 4)
-			_, lastError = s.ReadAt(keyLenBytes, readPosition)
-			if lastError != nil && lastError == io.EOF {
-				break
-			} else if lastError != nil {
-				return lastError
-			}
-			keyLen := encoding.Uint32(keyLenBytes)
-			readPosition += 4
-
-			// now we read the namespace based on the length
-			nsBytes := make([]byte, keyLen)
-			_, lastError = s.ReadAt(nsBytes, readPosition)
-			if lastError != nil {
-				break
-			}
-			// we can add 4 here since we know the size of the value is 8 bytes
-			readPosition += int64(keyLen) + 4
-			// we can cheat here since we know the value will always be the 8-byte offset
-			valBytes := make([]byte, 8)
-			_, lastError = s.ReadAt(valBytes, readPosition)
-			if lastError != nil {
-				break
-			}
-			readPosition += 8
-			m.nsMap[string(nsBytes)] = encoding.Uint64(valBytes)
+	r, err := m.log.NewReader(-1)
+	if err != nil {
+		return err
+	}
+	for {
+		_, e, err := commitlog.ReadEntry(r)
+		if err != nil {
+			return err
 		}
+		// the value will always be the 8-byte offset
+		m.nsMap[string(e.Key)] = encoding.Uint64(e.Value)
 	}
-	return lastError
 }
 
 // CommitOffset verifies it does not contain an offset older than the current offset
@@ -1,6 +1,8 @@
 package offset_test
 
 import (
+	"bytes"
+	"encoding/base64"
 	"fmt"
 	"math/rand"
 	"os"
@@ -9,6 +11,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/offset"
 )
 
@@ -123,3 +126,37 @@ func TestEmptyMap(t *testing.T) {
 func cleanup(p string, t *testing.T) {
 	os.RemoveAll(p)
 }
+
+func TestEncryptedLogManager(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("managertest%d", rand.Int63()))
+	defer cleanup(path, t)
+	kr, err := commitlog.ParseKeyring("k1:" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if err != nil {
+		t.Fatalf("unexpected ParseKeyring error, %s", err)
+	}
+	m, err := offset.NewLogManager(path, "encrypted0", commitlog.WithEncryption(kr, true))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	for ns, lastOffset := range expectedMap {
+		if err := m.CommitOffset(offset.Offset{
+			Namespace: ns,
+			LogOffset: lastOffset,
+			Timestamp: time.Now().Unix(),
+		}, false); err != nil {
+			t.Fatalf("unexpected CommitOffset error, %s", err)
+		}
+	}
+
+	m, err = offset.NewLogManager(path, "encrypted0", commitlog.WithEncryption(kr, true))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	if !reflect.DeepEqual(m.OffsetMap(), expectedMap) {
+		t.Errorf("bad OffsetMap, expected, %+v, got %+v", expectedMap, m.OffsetMap())
+	}
+
+	if _, err := offset.NewLogManager(path, "encrypted0"); err == nil {
+		t.Error("expected error opening encrypted offsets without a key but didn't receive one")
+	}
+}
@@ -6,10 +6,6 @@ import (
 	"github.com/compose/transporter/commitlog"
 )
 
-const (
-	offsetHeaderLen = 21
-)
-
 var (
 	encoding = binary.BigEndian
 )
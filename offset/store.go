@@ -0,0 +1,233 @@
+package offset
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/log"
+)
+
+var (
+	_ Manager = &StoreManager{}
+	_ Store   = &FileStore{}
+
+	_ io.Closer = &StoreManager{}
+	_ io.Closer = &FileStore{}
+)
+
+// Store defines the functions needed to persist offsets outside of the local commit log,
+// such as in a file or in the sink itself.
+type Store interface {
+	// Load returns the newest offset for every namespace.
+	Load() (map[string]uint64, error)
+	// Save persists the offset for a single namespace.
+	Save(Offset) error
+}
+
+// StoreManager implements Manager on top of a Store. The offsets are loaded once when created
+// and kept in memory afterwards so the Store only needs to handle writes.
+type StoreManager struct {
+	store Store
+	nsMap map[string]uint64
+	sync.Mutex
+}
+
+// NewStoreManager creates a new StoreManager with the offsets currently in the Store.
+func NewStoreManager(s Store) (*StoreManager, error) {
+	nsMap, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	return &StoreManager{store: s, nsMap: nsMap}, nil
+}
+
+// CommitOffset verifies it does not contain an offset older than the current offset
+// and saves it to the Store.
+func (m *StoreManager) CommitOffset(o Offset, override bool) error {
+	m.Lock()
+	defer m.Unlock()
+	if currentOffset, ok := m.nsMap[o.Namespace]; !override && ok && currentOffset >= o.LogOffset {
+		log.With("currentOffest", currentOffset).
+			With("providedOffset", o.LogOffset).
+			Debugln("refusing to commit offset")
+		return nil
+	}
+	if err := m.store.Save(o); err != nil {
+		return err
+	}
+	m.nsMap[o.Namespace] = o.LogOffset
+	return nil
+}
+
+// OffsetMap provides access to the underlying map containing the newest offset for every
+// namespace.
+func (m *StoreManager) OffsetMap() map[string]uint64 {
+	m.Lock()
+	defer m.Unlock()
+	return m.nsMap
+}
+
+// NewestOffset loops over every offset and returns the highest one.
+func (m *StoreManager) NewestOffset() int64 {
+	m.Lock()
+	defer m.Unlock()
+	if len(m.nsMap) == 0 {
+		return -1
+	}
+	var newestOffset uint64
+	for _, v := range m.nsMap {
+		if newestOffset < v {
+			newestOffset = v
+		}
+	}
+	return int64(newestOffset)
+}
+
+// Close closes the Store when it implements io.Closer so any offsets it buffers are written.
+func (m *StoreManager) Close() error {
+	if c, ok := m.store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// DefaultFileStoreInterval is the minimum time between two writes of the FileStore.
+const DefaultFileStoreInterval = time.Second
+
+// FileStore keeps the offsets of a sink in a single JSON file. The file is rewritten to a
+// temporary file which is synced and then renamed over the original so it always contains a
+// complete set of offsets. To keep high throughput sinks from waiting on the disk, the file
+// is written at most once per DefaultFileStoreInterval, offsets saved in between are written
+// together at the end of the interval or when the FileStore is closed.
+type FileStore struct {
+	path     string
+	interval time.Duration
+	nsMap    map[string]uint64
+	written  time.Time
+	timer    *time.Timer
+	closed   bool
+	err      error
+	sync.Mutex
+}
+
+// NewFileStore creates a FileStore for the named sink in the provided directory.
+func NewFileStore(path, name string) (*FileStore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{
+		path:     filepath.Join(path, fmt.Sprintf("%s-%s.json", offsetPrefixDir, name)),
+		interval: DefaultFileStoreInterval,
+		nsMap:    make(map[string]uint64),
+	}, nil
+}
+
+// NewFileManager creates a StoreManager backed by a FileStore.
+func NewFileManager(path, name string) (*StoreManager, error) {
+	s, err := NewFileStore(path, name)
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreManager(s)
+}
+
+// Load reads the offsets from the file, a missing file means no offsets have been saved.
+func (s *FileStore) Load() (map[string]uint64, error) {
+	s.Lock()
+	defer s.Unlock()
+	b, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return make(map[string]uint64), nil
+	} else if err != nil {
+		return nil, err
+	}
+	nsMap := make(map[string]uint64)
+	if err := json.Unmarshal(b, &nsMap); err != nil {
+		return nil, err
+	}
+	for ns, o := range nsMap {
+		s.nsMap[ns] = o
+	}
+	return nsMap, nil
+}
+
+// Save records o and writes every known offset to the file, unless the file was written less
+// than the interval ago in which case the write is delayed until the interval has passed. An
+// error from a delayed write is returned by the next call to Save.
+func (s *FileStore) Save(o Offset) error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return s.err
+	}
+	s.nsMap[o.Namespace] = o.LogOffset
+	if wait := s.interval - time.Since(s.written); wait > 0 && !s.closed {
+		if s.timer == nil {
+			s.timer = time.AfterFunc(wait, s.flush)
+		}
+		return nil
+	}
+	return s.write()
+}
+
+// Close writes any offsets saved since the last write, offsets saved afterwards are written
+// immediately.
+func (s *FileStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	s.closed = true
+	if s.timer == nil {
+		return s.err
+	}
+	return s.write()
+}
+
+func (s *FileStore) flush() {
+	s.Lock()
+	defer s.Unlock()
+	if s.timer == nil {
+		return
+	}
+	if err := s.write(); err != nil {
+		log.With("path", s.path).Errorf("unable to write offsets, %s", err)
+		s.err = err
+	}
+}
+
+func (s *FileStore) write() error {
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	b, err := json.Marshal(s.nsMap)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	s.written = time.Now()
+	return nil
+}
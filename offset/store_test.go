@@ -0,0 +1,142 @@
+package offset_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/offset"
+)
+
+type mockStore struct {
+	loaded  map[string]uint64
+	saved   []offset.Offset
+	loadErr error
+	saveErr error
+}
+
+func (s *mockStore) Load() (map[string]uint64, error) {
+	return s.loaded, s.loadErr
+}
+
+func (s *mockStore) Save(o offset.Offset) error {
+	if s.saveErr != nil {
+		return s.saveErr
+	}
+	s.saved = append(s.saved, o)
+	return nil
+}
+
+func TestStoreManager(t *testing.T) {
+	s := &mockStore{loaded: map[string]uint64{"foo": 10}}
+	m, err := offset.NewStoreManager(s)
+	if err != nil {
+		t.Fatalf("unexpected NewStoreManager error, %s", err)
+	}
+	m.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 5}, false)
+	m.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 12}, false)
+	m.CommitOffset(offset.Offset{Namespace: "bar", LogOffset: 3}, false)
+	m.CommitOffset(offset.Offset{Namespace: "bar", LogOffset: 2}, true)
+	expectedSaved := []offset.Offset{
+		{Namespace: "foo", LogOffset: 12},
+		{Namespace: "bar", LogOffset: 3},
+		{Namespace: "bar", LogOffset: 2},
+	}
+	if !reflect.DeepEqual(s.saved, expectedSaved) {
+		t.Errorf("wrong offsets saved, expected %v, got %v", expectedSaved, s.saved)
+	}
+	expectedMap := map[string]uint64{"foo": 12, "bar": 2}
+	if !reflect.DeepEqual(m.OffsetMap(), expectedMap) {
+		t.Errorf("wrong offset map, expected %v, got %v", expectedMap, m.OffsetMap())
+	}
+	if m.NewestOffset() != 12 {
+		t.Errorf("wrong NewestOffset, expected 12, got %d", m.NewestOffset())
+	}
+
+	s.saveErr = errors.New("save failed")
+	if err := m.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 20}, false); err != s.saveErr {
+		t.Errorf("expected save error, got %v", err)
+	}
+	if m.OffsetMap()["foo"] != 12 {
+		t.Errorf("offset should not change when the save fails, got %d", m.OffsetMap()["foo"])
+	}
+
+	if _, err := offset.NewStoreManager(&mockStore{loadErr: errors.New("load failed")}); err == nil {
+		t.Error("expected load error but didn't receive one")
+	}
+}
+
+func TestFileManager(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("filemanagertest%d", rand.Int63()))
+	defer os.RemoveAll(path)
+	m, err := offset.NewFileManager(path, "sink")
+	if err != nil {
+		t.Fatalf("unexpected NewFileManager error, %s", err)
+	}
+	if m.NewestOffset() != -1 {
+		t.Errorf("wrong NewestOffset for new file, expected -1, got %d", m.NewestOffset())
+	}
+	m.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 1}, false)
+	m.CommitOffset(offset.Offset{Namespace: "bar", LogOffset: 2}, false)
+
+	// the second offset is written at the end of the interval or on Close
+	b, err := ioutil.ReadFile(filepath.Join(path, "__consumer_offsets-sink.json"))
+	if err != nil {
+		t.Fatalf("unable to read offsets file, %s", err)
+	}
+	if string(b) != `{"foo":1}` {
+		t.Errorf("wrong file contents before Close, got %s", b)
+	}
+	if err := m.Close(); err != nil {
+		t.Fatalf("unexpected Close error, %s", err)
+	}
+
+	m, err = offset.NewFileManager(path, "sink")
+	if err != nil {
+		t.Fatalf("unexpected NewFileManager error, %s", err)
+	}
+	expected := map[string]uint64{"foo": 1, "bar": 2}
+	if !reflect.DeepEqual(m.OffsetMap(), expected) {
+		t.Errorf("wrong offset map, expected %v, got %v", expected, m.OffsetMap())
+	}
+	m.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 3}, false)
+
+	b, err = ioutil.ReadFile(filepath.Join(path, "__consumer_offsets-sink.json"))
+	if err != nil {
+		t.Fatalf("unable to read offsets file, %s", err)
+	}
+	if string(b) != `{"bar":2,"foo":3}` {
+		t.Errorf("wrong file contents, got %s", b)
+	}
+	if _, err := os.Stat(filepath.Join(path, "__consumer_offsets-sink.json.tmp")); !os.IsNotExist(err) {
+		t.Errorf("temporary file should have been renamed, %v", err)
+	}
+}
+
+func TestFileStoreDelayedWrite(t *testing.T) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("filestoretest%d", rand.Int63()))
+	defer os.RemoveAll(path)
+	s, err := offset.NewFileStore(path, "sink")
+	if err != nil {
+		t.Fatalf("unexpected NewFileStore error, %s", err)
+	}
+	for i := uint64(1); i <= 3; i++ {
+		if err := s.Save(offset.Offset{Namespace: "foo", LogOffset: i}); err != nil {
+			t.Fatalf("unexpected Save error, %s", err)
+		}
+	}
+	time.Sleep(offset.DefaultFileStoreInterval + 200*time.Millisecond)
+	b, err := ioutil.ReadFile(filepath.Join(path, "__consumer_offsets-sink.json"))
+	if err != nil {
+		t.Fatalf("unable to read offsets file, %s", err)
+	}
+	if string(b) != `{"foo":3}` {
+		t.Errorf("wrong file contents, got %s", b)
+	}
+}
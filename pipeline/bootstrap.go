@@ -0,0 +1,182 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/offset"
+	"github.com/compose/transporter/pipe"
+)
+
+// the supported values for a sink's start_from, any other value is parsed as a timestamp.
+const (
+	startFromEarliest  = "earliest"
+	startFromLatest    = "latest"
+	startFromSnapshot  = "snapshot"
+	startFromTimestamp = "timestamp"
+)
+
+// bootstrap positions a child which has never committed an offset, such as a sink newly added
+// to an existing pipeline, based on its start_from. Offsets are committed for every namespace
+// up to the configured position so the normal resume logic only sends what comes after it. The
+// returned bool is true when the child needs a snapshot of the source before continuing, which
+// is also the case when a previous snapshot didn't complete.
+func (n *Node) bootstrap(child *Node) (bool, error) {
+	if child.om == nil {
+		return false, nil
+	}
+	if child.startFrom == startFromSnapshot {
+		child.snapshotMarker = filepath.Join(n.clog.Path(), fmt.Sprintf("__snapshot_pending-%s", child.Name))
+		if _, err := os.Stat(child.snapshotMarker); err == nil && child.om.NewestOffset() >= 0 {
+			child.l.Infoln("previous snapshot did not complete, snapshotting again")
+			return true, nil
+		}
+	}
+	if child.om.NewestOffset() >= 0 || child.startFrom == startFromEarliest {
+		return false, nil
+	}
+	var stop func(commitlog.LogEntry) bool
+	switch child.startFrom {
+	case startFromTimestamp:
+		ts := uint64(child.startFromTime.Unix())
+		stop = func(e commitlog.LogEntry) bool { return e.Timestamp >= ts }
+	default:
+		stop = func(commitlog.LogEntry) bool { return false }
+	}
+	offsets, err := lastOffsets(n.clog, stop)
+	if err != nil {
+		return false, err
+	}
+	if child.startFrom == startFromSnapshot {
+		// the marker is written before any offset so a snapshot which doesn't complete is
+		// run again rather than the child being treated as caught up
+		if err := ioutil.WriteFile(child.snapshotMarker, nil, 0644); err != nil {
+			return false, err
+		}
+	}
+	child.l.With("start_from", child.startFrom).
+		With("num_namespaces", len(offsets)).
+		Infoln("bootstrapping offsets for new sink")
+	for ns, o := range offsets {
+		if err := child.om.CommitOffset(offset.Offset{Namespace: ns, LogOffset: o, Timestamp: time.Now().Unix()}, false); err != nil {
+			return false, err
+		}
+	}
+	return child.startFrom == startFromSnapshot, nil
+}
+
+// lastOffsets reads the commit log until stop returns true and returns the offset of the last
+// entry read for every namespace. Commit log timestamps come from the source so the first entry
+// matching stop is used rather than trying to find every entry matching it.
+func lastOffsets(clog *commitlog.CommitLog, stop func(commitlog.LogEntry) bool) (map[string]uint64, error) {
+	offsets := make(map[string]uint64)
+	r, err := clog.NewReader(-1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		o, e, err := commitlog.ReadEntry(r)
+		if err == io.EOF {
+			return offsets, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if stop(e) {
+			return offsets, nil
+		}
+		offsets[string(e.Key)] = o
+	}
+}
+
+// snapshotOffset returns the offset the snapshot messages of the child are tracked at, the
+// offset it was bootstrapped to, so confirming them never moves its offsets.
+func snapshotOffset(child *Node) offset.Offset {
+	off := offset.Offset{Namespace: "", LogOffset: uint64(child.om.NewestOffset())}
+	for ns, o := range child.om.OffsetMap() {
+		if o == off.LogOffset {
+			off.Namespace = ns
+		}
+	}
+	return off
+}
+
+// snapshot copies the source into a single child using the source's snapshot reader, the
+// messages are sent directly to the child's pipe so no other sink receives them. It is only
+// called after bootstrap has committed offsets for the entire log so changes appended after the
+// snapshot are delivered by the pipeline as usual. The snapshot ends with snapshotEnd, the
+// child removes its snapshot marker once every message before it is confirmed.
+func (n *Node) snapshot(child *Node, off offset.Offset) error {
+	child.l.Infoln("adaptor Snapshotting...")
+	defer func() {
+		child.l.Infoln("adaptor Snapshot complete")
+	}()
+	if n.snapshotReader == nil {
+		return adaptor.ErrFuncNotSupported{Name: n.Type, Func: "SnapshotReader()"}
+	}
+
+	s, err := n.c.Connect()
+	if err != nil {
+		return err
+	}
+	if closer, ok := s.(client.Closer); ok {
+		defer closer.Close()
+	}
+	readFunc := n.snapshotReader.Read(map[string]client.MessageSet{}, func(check string) bool { return n.nsFilter.MatchString(check) })
+	msgChan, err := readFunc(s, n.done)
+	if err != nil {
+		return err
+	}
+	for msg := range msgChan {
+		if child.pipe.Stopped {
+			return ErrResumeStopped
+		}
+		off.Timestamp = time.Now().Unix()
+		child.pipe.In <- pipe.TrackedMessage{Msg: sourceMsg(msg), Off: off}
+	}
+	end := message.From(ops.Noop, "", nil)
+	child.offsetLock.Lock()
+	child.snapshotEnd = end
+	child.offsetLock.Unlock()
+	child.pipe.In <- pipe.TrackedMessage{Msg: end, Off: off}
+	return nil
+}
+
+// isSnapshotEnd returns true for the message ending the snapshot of the node, the snapshot
+// is complete once the messages written before it are confirmed.
+func (n *Node) isSnapshotEnd(msg message.Msg) bool {
+	n.offsetLock.Lock()
+	defer n.offsetLock.Unlock()
+	if n.snapshotEnd == nil || msg != n.snapshotEnd {
+		return false
+	}
+	n.snapshotEnd = nil
+	if n.confirms != nil && len(n.pendingOffsets) > 0 {
+		n.snapshotEndPending = true
+		return true
+	}
+	if err := n.snapshotComplete(); err != nil {
+		n.l.Errorln(err)
+	}
+	return true
+}
+
+// snapshotComplete removes the snapshot marker of the node.
+func (n *Node) snapshotComplete() error {
+	n.l.Infoln("snapshot confirmed")
+	if n.snapshotMarker == "" {
+		return nil
+	}
+	if err := os.Remove(n.snapshotMarker); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
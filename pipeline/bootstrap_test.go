@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/adaptor"
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/log"
+	"github.com/compose/transporter/offset"
+)
+
+var withStartFromTests = []struct {
+	name         string
+	startFrom    string
+	expected     string
+	expectedTime time.Time
+	expectedErr  error
+}{
+	{"default", "", startFromEarliest, time.Time{}, nil},
+	{"latest", "latest", startFromLatest, time.Time{}, nil},
+	{"snapshot", "snapshot", startFromSnapshot, time.Time{}, nil},
+	{"timestamp", "2017-05-16T11:00:20Z", startFromTimestamp, time.Date(2017, 5, 16, 11, 0, 20, 0, time.UTC), nil},
+	{"invalid", "yesterday", startFromEarliest, time.Time{}, ErrInvalidStartFrom},
+}
+
+func TestWithStartFrom(t *testing.T) {
+	for _, st := range withStartFromTests {
+		n := &Node{startFrom: startFromEarliest}
+		err := WithStartFrom(st.startFrom)(n)
+		if err != st.expectedErr {
+			t.Errorf("[%s] wrong error, expected %v, got %v", st.name, st.expectedErr, err)
+		}
+		if n.startFrom != st.expected || !n.startFromTime.Equal(st.expectedTime) {
+			t.Errorf("[%s] wrong start_from, expected %s %s, got %s %s", st.name, st.expected, st.expectedTime, n.startFrom, n.startFromTime)
+		}
+	}
+}
+
+var bootstrapTests = []struct {
+	name             string
+	startFrom        string
+	existing         map[string]uint64
+	expectedOffsets  map[string]uint64
+	expectedSnapshot bool
+}{
+	{"earliest", "earliest", map[string]uint64{}, map[string]uint64{}, false},
+	{"latest", "latest", map[string]uint64{}, map[string]uint64{"foo": 8, "bar": 9}, false},
+	{"timestamp", time.Unix(1004, 0).UTC().Format(time.RFC3339), map[string]uint64{}, map[string]uint64{"foo": 2, "bar": 3}, false},
+	{"snapshot", "snapshot", map[string]uint64{}, map[string]uint64{"foo": 8, "bar": 9}, true},
+	{"existing_offsets", "snapshot", map[string]uint64{"foo": 4}, map[string]uint64{"foo": 4}, false},
+}
+
+func TestBootstrap(t *testing.T) {
+	clog, path := setupReplayLog(t)
+	defer os.RemoveAll(path)
+	defer clog.Close()
+	for _, bt := range bootstrapTests {
+		source, _ := NewNodeWithOptions("source", "mock", "/.*/")
+		source.clog = clog
+		om := &offset.MockManager{MemoryMap: bt.existing}
+		child, err := NewNodeWithOptions("sink", "mock", "/.*/", WithParent(source), WithOffsetManager(om), WithStartFrom(bt.startFrom))
+		if err != nil {
+			t.Fatalf("[%s] unexpected NewNodeWithOptions error, %s", bt.name, err)
+		}
+		child.l = log.With("name", child.Name)
+		snapshot, err := source.bootstrap(child)
+		if err != nil {
+			t.Fatalf("[%s] unexpected bootstrap error, %s", bt.name, err)
+		}
+		if snapshot != bt.expectedSnapshot {
+			t.Errorf("[%s] wrong snapshot, expected %v, got %v", bt.name, bt.expectedSnapshot, snapshot)
+		}
+		if !reflect.DeepEqual(om.OffsetMap(), bt.expectedOffsets) {
+			t.Errorf("[%s] wrong offsets, expected %v, got %v", bt.name, bt.expectedOffsets, om.OffsetMap())
+		}
+		if child.snapshotMarker != "" {
+			os.Remove(child.snapshotMarker)
+		}
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	clog, path := setupReplayLog(t)
+	defer os.RemoveAll(path)
+	defer clog.Close()
+	source, _ := NewNodeWithOptions("source", "mock", "/.*/")
+	source.clog = clog
+	om := &offset.MockManager{MemoryMap: map[string]uint64{}}
+	child, _ := NewNodeWithOptions("sink", "mock", "/.*/", WithParent(source), WithOffsetManager(om), WithStartFrom("snapshot"))
+	other, _ := NewNodeWithOptions("other", "mock", "/.*/", WithParent(source))
+	child.l = log.With("name", child.Name)
+
+	if err := source.snapshot(child, offset.Offset{}); !reflect.DeepEqual(err, adaptor.ErrFuncNotSupported{Name: "mock", Func: "SnapshotReader()"}) {
+		t.Errorf("expected ErrFuncNotSupported without a snapshot reader, got %v", err)
+	}
+
+	source.snapshotReader = &client.MockReader{MsgCount: 5}
+	if _, err := source.bootstrap(child); err != nil {
+		t.Fatalf("unexpected bootstrap error, %s", err)
+	}
+	if _, err := os.Stat(child.snapshotMarker); err != nil {
+		t.Fatalf("expected a snapshot marker, %s", err)
+	}
+	if err := source.snapshot(child, snapshotOffset(child)); err != nil {
+		t.Fatalf("unexpected snapshot error, %s", err)
+	}
+	if len(child.pipe.In) != 6 {
+		t.Errorf("wrong number of messages sent to sink, expected 6, got %d", len(child.pipe.In))
+	}
+	if len(other.pipe.In) != 0 {
+		t.Errorf("snapshot messages sent to other sink, got %d", len(other.pipe.In))
+	}
+	m := <-child.pipe.In
+	if m.Off.Namespace != "bar" || m.Off.LogOffset != 9 {
+		t.Errorf("wrong offset for snapshot message, expected bar 9, got %s %d", m.Off.Namespace, m.Off.LogOffset)
+	}
+	for len(child.pipe.In) > 1 {
+		<-child.pipe.In
+	}
+
+	// a restart before the snapshot is confirmed snapshots again
+	snapshot, err := source.bootstrap(child)
+	if err != nil || !snapshot {
+		t.Errorf("expected an interrupted snapshot to run again, got %v %v", snapshot, err)
+	}
+
+	child.pendingOffsets = []offset.Offset{m.Off}
+	child.confirms = make(chan struct{})
+	end := <-child.pipe.In
+	if !child.isSnapshotEnd(end.Msg) {
+		t.Fatal("expected the last message to end the snapshot")
+	}
+	if _, err := os.Stat(child.snapshotMarker); err != nil {
+		t.Errorf("snapshot marker removed before the messages were confirmed, %s", err)
+	}
+	if err := child.confirmOffsets(); err != nil {
+		t.Fatalf("unexpected confirmOffsets error, %s", err)
+	}
+	if _, err := os.Stat(child.snapshotMarker); !os.IsNotExist(err) {
+		t.Errorf("expected the snapshot marker to be removed, got %v", err)
+	}
+	if snapshot, _ := source.bootstrap(child); snapshot {
+		t.Error("expected no snapshot once the previous one is confirmed")
+	}
+}
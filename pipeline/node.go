@@ -8,7 +8,6 @@ package pipeline
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -17,13 +16,13 @@ import (
 	"sync"
 	"time"
 
-	"github.com/compose/mejson"
 	"github.com/compose/transporter/adaptor"
 	"github.com/compose/transporter/client"
 	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/function"
 	"github.com/compose/transporter/log"
 	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
 	"github.com/compose/transporter/message/ops"
 	"github.com/compose/transporter/offset"
 	"github.com/compose/transporter/pipe"
@@ -46,6 +45,10 @@ var (
 	// ErrConfirmOffset is returned if the underling OffsetManager fails to commit
 	// the offsets.
 	ErrConfirmOffset = errors.New("failed to confirm offsets")
+
+	// ErrInvalidStartFrom is returned by WithStartFrom for any value which is not a
+	// supported position or an RFC3339 timestamp.
+	ErrInvalidStartFrom = errors.New("start_from must be one of earliest, latest, snapshot, or an RFC3339 timestamp")
 )
 
 // OptionFunc is a function that configures a Node.
@@ -67,6 +70,7 @@ type Node struct {
 	nsFilter       *regexp.Regexp
 	c              client.Client
 	reader         client.Reader
+	snapshotReader client.Reader
 	writer         client.Writer
 	done           chan struct{}
 	wg             sync.WaitGroup
@@ -82,6 +86,14 @@ type Node struct {
 	writeTimeout   time.Duration
 
 	compactionInterval time.Duration
+
+	startFrom     string
+	startFromTime time.Time
+	// snapshotMarker is removed once every message sent by the snapshot of the node, ending
+	// with snapshotEnd, is confirmed by its writer
+	snapshotMarker     string
+	snapshotEnd        message.Msg
+	snapshotEndPending bool
 }
 
 // Transform defines the struct for including a native function in the pipeline.
@@ -114,6 +126,7 @@ func NewNodeWithOptions(name, kind, ns string, options ...OptionFunc) (*Node, er
 		resumeTimeout:      60 * time.Second,
 		writeTimeout:       defaultWriteTimeout,
 		compactionInterval: defaultCompactionInterval,
+		startFrom:          startFromEarliest,
 	}
 	// Run the options on it
 	for _, option := range options {
@@ -134,11 +147,18 @@ func WithClient(a adaptor.Adaptor) OptionFunc {
 	}
 }
 
-// WithReader sets the client.Reader to be used to source data from.
+// WithReader sets the client.Reader to be used to source data from. If the adaptor implements
+// adaptor.Snapshotter, its snapshot reader is used for bootstrapping sinks with a start_from of snapshot.
 func WithReader(a adaptor.Adaptor) OptionFunc {
 	return func(n *Node) error {
 		r, err := a.Reader()
+		if err != nil {
+			return err
+		}
 		n.reader = r
+		if s, ok := a.(adaptor.Snapshotter); ok {
+			n.snapshotReader, err = s.SnapshotReader()
+		}
 		return err
 	}
 }
@@ -230,6 +250,27 @@ func WithCompactionInterval(interval string) OptionFunc {
 	}
 }
 
+// WithStartFrom configures where a sink without any offsets starts reading the commit log,
+// which is one of earliest (the default), latest, snapshot, or an RFC3339 timestamp.
+func WithStartFrom(startFrom string) OptionFunc {
+	return func(n *Node) error {
+		switch startFrom {
+		case "":
+			n.startFrom = startFromEarliest
+		case startFromEarliest, startFromLatest, startFromSnapshot:
+			n.startFrom = startFrom
+		default:
+			t, err := time.Parse(time.RFC3339, startFrom)
+			if err != nil {
+				return ErrInvalidStartFrom
+			}
+			n.startFrom = startFromTimestamp
+			n.startFromTime = t
+		}
+		return nil
+	}
+}
+
 func (n *Node) String() string {
 	var (
 		s, prefix string
@@ -271,27 +312,34 @@ func (n *Node) Start() error {
 		if n.clog != nil {
 			nsOffsetMap := make(map[string]uint64)
 			errc := make(chan error, len(n.children))
+			var snapshots []*Node
 			// TODO: not entirely sure about this logic check...
 			if n.clog.OldestOffset() != n.clog.NewestOffset() {
 				n.l.With("newestOffset", n.clog.NewestOffset()).
 					With("oldestOffset", n.clog.OldestOffset()).
 					Infoln("existing messages in commitlog, checking writer offsets...")
 				for _, child := range n.children {
+					snapshot, err := n.bootstrap(child)
+					if err != nil {
+						return err
+					}
 					n.l.With("name", child.Name).Infof("offsetMap: %+v", child.om.OffsetMap())
 					// we subtract 1 from NewestOffset() because we only need to catch up
 					// to the last entry in the log
+					if snapshot {
+						snapshots = append(snapshots, child)
+					}
 					if child.om.NewestOffset() < (n.clog.NewestOffset() - 1) {
 						r, err := n.clog.NewReader(child.om.NewestOffset())
 						if err != nil {
 							return err
 						}
-						go func(r io.Reader) {
+						go func(child *Node, r io.Reader) {
 							errc <- child.resume(n.clog.NewestOffset()-1, r)
-						}(r)
+						}(child, r)
 					} else {
 						errc <- nil
 					}
-
 				}
 				n.l.Infoln("waiting for all children to resume...")
 				err := <-errc
@@ -337,9 +385,21 @@ func (n *Node) Start() error {
 						Msg:       d.msg.Msg,
 						Timestamp: d.msg.Timestamp,
 						Mode:      mode,
+						Position:  d.msg.Position,
 					}
 				}
 			}
+			// snapshots run alongside the source so the other children keep receiving
+			// changes while the new ones are copied
+			for _, child := range snapshots {
+				off := snapshotOffset(child)
+				go func(child *Node) {
+					if err := n.snapshot(child, off); err != nil {
+						n.l.With("name", child.Name).Errorf("snapshot error, %s", err)
+						errors <- err
+					}
+				}(child)
+			}
 			go n.runCompaction()
 		}
 		n.l.Infof("starting with metadata %+v", msgMap)
@@ -455,31 +515,43 @@ func (n *Node) start(nsMap map[string]client.MessageSet) error {
 	if err != nil {
 		return err
 	}
+	confirmer, _ := n.reader.(client.PositionConfirmer)
+	p := &positions{}
+	if confirmer != nil && n.clog != nil {
+		go n.confirmPositions(confirmer, p)
+	}
 	var logOffset int64
 	for msg := range msgChan {
 		if n.clog != nil {
-			d, _ := mejson.Marshal(msg.Msg.Data().AsMap())
-			b, _ := json.Marshal(d)
-			o, err := n.clog.Append(
-				commitlog.NewLogFromEntry(
-					commitlog.LogEntry{
-						Key:       []byte(msg.Msg.Namespace()),
-						Mode:      msg.Mode,
-						Op:        msg.Msg.OP(),
-						Timestamp: uint64(msg.Timestamp),
-						Value:     b,
-					}))
+			le, err := newLogEntry(msg)
+			if err != nil {
+				return err
+			}
+			o, err := n.clog.Append(commitlog.NewLogFromEntry(le))
 			if err != nil {
 				return err
 			}
 			logOffset = o
 			n.l.With("offset", logOffset).Debugln("attaching offset to message")
+			if confirmer != nil && len(msg.Position) > 0 {
+				p.add(uint64(logOffset), msg.Position)
+			}
 		}
-		n.pipe.Send(msg.Msg, offset.Offset{
+		n.pipe.Send(sourceMsg(msg), offset.Offset{
 			Namespace: msg.Msg.Namespace(),
 			LogOffset: uint64(logOffset),
 			Timestamp: time.Now().Unix(),
 		})
+		if n.clog == nil && confirmer != nil && len(msg.Position) > 0 {
+			// without a commit log nothing can be resumed so the source doesn't need to
+			// keep the message once it is sent
+			confirmer.ConfirmPosition(msg.Position)
+		}
+	}
+	if reporter, ok := n.reader.(client.ErrorReporter); ok {
+		if err := reporter.Err(); err != nil {
+			return err
+		}
 	}
 
 	n.l.Infoln("adaptor Start finished...")
@@ -514,6 +586,11 @@ type writeResult struct {
 }
 
 func (n *Node) write(msg message.Msg, off offset.Offset) (message.Msg, error) {
+	if n.isSnapshotEnd(msg) {
+		return nil, nil
+	}
+	// every child receives the same message from the parent
+	msg = message.Copy(msg)
 	if n.om != nil {
 		n.offsetLock.Lock()
 		msg = message.WithConfirms(n.confirms, msg)
@@ -548,6 +625,7 @@ func (n *Node) write(msg message.Msg, off offset.Offset) (message.Msg, error) {
 		n.offsetLock.Lock()
 		n.pendingOffsets = append(n.pendingOffsets, off)
 		n.offsetLock.Unlock()
+		msg = message.WithOffset(off.Namespace, off.LogOffset, msg)
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), n.writeTimeout)
 	defer cancel()
@@ -590,6 +668,12 @@ func (n *Node) confirmOffsets() error {
 		}
 	}
 	n.pendingOffsets = make([]offset.Offset, 0)
+	if n.snapshotEndPending {
+		n.snapshotEndPending = false
+		if err := n.snapshotComplete(); err != nil {
+			n.l.Errorln(err)
+		}
+	}
 	return nil
 }
 
@@ -608,6 +692,23 @@ func (n *Node) applyTransforms(msg message.Msg) (message.Msg, error) {
 				n.l.With("transform", transform.Name).Debugln("returned nil message, skipping")
 				return nil, nil
 			}
+			if mode, ok := message.Mode(msg); ok {
+				if _, has := message.Mode(m); !has {
+					// writers treat the messages of the initial copy differently, such as
+					// the postgres bulk_copy
+					m = message.WithMode(mode, m)
+				}
+			}
+			if ts, ok := message.SourceTimestamp(msg); ok {
+				if _, has := message.SourceTimestamp(m); !has {
+					m = message.WithSourceTimestamp(ts, m)
+				}
+			}
+			if u, ok := message.Update(msg); ok && m.OP() == ops.Update {
+				// the update operators are kept for writers applying them, with $set rebuilt
+				// so the fields the transform dropped or renamed aren't written anyway
+				m = message.WithUpdate(transformedUpdate(u, m.Data()), m)
+			}
 			msg = m
 			if msg.OP() == ops.Skip {
 				n.l.With("transform", transform.Name).With("op", msg.OP()).Debugln("skipping message")
@@ -618,6 +719,28 @@ func (n *Node) applyTransforms(msg message.Msg) (message.Msg, error) {
 	return msg, nil
 }
 
+// transformedUpdate rebuilds the $set operator of the update from the transformed data, which
+// holds the _id and the fields the source set (see message.WithUpdate). The other operators are
+// kept as they are.
+func transformedUpdate(update, d data.Data) data.Data {
+	rebuilt := data.Data{}
+	for k, v := range update {
+		if k != "$set" {
+			rebuilt[k] = v
+		}
+	}
+	set := make(map[string]interface{})
+	for k, v := range d {
+		if k != "_id" {
+			set[k] = v
+		}
+	}
+	if len(set) > 0 {
+		rebuilt["$set"] = set
+	}
+	return rebuilt
+}
+
 // Stop this node's adaptor, and sends a stop to each child of this node
 func (n *Node) Stop() {
 	n.stop()
@@ -634,6 +757,12 @@ func (n *Node) stop() error {
 
 	if n.om != nil {
 		close(n.confirmsDone)
+		// offset stores buffering their writes need to write them out
+		if closer, ok := n.om.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				n.l.Errorf("unable to close offset manager, %s", err)
+			}
+		}
 	}
 
 	if closer, ok := n.writer.(client.Closer); ok {
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"reflect"
 	"regexp"
 	"sync"
 	"testing"
@@ -18,6 +19,7 @@ import (
 	"github.com/compose/transporter/function"
 	"github.com/compose/transporter/log"
 	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
 	"github.com/compose/transporter/message/ops"
 	"github.com/compose/transporter/offset"
 )
@@ -663,6 +665,178 @@ var (
 	}
 )
 
+// recordingWriter records the confirms channel and offset attached to every message it writes.
+type recordingWriter struct {
+	mu       sync.Mutex
+	confirms []chan struct{}
+	offsets  []uint64
+}
+
+func (w *recordingWriter) Write(msg message.Msg) func(client.Session) (message.Msg, error) {
+	return func(client.Session) (message.Msg, error) {
+		_, o, _ := message.Offset(msg)
+		w.mu.Lock()
+		w.confirms = append(w.confirms, msg.Confirms())
+		w.offsets = append(w.offsets, o)
+		w.mu.Unlock()
+		return msg, nil
+	}
+}
+
+func TestWriteMultipleSinks(t *testing.T) {
+	source, _ := NewNodeWithOptions("source", "mock", defaultNsString)
+	var children []*Node
+	for _, name := range []string{"sink1", "sink2"} {
+		child, _ := NewNodeWithOptions(name, "mock", defaultNsString,
+			WithParent(source),
+			WithOffsetManager(&offset.MockManager{MemoryMap: map[string]uint64{}}))
+		child.l = log.With("name", name)
+		child.writer = &recordingWriter{}
+		child.confirms = make(chan struct{})
+		children = append(children, child)
+	}
+	// the children write the same messages concurrently, as they do when the source sends
+	// them, run with -race to catch them changing the shared message
+	var wg sync.WaitGroup
+	msgs := make([]message.Msg, 50)
+	for i := range msgs {
+		msgs[i] = message.From(ops.Insert, "foo", map[string]interface{}{"i": i})
+	}
+	for _, child := range children {
+		wg.Add(1)
+		go func(child *Node) {
+			defer wg.Done()
+			for i, msg := range msgs {
+				if _, err := child.write(msg, offset.Offset{Namespace: "foo", LogOffset: uint64(i)}); err != nil {
+					t.Errorf("[%s] unexpected write error, %s", child.Name, err)
+				}
+			}
+		}(child)
+	}
+	wg.Wait()
+	for _, child := range children {
+		w := child.writer.(*recordingWriter)
+		for i := range msgs {
+			if w.confirms[i] != child.confirms {
+				t.Errorf("[%s] message %d written with the confirms of another sink", child.Name, i)
+			}
+			if w.offsets[i] != uint64(i) {
+				t.Errorf("[%s] wrong offset for message %d, got %d", child.Name, i, w.offsets[i])
+			}
+		}
+	}
+	for i, msg := range msgs {
+		if _, _, ok := message.Offset(msg); ok || msg.Confirms() != nil {
+			t.Errorf("message %d sent by the source was changed", i)
+		}
+	}
+}
+
+// rebuilder builds a new message like the goja and pick transforms do.
+type rebuilder struct{}
+
+func (rebuilder) Apply(msg message.Msg) (message.Msg, error) {
+	return message.From(msg.OP(), msg.Namespace(), msg.Data()), nil
+}
+
+func TestApplyTransformsKeepsMode(t *testing.T) {
+	n, _ := NewNodeWithOptions("sink", "mock", defaultNsString,
+		WithTransforms([]*Transform{{"rebuild", rebuilder{}, DefaultNS}}))
+	n.l = log.With("name", "sink")
+	msg := sourceMsg(client.MessageSet{
+		Msg:       message.From(ops.Insert, "foo", map[string]interface{}{"i": 1}),
+		Timestamp: 1494946820,
+		Mode:      commitlog.Copy,
+	})
+	msg, err := n.applyTransforms(msg)
+	if err != nil {
+		t.Fatalf("unexpected applyTransforms error, %s", err)
+	}
+	if mode, ok := message.Mode(msg); !ok || mode != commitlog.Copy {
+		t.Errorf("expected the transformed message to be in the Copy mode, got %v %t", mode, ok)
+	}
+	if ts, ok := message.SourceTimestamp(msg); !ok || ts != 1494946820 {
+		t.Errorf("wrong source timestamp, expected 1494946820, got %d %t", ts, ok)
+	}
+}
+
+// dropField removes the field from the message data in place like the omit transform does.
+type dropField string
+
+func (f dropField) Apply(msg message.Msg) (message.Msg, error) {
+	msg.Data().Delete(string(f))
+	return msg, nil
+}
+
+var applyTransformsUpdateTests = []struct {
+	name      string
+	transform function.Function
+	update    data.Data
+	expected  data.Data
+}{
+	{
+		"dropped field",
+		dropField("ssn"),
+		data.Data{"$set": map[string]interface{}{"name": "alice", "ssn": "123"}, "$unset": map[string]interface{}{"age": true}},
+		data.Data{"$set": map[string]interface{}{"name": "alice"}, "$unset": map[string]interface{}{"age": true}},
+	},
+	{
+		"new message",
+		rebuilder{},
+		data.Data{"$set": map[string]interface{}{"name": "alice", "ssn": "123"}},
+		data.Data{"$set": map[string]interface{}{"name": "alice", "ssn": "123"}},
+	},
+	{
+		"every field dropped",
+		dropField("ssn"),
+		data.Data{"$set": map[string]interface{}{"ssn": "123"}},
+		data.Data{},
+	},
+}
+
+func TestApplyTransformsUpdate(t *testing.T) {
+	for _, at := range applyTransformsUpdateTests {
+		t.Run(at.name, func(t *testing.T) {
+			n, _ := NewNodeWithOptions("sink", "mock", defaultNsString,
+				WithTransforms([]*Transform{{"transform", at.transform, DefaultNS}}))
+			n.l = log.With("name", "sink")
+			d := data.Data{"_id": 1}
+			for k, v := range at.update["$set"].(map[string]interface{}) {
+				d[k] = v
+			}
+			msg, err := n.applyTransforms(message.WithUpdate(at.update, message.From(ops.Update, "foo", d)))
+			if err != nil {
+				t.Fatalf("unexpected applyTransforms error, %s", err)
+			}
+			if u, ok := message.Update(msg); !ok || !reflect.DeepEqual(u, at.expected) {
+				t.Errorf("wrong update, expected %v, got %v %t", at.expected, u, ok)
+			}
+		})
+	}
+}
+
+// failingReader sends its messages and then reports err, as a tailer does when streaming fails
+// after the copy.
+type failingReader struct {
+	client.MockReader
+	err error
+}
+
+func (r *failingReader) Err() error {
+	return r.err
+}
+
+func TestStartReaderError(t *testing.T) {
+	errStream := errors.New("stream failed")
+	source, _ := NewNodeWithOptions("source", "mock", defaultNsString)
+	source.l = log.With("name", "source")
+	source.c = &client.Mock{}
+	source.reader = &failingReader{client.MockReader{MsgCount: 3}, errStream}
+	if err := source.start(map[string]client.MessageSet{}); err != errStream {
+		t.Errorf("expected %s, got %v", errStream, err)
+	}
+}
+
 func TestStop(t *testing.T) {
 	for _, st := range stopTests {
 		log.Infof("starting %s", st.name)
@@ -0,0 +1,85 @@
+package pipeline
+
+import (
+	"bytes"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/compose/transporter/client"
+)
+
+// positionInterval is how often the Position committed by every child is sent to a Reader
+// implementing client.PositionConfirmer.
+const positionInterval = time.Second
+
+type positionEntry struct {
+	offset   uint64
+	position []byte
+}
+
+// positions tracks the Position of the messages appended to the commit log so the Position
+// committed by every child can be found from their offsets. Consecutive messages from the
+// same source transaction share a Position which is only committed once all of them are.
+type positions struct {
+	mu      sync.Mutex
+	entries []positionEntry
+}
+
+func (p *positions) add(offset uint64, position []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.entries = append(p.entries, positionEntry{offset, position})
+}
+
+// committed returns the newest Position whose messages are all at or before offset, nil is
+// returned when there isn't one. Entries older than the returned Position are dropped.
+func (p *positions) committed(offset uint64) []byte {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := -1
+	for i+1 < len(p.entries) && p.entries[i+1].offset <= offset {
+		i++
+	}
+	if i < 0 {
+		return nil
+	}
+	if i+1 < len(p.entries) {
+		// the next message is part of the same transaction so fall back to the previous one
+		for i >= 0 && bytes.Equal(p.entries[i].position, p.entries[i+1].position) {
+			i--
+		}
+		if i < 0 {
+			return nil
+		}
+	}
+	position := p.entries[i].position
+	p.entries = p.entries[i:]
+	return position
+}
+
+// confirmPositions periodically sends the Position committed by every child to the
+// confirmer until the node is stopped.
+func (n *Node) confirmPositions(confirmer client.PositionConfirmer, p *positions) {
+	ticker := time.NewTicker(positionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-n.done:
+			return
+		}
+		offset := int64(math.MaxInt64)
+		for _, child := range n.children {
+			if child.om != nil && child.om.NewestOffset() < offset {
+				offset = child.om.NewestOffset()
+			}
+		}
+		if offset < 0 || offset == math.MaxInt64 {
+			continue
+		}
+		if position := p.committed(uint64(offset)); position != nil {
+			confirmer.ConfirmPosition(position)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package pipeline
+
+import (
+	"testing"
+)
+
+var positionTests = []struct {
+	name     string
+	offset   uint64
+	expected string
+}{
+	{"before any entry", 0, ""},
+	{"inside the first transaction", 1, ""},
+	{"end of the first transaction", 2, "0/10"},
+	{"inside the second transaction", 4, "0/10"},
+	{"last entry", 6, "0/30"},
+	{"after the last entry", 10, "0/30"},
+}
+
+func TestPositionsCommitted(t *testing.T) {
+	for _, pt := range positionTests {
+		p := &positions{}
+		for _, e := range []positionEntry{
+			{1, []byte("0/10")},
+			{2, []byte("0/10")},
+			{3, []byte("0/20")},
+			{4, []byte("0/20")},
+			{5, []byte("0/20")},
+			{6, []byte("0/30")},
+		} {
+			p.add(e.offset, e.position)
+		}
+		if actual := string(p.committed(pt.offset)); actual != pt.expected {
+			t.Errorf("[%s] wrong position, expected %q, got %q", pt.name, pt.expected, actual)
+		}
+	}
+}
+
+func TestPositionsCommittedDropsOldEntries(t *testing.T) {
+	p := &positions{}
+	p.add(1, []byte("0/10"))
+	p.add(2, []byte("0/20"))
+	p.add(3, []byte("0/30"))
+	if actual := string(p.committed(2)); actual != "0/20" {
+		t.Fatalf("wrong position, expected 0/20, got %q", actual)
+	}
+	if len(p.entries) != 2 {
+		t.Errorf("wrong number of entries, expected 2, got %d", len(p.entries))
+	}
+	if actual := string(p.committed(1)); actual != "" {
+		t.Errorf("wrong position for an offset which was already committed, expected none, got %q", actual)
+	}
+}
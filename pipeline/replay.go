@@ -0,0 +1,74 @@
+package pipeline
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/log"
+)
+
+// ReplayOptions controls which entries of the commit log are applied by Replay.
+type ReplayOptions struct {
+	// NsFilter limits the replay to namespaces matching the regex, all namespaces are
+	// replayed when nil.
+	NsFilter *regexp.Regexp
+
+	// UntilOffset is the last offset applied, a negative value replays to the end of the log.
+	UntilOffset int64
+
+	// UntilTimestamp skips any entry with a later timestamp, the zero value disables the check.
+	UntilTimestamp time.Time
+}
+
+// ReplayError wraps the error returned while replaying the entry at Offset.
+type ReplayError struct {
+	Offset uint64
+	Err    error
+}
+
+func (e ReplayError) Error() string {
+	return fmt.Sprintf("replay failed at offset %d, %s", e.Offset, e.Err)
+}
+
+// Replay reads the commit log from its oldest offset and writes every message allowed by opts
+// to the sink through the normal client.Write path, which makes it possible to restore a sink
+// to the state it was in at a given offset or point in time. Timestamps in the commit log come
+// from the source and are not guaranteed to increase with the offset so entries after
+// UntilTimestamp are skipped rather than ending the replay. The number of messages written
+// is returned.
+func Replay(clog *commitlog.CommitLog, c client.Client, w client.Writer, opts ReplayOptions) (int, error) {
+	r, err := clog.NewReader(-1)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for {
+		d, err := readResumeData(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+		if opts.UntilOffset >= 0 && d.offset > uint64(opts.UntilOffset) {
+			break
+		}
+		if opts.NsFilter != nil && !opts.NsFilter.MatchString(d.ns) {
+			continue
+		}
+		if !opts.UntilTimestamp.IsZero() && d.msg.Timestamp > opts.UntilTimestamp.Unix() {
+			continue
+		}
+		if _, err := client.Write(c, w, d.msg.Msg); err != nil {
+			return count, ReplayError{d.offset, err}
+		}
+		count++
+		if count%10000 == 0 {
+			log.With("offset", d.offset).With("count", count).Infoln("still replaying...")
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+)
+
+var replayTests = []struct {
+	name          string
+	opts          ReplayOptions
+	expectedCount int
+	expectedErr   error
+}{
+	{"everything", ReplayOptions{UntilOffset: -1}, 10, nil},
+	{"until_offset", ReplayOptions{UntilOffset: 4}, 5, nil},
+	{"until_timestamp", ReplayOptions{UntilOffset: -1, UntilTimestamp: time.Unix(1000+2, 0)}, 3, nil},
+	{"ns_filter", ReplayOptions{UntilOffset: 5, NsFilter: regexp.MustCompile("^bar$")}, 3, nil},
+}
+
+func setupReplayLog(t *testing.T) (*commitlog.CommitLog, string) {
+	path := filepath.Join(os.TempDir(), fmt.Sprintf("replaytest%d", rand.Int63()))
+	clog, err := commitlog.New(commitlog.WithPath(path), commitlog.WithMaxSegmentBytes(300))
+	if err != nil {
+		t.Fatalf("unexpected commitlog.New error, %s", err)
+	}
+	for i := 0; i < 10; i++ {
+		ns := "foo"
+		if i%2 == 1 {
+			ns = "bar"
+		}
+		b, _ := data.Data{"i": i}.MarshalBinary()
+		_, err := clog.Append(commitlog.NewLogFromEntry(commitlog.LogEntry{
+			Key:       []byte(ns),
+			Value:     b,
+			Timestamp: uint64(1000 + i),
+			Mode:      commitlog.Sync,
+			Op:        ops.Insert,
+			Encoding:  commitlog.BinaryEncoding,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected Append error, %s", err)
+		}
+	}
+	return clog, path
+}
+
+func TestReplay(t *testing.T) {
+	clog, path := setupReplayLog(t)
+	defer os.RemoveAll(path)
+	defer clog.Close()
+	for _, rt := range replayTests {
+		w := &client.MockWriter{}
+		count, err := Replay(clog, &client.Mock{}, w, rt.opts)
+		if !reflect.DeepEqual(err, rt.expectedErr) {
+			t.Errorf("[%s] wrong error, expected %v, got %v", rt.name, rt.expectedErr, err)
+		}
+		if count != rt.expectedCount || w.MsgCount != rt.expectedCount {
+			t.Errorf("[%s] wrong count, expected %d, got %d (%d written)", rt.name, rt.expectedCount, count, w.MsgCount)
+		}
+	}
+}
+
+func TestReplayWriteErr(t *testing.T) {
+	clog, path := setupReplayLog(t)
+	defer os.RemoveAll(path)
+	defer clog.Close()
+	_, err := Replay(clog, &client.Mock{}, &client.MockErrWriter{}, ReplayOptions{UntilOffset: -1})
+	expected := ReplayError{0, client.ErrMockWrite}
+	if !reflect.DeepEqual(err, expected) {
+		t.Errorf("wrong error, expected %v, got %v", expected, err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/compose/transporter/client"
 	"github.com/compose/transporter/commitlog"
 	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
 )
 
 type resumeData struct {
@@ -24,18 +25,82 @@ func readResumeData(r io.Reader) (resumeData, error) {
 	}
 	rd.offset = logOffset
 	rd.ns = string(entry.Key)
-	d := make(map[string]interface{})
-	if err := json.Unmarshal(entry.Value, &d); err != nil {
-		return resumeData{}, err
-	}
-	data, err := mejson.Unmarshal(d)
+	d, err := EntryData(entry)
 	if err != nil {
 		return resumeData{}, err
 	}
+	msg := message.From(entry.Op, string(entry.Key), d)
+	if len(entry.Update) > 0 {
+		u, err := EntryUpdate(entry)
+		if err != nil {
+			return resumeData{}, err
+		}
+		msg = message.WithUpdate(u, msg)
+	}
 	rd.msg = client.MessageSet{
-		Msg:       message.From(entry.Op, string(entry.Key), map[string]interface{}(data)),
+		Msg:       message.WithSourceTimestamp(int64(entry.Timestamp), message.WithMode(entry.Mode, msg)),
 		Timestamp: int64(entry.Timestamp),
 		Mode:      entry.Mode,
+		Position:  entry.Position,
 	}
 	return rd, nil
 }
+
+// sourceMsg returns the message of the MessageSet with the mode and timestamp of the MessageSet
+// attached.
+func sourceMsg(msg client.MessageSet) message.Msg {
+	return message.WithSourceTimestamp(msg.Timestamp, message.WithMode(msg.Mode, msg.Msg))
+}
+
+// newLogEntry builds the LogEntry the message read by the source is stored as, the update
+// operators attached to the message (see message.WithUpdate) are stored apart from its data.
+func newLogEntry(msg client.MessageSet) (commitlog.LogEntry, error) {
+	b, err := msg.Msg.Data().MarshalBinary()
+	if err != nil {
+		return commitlog.LogEntry{}, err
+	}
+	le := commitlog.LogEntry{
+		Key:       []byte(msg.Msg.Namespace()),
+		Mode:      msg.Mode,
+		Op:        msg.Msg.OP(),
+		Timestamp: uint64(msg.Timestamp),
+		Value:     b,
+		Encoding:  commitlog.BinaryEncoding,
+		Position:  msg.Position,
+	}
+	if u, ok := message.Update(msg.Msg); ok {
+		if le.Update, err = u.MarshalBinary(); err != nil {
+			return commitlog.LogEntry{}, err
+		}
+	}
+	return le, nil
+}
+
+// EntryData decodes the message data stored in the value of the LogEntry based on its Encoding.
+func EntryData(entry commitlog.LogEntry) (data.Data, error) {
+	if entry.Encoding == commitlog.BinaryEncoding {
+		var d data.Data
+		err := d.UnmarshalBinary(entry.Value)
+		return d, err
+	}
+	d := make(map[string]interface{})
+	if err := json.Unmarshal(entry.Value, &d); err != nil {
+		return nil, err
+	}
+	m, err := mejson.Unmarshal(d)
+	if err != nil {
+		return nil, err
+	}
+	return data.Data(m), nil
+}
+
+// EntryUpdate decodes the update operators stored in the LogEntry, nil is returned when it has
+// none.
+func EntryUpdate(entry commitlog.LogEntry) (data.Data, error) {
+	if len(entry.Update) == 0 {
+		return nil, nil
+	}
+	var u data.Data
+	err := u.UnmarshalBinary(entry.Update)
+	return u, err
+}
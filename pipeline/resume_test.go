@@ -0,0 +1,96 @@
+package pipeline
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/client"
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message"
+	"github.com/compose/transporter/message/data"
+	"github.com/compose/transporter/message/ops"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestReadResumeDataBinary(t *testing.T) {
+	d := data.Data{
+		"_id":   bson.ObjectIdHex("58efd14b60d271d7457b4f24"),
+		"count": int64(1) << 60,
+		"i":     1,
+		"at":    time.Date(2017, 5, 16, 11, 0, 20, 123456789, time.UTC),
+		"doc":   bson.M{"nested": []interface{}{int32(1), "a"}},
+	}
+	b, err := d.MarshalBinary()
+	if err != nil {
+		t.Fatalf("unexpected MarshalBinary error, %s", err)
+	}
+	l := commitlog.NewLogFromEntry(commitlog.LogEntry{
+		Key:       []byte("MyCollection"),
+		Value:     b,
+		Timestamp: 1491252302,
+		Mode:      commitlog.Sync,
+		Op:        ops.Update,
+		Encoding:  commitlog.BinaryEncoding,
+	})
+	l.PutOffset(12)
+
+	rd, err := readResumeData(bytes.NewReader(l))
+	if err != nil {
+		t.Fatalf("unexpected readResumeData error, %s", err)
+	}
+	if rd.offset != 12 || rd.ns != "MyCollection" || rd.msg.Mode != commitlog.Sync || rd.msg.Msg.OP() != ops.Update {
+		t.Errorf("wrong resume data, got %+v", rd)
+	}
+	if !reflect.DeepEqual(rd.msg.Msg.Data(), d) {
+		t.Errorf("wrong data, expected %#v, got %#v", d, rd.msg.Msg.Data())
+	}
+}
+
+func TestReadResumeDataJSON(t *testing.T) {
+	l := commitlog.NewLogFromEntry(commitlog.LogEntry{
+		Key:       []byte("MyCollection"),
+		Value:     []byte(`{"_id":{"$oid":"58efd14b60d271d7457b4f24"},"i":0}`),
+		Timestamp: 1491252302,
+	})
+	rd, err := readResumeData(bytes.NewReader(l))
+	if err != nil {
+		t.Fatalf("unexpected readResumeData error, %s", err)
+	}
+	expected := data.Data{"_id": bson.ObjectIdHex("58efd14b60d271d7457b4f24"), "i": float64(0)}
+	if !reflect.DeepEqual(rd.msg.Msg.Data(), expected) {
+		t.Errorf("wrong data, expected %#v, got %#v", expected, rd.msg.Msg.Data())
+	}
+}
+
+func TestReadResumeDataUpdate(t *testing.T) {
+	d := data.Data{"_id": 1, "name": "bob"}
+	update := data.Data{"$set": map[string]interface{}{"name": "bob"}, "$unset": map[string]interface{}{"age": true}}
+	le, err := newLogEntry(client.MessageSet{
+		Msg:  message.WithUpdate(update, message.From(ops.Update, "MyCollection", d)),
+		Mode: commitlog.Sync,
+	})
+	if err != nil {
+		t.Fatalf("unexpected newLogEntry error, %s", err)
+	}
+	// the update is kept apart from the document so tools reading the value don't show it
+	stored, err := EntryData(le)
+	if err != nil {
+		t.Fatalf("unexpected EntryData error, %s", err)
+	}
+	if !reflect.DeepEqual(stored, d) {
+		t.Errorf("wrong stored data, expected %#v, got %#v", d, stored)
+	}
+	rd, err := readResumeData(bytes.NewReader(commitlog.NewLogFromEntry(le)))
+	if err != nil {
+		t.Fatalf("unexpected readResumeData error, %s", err)
+	}
+	if !reflect.DeepEqual(rd.msg.Msg.Data(), d) {
+		t.Errorf("wrong data, expected %#v, got %#v", d, rd.msg.Msg.Data())
+	}
+	u, ok := message.Update(rd.msg.Msg)
+	if !ok || !reflect.DeepEqual(u.AsMap(), update.AsMap()) {
+		t.Errorf("wrong update, expected %#v, got %#v %v", update, u, ok)
+	}
+}
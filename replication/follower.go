@@ -0,0 +1,236 @@
+package replication
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/compose/transporter/log"
+)
+
+// ErrInsecurePrimary is returned when the primary isn't served over HTTPS.
+var ErrInsecurePrimary = errors.New("the primary must be served over https")
+
+// Follower keeps a copy of a primary's log directory up to date by copying the bytes added
+// to every file since the last sync. Files which were rewritten on the primary, such as
+// compacted segments, are copied again in full and files removed from the primary are
+// removed locally.
+type Follower struct {
+	primary   string
+	path      string
+	token     string
+	tlsConfig *tls.Config
+	client    *http.Client
+}
+
+// FollowerOptionFunc is a function that configures a Follower.
+// It is used in NewFollower.
+type FollowerOptionFunc func(*Follower) error
+
+// WithToken sets the token the Follower authenticates to the primary with.
+func WithToken(token string) FollowerOptionFunc {
+	return func(f *Follower) error {
+		f.token = token
+		return nil
+	}
+}
+
+// WithTLS verifies the primary's certificate against the CAs in caFile, the system CAs are
+// used when it's empty. When certFile and keyFile are set, the Follower presents the
+// certificate to the primary.
+func WithTLS(caFile, certFile, keyFile string) FollowerOptionFunc {
+	return func(f *Follower) error {
+		if caFile != "" {
+			pool, err := loadCertPool(caFile)
+			if err != nil {
+				return err
+			}
+			f.tlsConfig.RootCAs = pool
+		}
+		if certFile != "" || keyFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return err
+			}
+			f.tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		return nil
+	}
+}
+
+// NewFollower creates a Follower which copies the log directory served at the primary URL
+// into path, the primary URL must use https.
+func NewFollower(primary, path string, options ...FollowerOptionFunc) (*Follower, error) {
+	if !strings.HasPrefix(primary, "https://") {
+		return nil, ErrInsecurePrimary
+	}
+	f := &Follower{
+		primary:   strings.TrimSuffix(primary, "/"),
+		path:      path,
+		tlsConfig: &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	for _, option := range options {
+		if err := option(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+	f.client = &http.Client{
+		Timeout:   time.Minute,
+		Transport: &http.Transport{TLSClientConfig: f.tlsConfig},
+	}
+	return f, nil
+}
+
+// get sends a GET request to the primary with the Follower's token.
+func (f *Follower) get(u string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	return f.client.Do(req)
+}
+
+// Run syncs every interval until done is closed, errors are logged and the sync retried
+// at the next interval.
+func (f *Follower) Run(interval time.Duration, done chan struct{}) {
+	for {
+		if n, err := f.Sync(); err != nil {
+			log.With("primary", f.primary).Errorf("sync failed, %s", err)
+		} else if n > 0 {
+			log.With("primary", f.primary).With("bytes", n).Debugln("sync complete")
+		}
+		select {
+		case <-done:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// Sync copies the changes made on the primary since the previous sync and returns the
+// number of bytes copied.
+func (f *Follower) Sync() (int64, error) {
+	resp, err := f.get(f.primary + "/files")
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("unable to list files, %s", resp.Status)
+	}
+	var files []File
+	if err := json.NewDecoder(resp.Body).Decode(&files); err != nil {
+		return 0, err
+	}
+
+	var copied int64
+	remote := make(map[string]bool)
+	for _, file := range files {
+		remote[file.Path] = true
+		n, err := f.syncFile(file)
+		copied += n
+		if err != nil {
+			return copied, err
+		}
+	}
+
+	local, err := listFiles(f.path)
+	if err != nil {
+		return copied, err
+	}
+	for _, file := range local {
+		if !remote[file.Path] {
+			log.With("file", file.Path).Infoln("removing file no longer on primary")
+			if err := os.Remove(filepath.Join(f.path, filepath.FromSlash(file.Path))); err != nil {
+				return copied, err
+			}
+		}
+	}
+	return copied, nil
+}
+
+func (f *Follower) syncFile(file File) (int64, error) {
+	name := filepath.Join(f.path, filepath.FromSlash(file.Path))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return 0, err
+	}
+	lf, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return 0, err
+	}
+	defer lf.Close()
+	stat, err := lf.Stat()
+	if err != nil {
+		return 0, err
+	}
+	from := stat.Size()
+	reset := false
+	if from == file.Size {
+		if stat.ModTime().Equal(file.ModTime) {
+			return 0, nil
+		}
+		// compaction rewrites a segment in place and can leave it with the size of the
+		// local copy so it has to be copied again in full
+		reset = true
+		from = 0
+	}
+	sum, err := checksum(lf, from)
+	if err != nil {
+		return 0, err
+	}
+
+	u := fmt.Sprintf("%s/file?path=%s&from=%d&crc=%d", f.primary, url.QueryEscape(file.Path), from, sum)
+	resp, err := f.get(u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		// removed since it was listed, the next sync removes the local copy
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unable to copy %s, %s", file.Path, resp.Status)
+	}
+
+	if !reset && resp.Header.Get(resetHeader) == "" {
+		n, err := io.Copy(lf, resp.Body)
+		if err != nil {
+			return n, err
+		}
+		return n, os.Chtimes(name, file.ModTime, file.ModTime)
+	}
+	log.With("file", file.Path).Infoln("file changed on primary, copying it again")
+	tmp := name + ".tmp"
+	tf, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(tf, resp.Body)
+	if err != nil {
+		tf.Close()
+		return n, err
+	}
+	if err := tf.Close(); err != nil {
+		return n, err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return n, err
+	}
+	return n, os.Chtimes(name, file.ModTime, file.ModTime)
+}
@@ -0,0 +1,47 @@
+package replication
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/compose/transporter/commitlog"
+)
+
+// Promote prepares a standby's copy of the log directory to be used by a pipeline. Files
+// left by an interrupted sync and entries only partially copied while the primary was
+// appending to them are removed. The number of bytes truncated from the segments is
+// returned.
+func Promote(path string) (int64, error) {
+	dirs := []string{path}
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if p != path {
+				if !validDir(filepath.Base(p)) {
+					return filepath.SkipDir
+				}
+				dirs = append(dirs, p)
+			}
+			return nil
+		}
+		if strings.HasSuffix(p, ".tmp") {
+			return os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	var removed int64
+	for _, dir := range dirs {
+		n, err := commitlog.Repair(dir)
+		removed += n
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
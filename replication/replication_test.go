@@ -0,0 +1,284 @@
+package replication_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/compose/transporter/commitlog"
+	"github.com/compose/transporter/message/ops"
+	"github.com/compose/transporter/offset"
+	"github.com/compose/transporter/replication"
+)
+
+func appendEntries(t *testing.T, l *commitlog.CommitLog, start, count int) {
+	for i := start; i < start+count; i++ {
+		_, err := l.Append(commitlog.NewLogFromEntry(commitlog.LogEntry{
+			Key:       []byte("foo"),
+			Value:     []byte(fmt.Sprintf(`{"i":%d}`, i)),
+			Timestamp: uint64(time.Now().Unix()),
+			Op:        ops.Insert,
+			Mode:      commitlog.Sync,
+		}))
+		if err != nil {
+			t.Fatalf("unexpected Append error, %s", err)
+		}
+	}
+}
+
+func setupPrimary(t *testing.T) (string, *commitlog.CommitLog, *httptest.Server) {
+	dir, err := ioutil.TempDir("", "primary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := commitlog.New(commitlog.WithPath(dir), commitlog.WithMaxSegmentBytes(200))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	appendEntries(t, l, 0, 10)
+	om, err := offset.NewLogManager(dir, "sink")
+	if err != nil {
+		t.Fatalf("unexpected NewLogManager error, %s", err)
+	}
+	if err := om.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 7}, false); err != nil {
+		t.Fatalf("unexpected CommitOffset error, %s", err)
+	}
+	fm, err := offset.NewFileManager(dir, "file_sink")
+	if err != nil {
+		t.Fatalf("unexpected NewFileManager error, %s", err)
+	}
+	if err := fm.CommitOffset(offset.Offset{Namespace: "foo", LogOffset: 5}, false); err != nil {
+		t.Fatalf("unexpected CommitOffset error, %s", err)
+	}
+	return dir, l, httptest.NewTLSServer(replication.NewServer(dir, testToken))
+}
+
+const testToken = "secret"
+
+// newFollower creates a Follower trusting the certificate of the test server.
+func newFollower(t *testing.T, ts *httptest.Server, standby string, options ...replication.FollowerOptionFunc) *replication.Follower {
+	ca, err := ioutil.TempFile("", "ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(ca.Name())
+	pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	ca.Close()
+	options = append([]replication.FollowerOptionFunc{replication.WithTLS(ca.Name(), "", "")}, options...)
+	f, err := replication.NewFollower(ts.URL, standby, options...)
+	if err != nil {
+		t.Fatalf("unexpected NewFollower error, %s", err)
+	}
+	return f
+}
+
+// assertSameFiles verifies every file in the primary exists with the same contents in the
+// standby and vice versa.
+func assertSameFiles(t *testing.T, primary, standby string) {
+	files := func(dir string) map[string][]byte {
+		m := make(map[string][]byte)
+		filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(dir, p)
+			b, _ := ioutil.ReadFile(p)
+			m[rel] = b
+			return nil
+		})
+		return m
+	}
+	p, s := files(primary), files(standby)
+	if len(p) != len(s) {
+		t.Errorf("wrong number of files, expected %d, got %d", len(p), len(s))
+	}
+	for name, b := range p {
+		if !bytes.Equal(b, s[name]) {
+			t.Errorf("file %s does not match the primary", name)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	primary, l, ts := setupPrimary(t)
+	defer os.RemoveAll(primary)
+	defer l.Close()
+	defer ts.Close()
+	standby, err := ioutil.TempDir("", "standby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(standby)
+
+	f := newFollower(t, ts, standby, replication.WithToken(testToken))
+	if n, err := f.Sync(); err != nil || n == 0 {
+		t.Fatalf("expected initial sync to copy files, got %d, %v", n, err)
+	}
+	assertSameFiles(t, primary, standby)
+
+	if n, err := f.Sync(); err != nil || n != 0 {
+		t.Errorf("expected nothing to copy, got %d, %v", n, err)
+	}
+
+	appendEntries(t, l, 10, 5)
+	if n, err := f.Sync(); err != nil || n == 0 {
+		t.Errorf("expected new entries to be copied, got %d, %v", n, err)
+	}
+	assertSameFiles(t, primary, standby)
+
+	// rewrite the oldest segment with fewer bytes as compaction would
+	oldest := filepath.Join(primary, fmt.Sprintf(commitlog.LogNameFormat, 0))
+	b, err := ioutil.ReadFile(oldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(oldest, b[:len(b)/2], 0666); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Sync(); err != nil {
+		t.Errorf("unexpected Sync error, %s", err)
+	}
+	assertSameFiles(t, primary, standby)
+
+	// rewrite it again with different contents of the same size
+	b, err = ioutil.ReadFile(oldest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(oldest, bytes.Repeat([]byte{0}, len(b)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(oldest, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Sync(); err != nil || n != int64(len(b)) {
+		t.Errorf("expected the segment to be copied again, got %d, %v", n, err)
+	}
+	assertSameFiles(t, primary, standby)
+
+	if err := os.Remove(oldest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Sync(); err != nil {
+		t.Errorf("unexpected Sync error, %s", err)
+	}
+	assertSameFiles(t, primary, standby)
+}
+
+var invalidPathTests = []string{
+	"../secret.log",
+	"/etc/passwd",
+	"other/00000000000000000000.log",
+	"pipeline.js",
+}
+
+func TestServerInvalidPath(t *testing.T) {
+	primary, l, ts := setupPrimary(t)
+	defer os.RemoveAll(primary)
+	defer l.Close()
+	defer ts.Close()
+	for _, p := range invalidPathTests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("%s/file?path=%s", ts.URL, p), nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatalf("unexpected Get error, %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected %s to be rejected, got %s", p, resp.Status)
+		}
+	}
+}
+
+func TestServerUnauthorized(t *testing.T) {
+	primary, l, ts := setupPrimary(t)
+	defer os.RemoveAll(primary)
+	defer l.Close()
+	defer ts.Close()
+	standby, err := ioutil.TempDir("", "standby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(standby)
+	for _, token := range []string{"", "wrong"} {
+		f := newFollower(t, ts, standby, replication.WithToken(token))
+		if _, err := f.Sync(); err == nil {
+			t.Errorf("expected sync with token %q to be refused", token)
+		}
+	}
+	files, _ := ioutil.ReadDir(standby)
+	if len(files) != 0 {
+		t.Errorf("expected nothing copied without the token, got %d files", len(files))
+	}
+}
+
+func TestNewFollowerInsecure(t *testing.T) {
+	if _, err := replication.NewFollower("http://primary:7071", "standby"); err != replication.ErrInsecurePrimary {
+		t.Errorf("expected ErrInsecurePrimary, got %v", err)
+	}
+}
+
+func TestPromote(t *testing.T) {
+	primary, l, ts := setupPrimary(t)
+	defer os.RemoveAll(primary)
+	defer l.Close()
+	defer ts.Close()
+	standby, err := ioutil.TempDir("", "standby")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(standby)
+	f := newFollower(t, ts, standby, replication.WithToken(testToken))
+	if _, err := f.Sync(); err != nil {
+		t.Fatalf("unexpected Sync error, %s", err)
+	}
+
+	// simulate copying the active segment in the middle of an append
+	segments := l.Segments()
+	active := filepath.Join(standby, fmt.Sprintf(commitlog.LogNameFormat, segments[len(segments)-1].BaseOffset))
+	partial := commitlog.NewLogFromEntry(commitlog.LogEntry{Key: []byte("foo"), Value: []byte(`{"i":10}`)})
+	partial.PutOffset(10)
+	af, err := os.OpenFile(active, os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	af.Write(partial[:10])
+	af.Close()
+	if err := ioutil.WriteFile(filepath.Join(standby, "__consumer_offsets-sink", "00000000000000000000.log.tmp"), []byte("x"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := replication.Promote(standby)
+	if err != nil {
+		t.Fatalf("unexpected Promote error, %s", err)
+	}
+	if removed != 10 {
+		t.Errorf("wrong number of bytes removed, expected 10, got %d", removed)
+	}
+	assertSameFiles(t, primary, standby)
+
+	sl, err := commitlog.New(commitlog.WithPath(standby), commitlog.WithMaxSegmentBytes(200))
+	if err != nil {
+		t.Fatalf("unexpected New error, %s", err)
+	}
+	defer sl.Close()
+	if sl.NewestOffset() != l.NewestOffset() {
+		t.Errorf("wrong NewestOffset, expected %d, got %d", l.NewestOffset(), sl.NewestOffset())
+	}
+	om, err := offset.NewLogManager(standby, "sink")
+	if err != nil {
+		t.Fatalf("unexpected NewLogManager error, %s", err)
+	}
+	if om.NewestOffset() != 7 {
+		t.Errorf("wrong sink offset, expected 7, got %d", om.NewestOffset())
+	}
+}
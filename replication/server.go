@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// resetHeader is set on a file response when the standby's copy no longer matches the
+	// primary, such as after compaction, and the body contains the file from the beginning.
+	resetHeader = "X-Transporter-Reset"
+
+	// checkLen is the number of bytes before the requested position used to verify the
+	// standby's copy still matches the primary.
+	checkLen = 1024
+)
+
+var (
+	// ErrInvalidPath is returned when a requested file is outside of the log directory or
+	// is not part of the commit log or offsets.
+	ErrInvalidPath = errors.New("invalid file path")
+
+	// ErrUnauthorized is returned for requests without the token or client certificate the
+	// Server requires.
+	ErrUnauthorized = errors.New("unauthorized")
+)
+
+// File describes a single file of the log directory.
+type File struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Server serves the segments of the commit log and the offsets stored alongside it so
+// they can be copied by a Follower. The files are read directly from disk so the Server
+// can run next to a pipeline writing to the same directory.
+//
+// Every request must carry the token as a bearer token in its Authorization header or, when
+// token is empty, a client certificate verified by the TLS configuration the Server is run
+// with (see ServerTLSConfig).
+type Server struct {
+	path  string
+	token string
+	mux   *http.ServeMux
+}
+
+// NewServer creates a Server for the log directory at path.
+func NewServer(path, token string) *Server {
+	s := &Server{path: path, token: token, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/files", s.handleFiles)
+	s.mux.HandleFunc("/file", s.handleFile)
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		http.Error(w, ErrUnauthorized.Error(), http.StatusUnauthorized)
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token != "" {
+		return subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) == 1
+	}
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0
+}
+
+// ServerTLSConfig returns the TLS configuration for serving the commit log, when clientCAFile
+// is set followers must present a certificate signed by one of its CAs.
+func ServerTLSConfig(clientCAFile string) (*tls.Config, error) {
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return cfg, nil
+	}
+	pool, err := loadCertPool(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	return cfg, nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+	return pool, nil
+}
+
+func (s *Server) handleFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := listFiles(s.path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(files)
+}
+
+// handleFile writes the file starting at the "from" position. When "crc" is provided it
+// must match the checksum of the bytes preceding "from", otherwise the entire file is sent.
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if !validPath(path) {
+		http.Error(w, ErrInvalidPath.Error(), http.StatusBadRequest)
+		return
+	}
+	from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+	f, err := os.Open(filepath.Join(s.path, filepath.FromSlash(path)))
+	if os.IsNotExist(err) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	size := stat.Size()
+
+	if from > size || (from > 0 && !checksumMatches(f, from, r.URL.Query().Get("crc"))) {
+		from = 0
+		w.Header().Set(resetHeader, "true")
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(size-from, 10))
+	io.Copy(w, io.NewSectionReader(f, from, size-from))
+}
+
+func checksumMatches(f *os.File, from int64, crc string) bool {
+	if crc == "" {
+		return true
+	}
+	sum, err := checksum(f, from)
+	if err != nil {
+		return false
+	}
+	return strconv.FormatUint(uint64(sum), 10) == crc
+}
+
+// checksum returns the CRC32 of the checkLen bytes before position.
+func checksum(r io.ReaderAt, position int64) (uint32, error) {
+	start := position - checkLen
+	if start < 0 {
+		start = 0
+	}
+	b := make([]byte, position-start)
+	if _, err := r.ReadAt(b, start); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return crc32.ChecksumIEEE(b), nil
+}
+
+// listFiles returns the segments and offsets in the log directory. Offsets are listed
+// before the commit log segments so a Follower never copies offsets newer than the
+// entries it has.
+func listFiles(path string) ([]File, error) {
+	var offsets, segments []File
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if info.IsDir() {
+			if rel != "." && !validDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !validPath(rel) {
+			return nil
+		}
+		f := File{Path: rel, Size: info.Size(), ModTime: info.ModTime()}
+		if strings.Contains(rel, "/") || strings.HasSuffix(rel, jsonSuffix) {
+			offsets = append(offsets, f)
+		} else {
+			segments = append(segments, f)
+		}
+		return nil
+	})
+	return append(offsets, segments...), err
+}
+
+const (
+	logSuffix    = ".log"
+	jsonSuffix   = ".json"
+	offsetPrefix = "__consumer_offsets-"
+)
+
+func validDir(dir string) bool {
+	return strings.HasPrefix(dir, offsetPrefix) && !strings.Contains(dir, "/")
+}
+
+// validPath reports whether path is a commit log or offset segment directly in the log
+// directory or an offset directory, or a JSON offset file.
+func validPath(path string) bool {
+	if path == "" || strings.Contains(path, "..") || strings.HasPrefix(path, "/") {
+		return false
+	}
+	dir, name := "", path
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		dir, name = path[:i], path[i+1:]
+		if !validDir(dir) {
+			return false
+		}
+	}
+	switch {
+	case strings.HasSuffix(name, logSuffix):
+		return true
+	case dir == "" && strings.HasPrefix(name, offsetPrefix) && strings.HasSuffix(name, jsonSuffix):
+		return true
+	}
+	return false
+}